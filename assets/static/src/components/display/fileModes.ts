@@ -7,6 +7,7 @@ export const FileModes = Object.freeze({
   CSV: Symbol("csv"),
   ARCHIVE: Symbol("archive"),
   TEXT: Symbol("text"),
+  COLLECTION: Symbol("collection"),
 });
 
 export default FileModes;
@@ -12,6 +12,7 @@ export interface WindowConfig {
   max_size: number;
   force_random: boolean;
   auth: boolean;
+  oidc?: boolean;
   expiration_times: ExpirationTime[];
   custom_pages?: string[];
 }
@@ -0,0 +1,160 @@
+// Decrypts end-to-end encrypted uploads. The format is described in ENCRYPTION.md.
+
+const Magic = "LINXE2E";
+const Version = 1;
+const HeaderSize = 28;
+const KeySize = 32;
+const TagSize = 16;
+const MaxChunkSize = 4 * 1024 * 1024;
+const MaxInfoSize = 64 * 1024;
+
+export type EncryptedInfo = {
+  name?: string;
+  type?: string;
+};
+
+// Decodes the unpadded base64url key from a URL fragment.
+export const decodeKey = (s: string): Uint8Array | null => {
+  try {
+    const b64 = s.replace(/-/g, "+").replace(/_/g, "/");
+    const bin = atob(b64 + "=".repeat((4 - (b64.length % 4)) % 4));
+    const key = Uint8Array.from(bin, (c) => c.charCodeAt(0));
+    return key.length === KeySize ? key : null;
+  } catch {
+    return null;
+  }
+};
+
+const concat = (a: Uint8Array, b: Uint8Array) => {
+  const out = new Uint8Array(a.length + b.length);
+  out.set(a);
+  out.set(b, a.length);
+  return out;
+};
+
+// The nonce is an 11 byte counter followed by a flag which marks the last record.
+const nonce = (counter: number, last: boolean) => {
+  const n = new Uint8Array(12);
+  new DataView(n.buffer).setBigUint64(3, BigInt(counter));
+  n[11] = last ? 1 : 0;
+  return n;
+};
+
+// Reads exact amounts from a stream.
+class StreamReader {
+  private buf = new Uint8Array(0);
+  private done = false;
+
+  constructor(private reader: ReadableStreamDefaultReader<Uint8Array>) {}
+
+  // Returns n bytes, or fewer at the end of the stream.
+  async read(n: number) {
+    while (this.buf.length < n && !this.done) {
+      const { value, done } = await this.reader.read();
+      if (done) {
+        this.done = true;
+      } else {
+        this.buf = concat(this.buf, value);
+      }
+    }
+    const out = this.buf.slice(0, n);
+    this.buf = this.buf.slice(n);
+    return out;
+  }
+}
+
+// Decrypts a stream of ciphertext one record at a time.
+export const decryptStream = async (body: ReadableStream<Uint8Array>, rawKey: Uint8Array) => {
+  const reader = new StreamReader(body.getReader());
+
+  const header = await reader.read(HeaderSize);
+  if (
+    header.length < HeaderSize ||
+    new TextDecoder().decode(header.subarray(0, Magic.length)) !== Magic
+  ) {
+    throw new Error("Invalid encryption header");
+  }
+  if (header[7] !== Version) {
+    throw new Error(`Unsupported encryption version ${header[7]}`);
+  }
+  const chunkSize = new DataView(header.buffer).getUint32(8);
+  if (!chunkSize || chunkSize > MaxChunkSize) {
+    throw new Error("Invalid encryption header");
+  }
+
+  const ikm = await crypto.subtle.importKey("raw", rawKey, "HKDF", false, ["deriveKey"]);
+  const key = await crypto.subtle.deriveKey(
+    {
+      name: "HKDF",
+      hash: "SHA-256",
+      salt: header.slice(12, HeaderSize),
+      info: new TextEncoder().encode("linx-e2e v1"),
+    },
+    ikm,
+    { name: "AES-GCM", length: 256 },
+    false,
+    ["decrypt"],
+  );
+
+  const open = async (record: Uint8Array, counter: number, last: boolean) => {
+    try {
+      const plain = await crypto.subtle.decrypt(
+        { name: "AES-GCM", iv: nonce(counter, last), additionalData: header },
+        key,
+        record,
+      );
+      return new Uint8Array(plain);
+    } catch {
+      throw new Error("Decryption failed. Check that the link is complete.");
+    }
+  };
+
+  const infoSize = new DataView((await reader.read(4)).buffer).getUint32(0);
+  if (infoSize < TagSize || infoSize > MaxInfoSize) {
+    throw new Error("Invalid encryption header");
+  }
+  const infoRecord = await reader.read(infoSize);
+  if (infoRecord.length < infoSize) {
+    throw new Error("Truncated ciphertext");
+  }
+  const info: EncryptedInfo = JSON.parse(new TextDecoder().decode(await open(infoRecord, 0, false)));
+
+  // Records are read one byte past their end to know whether they are the last one.
+  const recordSize = chunkSize + TagSize;
+  let next = await reader.read(recordSize + 1);
+  let counter = 1;
+
+  const stream = new ReadableStream<Uint8Array>({
+    async pull(controller) {
+      const last = next.length <= recordSize;
+      if (last && next.length < TagSize) {
+        throw new Error("Truncated ciphertext");
+      }
+      controller.enqueue(await open(next.slice(0, recordSize), counter++, last));
+      if (last) {
+        controller.close();
+        return;
+      }
+      next = concat(next.slice(recordSize), await reader.read(recordSize));
+    },
+  });
+
+  return { info, stream };
+};
+
+// Downloads and decrypts a file.
+export const decryptFile = async (url: string, key: Uint8Array, accessKey?: string) => {
+  const res = await fetch(url, {
+    headers: accessKey ? { "Linx-Access-Key": accessKey } : {},
+    credentials: "include",
+  });
+  if (!res.ok || !res.body) {
+    throw new Error(`Failed to download file: ${res.status} ${res.statusText}`);
+  }
+
+  const { info, stream } = await decryptStream(res.body, key);
+  const blob = await new Response(stream, {
+    headers: { "Content-Type": info.type || "application/octet-stream" },
+  }).blob();
+  return { info, blob };
+};
@@ -6,6 +6,8 @@ import (
 	"gabe565.com/linx-server/internal/backends"
 	"gabe565.com/linx-server/internal/cleanup"
 	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/partial"
+	"gabe565.com/linx-server/internal/webhook"
 	"github.com/spf13/cobra"
 )
 
@@ -18,7 +20,7 @@ func New() *cobra.Command {
 
 		ValidArgsFunction: cobra.NoFileCompletions,
 	}
-	config.Default.RegisterBasicFlags(cmd)
+	config.Default().RegisterBasicFlags(cmd)
 	config.RegisterBasicCompletions(cmd)
 	cmd.Flags().Lookup(config.FlagNoLogs).Usage = "Disable logging of deleted files"
 	return cmd
@@ -27,21 +29,51 @@ func New() *cobra.Command {
 var ErrUnsupported = errors.New("backend does not support listing files")
 
 func run(cmd *cobra.Command, _ []string) error {
-	if err := config.Default.Load(cmd); err != nil {
+	if err := config.Default().Load(cmd); err != nil {
 		return err
 	}
 
 	cmd.SilenceUsage = true
 
-	storage, err := config.Default.NewStorageBackend(cmd.Context())
+	storage, err := config.Default().NewStorageBackend(cmd.Context())
 	if err != nil {
 		return err
 	}
+	if !config.Default().NoThumbnails {
+		if _, storage, err = config.Default().CacheThumbnails(storage); err != nil {
+			return err
+		}
+	}
+
+	if !config.Default().NoTorrent {
+		if _, storage, err = config.Default().CacheTorrents(storage); err != nil {
+			return err
+		}
+	}
+
+	if !config.Default().NoStats {
+		if _, storage, err = config.Default().TrackStats(storage); err != nil {
+			return err
+		}
+	}
+
+	if _, storage, err = config.Default().TrackShares(storage); err != nil {
+		return err
+	}
 
 	lister, ok := storage.(backends.ListBackend)
 	if !ok {
 		return ErrUnsupported
 	}
 
-	return cleanup.Cleanup(cmd.Context(), lister, config.Default.NoLogs)
+	// Webhooks are only queued here. A running server delivers them.
+	var hooks *webhook.Dispatcher
+	if len(config.Default().Webhooks.Targets) != 0 {
+		if hooks, err = config.Default().NewWebhooks(); err != nil {
+			return err
+		}
+	}
+
+	partials := partial.New(config.Default().PartialsPath)
+	return cleanup.Cleanup(cmd.Context(), lister, partials, hooks, config.Default().NoLogs)
 }
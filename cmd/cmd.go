@@ -11,11 +11,16 @@ import (
 	"time"
 
 	cleanupCmd "gabe565.com/linx-server/cmd/cleanup"
+	"gabe565.com/linx-server/cmd/dedup"
 	"gabe565.com/linx-server/cmd/genkey"
 	"gabe565.com/linx-server/cmd/migrate"
+	"gabe565.com/linx-server/cmd/reindex"
+	"gabe565.com/linx-server/cmd/rotatekey"
 	"gabe565.com/linx-server/internal/backends"
 	"gabe565.com/linx-server/internal/cleanup"
 	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/metrics"
+	"gabe565.com/linx-server/internal/partial"
 	"gabe565.com/linx-server/internal/server"
 	"gabe565.com/utils/cobrax"
 	"github.com/spf13/cobra"
@@ -32,10 +37,13 @@ func New(options ...cobrax.Option) *cobra.Command {
 	}
 	cmd.AddCommand(
 		cleanupCmd.New(),
+		dedup.New(),
 		genkey.New(),
 		migrate.New(),
+		reindex.New(),
+		rotatekey.New(),
 	)
-	config.Default.RegisterServeFlags(cmd)
+	config.Default().RegisterServeFlags(cmd)
 	config.RegisterServeCompletions(cmd)
 	for _, option := range options {
 		option(cmd)
@@ -44,7 +52,7 @@ func New(options ...cobrax.Option) *cobra.Command {
 }
 
 func run(cmd *cobra.Command, _ []string) error {
-	if err := config.Default.Load(cmd); err != nil {
+	if err := config.Default().Load(cmd); err != nil {
 		return err
 	}
 
@@ -52,19 +60,54 @@ func run(cmd *cobra.Command, _ []string) error {
 
 	slog.Info("Linx Server", "version", cobrax.GetVersion(cmd), "commit", cobrax.GetCommit(cmd))
 
-	mux, err := server.Setup()
+	handler, err := server.NewHandler(cmd.Context())
 	if err != nil {
 		return err
 	}
 
-	config.StorageBackend, err = config.Default.NewStorageBackend(cmd.Context())
+	config.StorageBackend, err = config.Default().NewStorageBackend(cmd.Context())
 	if err != nil {
 		return err
 	}
+	if config.Scanner, err = config.Default().NewScanner(); err != nil {
+		return err
+	}
+	if !config.Default().NoThumbnails {
+		if config.Thumbnails, config.StorageBackend, err = config.Default().CacheThumbnails(config.StorageBackend); err != nil {
+			return err
+		}
+	}
+	if !config.Default().NoTorrent {
+		if config.Torrents, config.StorageBackend, err = config.Default().CacheTorrents(config.StorageBackend); err != nil {
+			return err
+		}
+	}
+	if !config.Default().NoStats {
+		if config.Stats, config.StorageBackend, err = config.Default().TrackStats(config.StorageBackend); err != nil {
+			return err
+		}
+	}
+	if config.Shares, config.StorageBackend, err = config.Default().TrackShares(config.StorageBackend); err != nil {
+		return err
+	}
+	if config.Capacity, config.StorageBackend, err = config.Default().LimitStorage(
+		cmd.Context(), config.StorageBackend,
+	); err != nil {
+		return err
+	}
+	if config.Default().Metrics {
+		config.StorageBackend = metrics.WrapBackend(config.StorageBackend)
+	}
+	config.Lockout = config.Default().NewLockout()
+	if len(config.Default().Webhooks.Targets) != 0 {
+		if config.Hooks, err = config.Default().NewWebhooks(); err != nil {
+			return err
+		}
+	}
 
 	srv := &http.Server{
-		Addr:              config.Default.Bind,
-		Handler:           mux,
+		Addr:              config.Default().Bind,
+		Handler:           handler,
 		ReadHeaderTimeout: 3 * time.Second,
 	}
 
@@ -72,11 +115,11 @@ func run(cmd *cobra.Command, _ []string) error {
 
 	go func() {
 		var err error
-		if config.Default.TLS.Cert != "" {
-			slog.Info("Serving over https", "address", config.Default.Bind)
-			err = srv.ListenAndServeTLS(config.Default.TLS.Cert, config.Default.TLS.Key)
+		if config.Default().TLS.Cert != "" {
+			slog.Info("Serving over https", "address", config.Default().Bind)
+			err = srv.ListenAndServeTLS(config.Default().TLS.Cert, config.Default().TLS.Key)
 		} else {
-			slog.Info("Serving over http", "address", config.Default.Bind)
+			slog.Info("Serving over http", "address", config.Default().Bind)
 			err = srv.ListenAndServe()
 		}
 		if err != nil {
@@ -87,17 +130,32 @@ func run(cmd *cobra.Command, _ []string) error {
 	ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 	defer cancel()
 
-	if config.Default.CleanupEvery.Duration > 0 {
+	go reloadOnSignal(ctx, cmd, handler)
+
+	if config.Hooks != nil {
+		go config.Hooks.Run(ctx)
+	}
+	if config.Stats != nil {
+		go config.Stats.Run(ctx)
+	}
+
+	if config.Default().CleanupEvery.Duration > 0 {
+		if config.Capacity != nil {
+			go config.Capacity.Run(ctx, config.Default().CleanupEvery.Duration)
+		}
 		if backend, ok := config.StorageBackend.(backends.ListBackend); ok {
 			go func() {
-				cleanup.PeriodicCleanup(ctx, backend, config.Default.CleanupEvery.Duration, config.Default.NoLogs)
+				partials := partial.New(config.Default().PartialsPath)
+				cleanup.PeriodicCleanup(ctx, backend, partials, config.Hooks,
+					config.Default().CleanupEvery.Duration, config.Default().NoLogs,
+				)
 			}()
 		}
 	}
 
 	select {
 	case <-ctx.Done():
-		timeout := config.Default.GracefulShutdown.Duration
+		timeout := config.Default().GracefulShutdown.Duration
 
 		ctx, cancelTimeout := context.WithTimeout(context.Background(), timeout)
 		defer cancelTimeout()
@@ -109,8 +167,33 @@ func run(cmd *cobra.Command, _ []string) error {
 		if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
 			return err
 		}
+		if config.Stats != nil {
+			if err := config.Stats.Flush(); err != nil {
+				slog.Error("Failed to save access stats", "error", err)
+			}
+		}
 		return nil
 	case err := <-errCh:
 		return err
 	}
 }
+
+// reloadOnSignal reloads the config, auth files and custom pages when the process receives SIGHUP.
+func reloadOnSignal(ctx context.Context, cmd *cobra.Command, handler *server.Handler) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := handler.Reload(ctx, cmd); err != nil {
+				slog.Error("Failed to reload config; keeping the current config", "error", err)
+				continue
+			}
+			slog.Info("Reloaded config")
+		}
+	}
+}
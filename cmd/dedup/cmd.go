@@ -0,0 +1,108 @@
+package dedup
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/utils/must"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+const Concurrency = "concurrency"
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dedup",
+		Short: "Convert existing uploads to deduplicated storage",
+		Args:  cobra.NoArgs,
+		RunE:  run,
+
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+	config.Default().RegisterBasicFlags(cmd)
+	config.RegisterBasicCompletions(cmd)
+
+	cmd.Flags().Int(Concurrency, 4, "Number of uploads to convert in parallel")
+
+	cmd.Flags().Lookup(config.FlagNoLogs).Usage = "Disable logging of converted files"
+
+	return cmd
+}
+
+var ErrUnsupported = errors.New("backend does not support deduplication")
+
+func run(cmd *cobra.Command, _ []string) error {
+	if err := config.Default().Load(cmd); err != nil {
+		return err
+	}
+
+	cmd.SilenceUsage = true
+
+	// Encrypted uploads have unique ciphertext, so they can't be deduplicated.
+	if config.Default().Encryption.Enabled() {
+		return config.ErrDedupEncryption
+	}
+
+	storage, err := config.Default().NewStorageBackend(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	if _, ok := backends.Unwrap(storage).(backends.DedupBackend); !ok {
+		return ErrUnsupported
+	}
+	backend := storage.(backends.DedupBackend) //nolint:errcheck
+
+	ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	defer cancel()
+
+	group, ctx := errgroup.WithContext(ctx)
+	concurrency := must.Must2(cmd.Flags().GetInt(Concurrency))
+	group.SetLimit(concurrency)
+
+	var converted atomic.Int64
+	for path, err := range backend.List(ctx) {
+		group.Go(func() error {
+			if err != nil {
+				return fmt.Errorf("failed to list uploads: %w", err)
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			changed, err := backend.Dedup(ctx, path)
+			if err != nil {
+				if errors.Is(err, backends.ErrNotFound) {
+					return nil
+				}
+				return fmt.Errorf("failed to convert upload %q: %w", path, err)
+			}
+
+			if changed {
+				converted.Add(1)
+				if !config.Default().NoLogs {
+					slog.Info("Converted upload", "name", path)
+				}
+			}
+			return nil
+		})
+	}
+
+	err = group.Wait()
+	if !config.Default().NoLogs {
+		slog.Info("Deduplication finished", "converted", converted.Load())
+	}
+	if err == nil && !config.Default().Dedup {
+		slog.Warn("Set dedup = true so that new uploads are also deduplicated")
+	}
+	return err
+}
@@ -29,7 +29,7 @@ func New() *cobra.Command {
 		Args:  cobra.NoArgs,
 		RunE:  run,
 	}
-	config.Default.RegisterBasicFlags(cmd)
+	config.Default().RegisterBasicFlags(cmd)
 	config.RegisterBasicCompletions(cmd)
 
 	cmd.Flags().StringP(FlagFrom, "f", "", "Source backend (one of s3, local)")
@@ -46,7 +46,7 @@ func New() *cobra.Command {
 }
 
 func run(cmd *cobra.Command, _ []string) error {
-	if err := config.Default.Load(cmd); err != nil {
+	if err := config.Default().Load(cmd); err != nil {
 		return err
 	}
 
@@ -77,33 +77,11 @@ func run(cmd *cobra.Command, _ []string) error {
 				return fmt.Errorf("failed to list uploads: %w", err)
 			}
 
-			if ctx.Err() != nil {
-				return ctx.Err()
+			if err := migrateUpload(ctx, srcBackend, dstBackend, path); err != nil {
+				return err
 			}
 
-			meta, r, err := srcBackend.Get(ctx, path)
-			if err != nil {
-				return fmt.Errorf("failed to get upload: %w", err)
-			}
-			defer func() {
-				_ = r.Close()
-			}()
-
-			if ctx.Err() != nil {
-				return ctx.Err()
-			}
-
-			if _, err := dstBackend.Put(ctx, r, path, meta.Size, backends.PutOptions{
-				OriginalName: meta.OriginalName,
-				Expiry:       meta.Expiry,
-				DeleteKey:    meta.DeleteKey,
-				AccessKey:    meta.AccessKey,
-				Salt:         meta.Salt,
-			}); err != nil {
-				return fmt.Errorf("failed to put upload: %w", err)
-			}
-
-			if !config.Default.NoLogs {
+			if !config.Default().NoLogs {
 				slog.Info("Migrated upload", "name", path)
 			}
 			return nil
@@ -114,14 +92,70 @@ func run(cmd *cobra.Command, _ []string) error {
 	return err
 }
 
+// migrateUpload copies an upload and its metadata from src to dst.
+func migrateUpload(ctx context.Context, src, dst backends.StorageBackend, path string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	meta, r, err := src.Get(ctx, path)
+	if err != nil {
+		return fmt.Errorf("failed to get upload: %w", err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	opts := backends.PutOptions{
+		OriginalName:  meta.OriginalName,
+		Expiry:        meta.Expiry,
+		DeleteKey:     meta.DeleteKey,
+		AccessKey:     meta.AccessKey,
+		Salt:          meta.Salt,
+		Uploader:      meta.Uploader,
+		DownloadsLeft: meta.DownloadsLeft,
+		Encrypted:     meta.Encrypted,
+		WrappedKey:    meta.WrappedKey,
+	}
+	switch {
+	case meta.Encrypted || meta.WrappedKey != "":
+		// Encrypted contents can't be inspected, so the stored type and archive listing are copied.
+		opts.Mimetype = meta.Mimetype
+	case meta.Mimetype == backends.CollectionMimetype:
+		// A collection manifest would be detected as JSON.
+		opts.Mimetype = meta.Mimetype
+	}
+
+	m, err := dst.Put(ctx, r, path, meta.Size, opts)
+	if err != nil {
+		return fmt.Errorf("failed to put upload: %w", err)
+	}
+	copyArchive := opts.Mimetype != "" && len(meta.ArchiveFiles) != 0
+	if copyArchive || meta.ShareSecret != "" {
+		if copyArchive {
+			m.ArchiveFiles = meta.ArchiveFiles
+		}
+		// Keeping the secret keeps existing share links valid.
+		m.ShareSecret = meta.ShareSecret
+		if err := dst.PutMetadata(ctx, path, m); err != nil {
+			return fmt.Errorf("failed to put metadata: %w", err)
+		}
+	}
+	return nil
+}
+
 var ErrUnknownBackend = errors.New("unknown backend")
 
 func newBackend(ctx context.Context, name string) (backends.ListBackend, error) {
 	switch name {
 	case "s3":
-		return config.Default.NewS3Backend(ctx)
+		return config.Default().NewS3Backend(ctx)
 	case "local":
-		return config.Default.NewLocalBackend()
+		return config.Default().NewLocalBackend()
 	}
 	return nil, fmt.Errorf("%w: %s", ErrUnknownBackend, name)
 }
@@ -0,0 +1,45 @@
+package migrate
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/localfs/localfstest"
+	"gabe565.com/linx-server/internal/collection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateUpload(t *testing.T) {
+	src, dst := localfstest.New(t), localfstest.New(t)
+
+	var manifest collection.Manifest
+	for _, name := range []string{"a.txt", "b.txt"} {
+		m, err := src.Put(t.Context(), strings.NewReader("File "+name), name, 0, backends.PutOptions{})
+		require.NoError(t, err)
+		manifest.Files = append(manifest.Files, collection.File{Name: name, Checksum: m.Checksum})
+	}
+	b, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	_, err = src.Put(t.Context(), strings.NewReader(string(b)), "c.json", int64(len(b)), backends.PutOptions{
+		Mimetype: backends.CollectionMimetype,
+	})
+	require.NoError(t, err)
+
+	for key, err := range src.List(t.Context()) {
+		require.NoError(t, err)
+		require.NoError(t, migrateUpload(t.Context(), src, dst, key))
+	}
+
+	m, err := dst.Head(t.Context(), "c.json")
+	require.NoError(t, err)
+	assert.True(t, collection.Is(m))
+
+	migrated, err := collection.Read(t.Context(), dst, "c.json")
+	require.NoError(t, err)
+	members, err := migrated.Members(t.Context(), dst)
+	require.NoError(t, err)
+	assert.Len(t, members, 2)
+}
@@ -0,0 +1,129 @@
+package reindex
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/index"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/utils/must"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+const Concurrency = "concurrency"
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reindex",
+		Short: "Import existing upload metadata into the metadata index",
+		Args:  cobra.NoArgs,
+		RunE:  run,
+
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+	config.Default().RegisterBasicFlags(cmd)
+	config.RegisterBasicCompletions(cmd)
+
+	cmd.Flags().Int(Concurrency, 4, "Number of uploads to import in parallel")
+
+	cmd.Flags().Lookup(config.FlagNoLogs).Usage = "Disable logging of imported files"
+
+	return cmd
+}
+
+var (
+	ErrNoIndex     = errors.New("metadata-index is not configured")
+	ErrUnsupported = errors.New("backend does not support listing files")
+)
+
+func run(cmd *cobra.Command, _ []string) error {
+	if err := config.Default().Load(cmd); err != nil {
+		return err
+	}
+
+	cmd.SilenceUsage = true
+
+	if config.Default().MetadataIndex == "" {
+		return ErrNoIndex
+	}
+
+	storage, err := config.Default().NewStorageBackend(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	backend := storage.(index.Backend) //nolint:errcheck
+	defer func() {
+		_ = backend.Close()
+	}()
+
+	lister, ok := backend.Unwrap().(backends.ListBackend)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	defer cancel()
+
+	group, ctx := errgroup.WithContext(ctx)
+	concurrency := must.Must2(cmd.Flags().GetInt(Concurrency))
+	group.SetLimit(concurrency)
+
+	// Metadata is read from the storage backend, which holds the JSON sidecars or S3 user metadata.
+	var imported atomic.Int64
+	var seen sync.Map
+	for path, err := range lister.List(ctx) {
+		group.Go(func() error {
+			if err != nil {
+				return fmt.Errorf("failed to list uploads: %w", err)
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			seen.Store(path, struct{}{})
+			if err := backend.Refresh(ctx, path); err != nil {
+				return fmt.Errorf("failed to import upload %q: %w", path, err)
+			}
+
+			imported.Add(1)
+			if !config.Default().NoLogs {
+				slog.Info("Imported upload", "name", path)
+			}
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return err
+	}
+
+	// Remove uploads which were deleted outside of linx-server.
+	store := backend.Store()
+	var removed int64
+	for path, err := range store.List(ctx) {
+		if err != nil {
+			return fmt.Errorf("failed to list indexed uploads: %w", err)
+		}
+
+		if _, ok := seen.Load(path); !ok {
+			if err := store.Delete(ctx, path); err != nil {
+				return fmt.Errorf("failed to remove upload %q from index: %w", path, err)
+			}
+			removed++
+		}
+	}
+
+	if !config.Default().NoLogs {
+		slog.Info("Reindex finished", "imported", imported.Load(), "removed", removed)
+	}
+	return nil
+}
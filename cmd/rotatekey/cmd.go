@@ -0,0 +1,135 @@
+package rotatekey
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/atrest"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/utils/must"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+const Concurrency = "concurrency"
+
+func New() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rotate-key",
+		Short: "Re-encrypt the data keys of existing uploads with the current master key",
+		Long: `Re-encrypt the data keys of existing uploads with the current master key.
+
+Add a new key to the top of the encryption key file, run this command, then remove the old key.
+Only metadata is rewritten, so uploads are not copied.`,
+		Args: cobra.NoArgs,
+		RunE: run,
+
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+	config.Default().RegisterBasicFlags(cmd)
+	config.RegisterBasicCompletions(cmd)
+
+	cmd.Flags().Int(Concurrency, 4, "Number of uploads to rotate in parallel")
+
+	cmd.Flags().Lookup(config.FlagNoLogs).Usage = "Disable logging of rotated files"
+
+	return cmd
+}
+
+var ErrNotEncrypted = errors.New("encryption at rest is not configured")
+
+func run(cmd *cobra.Command, _ []string) error {
+	if err := config.Default().Load(cmd); err != nil {
+		return err
+	}
+
+	cmd.SilenceUsage = true
+
+	if !config.Default().Encryption.Enabled() {
+		return ErrNotEncrypted
+	}
+
+	storage, err := config.Default().NewStorageBackend(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	// The metadata index is skipped, since the wrapped keys are only stored by the underlying backend.
+	backend, err := findRotator(storage)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	defer cancel()
+
+	group, ctx := errgroup.WithContext(ctx)
+	concurrency := must.Must2(cmd.Flags().GetInt(Concurrency))
+	group.SetLimit(concurrency)
+
+	var rotated, unencrypted atomic.Int64
+	for path, err := range backend.List(ctx) {
+		group.Go(func() error {
+			if err != nil {
+				return fmt.Errorf("failed to list uploads: %w", err)
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			changed, err := backend.Rotate(ctx, path)
+			if err != nil {
+				switch {
+				case errors.Is(err, backends.ErrNotFound):
+					return nil
+				case errors.Is(err, atrest.ErrNotEncrypted):
+					unencrypted.Add(1)
+					return nil
+				}
+				return fmt.Errorf("failed to rotate upload %q: %w", path, err)
+			}
+
+			if changed {
+				rotated.Add(1)
+				if !config.Default().NoLogs {
+					slog.Info("Rotated upload", "name", path)
+				}
+			}
+			return nil
+		})
+	}
+
+	err = group.Wait()
+	if !config.Default().NoLogs {
+		slog.Info("Key rotation finished", "rotated", rotated.Load(), "unencrypted", unencrypted.Load())
+	}
+	if err == nil && unencrypted.Load() != 0 {
+		slog.Warn("Uploads stored before encryption was enabled are not encrypted", "count", unencrypted.Load())
+	}
+	return err
+}
+
+var ErrUnsupported = errors.New("backend does not support listing files")
+
+// findRotator returns the at-rest encryption wrapper from a chain of wrapped backends.
+func findRotator(b backends.StorageBackend) (atrest.Rotator, error) { //nolint:ireturn
+	for {
+		if rotator, ok := b.(atrest.Rotator); ok {
+			return rotator, nil
+		}
+		w, ok := b.(interface {
+			Unwrap() backends.StorageBackend
+		})
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		b = w.Unwrap()
+	}
+}
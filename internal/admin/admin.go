@@ -0,0 +1,219 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/handlers"
+	"gabe565.com/linx-server/internal/headers"
+	"gabe565.com/linx-server/internal/webhook"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 1000
+)
+
+// File is the admin view of an upload. Hashed keys are never included.
+type File struct {
+	Filename      string                  `json:"filename"`
+	URL           string                  `json:"url"`
+	OriginalName  string                  `json:"original_name,omitzero"`
+	Uploader      string                  `json:"uploader,omitzero"`
+	Mimetype      string                  `json:"mimetype"`
+	Size          int64                   `json:"size"`
+	Checksum      string                  `json:"checksum"`
+	ModTime       time.Time               `json:"mod_time"`
+	Expiry        time.Time               `json:"expiry,omitzero"`
+	Protected     bool                    `json:"protected"`
+	ArchiveFiles  []backends.ArchiveEntry `json:"archive_files,omitzero"`
+	DownloadsLeft int                     `json:"downloads_left,omitzero"`
+	Encrypted     bool                    `json:"encrypted,omitzero"`
+}
+
+func NewFile(r *http.Request, name string, m backends.Metadata) File {
+	return File{
+		Filename:      name,
+		URL:           headers.GetFileURL(r, name).String(),
+		OriginalName:  m.OriginalName,
+		Uploader:      m.Uploader,
+		Mimetype:      m.Mimetype,
+		Size:          m.Size,
+		Checksum:      m.Checksum,
+		ModTime:       m.ModTime,
+		Expiry:        m.Expiry,
+		Protected:     m.AccessKey != "",
+		ArchiveFiles:  m.ArchiveFiles,
+		DownloadsLeft: m.DownloadsLeft,
+		Encrypted:     m.Encrypted,
+	}
+}
+
+type ListResponse struct {
+	Files  []File `json:"files"`
+	Total  int    `json:"total"`
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+}
+
+type PatchRequest struct {
+	Expiry *string `json:"expiry"`
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func errorMsg(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	handlers.ErrorType(w, r, handlers.RespJSON, status, msg)
+}
+
+// ListFiles returns a page of uploads which match the request's filters.
+func ListFiles(w http.ResponseWriter, r *http.Request) {
+	backend, ok := config.StorageBackend.(backends.ListBackend)
+	if !ok {
+		errorMsg(w, r, http.StatusNotImplemented, "Storage backend does not support listing")
+		return
+	}
+
+	q := r.URL.Query()
+	filter, err := ParseFilter(q)
+	if err != nil {
+		errorMsg(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	res := ListResponse{
+		Files: make([]File, 0),
+		Limit: DefaultLimit,
+	}
+	if v := q.Get("limit"); v != "" {
+		if res.Limit, err = strconv.Atoi(v); err != nil || res.Limit < 1 {
+			errorMsg(w, r, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		res.Limit = min(res.Limit, MaxLimit)
+	}
+	if v := q.Get("offset"); v != "" {
+		if res.Offset, err = strconv.Atoi(v); err != nil || res.Offset < 0 {
+			errorMsg(w, r, http.StatusBadRequest, "offset must be a positive integer")
+			return
+		}
+	}
+
+	now := time.Now()
+	for name, err := range backend.List(r.Context()) {
+		if err != nil {
+			slog.Error("Failed to list uploads", "error", err)
+			errorMsg(w, r, http.StatusInternalServerError, "Failed to list uploads")
+			return
+		}
+
+		m, err := backend.Head(r.Context(), name)
+		if err != nil {
+			if !errors.Is(err, backends.ErrNotFound) {
+				slog.Warn("Failed to read upload metadata", "name", name, "error", err)
+			}
+			continue
+		}
+
+		if !filter.Match(name, m, now) {
+			continue
+		}
+
+		if res.Total >= res.Offset && len(res.Files) < res.Limit {
+			res.Files = append(res.Files, NewFile(r, name, m))
+		}
+		res.Total++
+	}
+
+	writeJSON(w, res)
+}
+
+// GetFile returns a single upload's metadata.
+func GetFile(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	m, ok := head(w, r, name)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, NewFile(r, name, m))
+}
+
+// DeleteFile removes an upload without requiring its delete key.
+func DeleteFile(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	m, ok := head(w, r, name)
+	if !ok {
+		return
+	}
+
+	if err := config.StorageBackend.Delete(r.Context(), name); err != nil {
+		slog.Error("Failed to delete upload", "name", name, "error", err)
+		errorMsg(w, r, http.StatusInternalServerError, "Failed to delete upload")
+		return
+	}
+	config.Hooks.Send(r.Context(), webhook.EventDelete, name, m)
+
+	if !config.Default().NoLogs {
+		slog.Info("Admin deleted upload", "name", name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PatchFile updates an upload's metadata.
+func PatchFile(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	var req PatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorMsg(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	m, ok := head(w, r, name)
+	if !ok {
+		return
+	}
+
+	if req.Expiry != nil {
+		expiry, err := ParseExpiry(*req.Expiry, time.Now())
+		if err != nil {
+			errorMsg(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		m.Expiry = expiry
+	}
+
+	if err := config.StorageBackend.PutMetadata(r.Context(), name, m); err != nil {
+		slog.Error("Failed to update upload metadata", "name", name, "error", err)
+		errorMsg(w, r, http.StatusInternalServerError, "Failed to update upload")
+		return
+	}
+
+	writeJSON(w, NewFile(r, name, m))
+}
+
+func head(w http.ResponseWriter, r *http.Request, name string) (backends.Metadata, bool) {
+	m, err := config.StorageBackend.Head(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, backends.ErrNotFound) {
+			errorMsg(w, r, http.StatusNotFound, "File not found")
+		} else {
+			slog.Error("Failed to read upload metadata", "name", name, "error", err)
+			errorMsg(w, r, http.StatusInternalServerError, "Failed to read upload")
+		}
+		return m, false
+	}
+	return m, true
+}
@@ -0,0 +1,176 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/utils/bytefmt"
+)
+
+// Filter selects uploads in the admin file list. Zero values are ignored.
+type Filter struct {
+	Name          string
+	Mimetype      string
+	MinSize       int64
+	MaxSize       int64
+	OlderThan     time.Duration
+	NewerThan     time.Duration
+	ExpiresBefore time.Time
+	ExpiresAfter  time.Time
+	NeverExpires  *bool
+}
+
+var (
+	ErrInvalidSize     = errors.New("invalid size")
+	ErrInvalidDuration = errors.New("invalid duration")
+	ErrInvalidTime     = errors.New("invalid time")
+	ErrInvalidBool     = errors.New("invalid boolean")
+	ErrInvalidExpiry   = errors.New("invalid expiry")
+)
+
+// ParseFilter reads a Filter from query parameters.
+func ParseFilter(q url.Values) (Filter, error) {
+	f := Filter{
+		Name:     strings.ToLower(q.Get("name")),
+		Mimetype: strings.ToLower(q.Get("mimetype")),
+	}
+
+	var errs []error
+	parse := func(key string, fn func(string) error) {
+		if v := q.Get(key); v != "" {
+			if err := fn(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+		}
+	}
+
+	parse("min_size", func(s string) (err error) {
+		f.MinSize, err = parseSize(s)
+		return err
+	})
+	parse("max_size", func(s string) (err error) {
+		f.MaxSize, err = parseSize(s)
+		return err
+	})
+	parse("older_than", func(s string) (err error) {
+		f.OlderThan, err = parseDuration(s)
+		return err
+	})
+	parse("newer_than", func(s string) (err error) {
+		f.NewerThan, err = parseDuration(s)
+		return err
+	})
+	parse("expires_before", func(s string) (err error) {
+		f.ExpiresBefore, err = parseTime(s)
+		return err
+	})
+	parse("expires_after", func(s string) (err error) {
+		f.ExpiresAfter, err = parseTime(s)
+		return err
+	})
+	parse("never_expires", func(s string) error {
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return ErrInvalidBool
+		}
+		f.NeverExpires = &v
+		return nil
+	})
+
+	return f, errors.Join(errs...)
+}
+
+// Match reports whether an upload is selected by the filter.
+func (f Filter) Match(name string, m backends.Metadata, now time.Time) bool {
+	if f.Name != "" &&
+		!strings.Contains(strings.ToLower(name), f.Name) &&
+		!strings.Contains(strings.ToLower(m.OriginalName), f.Name) {
+		return false
+	}
+
+	if f.Mimetype != "" {
+		mimetype := strings.ToLower(m.Mimetype)
+		if strings.HasSuffix(f.Mimetype, "/") || strings.HasSuffix(f.Mimetype, "/*") {
+			if !strings.HasPrefix(mimetype, strings.TrimSuffix(f.Mimetype, "*")) {
+				return false
+			}
+		} else if mimetype != f.Mimetype {
+			return false
+		}
+	}
+
+	switch {
+	case f.MinSize != 0 && m.Size < f.MinSize,
+		f.MaxSize != 0 && m.Size > f.MaxSize,
+		f.OlderThan != 0 && m.ModTime.After(now.Add(-f.OlderThan)),
+		f.NewerThan != 0 && m.ModTime.Before(now.Add(-f.NewerThan)),
+		f.NeverExpires != nil && *f.NeverExpires != m.Expiry.IsZero():
+		return false
+	}
+
+	if !f.ExpiresBefore.IsZero() && (m.Expiry.IsZero() || !m.Expiry.Before(f.ExpiresBefore)) {
+		return false
+	}
+	if !f.ExpiresAfter.IsZero() && !m.Expiry.IsZero() && !m.Expiry.After(f.ExpiresAfter) {
+		return false
+	}
+
+	return true
+}
+
+func parseSize(s string) (int64, error) {
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return v, nil
+	}
+	v, err := bytefmt.Decode(s)
+	if err != nil {
+		return 0, ErrInvalidSize
+	}
+	return v, nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if v, err := time.ParseDuration(s); err == nil {
+		return v, nil
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(v) * time.Second, nil
+	}
+	return 0, ErrInvalidDuration
+}
+
+func parseTime(s string) (time.Time, error) {
+	if v, err := time.Parse(time.RFC3339, s); err == nil {
+		return v, nil
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(v, 0), nil
+	}
+	return time.Time{}, ErrInvalidTime
+}
+
+// ParseExpiry parses a new expiry for an upload.
+// It accepts an RFC 3339 timestamp, or a duration or number of seconds from now. "0" and "never" remove the expiry.
+// Unlike uploads, the configured max expiry is not enforced.
+func ParseExpiry(s string, now time.Time) (time.Time, error) {
+	switch s {
+	case "", "0", "never":
+		return time.Time{}, nil
+	}
+
+	if v, err := time.Parse(time.RFC3339, s); err == nil {
+		return v, nil
+	}
+	if v, err := time.ParseDuration(s); err == nil && v > 0 {
+		return now.Add(v), nil
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil && v > 0 {
+		return now.Add(time.Duration(v) * time.Second), nil
+	}
+	return time.Time{}, ErrInvalidExpiry
+}
@@ -2,19 +2,23 @@ package apikeys
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
-	"gabe565.com/linx-server/internal/auth/keyhash"
 	"gabe565.com/linx-server/internal/util"
 )
 
 type AuthOptions struct {
-	AuthFile      string
+	AuthKeys      []Key
+	Scopes        []Scope // If set, keys must have one of these scopes
 	UnauthMethods []string
 	BasicAuth     bool
 	SiteName      string
@@ -23,44 +27,49 @@ type AuthOptions struct {
 
 type Middleware struct {
 	successHandler http.Handler
-	authKeys       []string
+	authKeys       []Key
 	o              AuthOptions
 }
 
-func ReadAuthKeys(authFile string) []string {
-	var authKeys []string
+var ErrNoValidKeys = errors.New("authfile contains no valid keys")
 
-	f, err := os.Open(authFile)
+// ReadAuthKeys loads an auth file. Keys in the flat format are given the default scopes.
+// Invalid keys are skipped, but a file where every key is invalid is rejected.
+func ReadAuthKeys(authFile string, scopes ...Scope) ([]Key, error) {
+	b, err := os.ReadFile(authFile)
 	if err != nil {
-		slog.Error("Failed to open authfile", "error", err)
-		os.Exit(1)
+		return nil, err
 	}
-	defer func() {
-		_ = f.Close()
-	}()
 
-	scanner := bufio.NewScanner(f)
+	if keys, ok := parseKeyFile(b, scopes); ok {
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("%s: %w", authFile, ErrNoValidKeys)
+		}
+		return keys, nil
+	}
+
+	var authKeys []Key
+	var invalid int
+	scanner := bufio.NewScanner(bytes.NewReader(b))
 	for i := 0; scanner.Scan(); i++ {
 		if len(scanner.Bytes()) == 0 || scanner.Bytes()[0] == '#' {
 			continue
 		}
 
-		key := scanner.Text()
-		if i := strings.Index(key, "#"); i != -1 {
-			key = key[:i]
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
 		}
-		key = strings.TrimSpace(key)
+		line = strings.TrimSpace(line)
 
-		if key == "" {
+		if line == "" {
 			continue
 		}
 
-		if !strings.HasPrefix(key, keyhash.KeyPrefix) {
-			key = keyhash.KeyPrefix + key
-		}
-
-		if !keyhash.IsValidHash(key, false) {
-			slog.Warn("Skipping invalid key in authfile", "line", i+1)
+		key, err := ParseKey(line, scopes...)
+		if err != nil {
+			slog.Warn("Skipping invalid key in authfile", "path", authFile, "line", i+1, "error", err)
+			invalid++
 			continue
 		}
 
@@ -68,11 +77,13 @@ func ReadAuthKeys(authFile string) []string {
 	}
 
 	if err := scanner.Err(); err != nil {
-		slog.Error("Scanner error while reading authfile", "error", err)
-		os.Exit(1) //nolint:gocritic
+		return nil, err
 	}
 
-	return slices.Clip(authKeys)
+	if len(authKeys) == 0 && invalid != 0 {
+		return nil, fmt.Errorf("%s: %w", authFile, ErrNoValidKeys)
+	}
+	return slices.Clip(authKeys), nil
 }
 
 func (a Middleware) getSitePrefix() string {
@@ -115,6 +126,12 @@ func (a Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if authKey, ok := KeyFromContext(r.Context()); ok && (len(a.o.Scopes) == 0 || authKey.HasScope(a.o.Scopes...)) {
+		// already authenticated, for example by an OIDC session
+		successHandler.ServeHTTP(w, r)
+		return
+	}
+
 	key := util.TryPathUnescape(r.Header.Get("Linx-Api-Key"))
 	if key == "" && a.o.BasicAuth {
 		_, password, ok := r.BasicAuth()
@@ -123,21 +140,40 @@ func (a Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	result, err := keyhash.CheckList(a.authKeys, key, "", false)
-	if err != nil || !result {
+	authKey, ok, err := Match(a.authKeys, key)
+	if err != nil || !ok || !authKey.Allows(r, time.Now()) {
 		http.HandlerFunc(a.badAuthorizationHandler).ServeHTTP(w, r)
 		return
 	}
 
-	successHandler.ServeHTTP(w, r)
+	successHandler.ServeHTTP(w, r.WithContext(WithKey(r.Context(), authKey)))
 }
 
 func NewAPIKeysMiddleware(o AuthOptions) func(http.Handler) http.Handler {
+	authKeys := o.AuthKeys
+	if len(o.Scopes) != 0 {
+		authKeys = WithScope(authKeys, o.Scopes...)
+	}
+
 	return func(h http.Handler) http.Handler {
 		return Middleware{
 			successHandler: h,
-			authKeys:       ReadAuthKeys(o.AuthFile),
+			authKeys:       authKeys,
 			o:              o,
 		}
 	}
 }
+
+// RequireScope rejects requests which were authenticated by a key without the given scope.
+// Requests without a key are allowed, since they are only possible when authentication is disabled.
+func RequireScope(scope Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key, ok := KeyFromContext(r.Context()); ok && !key.HasScope(scope) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
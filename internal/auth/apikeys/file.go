@@ -0,0 +1,121 @@
+package apikeys
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"gabe565.com/utils/bytefmt"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// KeyFile is the structured auth file format, for example:
+//
+//	[[keys]]
+//	name = "ci"
+//	key = "scrypt$..."
+//	scopes = ["upload", "delete-any"]
+//	expires = 2030-01-01
+//	allowed-cidrs = ["10.0.0.0/8"]
+//	max-bytes = "10GiB"
+//	allow-mimetypes = ["application/x-executable"]
+type KeyFile struct {
+	Keys []KeyEntry `toml:"keys"`
+}
+
+type KeyEntry struct {
+	Name         string    `toml:"name"`
+	Key          string    `toml:"key"`
+	Scopes       []Scope   `toml:"scopes"`
+	Expires      time.Time `toml:"expires"`
+	AllowedCIDRs []string  `toml:"allowed-cidrs"`
+	MaxBytes     string    `toml:"max-bytes"`
+	MaxFiles     int64     `toml:"max-files"`
+	MaxSize      string    `toml:"max-size"`
+	MaxExpiry    string    `toml:"max-expiry"`
+
+	AllowMimetypes  []string `toml:"allow-mimetypes"`
+	AllowExtensions []string `toml:"allow-extensions"`
+}
+
+var ErrMissingName = errors.New("missing name")
+
+// Parse converts the entry to a Key. Entries without scopes are given the default scopes.
+func (e KeyEntry) Parse(scopes ...Scope) (Key, error) {
+	key := Key{
+		Name:            e.Name,
+		Scopes:          e.Scopes,
+		Expires:         e.Expires,
+		AllowMimetypes:  e.AllowMimetypes,
+		AllowExtensions: e.AllowExtensions,
+	}
+	if key.Name == "" {
+		return key, ErrMissingName
+	}
+	if err := key.setHash(e.Key); err != nil {
+		return key, err
+	}
+
+	if len(key.Scopes) == 0 {
+		key.Scopes = scopes
+	}
+	for _, s := range key.Scopes {
+		if !s.Valid() {
+			return key, fmt.Errorf("%w: %s", ErrUnknownScope, s)
+		}
+	}
+
+	for _, cidr := range e.AllowedCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return key, err
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		key.AllowedCIDRs = append(key.AllowedCIDRs, prefix.Masked())
+	}
+
+	var err error
+	if e.MaxBytes != "" {
+		if key.Limits.MaxBytes, err = bytefmt.Decode(e.MaxBytes); err != nil {
+			return key, fmt.Errorf("max-bytes: %w", err)
+		}
+	}
+	key.Limits.MaxFiles = e.MaxFiles
+	if e.MaxSize != "" {
+		if key.Limits.MaxSize, err = bytefmt.Decode(e.MaxSize); err != nil {
+			return key, fmt.Errorf("max-size: %w", err)
+		}
+	}
+	if e.MaxExpiry != "" {
+		if key.Limits.MaxExpiry, err = time.ParseDuration(e.MaxExpiry); err != nil {
+			return key, fmt.Errorf("max-expiry: %w", err)
+		}
+	}
+
+	return key, nil
+}
+
+// parseKeyFile parses the structured auth file format.
+// It returns false if b is not a structured auth file, so that it can be read as a flat list of keys.
+func parseKeyFile(b []byte, scopes []Scope) ([]Key, bool) {
+	var f KeyFile
+	if err := toml.Unmarshal(b, &f); err != nil || len(f.Keys) == 0 {
+		return nil, false
+	}
+
+	keys := make([]Key, 0, len(f.Keys))
+	for i, entry := range f.Keys {
+		key, err := entry.Parse(scopes...)
+		if err != nil {
+			slog.Warn("Skipping invalid key in authfile", "index", i, "name", entry.Name, "error", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, true
+}
@@ -0,0 +1,200 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gabe565.com/linx-server/internal/auth/keyhash"
+	"gabe565.com/utils/bytefmt"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type Scope string
+
+const (
+	ScopeUpload       Scope = "upload"
+	ScopeRemoteUpload Scope = "remote-upload"
+	ScopeDeleteAny    Scope = "delete-any"
+	ScopeAdmin        Scope = "admin"
+)
+
+func (s Scope) Valid() bool {
+	switch s {
+	case ScopeUpload, ScopeRemoteUpload, ScopeDeleteAny, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// Key is an entry in an auth file.
+type Key struct {
+	Name         string
+	Hash         string
+	Scopes       []Scope
+	Expires      time.Time
+	AllowedCIDRs []netip.Prefix
+	Limits       Limits
+	// AllowMimetypes and AllowExtensions are accepted from the key even if the content type policy rejects them.
+	AllowMimetypes  []string
+	AllowExtensions []string
+}
+
+// ID identifies the key without exposing its hash. It is recorded as the uploader of each file.
+func (k Key) ID() string {
+	if k.Name != "" {
+		return k.Name
+	}
+	sum := sha256.Sum256([]byte(k.Hash))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (k Key) HasScope(scopes ...Scope) bool {
+	for _, s := range scopes {
+		if slices.Contains(k.Scopes, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the key may be used for a request.
+func (k Key) Allows(r *http.Request, now time.Time) bool {
+	if !k.Expires.IsZero() && now.After(k.Expires) {
+		return false
+	}
+
+	if len(k.AllowedCIDRs) == 0 {
+		return true
+	}
+
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	return slices.ContainsFunc(k.AllowedCIDRs, func(p netip.Prefix) bool {
+		return p.Contains(addr)
+	})
+}
+
+// WithScope returns the keys which have any of the given scopes.
+func WithScope(keys []Key, scopes ...Scope) []Key {
+	return slices.DeleteFunc(slices.Clone(keys), func(k Key) bool {
+		return !k.HasScope(scopes...)
+	})
+}
+
+// Limits restricts the uploads created with a key. Zero values are unlimited.
+type Limits struct {
+	MaxBytes  int64
+	MaxFiles  int64
+	MaxSize   int64
+	MaxExpiry time.Duration
+}
+
+var (
+	ErrInvalidKey    = errors.New("invalid key")
+	ErrUnknownOption = errors.New("unknown option")
+	ErrUnknownScope  = errors.New("unknown scope")
+)
+
+// ParseKey parses a line of a flat auth file.
+// The scrypted key may be followed by space-separated limits, for example:
+//
+//	<key> max-bytes=10GiB max-files=1000 max-size=500MiB max-expiry=168h allow-extensions=exe,bin
+func ParseKey(line string, scopes ...Scope) (Key, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return Key{}, ErrInvalidKey
+	}
+
+	key := Key{Scopes: scopes}
+	if err := key.setHash(fields[0]); err != nil {
+		return key, err
+	}
+
+	for _, field := range fields[1:] {
+		k, v, _ := strings.Cut(field, "=")
+		var err error
+		switch k {
+		case "max-bytes":
+			key.Limits.MaxBytes, err = bytefmt.Decode(v)
+		case "max-files":
+			key.Limits.MaxFiles, err = strconv.ParseInt(v, 10, 64)
+		case "max-size":
+			key.Limits.MaxSize, err = bytefmt.Decode(v)
+		case "max-expiry":
+			key.Limits.MaxExpiry, err = time.ParseDuration(v)
+		case "allow-mimetypes":
+			key.AllowMimetypes = strings.Split(v, ",")
+		case "allow-extensions":
+			key.AllowExtensions = strings.Split(v, ",")
+		default:
+			err = ErrUnknownOption
+		}
+		if err != nil {
+			return key, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+
+	return key, nil
+}
+
+func (k *Key) setHash(hash string) error {
+	if !strings.HasPrefix(hash, keyhash.KeyPrefix) {
+		hash = keyhash.KeyPrefix + hash
+	}
+	if !keyhash.IsValidHash(hash, false) {
+		return ErrInvalidKey
+	}
+	k.Hash = hash
+	return nil
+}
+
+// Match returns the key which matches request.
+func Match(keys []Key, request string) (Key, bool, error) {
+	hashes := make([]string, 0, len(keys))
+	for _, k := range keys {
+		hashes = append(hashes, k.Hash)
+	}
+
+	i, err := keyhash.MatchList(hashes, request, "", false)
+	if err != nil || i == -1 {
+		return Key{}, false, err
+	}
+	return keys[i], true, nil
+}
+
+type ctxKey uint8
+
+const keyCtx ctxKey = iota
+
+// WithKey returns a copy of ctx which holds the key that authenticated a request.
+// The key is also added to the request log.
+func WithKey(ctx context.Context, key Key) context.Context {
+	if entry, ok := ctx.Value(middleware.LogEntryCtxKey).(interface{ SetKeyName(name string) }); ok {
+		entry.SetKeyName(key.ID())
+	}
+	return context.WithValue(ctx, keyCtx, key)
+}
+
+// KeyFromContext returns the key that authenticated a request.
+func KeyFromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(keyCtx).(Key)
+	return key, ok
+}
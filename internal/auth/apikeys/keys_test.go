@@ -0,0 +1,144 @@
+package apikeys
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gabe565.com/linx-server/internal/auth/keyhash"
+	"gabe565.com/utils/bytefmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKey(t *testing.T) {
+	hash, err := keyhash.Hash("secret", "", false)
+	require.NoError(t, err)
+
+	key, err := ParseKey(hash + " max-bytes=1GiB max-files=10 max-size=10MiB max-expiry=24h allow-extensions=exe,bin")
+	require.NoError(t, err)
+	assert.Equal(t, hash, key.Hash)
+	assert.Equal(t, Limits{
+		MaxBytes:  bytefmt.GiB,
+		MaxFiles:  10,
+		MaxSize:   10 * bytefmt.MiB,
+		MaxExpiry: 24 * time.Hour,
+	}, key.Limits)
+	assert.Equal(t, []string{"exe", "bin"}, key.AllowExtensions)
+
+	key, err = ParseKey(hash[len(keyhash.KeyPrefix):])
+	require.NoError(t, err)
+	assert.Equal(t, hash, key.Hash)
+
+	_, err = ParseKey("invalid")
+	require.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = ParseKey(hash + " max-unknown=1")
+	require.ErrorIs(t, err, ErrUnknownOption)
+}
+
+func TestMatch(t *testing.T) {
+	var keys []Key
+	for _, k := range []string{"a", "b"} {
+		hash, err := keyhash.Hash(k, "", false)
+		require.NoError(t, err)
+		keys = append(keys, Key{Hash: hash})
+	}
+
+	key, ok, err := Match(keys, "b")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, keys[1], key)
+
+	_, ok, err = Match(keys, "c")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestReadAuthKeys(t *testing.T) {
+	hash, err := keyhash.Hash("secret", "", false)
+	require.NoError(t, err)
+
+	t.Run("flat", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys")
+		require.NoError(t, os.WriteFile(path, []byte(hash+"\n"), 0o600))
+
+		keys, err := ReadAuthKeys(path, ScopeRemoteUpload)
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		assert.Equal(t, hash, keys[0].Hash)
+		assert.Equal(t, []Scope{ScopeRemoteUpload}, keys[0].Scopes)
+	})
+
+	t.Run("structured", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.toml")
+		require.NoError(t, os.WriteFile(path, []byte(`
+[[keys]]
+name = "ci"
+key = "`+hash+`"
+scopes = ["upload", "delete-any"]
+expires = 2030-01-01T00:00:00Z
+allowed-cidrs = ["10.0.0.0/8", "192.168.1.1"]
+max-bytes = "1GiB"
+allow-mimetypes = ["application/x-executable"]
+
+[[keys]]
+name = "default"
+key = "`+hash+`"
+
+[[keys]]
+name = "invalid"
+key = "`+hash+`"
+scopes = ["unknown"]
+`), 0o600))
+
+		keys, err := ReadAuthKeys(path, ScopeUpload)
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		assert.Equal(t, Key{
+			Name:    "ci",
+			Hash:    hash,
+			Scopes:  []Scope{ScopeUpload, ScopeDeleteAny},
+			Expires: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+			AllowedCIDRs: []netip.Prefix{
+				netip.MustParsePrefix("10.0.0.0/8"),
+				netip.MustParsePrefix("192.168.1.1/32"),
+			},
+			Limits:         Limits{MaxBytes: bytefmt.GiB},
+			AllowMimetypes: []string{"application/x-executable"},
+		}, keys[0])
+		assert.Equal(t, "default", keys[1].ID())
+		assert.Equal(t, []Scope{ScopeUpload}, keys[1].Scopes)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys")
+		require.NoError(t, os.WriteFile(path, []byte("invalid\n"), 0o600))
+
+		_, err := ReadAuthKeys(path)
+		require.ErrorIs(t, err, ErrNoValidKeys)
+
+		_, err = ReadAuthKeys(filepath.Join(t.TempDir(), "missing"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestKeyAllows(t *testing.T) {
+	now := time.Now()
+	key := Key{
+		Expires:      now.Add(time.Hour),
+		AllowedCIDRs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.1.2.3:1234"
+	assert.True(t, key.Allows(r, now))
+	assert.False(t, key.Allows(r, now.Add(2*time.Hour)))
+
+	r.RemoteAddr = "192.168.1.1:1234"
+	assert.False(t, key.Allows(r, now))
+}
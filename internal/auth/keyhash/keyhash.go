@@ -68,31 +68,37 @@ func Check(stored, request, salt string, urlSafe bool) (bool, error) {
 }
 
 func CheckList(stored []string, request, salt string, urlSafe bool) (bool, error) {
+	i, err := MatchList(stored, request, salt, urlSafe)
+	return i != -1, err
+}
+
+// MatchList returns the index of the stored hash which matches request, or -1.
+func MatchList(stored []string, request, salt string, urlSafe bool) (int, error) {
 	if salt == "" {
 		salt = scryptSalt
 	}
 
 	requestHash, err := scrypt.Key([]byte(request), []byte(salt), scryptN, scryptR, scryptP, scryptKeyLen)
 	if err != nil {
-		return false, err
+		return -1, err
 	}
 
 	prefix, encoding := getEncoding(urlSafe)
 
-	for _, entry := range stored {
+	for i, entry := range stored {
 		raw := strings.TrimPrefix(entry, prefix)
 
 		storedHash, err := encoding.DecodeString(raw)
 		if err != nil {
-			return false, err
+			return -1, err
 		}
 
 		if subtle.ConstantTimeCompare(storedHash, requestHash) == 1 {
-			return true, nil
+			return i, nil
 		}
 	}
 
-	return false, nil
+	return -1, nil
 }
 
 func CheckWithFallback(stored, request, salt string) (bool, error) {
@@ -0,0 +1,88 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"gabe565.com/linx-server/internal/headers"
+)
+
+var ErrInvalidCookie = errors.New("invalid cookie")
+
+// sign encodes v as JSON and appends an HMAC so that it can be stored in a cookie and trusted when read back.
+func sign(key []byte, v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac(key, payload)), nil
+}
+
+// verify checks the HMAC of a value created by sign, then decodes it into v.
+func verify(key []byte, s string, v any) error {
+	payload, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return ErrInvalidCookie
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(b, mac(key, payload)) {
+		return ErrInvalidCookie
+	}
+
+	if b, err = base64.RawURLEncoding.DecodeString(payload); err != nil {
+		return ErrInvalidCookie
+	}
+	return json.Unmarshal(b, v)
+}
+
+func mac(key []byte, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+func (p *Provider) setCookie(w http.ResponseWriter, r *http.Request, name string, v any, expires time.Time) error {
+	value, err := sign(p.o.SessionKey, v)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     sitePath(r),
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   headers.GetSiteURL(r).Scheme == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (p *Provider) readCookie(r *http.Request, name string, v any) error {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return err
+	}
+	return verify(p.o.SessionKey, c.Value, v)
+}
+
+func clearCookie(w http.ResponseWriter, r *http.Request, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     sitePath(r),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   headers.GetSiteURL(r).Scheme == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,261 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gabe565.com/linx-server/internal/auth/apikeys"
+	"gabe565.com/linx-server/internal/handlers"
+	"gabe565.com/linx-server/internal/headers"
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	LoginPath    = "auth/oidc/login"
+	CallbackPath = "auth/oidc/callback"
+	LogoutPath   = "auth/oidc/logout"
+
+	SessionCookie = "linx_session"
+	stateCookie   = "linx_oidc_state"
+	stateExpiry   = 10 * time.Minute
+)
+
+type Options struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	Scopes        []string
+	GroupsClaim   string
+	AllowedGroups []string // If set, users must be a member of one of these groups
+	SessionExpiry time.Duration
+	SessionKey    []byte // If unset, a random key is used until the process exits
+}
+
+// Provider logs users in with an OpenID Connect issuer using the authorization code flow with PKCE.
+type Provider struct {
+	o        Options
+	provider *gooidc.Provider
+	verifier *gooidc.IDTokenVerifier
+}
+
+// randomKey is shared between providers so that sessions survive a config reload.
+//
+//nolint:gochecknoglobals
+var randomKey = sync.OnceValue(func() []byte {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return b
+})
+
+// New discovers the issuer's endpoints.
+func New(ctx context.Context, o Options) (*Provider, error) {
+	provider, err := gooidc.NewProvider(ctx, o.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(o.SessionKey) == 0 {
+		o.SessionKey = randomKey()
+	}
+	if !slices.Contains(o.Scopes, gooidc.ScopeOpenID) {
+		o.Scopes = append([]string{gooidc.ScopeOpenID}, o.Scopes...)
+	}
+
+	return &Provider{
+		o:        o,
+		provider: provider,
+		verifier: provider.Verifier(&gooidc.Config{ClientID: o.ClientID}),
+	}, nil
+}
+
+func (p *Provider) oauth2Config(r *http.Request) *oauth2.Config {
+	redirect := headers.GetSiteURL(r)
+	redirect.Path = path.Join(redirect.Path, CallbackPath)
+
+	return &oauth2.Config{
+		ClientID:     p.o.ClientID,
+		ClientSecret: p.o.ClientSecret,
+		Endpoint:     p.provider.Endpoint(),
+		RedirectURL:  redirect.String(),
+		Scopes:       p.o.Scopes,
+	}
+}
+
+type state struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Redirect string    `json:"redirect"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Session is stored in a signed cookie once a user has logged in.
+type Session struct {
+	Subject string    `json:"sub"`
+	Expires time.Time `json:"exp"`
+}
+
+// Key returns the identity used to authorize requests made with the session.
+func (s Session) Key() apikeys.Key {
+	return apikeys.Key{
+		Name:   s.Subject,
+		Scopes: []apikeys.Scope{apikeys.ScopeUpload},
+	}
+}
+
+// Login redirects to the issuer.
+func (p *Provider) Login(w http.ResponseWriter, r *http.Request) {
+	s := state{
+		State:    rand.Text(),
+		Nonce:    rand.Text(),
+		Verifier: oauth2.GenerateVerifier(),
+		Redirect: redirectPath(r, r.FormValue("redirect")),
+		Expires:  time.Now().Add(stateExpiry),
+	}
+	if err := p.setCookie(w, r, stateCookie, s, s.Expires); err != nil {
+		slog.Error("Failed to create OIDC state", "error", err)
+		handlers.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	u := p.oauth2Config(r).AuthCodeURL(s.State, gooidc.Nonce(s.Nonce), oauth2.S256ChallengeOption(s.Verifier))
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// Callback completes a login and creates the session.
+func (p *Provider) Callback(w http.ResponseWriter, r *http.Request) {
+	var s state
+	if err := p.readCookie(r, stateCookie, &s); err != nil || time.Now().After(s.Expires) ||
+		r.FormValue("state") != s.State {
+		handlers.ErrorMsg(w, r, http.StatusBadRequest, "Login expired, please try again")
+		return
+	}
+	clearCookie(w, r, stateCookie)
+
+	if e := r.FormValue("error"); e != "" {
+		slog.Warn("OIDC login failed", "error", e, "description", r.FormValue("error_description"))
+		handlers.ErrorMsg(w, r, http.StatusUnauthorized, "Login failed")
+		return
+	}
+
+	subject, err := p.exchange(r, s)
+	if err != nil {
+		if errors.Is(err, ErrNotAllowed) {
+			handlers.ErrorMsg(w, r, http.StatusForbidden, "You are not allowed to upload to this server")
+			return
+		}
+		slog.Warn("OIDC login failed", "error", err)
+		handlers.ErrorMsg(w, r, http.StatusUnauthorized, "Login failed")
+		return
+	}
+
+	session := Session{Subject: subject, Expires: time.Now().Add(p.o.SessionExpiry)}
+	if err := p.setCookie(w, r, SessionCookie, session, session.Expires); err != nil {
+		slog.Error("Failed to create session", "error", err)
+		handlers.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, s.Redirect, http.StatusFound)
+}
+
+var (
+	ErrMissingIDToken = errors.New("token response did not include an id_token")
+	ErrInvalidNonce   = errors.New("invalid nonce")
+	ErrNotAllowed     = errors.New("user is not a member of an allowed group")
+)
+
+// exchange redeems the authorization code and returns the user's subject.
+func (p *Provider) exchange(r *http.Request, s state) (string, error) {
+	token, err := p.oauth2Config(r).Exchange(r.Context(), r.FormValue("code"), oauth2.VerifierOption(s.Verifier))
+	if err != nil {
+		return "", err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", ErrMissingIDToken
+	}
+
+	idToken, err := p.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		return "", err
+	}
+	if idToken.Nonce != s.Nonce {
+		return "", ErrInvalidNonce
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return "", err
+	}
+	if !p.allowed(claims) {
+		return "", ErrNotAllowed
+	}
+
+	return idToken.Subject, nil
+}
+
+func (p *Provider) allowed(claims map[string]any) bool {
+	if len(p.o.AllowedGroups) == 0 {
+		return true
+	}
+
+	switch groups := claims[p.o.GroupsClaim].(type) {
+	case string:
+		return slices.Contains(p.o.AllowedGroups, groups)
+	case []any:
+		return slices.ContainsFunc(groups, func(g any) bool {
+			s, ok := g.(string)
+			return ok && slices.Contains(p.o.AllowedGroups, s)
+		})
+	}
+	return false
+}
+
+// Logout removes the session cookie.
+func (p *Provider) Logout(w http.ResponseWriter, r *http.Request) {
+	clearCookie(w, r, SessionCookie)
+	http.Redirect(w, r, redirectPath(r, r.FormValue("redirect")), http.StatusFound)
+}
+
+// Session returns the request's session if it is valid.
+func (p *Provider) Session(r *http.Request) (Session, bool) {
+	var s Session
+	if err := p.readCookie(r, SessionCookie, &s); err != nil || s.Subject == "" || time.Now().After(s.Expires) {
+		return s, false
+	}
+	return s, true
+}
+
+// Middleware authorizes requests which have a valid session.
+// Requests without a session are passed on unchanged so that API keys can still be used.
+func (p *Provider) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s, ok := p.Session(r); ok {
+			r = r.WithContext(apikeys.WithKey(r.Context(), s.Key()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redirectPath only allows redirects to a local path.
+func redirectPath(r *http.Request, p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.Contains(p, `\`) {
+		return sitePath(r)
+	}
+	return p
+}
+
+func sitePath(r *http.Request) string {
+	return path.Join("/", headers.GetSiteURL(r).Path)
+}
@@ -0,0 +1,98 @@
+package oidc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gabe565.com/linx-server/internal/auth/apikeys"
+	"gabe565.com/linx-server/internal/auth/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func login(t *testing.T, p *Provider, issuer *oidctest.Issuer) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/"+LoginPath+"?redirect=/paste", nil)
+	p.Login(w, req)
+	require.Equal(t, http.StatusFound, w.Code)
+
+	callback := issuer.Authorize(t, w.Header().Get("Location"))
+	req = httptest.NewRequest(http.MethodGet, callback, nil)
+	req.Header.Set("Accept", "application/json")
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+
+	w = httptest.NewRecorder()
+	p.Callback(w, req)
+	return w
+}
+
+func TestProvider(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, "staff", "uploaders")
+	p, err := New(t.Context(), Options{
+		Issuer:        issuer.URL,
+		ClientID:      "linx",
+		GroupsClaim:   "groups",
+		AllowedGroups: []string{"uploaders"},
+		SessionExpiry: time.Hour,
+	})
+	require.NoError(t, err)
+
+	w := login(t, p, issuer)
+	require.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/paste", w.Header().Get("Location"))
+
+	var session *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == SessionCookie {
+			session = c
+		}
+	}
+	require.NotNil(t, session)
+	assert.True(t, session.HttpOnly)
+
+	var key apikeys.Key
+	var ok bool
+	handler := p.Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		key, ok = apikeys.KeyFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	req.AddCookie(session)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	require.True(t, ok)
+	assert.Equal(t, oidctest.Subject, key.ID())
+	assert.True(t, key.HasScope(apikeys.ScopeUpload))
+
+	// tampered session
+	session.Value = "x" + session.Value
+	req = httptest.NewRequest(http.MethodPost, "/upload", nil)
+	req.AddCookie(session)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.False(t, ok)
+}
+
+func TestProviderGroups(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, "staff")
+	p, err := New(t.Context(), Options{
+		Issuer:        issuer.URL,
+		ClientID:      "linx",
+		GroupsClaim:   "groups",
+		AllowedGroups: []string{"uploaders"},
+		SessionExpiry: time.Hour,
+	})
+	require.NoError(t, err)
+
+	w := login(t, p, issuer)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestRedirectPath(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Equal(t, "/paste", redirectPath(r, "/paste"))
+	assert.Equal(t, "/", redirectPath(r, "//evil.example.com"))
+	assert.Equal(t, "/", redirectPath(r, "https://evil.example.com"))
+}
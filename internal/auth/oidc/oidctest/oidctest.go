@@ -0,0 +1,125 @@
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Subject is the subject claim of the user which the issuer logs in.
+const Subject = "user-123"
+
+// Issuer is a minimal OpenID Connect issuer which logs in a fixed user.
+type Issuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	groups []string
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+// NewIssuer starts an issuer whose ID tokens list the given groups. It is closed when the test finishes.
+func NewIssuer(t testing.TB, groups ...string) *Issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	m := &Issuer{key: key, groups: groups, codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		auth, ok := m.codes[r.FormValue("code")]
+		delete(m.codes, r.FormValue("code"))
+		m.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || auth.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     m.idToken(t, auth),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// Authorize simulates the user logging in at the issuer and returns the callback URL.
+// location is the redirect to the issuer's authorization endpoint.
+func (m *Issuer) Authorize(t testing.TB, location string) string {
+	u, err := url.Parse(location)
+	require.NoError(t, err)
+	q := u.Query()
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+
+	code := rand.Text()
+	m.mu.Lock()
+	m.codes[code] = q
+	m.mu.Unlock()
+
+	callback, err := url.Parse(q.Get("redirect_uri"))
+	require.NoError(t, err)
+	callback.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	return callback.String()
+}
+
+func (m *Issuer) idToken(t testing.TB, auth url.Values) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	require.NoError(t, err)
+	claims, err := json.Marshal(map[string]any{
+		"iss":    m.URL,
+		"sub":    Subject,
+		"aud":    auth.Get("client_id"),
+		"nonce":  auth.Get("nonce"),
+		"iat":    time.Now().Unix(),
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"groups": m.groups,
+	})
+	require.NoError(t, err)
+
+	payload := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(payload))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	require.NoError(t, err)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
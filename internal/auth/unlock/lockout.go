@@ -0,0 +1,122 @@
+package unlock
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrLocked = errors.New("too many failed access key attempts")
+
+// Lockout counts failed access key attempts for each upload.
+// Once an upload reaches the maximum number of attempts, it is locked for the lockout duration,
+// which doubles with each further failure up to the maximum lockout.
+// Holders of an unlock token are not affected, since their cookie is verified without the access key.
+type Lockout struct {
+	maxAttempts int
+	lockout     time.Duration
+	maxLockout  time.Duration
+
+	mu        sync.Mutex
+	uploads   map[string]*attempts
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+type attempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// NewLockout returns nil if maxAttempts is 0, which disables lockouts.
+func NewLockout(maxAttempts int, lockout, maxLockout time.Duration) *Lockout {
+	if maxAttempts <= 0 {
+		return nil
+	}
+	return &Lockout{
+		maxAttempts: maxAttempts,
+		lockout:     lockout,
+		maxLockout:  max(maxLockout, lockout),
+		uploads:     make(map[string]*attempts),
+		now:         time.Now,
+	}
+}
+
+// Locked returns when an upload's lockout ends. It is a no-op on a nil Lockout.
+func (l *Lockout) Locked(name string) (time.Time, bool) {
+	if l == nil {
+		return time.Time{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.uploads[name]
+	if !ok || !a.lockedUntil.After(l.now()) {
+		return time.Time{}, false
+	}
+	return a.lockedUntil, true
+}
+
+// Fail records a failed attempt and returns the number of consecutive failures.
+// If the attempt locked the upload, it also returns when the lockout ends. It is a no-op on a nil Lockout.
+func (l *Lockout) Fail(name string) (int, time.Time) {
+	if l == nil {
+		return 0, time.Time{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	a, ok := l.uploads[name]
+	if !ok {
+		a = &attempts{}
+		l.uploads[name] = a
+	}
+	a.failures++
+	a.lastFailure = now
+
+	if a.failures < l.maxAttempts {
+		return a.failures, time.Time{}
+	}
+
+	lockout := l.lockout
+	for range a.failures - l.maxAttempts {
+		lockout *= 2
+		if lockout >= l.maxLockout {
+			lockout = l.maxLockout
+			break
+		}
+	}
+	a.lockedUntil = now.Add(lockout)
+	return a.failures, a.lockedUntil
+}
+
+// Reset forgets an upload's failed attempts after it was unlocked. It is a no-op on a nil Lockout.
+func (l *Lockout) Reset(name string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.uploads, name)
+}
+
+// prune forgets uploads which have not failed for longer than the maximum lockout.
+func (l *Lockout) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+
+	for name, a := range l.uploads {
+		if now.Sub(a.lastFailure) > l.maxLockout && !a.lockedUntil.After(now) {
+			delete(l.uploads, name)
+		}
+	}
+}
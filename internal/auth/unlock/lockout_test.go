@@ -0,0 +1,67 @@
+package unlock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockout(t *testing.T) {
+	now := time.Now()
+	l := NewLockout(3, time.Minute, 5*time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := 1; i < 3; i++ {
+		failures, until := l.Fail("a.txt")
+		assert.Equal(t, i, failures)
+		assert.True(t, until.IsZero())
+	}
+	_, locked := l.Locked("a.txt")
+	assert.False(t, locked)
+
+	// The lockout doubles with each failure after it ends, up to the maximum
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute} {
+		_, until := l.Fail("a.txt")
+		assert.Equal(t, now.Add(want), until)
+		got, locked := l.Locked("a.txt")
+		assert.True(t, locked)
+		assert.Equal(t, until, got)
+
+		now = until
+		_, locked = l.Locked("a.txt")
+		assert.False(t, locked)
+	}
+
+	_, locked = l.Locked("b.txt")
+	assert.False(t, locked)
+
+	l.Reset("a.txt")
+	failures, _ := l.Fail("a.txt")
+	assert.Equal(t, 1, failures)
+}
+
+func TestLockoutPrune(t *testing.T) {
+	now := time.Now()
+	l := NewLockout(3, time.Minute, time.Hour)
+	l.now = func() time.Time { return now }
+
+	l.Fail("a.txt")
+	now = now.Add(2 * time.Hour)
+	l.Fail("b.txt")
+	require.Len(t, l.uploads, 1)
+	assert.Contains(t, l.uploads, "b.txt")
+}
+
+func TestLockoutDisabled(t *testing.T) {
+	l := NewLockout(0, time.Minute, time.Hour)
+	require.Nil(t, l)
+
+	failures, until := l.Fail("a.txt")
+	assert.Zero(t, failures)
+	assert.True(t, until.IsZero())
+	_, locked := l.Locked("a.txt")
+	assert.False(t, locked)
+	l.Reset("a.txt")
+}
@@ -0,0 +1,82 @@
+package unlock
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid unlock token")
+
+// RandomKey signs tokens when no key is configured. It is shared so that tokens survive a config reload.
+//
+//nolint:gochecknoglobals
+var RandomKey = sync.OnceValue(func() []byte {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return b
+})
+
+type claims struct {
+	Name    string `json:"n"`
+	Expires int64  `json:"exp,omitzero"`
+}
+
+// NewToken returns a token which proves that the access key of an upload was entered,
+// so it can be stored in a cookie instead of the access key itself.
+// The token is bound to the upload's stored access key hash, so it is revoked when the upload is replaced.
+// A zero expiry creates a token which is valid until the key changes.
+func NewToken(key []byte, name, accessKey string, expires time.Time) (string, error) {
+	c := claims{Name: name}
+	if !expires.IsZero() {
+		c.Expires = expires.Unix()
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac(key, payload, accessKey)), nil
+}
+
+// VerifyToken checks that a token was created by NewToken for the upload and has not expired.
+// It is much cheaper than checking the access key, which uses scrypt.
+func VerifyToken(key []byte, token, name, accessKey string) error {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalidToken
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(b, mac(key, payload, accessKey)) {
+		return ErrInvalidToken
+	}
+
+	if b, err = base64.RawURLEncoding.DecodeString(payload); err != nil {
+		return ErrInvalidToken
+	}
+	var c claims
+	if err := json.Unmarshal(b, &c); err != nil {
+		return ErrInvalidToken
+	}
+
+	if c.Name != name || (c.Expires != 0 && time.Now().Unix() > c.Expires) {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func mac(key []byte, payload, accessKey string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+	h.Write([]byte{0})
+	h.Write([]byte(accessKey))
+	return h.Sum(nil)
+}
@@ -0,0 +1,26 @@
+package unlock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestToken(t *testing.T) {
+	key := []byte("secret")
+
+	token, err := NewToken(key, "a.txt", "hash", time.Time{})
+	require.NoError(t, err)
+	require.NoError(t, VerifyToken(key, token, "a.txt", "hash"))
+
+	require.ErrorIs(t, VerifyToken([]byte("other"), token, "a.txt", "hash"), ErrInvalidToken)
+	require.ErrorIs(t, VerifyToken(key, token, "b.txt", "hash"), ErrInvalidToken)
+	// A new access key revokes the token
+	require.ErrorIs(t, VerifyToken(key, token, "a.txt", "new hash"), ErrInvalidToken)
+	require.ErrorIs(t, VerifyToken(key, "supersecret", "a.txt", "hash"), ErrInvalidToken)
+
+	token, err = NewToken(key, "a.txt", "hash", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.ErrorIs(t, VerifyToken(key, token, "a.txt", "hash"), ErrInvalidToken)
+}
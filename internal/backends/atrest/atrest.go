@@ -0,0 +1,252 @@
+package atrest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"net/http"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/e2e"
+	"gabe565.com/linx-server/internal/helpers"
+)
+
+var (
+	ErrNotEncrypted = errors.New("upload is not encrypted at rest")
+	ErrNoReaderAt   = errors.New("backend does not support random access reads")
+)
+
+// Rotator re-wraps the data key of each upload with the current master key.
+type Rotator interface {
+	backends.ListBackend
+	Rotate(ctx context.Context, key string) (bool, error)
+}
+
+// Wrap encrypts uploads before they are stored by the wrapped backend.
+// Each upload is encrypted with its own data key in the chunked format described in ENCRYPTION.md,
+// and the data key is stored in the upload's metadata, wrapped by the current master key.
+// Uploads which were stored before encryption was enabled are served unchanged.
+// The optional list interface is kept if the wrapped backend implements it.
+func Wrap(b backends.StorageBackend, keys *Keyring) backends.StorageBackend { //nolint:ireturn
+	base := Backend{StorageBackend: b, keys: keys}
+
+	lister, ok := b.(backends.ListBackend)
+	if !ok {
+		return base
+	}
+	return listBackend{Backend: base, lister: lister}
+}
+
+type Backend struct {
+	backends.StorageBackend
+	keys *Keyring
+}
+
+func (b Backend) Unwrap() backends.StorageBackend { //nolint:ireturn
+	return b.StorageBackend
+}
+
+// decrypted converts metadata of the stored ciphertext to metadata of the plaintext.
+func decrypted(m backends.Metadata) backends.Metadata {
+	if m.WrappedKey != "" {
+		m.Size = e2e.DecryptedSize(m.Size)
+		m.WrappedKey = ""
+	}
+	return m
+}
+
+func (b Backend) Head(ctx context.Context, key string) (backends.Metadata, error) {
+	m, err := b.StorageBackend.Head(ctx, key)
+	return decrypted(m), err
+}
+
+func (b Backend) Size(ctx context.Context, key string) (int64, error) {
+	m, err := b.Head(ctx, key)
+	return m.Size, err
+}
+
+func (b Backend) Get(ctx context.Context, key string) (backends.Metadata, io.ReadCloser, error) {
+	m, rc, err := b.StorageBackend.Get(ctx, key)
+	if err != nil || m.WrappedKey == "" {
+		return m, rc, err
+	}
+
+	// Readers which support random access keep it, so archives can be read without decrypting them entirely.
+	if _, ok := rc.(io.ReaderAt); ok {
+		ra, err := b.newReaderAt(rc, m)
+		if err != nil {
+			_ = rc.Close()
+			return decrypted(m), nil, err
+		}
+		return decrypted(m), sectionReadCloser{SectionReader: io.NewSectionReader(ra, 0, ra.Size()), Closer: rc}, nil
+	}
+
+	dataKey, err := b.keys.Unwrap(m.WrappedKey)
+	if err != nil {
+		_ = rc.Close()
+		return decrypted(m), nil, err
+	}
+
+	d, err := e2e.NewReader(rc, dataKey)
+	if err != nil {
+		_ = rc.Close()
+		return decrypted(m), nil, err
+	}
+	return decrypted(m), readCloser{Reader: d, Closer: rc}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// ServeFile decrypts only the records which are needed, so range requests are supported.
+// The wrapped backend's reader must implement io.ReaderAt, which files and S3 objects do.
+func (b Backend) ServeFile(key string, w http.ResponseWriter, r *http.Request) error {
+	m, rc, err := b.StorageBackend.Get(r.Context(), key)
+	if err != nil {
+		return err
+	}
+	if m.WrappedKey == "" {
+		_ = rc.Close()
+		return b.StorageBackend.ServeFile(key, w, r)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	ra, err := b.newReaderAt(rc, m)
+	if err != nil {
+		return err
+	}
+
+	http.ServeContent(w, r, key, m.ModTime, io.NewSectionReader(ra, 0, ra.Size()))
+	return nil
+}
+
+func (b Backend) newReaderAt(rc io.ReadCloser, m backends.Metadata) (*e2e.ReaderAt, error) {
+	r, ok := rc.(io.ReaderAt)
+	if !ok {
+		return nil, ErrNoReaderAt
+	}
+
+	dataKey, err := b.keys.Unwrap(m.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return e2e.NewReaderAt(r, m.Size, dataKey)
+}
+
+func (b Backend) Put(
+	ctx context.Context,
+	r io.Reader,
+	key string,
+	size int64,
+	opts backends.PutOptions,
+) (backends.Metadata, error) {
+	// The wrapped backend can't detect the type of the ciphertext.
+	listArchive := opts.Mimetype == ""
+	if listArchive {
+		mime, detected, err := helpers.DetectMimetype(r)
+		if err != nil {
+			return backends.Metadata{}, err
+		}
+		r = detected
+		opts.Mimetype = mime.String()
+	}
+
+	dataKey := e2e.NewKey()
+	opts.WrappedKey = b.keys.Wrap(dataKey)
+	if size > 0 {
+		size = e2e.EncryptedSize(size)
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = pw.CloseWithError(e2e.Encrypt(pw, r, dataKey, e2e.Info{}))
+	}()
+
+	m, err := b.StorageBackend.Put(ctx, pr, key, size, opts)
+	_ = pr.Close()
+	<-done
+	if err != nil {
+		return decrypted(m), err
+	}
+
+	if listArchive && helpers.IsArchive(m.Mimetype) {
+		if files := b.listArchiveFiles(ctx, key); len(files) != 0 {
+			m.ArchiveFiles = files
+			if err := b.StorageBackend.PutMetadata(ctx, key, m); err != nil {
+				return decrypted(m), err
+			}
+		}
+	}
+	return decrypted(m), nil
+}
+
+// listArchiveFiles reads back a stored archive, since the wrapped backend could only list the ciphertext.
+func (b Backend) listArchiveFiles(ctx context.Context, key string) []backends.ArchiveEntry {
+	m, rc, err := b.StorageBackend.Get(ctx, key)
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	ra, err := b.newReaderAt(rc, m)
+	if err != nil {
+		return nil
+	}
+	files, _ := helpers.ListArchiveFiles(m.Mimetype, ra.Size(), io.NewSectionReader(ra, 0, ra.Size()))
+	return files
+}
+
+// PutMetadata keeps the stored wrapped key, so callers which only know the plaintext metadata can't lose it.
+func (b Backend) PutMetadata(ctx context.Context, key string, m backends.Metadata) error {
+	stored, err := b.StorageBackend.Head(ctx, key)
+	if err != nil {
+		return err
+	}
+	m.WrappedKey = stored.WrappedKey
+	m.Size = stored.Size
+	return b.StorageBackend.PutMetadata(ctx, key, m)
+}
+
+// Rotate re-wraps an upload's data key with the current master key.
+// Only the metadata is rewritten. It returns false if the data key was already wrapped with the current master key.
+func (b Backend) Rotate(ctx context.Context, key string) (bool, error) {
+	m, err := b.StorageBackend.Head(ctx, key)
+	switch {
+	case err != nil:
+		return false, err
+	case m.WrappedKey == "":
+		return false, ErrNotEncrypted
+	case !b.keys.NeedsRotation(m.WrappedKey):
+		return false, nil
+	}
+
+	dataKey, err := b.keys.Unwrap(m.WrappedKey)
+	if err != nil {
+		return false, err
+	}
+	m.WrappedKey = b.keys.Wrap(dataKey)
+	return true, b.StorageBackend.PutMetadata(ctx, key, m)
+}
+
+type listBackend struct {
+	Backend
+	lister backends.ListBackend
+}
+
+func (b listBackend) List(ctx context.Context) iter.Seq2[string, error] {
+	return b.lister.List(ctx)
+}
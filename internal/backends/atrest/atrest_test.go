@@ -0,0 +1,189 @@
+package atrest
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/localfs"
+	"gabe565.com/linx-server/internal/backends/localfs/localfstest"
+	"gabe565.com/linx-server/internal/e2e"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKey() string {
+	return base64.StdEncoding.EncodeToString(e2e.NewKey())
+}
+
+func newTestBackend(t *testing.T, keys ...string) (Rotator, localfs.Backend) {
+	inner := localfstest.New(t)
+
+	keyring, err := NewKeyring(keys...)
+	require.NoError(t, err)
+	return Wrap(inner, keyring).(Rotator), inner //nolint:errcheck
+}
+
+func TestBackend(t *testing.T) {
+	b, inner := newTestBackend(t, newTestKey())
+
+	content := make([]byte, 3*e2e.DefaultChunkSize+100)
+	_, _ = rand.Read(content)
+	copy(content, "plain text")
+
+	m, err := b.Put(t.Context(), bytes.NewReader(content), "a.txt", int64(len(content)), backends.PutOptions{})
+	require.NoError(t, err)
+	assert.EqualValues(t, len(content), m.Size)
+	assert.Empty(t, m.WrappedKey)
+
+	stored, r, err := inner.Get(t.Context(), "a.txt")
+	require.NoError(t, err)
+	ciphertext, err := io.ReadAll(r)
+	require.NoError(t, err)
+	_ = r.Close()
+	assert.NotEmpty(t, stored.WrappedKey)
+	assert.EqualValues(t, e2e.EncryptedSize(int64(len(content))), len(ciphertext))
+	assert.NotContains(t, string(ciphertext), "plain text")
+
+	m, err = b.Head(t.Context(), "a.txt")
+	require.NoError(t, err)
+	assert.EqualValues(t, len(content), m.Size)
+	assert.Equal(t, stored.Mimetype, m.Mimetype)
+
+	size, err := b.Size(t.Context(), "a.txt")
+	require.NoError(t, err)
+	assert.EqualValues(t, len(content), size)
+
+	_, r, err = b.Get(t.Context(), "a.txt")
+	require.NoError(t, err)
+	assert.Implements(t, (*io.ReaderAt)(nil), r, "archives need random access")
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	_ = r.Close()
+	assert.Equal(t, content, got)
+
+	// Updating metadata keeps the wrapped key
+	m.OriginalName = "b.txt"
+	require.NoError(t, b.PutMetadata(t.Context(), "a.txt", m))
+	_, r, err = b.Get(t.Context(), "a.txt")
+	require.NoError(t, err)
+	_ = r.Close()
+}
+
+func TestServeFile(t *testing.T) {
+	b, _ := newTestBackend(t, newTestKey())
+
+	content := bytes.Repeat([]byte("0123456789"), e2e.DefaultChunkSize/5)
+	_, err := b.Put(t.Context(), bytes.NewReader(content), "a.txt", 0, backends.PutOptions{})
+	require.NoError(t, err)
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/a.txt", nil)
+	req.Header.Set("Range", "bytes=65530-65545")
+	w := httptest.NewRecorder()
+	require.NoError(t, b.ServeFile("a.txt", w, req))
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, content[65530:65546], w.Body.Bytes())
+
+	req = httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/a.txt", nil)
+	w = httptest.NewRecorder()
+	require.NoError(t, b.ServeFile("a.txt", w, req))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, content, w.Body.Bytes())
+}
+
+func TestArchive(t *testing.T) {
+	b, _ := newTestBackend(t, newTestKey())
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	_, err := zw.Create("hello.txt")
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	m, err := b.Put(t.Context(), &buf, "a.zip", 0, backends.PutOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "application/zip", m.Mimetype)
+	require.Len(t, m.ArchiveFiles, 1)
+	assert.Equal(t, "hello.txt", m.ArchiveFiles[0].Path)
+}
+
+func TestUnencrypted(t *testing.T) {
+	b, inner := newTestBackend(t, newTestKey())
+
+	// Uploads stored before encryption was enabled are served unchanged
+	_, err := inner.Put(t.Context(), strings.NewReader("File content"), "a.txt", 0, backends.PutOptions{})
+	require.NoError(t, err)
+
+	_, r, err := b.Get(t.Context(), "a.txt")
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	_ = r.Close()
+	assert.Equal(t, "File content", string(got))
+
+	_, err = b.Rotate(t.Context(), "a.txt")
+	require.ErrorIs(t, err, ErrNotEncrypted)
+}
+
+func TestRotate(t *testing.T) {
+	oldKey, newKey := newTestKey(), newTestKey()
+	old, inner := newTestBackend(t, oldKey)
+
+	_, err := old.Put(t.Context(), strings.NewReader("File content"), "a.txt", 0, backends.PutOptions{})
+	require.NoError(t, err)
+
+	// The old key is still needed until the upload is rotated
+	keyring, err := NewKeyring(newKey)
+	require.NoError(t, err)
+	_, _, err = Wrap(inner, keyring).Get(t.Context(), "a.txt")
+	require.ErrorIs(t, err, ErrUnknownKey)
+
+	keyring, err = NewKeyring(newKey, oldKey)
+	require.NoError(t, err)
+	b := Wrap(inner, keyring).(Rotator) //nolint:errcheck
+	changed, err := b.Rotate(t.Context(), "a.txt")
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = b.Rotate(t.Context(), "a.txt")
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	keyring, err = NewKeyring(newKey)
+	require.NoError(t, err)
+	_, r, err := Wrap(inner, keyring).Get(t.Context(), "a.txt")
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	_ = r.Close()
+	assert.Equal(t, "File content", string(got))
+}
+
+func TestLoadKeyring(t *testing.T) {
+	key := newTestKey()
+	path := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(path, []byte("# current\n"+key+"\n\n"+newTestKey()+"\n"), 0o600))
+
+	keyring, err := LoadKeyring(path)
+	require.NoError(t, err)
+	require.Len(t, keyring.keys, 2)
+
+	expected, err := NewKeyring(key)
+	require.NoError(t, err)
+	assert.Equal(t, expected.CurrentID(), keyring.CurrentID())
+
+	_, err = NewKeyring("short")
+	require.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = NewKeyring()
+	require.ErrorIs(t, err, ErrNoKeys)
+}
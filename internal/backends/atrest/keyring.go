@@ -0,0 +1,144 @@
+package atrest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gabe565.com/linx-server/internal/e2e"
+)
+
+var (
+	ErrNoKeys     = errors.New("no encryption keys")
+	ErrInvalidKey = errors.New("invalid encryption key")
+	ErrUnknownKey = errors.New("upload was encrypted with an unknown key")
+	ErrWrappedKey = errors.New("invalid wrapped key")
+	ErrUnwrap     = errors.New("failed to unwrap data key")
+)
+
+// keyIDSize is the number of hex characters which identify a master key.
+const keyIDSize = 8
+
+// Keyring holds the master keys which wrap the data key of each upload.
+// The first key wraps new data keys. The others are only used to unwrap data keys until they are rotated.
+type Keyring struct {
+	keys []masterKey
+}
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewKeyring parses base64-encoded 32 byte master keys.
+func NewKeyring(keys ...string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	k := &Keyring{keys: make([]masterKey, 0, len(keys))}
+	for i, s := range keys {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil || len(raw) != e2e.KeySize {
+			return nil, fmt.Errorf("%w: key %d must be %d base64-encoded bytes", ErrInvalidKey, i+1, e2e.KeySize)
+		}
+
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(raw)
+		k.keys = append(k.keys, masterKey{id: hex.EncodeToString(sum[:])[:keyIDSize], aead: aead})
+	}
+	return k, nil
+}
+
+// LoadKeyring reads one master key per line from a file. Blank lines and lines starting with # are ignored.
+func LoadKeyring(path string) (*Keyring, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	k, err := NewKeyring(keys...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return k, nil
+}
+
+// CurrentID returns the ID of the key which wraps new data keys.
+func (k *Keyring) CurrentID() string {
+	return k.keys[0].id
+}
+
+// Wrap encrypts a data key with the current master key.
+// The result is the master key's ID and the base64url-encoded nonce and ciphertext, separated by a colon.
+func (k *Keyring) Wrap(dataKey []byte) string {
+	current := k.keys[0]
+	sealed := make([]byte, current.aead.NonceSize(), current.aead.NonceSize()+len(dataKey)+current.aead.Overhead())
+	_, _ = rand.Read(sealed)
+	sealed = current.aead.Seal(sealed, sealed, dataKey, []byte(current.id))
+	return current.id + ":" + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// Unwrap decrypts a data key which was wrapped by any key in the keyring.
+func (k *Keyring) Unwrap(wrapped string) ([]byte, error) {
+	id, encoded, ok := strings.Cut(wrapped, ":")
+	if !ok {
+		return nil, ErrWrappedKey
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrWrappedKey
+	}
+
+	for _, key := range k.keys {
+		if key.id != id {
+			continue
+		}
+		if len(sealed) < key.aead.NonceSize() {
+			return nil, ErrWrappedKey
+		}
+		nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+		dataKey, err := key.aead.Open(nil, nonce, ciphertext, []byte(id))
+		if err != nil {
+			return nil, ErrUnwrap
+		}
+		return dataKey, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+}
+
+// NeedsRotation reports whether a wrapped key was wrapped by an older master key.
+func (k *Keyring) NeedsRotation(wrapped string) bool {
+	id, _, _ := strings.Cut(wrapped, ":")
+	return id != k.CurrentID()
+}
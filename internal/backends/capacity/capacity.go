@@ -0,0 +1,366 @@
+package capacity
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+)
+
+// Policy decides what happens when an upload would exceed the storage limit.
+type Policy string
+
+const (
+	// PolicyReject rejects the upload.
+	PolicyReject Policy = "reject"
+	// PolicyOldest evicts the least recently modified uploads.
+	PolicyOldest Policy = "oldest"
+	// PolicySoonestExpiry evicts the uploads which will expire soonest. Uploads which never expire are kept.
+	PolicySoonestExpiry Policy = "soonest-expiry"
+	// PolicyLargestNeverExpiring evicts the largest uploads which never expire.
+	PolicyLargestNeverExpiring Policy = "largest-never-expiring"
+)
+
+func Policies() []Policy {
+	return []Policy{PolicyReject, PolicyOldest, PolicySoonestExpiry, PolicyLargestNeverExpiring}
+}
+
+var (
+	ErrInsufficientStorage = errors.New("insufficient storage")
+	ErrUnknownSize         = errors.New("size is required to replace an upload")
+	ErrUnknownPolicy       = errors.New("unknown eviction policy")
+	ErrUnsupported         = errors.New("storage backend does not support listing uploads")
+)
+
+func ParsePolicy(s string) (Policy, error) {
+	if s == "" {
+		return PolicyReject, nil
+	}
+	if p := Policy(s); slices.Contains(Policies(), p) {
+		return p, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownPolicy, s)
+}
+
+type entry struct {
+	size    int64
+	modTime time.Time
+	expiry  time.Time
+}
+
+// Backend limits the total size of all uploads.
+// Usage is counted when the backend is created, then kept up to date as uploads are written and deleted.
+// Uploads deleted by other processes, such as the cleanup command, are only noticed when usage is recounted.
+// Each upload counts its full size, even if deduplication stores its contents only once.
+type Backend struct {
+	backends.ListBackend
+	limit  int64
+	policy Policy
+
+	// evictMu is held while uploads are evicted, so concurrent uploads don't both count the same freed space.
+	evictMu   sync.Mutex
+	recountMu sync.Mutex
+	mu        sync.Mutex
+	used      int64
+	reserved  int64
+	entries   map[string]entry
+	// touched records the uploads which were written or deleted while usage is recounted.
+	touched map[string]struct{}
+}
+
+// New counts the size of every upload in backend.
+func New(ctx context.Context, backend backends.StorageBackend, limit int64, policy Policy) (*Backend, error) {
+	lister, ok := backend.(backends.ListBackend)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	entries, err := count(ctx, lister)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backend{
+		ListBackend: lister,
+		limit:       limit,
+		policy:      policy,
+		used:        total(entries),
+		entries:     entries,
+	}, nil
+}
+
+func count(ctx context.Context, lister backends.ListBackend) (map[string]entry, error) {
+	entries := make(map[string]entry)
+	for key, err := range lister.List(ctx) {
+		if err != nil {
+			return nil, err
+		}
+
+		m, err := lister.Head(ctx, key)
+		if err != nil {
+			if errors.Is(err, backends.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		entries[key] = entry{size: m.Size, modTime: m.ModTime, expiry: m.Expiry}
+	}
+	return entries, nil
+}
+
+func total(entries map[string]entry) int64 {
+	var used int64
+	for _, e := range entries {
+		used += e.size
+	}
+	return used
+}
+
+// Recount counts the size of every upload again, so uploads deleted by other processes stop counting.
+// Uploads which are written or deleted while counting keep the size recorded by b.
+func (b *Backend) Recount(ctx context.Context) error {
+	b.recountMu.Lock()
+	defer b.recountMu.Unlock()
+
+	b.mu.Lock()
+	b.touched = make(map[string]struct{})
+	b.mu.Unlock()
+
+	entries, err := count(ctx, b.ListBackend)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	touched := b.touched
+	b.touched = nil
+	if err != nil {
+		return err
+	}
+
+	for key := range touched {
+		if e, ok := b.entries[key]; ok {
+			entries[key] = e
+		} else {
+			delete(entries, key)
+		}
+	}
+	b.entries = entries
+	b.used = total(entries)
+	return nil
+}
+
+// Run recounts usage every interval until ctx is canceled.
+func (b *Backend) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.Recount(ctx); err != nil && ctx.Err() == nil {
+				slog.Error("Failed to recount storage usage", "error", err)
+			}
+		}
+	}
+}
+
+func (b *Backend) Unwrap() backends.StorageBackend { //nolint:ireturn
+	return b.ListBackend
+}
+
+// Used returns the total size of all uploads.
+func (b *Backend) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+func (b *Backend) set(key string, m backends.Metadata) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used += m.Size - b.entries[key].size
+	b.entries[key] = entry{size: m.Size, modTime: m.ModTime, expiry: m.Expiry}
+	if b.touched != nil {
+		b.touched[key] = struct{}{}
+	}
+}
+
+func (b *Backend) contains(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.entries[key]
+	return ok
+}
+
+func (b *Backend) remove(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= b.entries[key].size
+	delete(b.entries, key)
+	if b.touched != nil {
+		b.touched[key] = struct{}{}
+	}
+}
+
+// Put ensures there is room for the upload, evicting other uploads if the policy allows it.
+// The space stays reserved until the upload is written.
+// If the size is unknown, the upload is written first and removed again if there is no room.
+// Replacing an upload requires its size, since the previous version can't be restored.
+func (b *Backend) Put(
+	ctx context.Context,
+	r io.Reader,
+	key string,
+	size int64,
+	opts backends.PutOptions,
+) (backends.Metadata, error) {
+	if size > 0 {
+		release, err := b.makeRoom(ctx, key, size)
+		if err != nil {
+			return backends.Metadata{}, err
+		}
+		defer release()
+	} else if b.contains(key) {
+		return backends.Metadata{}, ErrUnknownSize
+	}
+
+	m, err := b.ListBackend.Put(ctx, r, key, size, opts)
+	if err != nil {
+		return m, err
+	}
+	b.set(key, m)
+
+	if size <= 0 {
+		release, err := b.makeRoom(ctx, key, m.Size)
+		if err != nil {
+			if err := b.Delete(ctx, key); err != nil {
+				slog.Error("Failed to remove upload which exceeded the storage limit", "name", key, "error", err)
+			}
+			return backends.Metadata{}, err
+		}
+		release()
+	}
+	return m, nil
+}
+
+func (b *Backend) PutMetadata(ctx context.Context, key string, m backends.Metadata) error {
+	if err := b.ListBackend.PutMetadata(ctx, key, m); err != nil {
+		return err
+	}
+	b.set(key, m)
+	return nil
+}
+
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	err := b.ListBackend.Delete(ctx, key)
+	if err == nil || errors.Is(err, backends.ErrNotFound) {
+		b.remove(key)
+	}
+	return err
+}
+
+// makeRoom evicts uploads until key fits within the limit with the given size,
+// then reserves the space until release is called.
+// Nothing is evicted unless enough space can be freed.
+func (b *Backend) makeRoom(ctx context.Context, key string, size int64) (func(), error) {
+	b.evictMu.Lock()
+	defer b.evictMu.Unlock()
+
+	b.mu.Lock()
+	need := b.used + b.reserved - b.entries[key].size + size - b.limit
+	reserve := max(size-b.entries[key].size, 0)
+	var candidates []candidate
+	if need > 0 {
+		candidates = b.candidates(key)
+	}
+	b.mu.Unlock()
+
+	var evict []string
+	var freed int64
+	for _, c := range candidates {
+		if freed >= need {
+			break
+		}
+		evict = append(evict, c.key)
+		freed += c.size
+	}
+	if freed < need {
+		slog.Warn("Rejecting upload which exceeds the storage limit",
+			"name", key, "size", size, "limit", b.limit, "policy", b.policy,
+		)
+		return nil, ErrInsufficientStorage
+	}
+
+	for _, name := range evict {
+		slog.Info("Evicting upload to free storage", "name", name, "for", key, "policy", b.policy)
+		if err := b.Delete(ctx, name); err != nil && !errors.Is(err, backends.ErrNotFound) {
+			return nil, fmt.Errorf("failed to evict %s: %w", name, err)
+		}
+	}
+
+	b.mu.Lock()
+	b.reserved += reserve
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		b.reserved -= reserve
+		b.mu.Unlock()
+	}, nil
+}
+
+type candidate struct {
+	key string
+	entry
+}
+
+// candidates lists uploads which may be evicted, in the order they should be evicted.
+// The caller must hold the lock.
+func (b *Backend) candidates(exclude string) []candidate {
+	var keep func(entry) bool
+	var compare func(a, b candidate) int
+	switch b.policy {
+	case PolicyOldest:
+		compare = func(a, b candidate) int { return a.modTime.Compare(b.modTime) }
+	case PolicySoonestExpiry:
+		keep = func(e entry) bool { return !e.expiry.IsZero() }
+		compare = func(a, b candidate) int { return a.expiry.Compare(b.expiry) }
+	case PolicyLargestNeverExpiring:
+		keep = func(e entry) bool { return e.expiry.IsZero() }
+		compare = func(a, b candidate) int { return cmp.Compare(b.size, a.size) }
+	default:
+		return nil
+	}
+
+	candidates := make([]candidate, 0, len(b.entries))
+	for _, key := range slices.Sorted(maps.Keys(b.entries)) {
+		e := b.entries[key]
+		if key == exclude || (keep != nil && !keep(e)) {
+			continue
+		}
+		candidates = append(candidates, candidate{key: key, entry: e})
+	}
+	slices.SortStableFunc(candidates, compare)
+	return candidates
+}
+
+// Wrap creates a Backend which keeps the optional interfaces of the wrapped backend.
+func Wrap( //nolint:ireturn
+	ctx context.Context,
+	backend backends.StorageBackend,
+	limit int64,
+	policy Policy,
+) (*Backend, backends.StorageBackend, error) {
+	b, err := New(ctx, backend, limit, policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, backends.Wrap(b, backend), nil
+}
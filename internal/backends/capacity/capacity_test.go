@@ -0,0 +1,188 @@
+package capacity
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/localfs"
+	"gabe565.com/linx-server/internal/backends/localfs/localfstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBackend(t *testing.T) (localfs.Backend, string) {
+	metaPath, filesPath := localfstest.Dirs(t)
+	return localfs.New(metaPath, filesPath, false), metaPath
+}
+
+func put(t *testing.T, b backends.StorageBackend, key string, size int, expiry time.Duration) error {
+	var opts backends.PutOptions
+	if expiry != 0 {
+		opts.Expiry = time.Now().Add(expiry)
+	}
+	_, err := b.Put(t.Context(), strings.NewReader(strings.Repeat("a", size)), key, int64(size), opts)
+	return err
+}
+
+func exists(t *testing.T, b backends.StorageBackend, key string) bool {
+	ok, err := b.Exists(t.Context(), key)
+	require.NoError(t, err)
+	return ok
+}
+
+func TestNew(t *testing.T) {
+	inner, _ := newTestBackend(t)
+	require.NoError(t, put(t, inner, "a.txt", 10, 0))
+	require.NoError(t, put(t, inner, "b.txt", 5, 0))
+
+	b, err := New(t.Context(), inner, 100, PolicyReject)
+	require.NoError(t, err)
+	assert.EqualValues(t, 15, b.Used())
+
+	require.NoError(t, b.Delete(t.Context(), "a.txt"))
+	assert.EqualValues(t, 5, b.Used())
+
+	// replacing an upload only counts the difference
+	require.NoError(t, put(t, b, "b.txt", 8, 0))
+	assert.EqualValues(t, 8, b.Used())
+}
+
+func TestPolicies(t *testing.T) {
+	tests := []struct {
+		policy  Policy
+		setup   func(t *testing.T, b backends.StorageBackend, metaPath string)
+		evicted string
+	}{
+		{
+			policy: PolicyOldest,
+			setup: func(t *testing.T, b backends.StorageBackend, metaPath string) {
+				require.NoError(t, put(t, b, "a.txt", 12, time.Hour))
+				require.NoError(t, put(t, b, "b.txt", 12, 0))
+				old := time.Now().Add(-time.Hour)
+				require.NoError(t, os.Chtimes(filepath.Join(metaPath, "b.txt.json"), old, old))
+			},
+			evicted: "b.txt",
+		},
+		{
+			policy: PolicySoonestExpiry,
+			setup: func(t *testing.T, b backends.StorageBackend, metaPath string) {
+				require.NoError(t, put(t, b, "a.txt", 12, 2*time.Hour))
+				require.NoError(t, put(t, b, "b.txt", 12, time.Hour))
+			},
+			evicted: "b.txt",
+		},
+		{
+			policy: PolicyLargestNeverExpiring,
+			setup: func(t *testing.T, b backends.StorageBackend, metaPath string) {
+				require.NoError(t, put(t, b, "a.txt", 5, 0))
+				require.NoError(t, put(t, b, "b.txt", 12, 0))
+				require.NoError(t, put(t, b, "c.txt", 12, time.Hour))
+			},
+			evicted: "b.txt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			inner, metaPath := newTestBackend(t)
+			tt.setup(t, inner, metaPath)
+			b, err := New(t.Context(), inner, 30, tt.policy)
+			require.NoError(t, err)
+
+			require.NoError(t, put(t, b, "new.txt", 12, 0))
+			assert.False(t, exists(t, b, tt.evicted))
+			assert.True(t, exists(t, b, "new.txt"))
+			assert.LessOrEqual(t, b.Used(), int64(30))
+		})
+	}
+}
+
+func TestReject(t *testing.T) {
+	inner, _ := newTestBackend(t)
+	b, err := New(t.Context(), inner, 30, PolicyReject)
+	require.NoError(t, err)
+
+	require.NoError(t, put(t, b, "a.txt", 12, 0))
+	require.NoError(t, put(t, b, "b.txt", 12, 0))
+	require.ErrorIs(t, put(t, b, "c.txt", 12, 0), ErrInsufficientStorage)
+	assert.False(t, exists(t, b, "c.txt"))
+
+	// uploads of unknown size are removed after they are written
+	_, err = b.Put(t.Context(), strings.NewReader(strings.Repeat("a", 12)), "d.txt", 0, backends.PutOptions{})
+	require.ErrorIs(t, err, ErrInsufficientStorage)
+	assert.False(t, exists(t, b, "d.txt"))
+	assert.EqualValues(t, 24, b.Used())
+
+	// replacements of unknown size are rejected before the previous version is removed
+	_, err = b.Put(t.Context(), strings.NewReader(strings.Repeat("a", 20)), "a.txt", 0, backends.PutOptions{})
+	require.ErrorIs(t, err, ErrUnknownSize)
+	assert.True(t, exists(t, b, "a.txt"))
+	assert.EqualValues(t, 24, b.Used())
+
+	// nothing is evicted if the upload cannot fit
+	b.policy = PolicySoonestExpiry
+	require.ErrorIs(t, put(t, b, "c.txt", 12, 0), ErrInsufficientStorage)
+	assert.True(t, exists(t, b, "a.txt"))
+	assert.True(t, exists(t, b, "b.txt"))
+}
+
+func TestConcurrentPut(t *testing.T) {
+	inner, _ := newTestBackend(t)
+	b, err := New(t.Context(), inner, 30, PolicyReject)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	var stored atomic.Int64
+	for i := range 10 {
+		wg.Go(func() {
+			if err := put(t, b, strconv.Itoa(i)+".txt", 12, 0); err == nil {
+				stored.Add(1)
+			} else {
+				assert.ErrorIs(t, err, ErrInsufficientStorage)
+			}
+		})
+	}
+	wg.Wait()
+
+	// space is reserved before writing, so only two uploads fit
+	assert.EqualValues(t, 2, stored.Load())
+	assert.EqualValues(t, 24, b.Used())
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, PolicyReject, p)
+
+	p, err = ParsePolicy("oldest")
+	require.NoError(t, err)
+	assert.Equal(t, PolicyOldest, p)
+
+	_, err = ParsePolicy("newest")
+	require.ErrorIs(t, err, ErrUnknownPolicy)
+}
+
+func TestRecount(t *testing.T) {
+	inner, _ := newTestBackend(t)
+	b, err := New(t.Context(), inner, 30, PolicyReject)
+	require.NoError(t, err)
+
+	require.NoError(t, put(t, b, "a.txt", 20, 0))
+	require.ErrorIs(t, put(t, b, "b.txt", 20, 0), ErrInsufficientStorage)
+
+	// another process deletes the upload
+	require.NoError(t, inner.Delete(t.Context(), "a.txt"))
+	require.NoError(t, put(t, inner, "c.txt", 5, 0))
+	assert.EqualValues(t, 20, b.Used())
+
+	require.NoError(t, b.Recount(t.Context()))
+	assert.EqualValues(t, 5, b.Used())
+	require.NoError(t, put(t, b, "b.txt", 20, 0))
+	assert.EqualValues(t, 25, b.Used())
+}
@@ -0,0 +1,126 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+)
+
+var (
+	_ backends.ExpiryBackend = Backend{}
+	_ backends.DedupBackend  = Backend{}
+	_ backends.UsageBackend  = Backend{}
+)
+
+var ErrDedupUnsupported = errors.New("backend does not support deduplication")
+
+// Backend serves metadata from a MetadataStore and keeps it in sync with the wrapped storage backend.
+// File contents are always read from the wrapped backend.
+type Backend struct {
+	backends.StorageBackend
+	store backends.MetadataStore
+}
+
+func New(backend backends.StorageBackend, store backends.MetadataStore) Backend {
+	return Backend{
+		StorageBackend: backend,
+		store:          store,
+	}
+}
+
+func (b Backend) Unwrap() backends.StorageBackend { //nolint:ireturn
+	return b.StorageBackend
+}
+
+func (b Backend) Store() backends.MetadataStore { //nolint:ireturn
+	return b.store
+}
+
+func (b Backend) Close() error {
+	return b.store.Close()
+}
+
+func (b Backend) Delete(ctx context.Context, key string) error {
+	err := b.StorageBackend.Delete(ctx, key)
+	if err != nil && !errors.Is(err, backends.ErrNotFound) {
+		return err
+	}
+	return errors.Join(err, b.store.Delete(ctx, key))
+}
+
+// Head reads metadata from the index, falling back to the wrapped backend for uploads which are not indexed yet.
+func (b Backend) Head(ctx context.Context, key string) (backends.Metadata, error) {
+	m, err := b.store.Get(ctx, key)
+	if !errors.Is(err, backends.ErrNotFound) {
+		return m, err
+	}
+
+	if m, err = b.StorageBackend.Head(ctx, key); err != nil {
+		return m, err
+	}
+	return m, b.store.Put(ctx, key, m)
+}
+
+func (b Backend) Put(
+	ctx context.Context,
+	r io.Reader,
+	key string,
+	size int64,
+	opts backends.PutOptions,
+) (backends.Metadata, error) {
+	m, err := b.StorageBackend.Put(ctx, r, key, size, opts)
+	if err != nil {
+		return m, err
+	}
+	return m, b.Refresh(ctx, key)
+}
+
+func (b Backend) PutMetadata(ctx context.Context, key string, m backends.Metadata) error {
+	if err := b.StorageBackend.PutMetadata(ctx, key, m); err != nil {
+		return err
+	}
+	return b.Refresh(ctx, key)
+}
+
+// Refresh copies an upload's metadata from the wrapped backend into the index.
+// Uploads which no longer exist are removed from the index.
+func (b Backend) Refresh(ctx context.Context, key string) error {
+	m, err := b.StorageBackend.Head(ctx, key)
+	if err != nil {
+		if errors.Is(err, backends.ErrNotFound) {
+			return b.store.Delete(ctx, key)
+		}
+		return err
+	}
+	return b.store.Put(ctx, key, m)
+}
+
+// List lists indexed uploads.
+func (b Backend) List(ctx context.Context) iter.Seq2[string, error] {
+	return b.store.List(ctx)
+}
+
+func (b Backend) ListExpired(ctx context.Context, before time.Time) iter.Seq2[string, error] {
+	return b.store.ListExpired(ctx, before)
+}
+
+func (b Backend) Usage(ctx context.Context, uploader string) (backends.Usage, error) {
+	return b.store.Usage(ctx, uploader)
+}
+
+func (b Backend) Dedup(ctx context.Context, key string) (bool, error) {
+	backend, ok := b.StorageBackend.(backends.DedupBackend)
+	if !ok {
+		return false, ErrDedupUnsupported
+	}
+
+	changed, err := backend.Dedup(ctx, key)
+	if err != nil || !changed {
+		return changed, err
+	}
+	return changed, b.Refresh(ctx, key)
+}
@@ -0,0 +1,61 @@
+package index
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/localfs"
+	"gabe565.com/linx-server/internal/backends/localfs/localfstest"
+	"gabe565.com/linx-server/internal/backends/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBackend(t *testing.T) (Backend, localfs.Backend) {
+	inner := localfstest.New(t)
+
+	store, err := sqlite.New(t.Context(), filepath.Join(t.TempDir(), "index.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+
+	return New(inner, store), inner
+}
+
+func TestBackend(t *testing.T) {
+	b, inner := newTestBackend(t)
+
+	_, err := b.Put(t.Context(), strings.NewReader("File content"), "a.txt", 0, backends.PutOptions{
+		Expiry: time.Now().Add(-time.Minute),
+	})
+	require.NoError(t, err)
+
+	// Uploads written before the index was enabled are imported on first access
+	_, err = inner.Put(t.Context(), strings.NewReader("File content"), "b.txt", 0, backends.PutOptions{})
+	require.NoError(t, err)
+	m, err := b.Head(t.Context(), "b.txt")
+	require.NoError(t, err)
+	assert.EqualValues(t, 12, m.Size)
+
+	var keys []string
+	for key, err := range b.List(t.Context()) {
+		require.NoError(t, err)
+		keys = append(keys, key)
+	}
+	assert.Equal(t, []string{"a.txt", "b.txt"}, keys)
+
+	keys = keys[:0]
+	for key, err := range b.ListExpired(t.Context(), time.Now()) {
+		require.NoError(t, err)
+		keys = append(keys, key)
+	}
+	assert.Equal(t, []string{"a.txt"}, keys)
+
+	require.NoError(t, b.Delete(t.Context(), "a.txt"))
+	_, err = b.Store().Get(t.Context(), "a.txt")
+	require.ErrorIs(t, err, backends.ErrNotFound)
+	_, err = inner.Head(t.Context(), "a.txt")
+	require.ErrorIs(t, err, backends.ErrNotFound)
+}
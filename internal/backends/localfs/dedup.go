@@ -0,0 +1,288 @@
+package localfs
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/helpers"
+	"github.com/dchest/uniuri"
+)
+
+// Deduplicated uploads are hard links to a blob named by its checksum.
+// Each upload also owns an empty marker in the blob's refs directory, and the blob is removed with its last marker.
+const (
+	blobsDir = ".blobs"
+	refsDir  = blobsDir + "/refs"
+	lockPath = blobsDir + "/lock"
+)
+
+func blobPath(checksum string) string {
+	return path.Join(blobsDir, checksum)
+}
+
+func refPath(checksum, key string) string {
+	return path.Join(refsDir, checksum, key)
+}
+
+// lockBlobs locks the blobs while their references change.
+// The lock is a file lock, so it is shared with other processes using the same files path, such as the cleanup command.
+func (b Backend) lockBlobs(filesRoot *os.Root) (func(), error) {
+	b.mu.Lock()
+
+	f, err := filesRoot.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// There are no blobs yet. putDedup creates the directory before locking.
+			return b.mu.Unlock, nil
+		}
+		b.mu.Unlock()
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		b.mu.Unlock()
+		return nil, err
+	}
+
+	return func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+		b.mu.Unlock()
+	}, nil
+}
+
+var errInvalidChecksum = errors.New("invalid checksum")
+
+func validChecksum(checksum string) error {
+	if _, err := hex.DecodeString(checksum); err != nil || checksum == "" {
+		return errInvalidChecksum
+	}
+	return nil
+}
+
+func (b Backend) putDedup(
+	filesRoot *os.Root,
+	r io.Reader,
+	key string,
+	size int64,
+	opts backends.PutOptions,
+) (backends.Metadata, error) {
+	var m backends.Metadata
+
+	if err := filesRoot.MkdirAll(refsDir, 0o755); err != nil {
+		return m, err
+	}
+
+	tmp := path.Join(blobsDir, "tmp-"+uniuri.New())
+	f, err := filesRoot.Create(tmp)
+	if err != nil {
+		return m, err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = filesRoot.Remove(tmp)
+	}()
+
+	m, err = helpers.GenerateMetadata(io.TeeReader(r, f))
+	if err != nil {
+		return m, err
+	}
+
+	switch {
+	case m.Size == 0:
+		return m, backends.ErrFileEmpty
+	case size > 0 && m.Size != size:
+		return m, backends.ErrSizeMismatch
+	}
+
+	m.OriginalName = opts.OriginalName
+	m.Expiry = opts.Expiry
+	m.DeleteKey = opts.DeleteKey
+	m.AccessKey = opts.AccessKey
+	m.Salt = opts.Salt
+	m.Uploader = opts.Uploader
+	m.DownloadsLeft = opts.DownloadsLeft
+	m.Encrypted = opts.Encrypted
+	m.WrappedKey = opts.WrappedKey
+
+	if opts.Mimetype != "" {
+		m.Mimetype = opts.Mimetype
+	} else if _, err := f.Seek(0, io.SeekStart); err == nil {
+		m.ArchiveFiles, _ = helpers.ListArchiveFiles(m.Mimetype, m.Size, f)
+	}
+
+	if err := f.Close(); err != nil {
+		return m, err
+	}
+
+	unlock, err := b.lockBlobs(filesRoot)
+	if err != nil {
+		return m, err
+	}
+	defer unlock()
+
+	if err := b.unlink(filesRoot, key); err != nil {
+		return m, err
+	}
+
+	if err := b.link(filesRoot, tmp, key, m.Checksum); err != nil {
+		return m, err
+	}
+
+	if err := b.writeMetadata(key, m); err != nil {
+		_ = filesRoot.Remove(key)
+		_ = b.release(filesRoot, key, m.Checksum)
+		return m, err
+	}
+
+	return m, nil
+}
+
+// link stores src as the blob for checksum if it does not exist yet, then links key to the blob.
+// The blobs must be locked.
+func (b Backend) link(filesRoot *os.Root, src, key, checksum string) error {
+	blob := blobPath(checksum)
+	if _, err := filesRoot.Stat(blob); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if err := filesRoot.Link(src, blob); err != nil {
+			return err
+		}
+	}
+
+	if err := filesRoot.MkdirAll(path.Join(refsDir, checksum), 0o755); err != nil {
+		return err
+	}
+	if err := filesRoot.WriteFile(refPath(checksum, key), nil, 0o644); err != nil {
+		return err
+	}
+
+	if src == key {
+		return nil
+	}
+	if err := filesRoot.Link(blob, key); err != nil {
+		_ = b.release(filesRoot, key, checksum)
+		return err
+	}
+	return nil
+}
+
+// unlink removes an existing upload file so that it can be replaced without truncating a shared blob.
+// The blobs must be locked.
+func (b Backend) unlink(filesRoot *os.Root, key string) error {
+	var checksum string
+	if m, err := b.readMetadata(key); err == nil {
+		checksum = m.Checksum
+	}
+
+	if err := filesRoot.Remove(key); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if checksum != "" {
+		return b.release(filesRoot, key, checksum)
+	}
+	return nil
+}
+
+// release drops the reference from key to a blob, removing the blob once it is unreferenced.
+// The blobs must be locked.
+func (b Backend) release(filesRoot *os.Root, key, checksum string) error {
+	if err := validChecksum(checksum); err != nil {
+		return nil //nolint:nilerr
+	}
+
+	if err := filesRoot.Remove(refPath(checksum, key)); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	dir := path.Join(refsDir, checksum)
+	f, err := filesRoot.Open(dir)
+	if err != nil {
+		return err
+	}
+	entries, err := f.ReadDir(1)
+	_ = f.Close()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if len(entries) != 0 {
+		return nil
+	}
+
+	return errors.Join(filesRoot.Remove(dir), filesRoot.Remove(blobPath(checksum)))
+}
+
+// Dedup converts an existing upload to deduplicated storage.
+func (b Backend) Dedup(ctx context.Context, key string) (bool, error) {
+	m, err := b.Head(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	filesRoot, err := os.OpenRoot(b.filesPath)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = filesRoot.Close()
+	}()
+
+	if err := filesRoot.MkdirAll(refsDir, 0o755); err != nil {
+		return false, err
+	}
+
+	f, err := filesRoot.Open(key)
+	if err != nil {
+		return false, err
+	}
+	computed, err := helpers.GenerateMetadata(f)
+	_ = f.Close()
+	if err != nil {
+		return false, err
+	}
+
+	unlock, err := b.lockBlobs(filesRoot)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	if m.Checksum != "" {
+		if _, err := filesRoot.Stat(refPath(m.Checksum, key)); err == nil {
+			return false, nil
+		}
+	}
+
+	if computed.Checksum != m.Checksum {
+		m.Checksum = computed.Checksum
+		if err := b.writeMetadata(key, m); err != nil {
+			return false, err
+		}
+	}
+
+	blob := blobPath(m.Checksum)
+	if _, err := filesRoot.Stat(blob); err == nil {
+		// Another upload already owns a blob with this content, so replace this copy with a link.
+		tmp := path.Join(blobsDir, "tmp-"+uniuri.New())
+		if err := filesRoot.Rename(key, tmp); err != nil {
+			return false, err
+		}
+		if err := b.link(filesRoot, blob, key, m.Checksum); err != nil {
+			_ = filesRoot.Rename(tmp, key)
+			return false, err
+		}
+		return true, filesRoot.Remove(tmp)
+	}
+
+	return true, b.link(filesRoot, key, key, m.Checksum)
+}
@@ -0,0 +1,133 @@
+package localfs_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/localfs"
+	"gabe565.com/linx-server/internal/backends/localfs/localfstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBackend(t *testing.T, dedup bool) (localfs.Backend, string) {
+	metaPath, filesPath := localfstest.Dirs(t)
+	return localfs.New(metaPath, filesPath, dedup), filesPath
+}
+
+func TestDedupPutAndDelete(t *testing.T) {
+	b, filesPath := newTestBackend(t, true)
+
+	a, err := b.Put(t.Context(), strings.NewReader("File content"), "a.txt", 0, backends.PutOptions{DeleteKey: "a"})
+	require.NoError(t, err)
+	c, err := b.Put(t.Context(), strings.NewReader("File content"), "b.txt", 0, backends.PutOptions{DeleteKey: "b"})
+	require.NoError(t, err)
+	assert.Equal(t, a.Checksum, c.Checksum)
+
+	blob := filepath.Join(filesPath, localfs.BlobPath(a.Checksum))
+	require.FileExists(t, blob)
+
+	// Each upload keeps its own metadata
+	meta, err := b.Head(t.Context(), "b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "b", meta.DeleteKey)
+	assert.EqualValues(t, 12, meta.Size)
+
+	require.NoError(t, b.Delete(t.Context(), "a.txt"))
+	assert.FileExists(t, blob)
+
+	_, r, err := b.Get(t.Context(), "b.txt")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = r.Close() })
+
+	require.NoError(t, b.Delete(t.Context(), "b.txt"))
+	assert.NoFileExists(t, blob)
+
+	list := make([]string, 0, 1)
+	for name, err := range b.List(t.Context()) {
+		require.NoError(t, err)
+		list = append(list, name)
+	}
+	assert.Empty(t, list)
+}
+
+func TestDedupOverwrite(t *testing.T) {
+	b, filesPath := newTestBackend(t, true)
+
+	a, err := b.Put(t.Context(), strings.NewReader("File content"), "a.txt", 0, backends.PutOptions{})
+	require.NoError(t, err)
+	_, err = b.Put(t.Context(), strings.NewReader("File content"), "b.txt", 0, backends.PutOptions{})
+	require.NoError(t, err)
+
+	// Replacing an upload must not modify the shared blob
+	_, err = b.Put(t.Context(), strings.NewReader("New content"), "a.txt", 0, backends.PutOptions{})
+	require.NoError(t, err)
+
+	got, err := os.ReadFile(filepath.Join(filesPath, "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "File content", string(got))
+	assert.FileExists(t, filepath.Join(filesPath, localfs.BlobPath(a.Checksum)))
+}
+
+func TestDedupConvert(t *testing.T) {
+	b, filesPath := newTestBackend(t, false)
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		_, err := b.Put(t.Context(), strings.NewReader("File content"), name, 0, backends.PutOptions{})
+		require.NoError(t, err)
+	}
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		changed, err := b.Dedup(t.Context(), name)
+		require.NoError(t, err)
+		assert.True(t, changed)
+
+		changed, err = b.Dedup(t.Context(), name)
+		require.NoError(t, err)
+		assert.False(t, changed)
+	}
+
+	a, err := os.Stat(filepath.Join(filesPath, "a.txt"))
+	require.NoError(t, err)
+	c, err := os.Stat(filepath.Join(filesPath, "b.txt"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(a, c))
+
+	require.NoError(t, b.Delete(t.Context(), "a.txt"))
+	require.NoError(t, b.Delete(t.Context(), "b.txt"))
+	entries, err := os.ReadDir(filepath.Join(filesPath, localfs.RefsDir))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDedupLockShared(t *testing.T) {
+	metaPath, filesPath := localfstest.Dirs(t)
+	// Each backend has its own mutex, like separate processes sharing the files path.
+	a, b := localfs.New(metaPath, filesPath, true), localfs.New(metaPath, filesPath, true)
+	_, err := a.Put(t.Context(), strings.NewReader("File content"), "a.txt", 0, backends.PutOptions{})
+	require.NoError(t, err)
+
+	unlock, err := a.LockBlobs()
+	require.NoError(t, err)
+
+	locked := make(chan struct{})
+	go func() {
+		defer close(locked)
+		unlock, err := b.LockBlobs()
+		if assert.NoError(t, err) {
+			unlock()
+		}
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("blobs were locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+}
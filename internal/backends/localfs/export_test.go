@@ -0,0 +1,25 @@
+package localfs
+
+import "os"
+
+//nolint:gochecknoglobals
+var BlobPath = blobPath
+
+const RefsDir = refsDir
+
+// LockBlobs locks the blobs of b until unlock is called.
+func (b Backend) LockBlobs() (func(), error) {
+	root, err := os.OpenRoot(b.filesPath)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := b.lockBlobs(root)
+	if err != nil {
+		_ = root.Close()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		_ = root.Close()
+	}, nil
+}
@@ -8,10 +8,12 @@ import (
 	"iter"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"gabe565.com/linx-server/internal/backends"
 	"gabe565.com/linx-server/internal/helpers"
+	"github.com/dchest/uniuri"
 )
 
 var _ backends.ListBackend = Backend{}
@@ -19,21 +21,33 @@ var _ backends.ListBackend = Backend{}
 type Backend struct {
 	metaPath  string
 	filesPath string
+	dedup     bool
+	mu        *sync.Mutex
 }
 
 type MetadataJSON struct {
-	OriginalName string          `json:"original_name,omitzero"`
-	DeleteKey    string          `json:"delete_key"`
-	AccessKey    string          `json:"access_key,omitzero"`
-	Salt         string          `json:"salt,omitzero"`
-	Sha256sum    string          `json:"sha256sum,omitzero"`
-	Checksum     string          `json:"checksum"`
-	Mimetype     string          `json:"mimetype"`
-	Expiry       backends.Expiry `json:"expiry,omitzero"`
-	ArchiveFiles []string        `json:"archive_files,omitzero"`
+	OriginalName  string                  `json:"original_name,omitzero"`
+	DeleteKey     string                  `json:"delete_key"`
+	AccessKey     string                  `json:"access_key,omitzero"`
+	Salt          string                  `json:"salt,omitzero"`
+	Uploader      string                  `json:"uploader,omitzero"`
+	Sha256sum     string                  `json:"sha256sum,omitzero"`
+	Checksum      string                  `json:"checksum"`
+	Mimetype      string                  `json:"mimetype"`
+	Expiry        backends.Expiry         `json:"expiry,omitzero"`
+	ArchiveFiles  []backends.ArchiveEntry `json:"archive_files,omitzero"`
+	DownloadsLeft int                     `json:"downloads_left,omitzero"`
+	Encrypted     bool                    `json:"encrypted,omitzero"`
+	WrappedKey    string                  `json:"wrapped_key,omitzero"`
+	ShareSecret   string                  `json:"share_secret,omitzero"`
 }
 
 func (b Backend) Delete(_ context.Context, key string) error {
+	var checksum string
+	if m, err := b.readMetadata(key); err == nil {
+		checksum = m.Checksum
+	}
+
 	metaRoot, err := os.OpenRoot(b.metaPath)
 	if err != nil {
 		return err
@@ -57,7 +71,17 @@ func (b Backend) Delete(_ context.Context, key string) error {
 		}
 	}
 
-	return errors.Join(filesRoot.Remove(key), metaErr)
+	err = errors.Join(filesRoot.Remove(key), metaErr)
+
+	if checksum != "" {
+		unlock, lockErr := b.lockBlobs(filesRoot)
+		if lockErr != nil {
+			return errors.Join(err, lockErr)
+		}
+		defer unlock()
+		err = errors.Join(err, b.release(filesRoot, key, checksum))
+	}
+	return err
 }
 
 func (b Backend) Exists(_ context.Context, key string) (bool, error) {
@@ -79,6 +103,29 @@ func (b Backend) Exists(_ context.Context, key string) (bool, error) {
 }
 
 func (b Backend) Head(_ context.Context, key string) (backends.Metadata, error) {
+	metadata, err := b.readMetadata(key)
+	if err != nil {
+		return metadata, err
+	}
+
+	filesRoot, err := os.OpenRoot(b.filesPath)
+	if err != nil {
+		return metadata, err
+	}
+	defer func() {
+		_ = filesRoot.Close()
+	}()
+
+	fileStat, err := filesRoot.Stat(key)
+	if err != nil {
+		return metadata, err
+	}
+	metadata.Size = fileStat.Size()
+
+	return metadata, nil
+}
+
+func (b Backend) readMetadata(key string) (backends.Metadata, error) {
 	var metadata backends.Metadata
 
 	metaRoot, err := os.OpenRoot(b.metaPath)
@@ -111,6 +158,7 @@ func (b Backend) Head(_ context.Context, key string) (backends.Metadata, error)
 	metadata.DeleteKey = mjson.DeleteKey
 	metadata.AccessKey = mjson.AccessKey
 	metadata.Salt = mjson.Salt
+	metadata.Uploader = mjson.Uploader
 	metadata.Mimetype = mjson.Mimetype
 	metadata.ArchiveFiles = mjson.ArchiveFiles
 	metadata.Checksum = mjson.Checksum
@@ -118,25 +166,15 @@ func (b Backend) Head(_ context.Context, key string) (backends.Metadata, error)
 		metadata.Checksum = mjson.Sha256sum
 	}
 	metadata.Expiry = time.Time(mjson.Expiry)
+	metadata.DownloadsLeft = mjson.DownloadsLeft
+	metadata.Encrypted = mjson.Encrypted
+	metadata.WrappedKey = mjson.WrappedKey
+	metadata.ShareSecret = mjson.ShareSecret
 
 	if stat, err := f.Stat(); err == nil {
 		metadata.ModTime = stat.ModTime()
 	}
 
-	filesRoot, err := os.OpenRoot(b.filesPath)
-	if err != nil {
-		return metadata, err
-	}
-	defer func() {
-		_ = filesRoot.Close()
-	}()
-
-	fileStat, err := filesRoot.Stat(key)
-	if err != nil {
-		return metadata, err
-	}
-	metadata.Size = fileStat.Size()
-
 	return metadata, nil
 }
 
@@ -165,14 +203,19 @@ func (b Backend) ServeFile(key string, w http.ResponseWriter, r *http.Request) e
 
 func (b Backend) writeMetadata(key string, metadata backends.Metadata) error {
 	mjson := MetadataJSON{
-		OriginalName: metadata.OriginalName,
-		DeleteKey:    metadata.DeleteKey,
-		AccessKey:    metadata.AccessKey,
-		Salt:         metadata.Salt,
-		Mimetype:     metadata.Mimetype,
-		ArchiveFiles: metadata.ArchiveFiles,
-		Checksum:     metadata.Checksum,
-		Expiry:       backends.Expiry(metadata.Expiry),
+		OriginalName:  metadata.OriginalName,
+		DeleteKey:     metadata.DeleteKey,
+		AccessKey:     metadata.AccessKey,
+		Salt:          metadata.Salt,
+		Uploader:      metadata.Uploader,
+		Mimetype:      metadata.Mimetype,
+		ArchiveFiles:  metadata.ArchiveFiles,
+		Checksum:      metadata.Checksum,
+		Expiry:        backends.Expiry(metadata.Expiry),
+		DownloadsLeft: metadata.DownloadsLeft,
+		Encrypted:     metadata.Encrypted,
+		WrappedKey:    metadata.WrappedKey,
+		ShareSecret:   metadata.ShareSecret,
 	}
 
 	metaRoot, err := os.OpenRoot(b.metaPath)
@@ -183,16 +226,19 @@ func (b Backend) writeMetadata(key string, metadata backends.Metadata) error {
 		_ = metaRoot.Close()
 	}()
 
+	// The metadata is written to a temporary file, then renamed,
+	// so that concurrent reads never see a partially written file.
 	var success bool
 	path := key + ".json"
-	f, err := metaRoot.Create(path)
+	tmp := path + ".tmp-" + uniuri.New()
+	f, err := metaRoot.Create(tmp)
 	if err != nil {
 		return err
 	}
 	defer func() {
 		_ = f.Close()
 		if !success {
-			_ = metaRoot.Remove(path)
+			_ = metaRoot.Remove(tmp)
 		}
 	}()
 
@@ -205,6 +251,10 @@ func (b Backend) writeMetadata(key string, metadata backends.Metadata) error {
 		return err
 	}
 
+	if err := metaRoot.Rename(tmp, path); err != nil {
+		return err
+	}
+
 	success = true
 	return nil
 }
@@ -226,6 +276,21 @@ func (b Backend) Put(
 		_ = filesRoot.Close()
 	}()
 
+	if b.dedup {
+		return b.putDedup(filesRoot, r, key, size, opts)
+	}
+
+	// Remove the previous upload first, since it may be a link to a deduplicated blob.
+	unlock, err := b.lockBlobs(filesRoot)
+	if err != nil {
+		return m, err
+	}
+	err = b.unlink(filesRoot, key)
+	unlock()
+	if err != nil {
+		return m, err
+	}
+
 	var success bool
 	f, err := filesRoot.Create(key)
 	if err != nil {
@@ -255,8 +320,14 @@ func (b Backend) Put(
 	m.DeleteKey = opts.DeleteKey
 	m.AccessKey = opts.AccessKey
 	m.Salt = opts.Salt
-
-	if _, err := f.Seek(0, io.SeekStart); err == nil {
+	m.Uploader = opts.Uploader
+	m.DownloadsLeft = opts.DownloadsLeft
+	m.Encrypted = opts.Encrypted
+	m.WrappedKey = opts.WrappedKey
+
+	if opts.Mimetype != "" {
+		m.Mimetype = opts.Mimetype
+	} else if _, err := f.Seek(0, io.SeekStart); err == nil {
 		m.ArchiveFiles, _ = helpers.ListArchiveFiles(m.Mimetype, m.Size, f)
 	}
 
@@ -312,9 +383,11 @@ func (b Backend) List(_ context.Context) iter.Seq2[string, error] {
 	}
 }
 
-func New(metaPath string, filesPath string) Backend {
+func New(metaPath string, filesPath string, dedup bool) Backend {
 	return Backend{
 		metaPath:  metaPath,
 		filesPath: filesPath,
+		dedup:     dedup,
+		mu:        &sync.Mutex{},
 	}
 }
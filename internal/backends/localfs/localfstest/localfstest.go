@@ -0,0 +1,25 @@
+package localfstest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gabe565.com/linx-server/internal/backends/localfs"
+	"github.com/stretchr/testify/require"
+)
+
+// Dirs creates a metadata and a files directory in a temporary directory.
+func Dirs(t testing.TB) (string, string) {
+	tmp := t.TempDir()
+	metaPath, filesPath := filepath.Join(tmp, "meta"), filepath.Join(tmp, "files")
+	require.NoError(t, os.Mkdir(metaPath, 0o700))
+	require.NoError(t, os.Mkdir(filesPath, 0o755))
+	return metaPath, filesPath
+}
+
+// New creates a local storage backend in a temporary directory.
+func New(t testing.TB) localfs.Backend {
+	metaPath, filesPath := Dirs(t)
+	return localfs.New(metaPath, filesPath, false)
+}
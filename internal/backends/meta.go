@@ -1,8 +1,11 @@
 package backends
 
 import (
+	"encoding/json"
 	"errors"
+	"io/fs"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -11,14 +14,52 @@ type Metadata struct {
 	DeleteKey    string
 	AccessKey    string
 	Salt         string
+	Uploader     string
 	Checksum     string
 	Mimetype     string
 	Size         int64
 	ModTime      time.Time
 	Expiry       time.Time
-	ArchiveFiles []string
+	ArchiveFiles []ArchiveEntry
+	// DownloadsLeft is the number of times the file can still be downloaded. 0 means unlimited.
+	DownloadsLeft int
+	// Encrypted is set for uploads which were encrypted by the client. The server can't read their contents.
+	Encrypted bool
+	// WrappedKey is the encrypted data key of an upload which is encrypted at rest.
+	// It is only used between the at-rest encryption wrapper and the backend which stores the upload.
+	WrappedKey string
+	// ShareSecret signs the upload's share links. Replacing it revokes every link.
+	ShareSecret string
 }
 
+// ArchiveEntry is a file or directory which was listed from an archive upload.
+type ArchiveEntry struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode,omitzero"`
+	ModTime time.Time   `json:"mtime,omitzero"`
+	IsDir   bool        `json:"is_dir,omitzero"`
+}
+
+// UnmarshalJSON also accepts a bare path, which older versions stored for each entry.
+func (e *ArchiveEntry) UnmarshalJSON(b []byte) error {
+	var path string
+	if err := json.Unmarshal(b, &path); err == nil {
+		*e = ArchiveEntry{Path: path, IsDir: strings.HasSuffix(path, "/")}
+		return nil
+	}
+
+	type entry ArchiveEntry
+	return json.Unmarshal(b, (*entry)(e))
+}
+
+const (
+	// EncryptedMimetype is stored for encrypted uploads instead of a detected type.
+	EncryptedMimetype = "application/octet-stream"
+	// CollectionMimetype is stored for collections, whose content lists the uploads they contain.
+	CollectionMimetype = "application/vnd.linx.collection+json"
+)
+
 var ErrBadMetadata = errors.New("corrupted metadata")
 
 func (m Metadata) Etag() string {
@@ -0,0 +1,253 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/helpers"
+	"gabe565.com/linx-server/internal/util"
+	"github.com/minio/minio-go/v7"
+)
+
+// Deduplicated uploads are stored as an empty pointer object which references a blob named by its checksum.
+// Each upload also owns an empty marker under the blob's refs prefix, and the blob is removed with its last marker.
+// References are only locked within a process, so the cleanup and dedup commands must not run while a server
+// uses the same bucket. Otherwise a blob may be removed while another process links a new upload to it.
+const (
+	blobPrefix = "blobs/"
+	refPrefix  = "refs/"
+
+	Blob      = "blob"
+	BlobSize  = "blobsize"
+	Sha256sum = "sha256sum"
+)
+
+func blobKey(checksum string) string {
+	return blobPrefix + checksum
+}
+
+func refKey(checksum, key string) string {
+	return refPrefix + checksum + "/" + key
+}
+
+func isInternalKey(key string) bool {
+	return strings.HasPrefix(key, blobPrefix) || strings.HasPrefix(key, refPrefix)
+}
+
+// pointerBlob returns the checksum of the blob referenced by a pointer object, or an empty string.
+func pointerBlob(info minio.ObjectInfo) string {
+	for k, v := range info.UserMetadata {
+		if strings.EqualFold(k, Blob) {
+			return v
+		}
+	}
+	return ""
+}
+
+func mapPointerMetadata(m backends.Metadata) map[string]string {
+	mapped := mapMetadata(m)
+	mapped[Blob] = m.Checksum
+	mapped[BlobSize] = strconv.FormatInt(m.Size, 10)
+	mapped[Sha256sum] = m.Checksum
+	return mapped
+}
+
+func (b Backend) putPointer(ctx context.Context, key string, m backends.Metadata) error {
+	_, err := b.client.PutObject(ctx, b.bucket, key, bytes.NewReader(nil), 0, minio.PutObjectOptions{
+		ContentType:        m.Mimetype,
+		ContentDisposition: util.EncodeContentDisposition("attachment", m.OriginalName),
+		UserMetadata:       mapPointerMetadata(m),
+	})
+	return err
+}
+
+func (b Backend) putDedup(
+	ctx context.Context,
+	r io.Reader,
+	key string,
+	size int64,
+	opts backends.PutOptions,
+) (backends.Metadata, error) {
+	var m backends.Metadata
+
+	tmp, err := os.CreateTemp("", "linx-dedup-*")
+	if err != nil {
+		return m, err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	m, err = helpers.GenerateMetadata(io.TeeReader(r, tmp))
+	if err != nil {
+		return m, err
+	}
+
+	switch {
+	case m.Size == 0:
+		return m, backends.ErrFileEmpty
+	case size > 0 && m.Size != size:
+		return m, backends.ErrSizeMismatch
+	}
+
+	m.OriginalName = opts.OriginalName
+	m.Expiry = opts.Expiry
+	m.DeleteKey = opts.DeleteKey
+	m.AccessKey = opts.AccessKey
+	m.Salt = opts.Salt
+	m.Uploader = opts.Uploader
+	m.DownloadsLeft = opts.DownloadsLeft
+	m.Encrypted = opts.Encrypted
+	m.WrappedKey = opts.WrappedKey
+	if opts.Mimetype != "" {
+		m.Mimetype = opts.Mimetype
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.unlink(ctx, key); err != nil {
+		return m, err
+	}
+
+	if _, err := b.client.StatObject(ctx, b.bucket, blobKey(m.Checksum), minio.StatObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).StatusCode != http.StatusNotFound {
+			return m, err
+		}
+
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return m, err
+		}
+		if _, err := b.client.PutObject(ctx, b.bucket, blobKey(m.Checksum), tmp, m.Size, minio.PutObjectOptions{
+			ContentType: m.Mimetype,
+		}); err != nil {
+			return m, err
+		}
+	}
+
+	if err := b.addRef(ctx, key, m.Checksum); err != nil {
+		return m, err
+	}
+
+	if err := b.putPointer(ctx, key, m); err != nil {
+		_ = b.release(ctx, key, m.Checksum)
+		return m, err
+	}
+
+	return m, nil
+}
+
+func (b Backend) addRef(ctx context.Context, key, checksum string) error {
+	_, err := b.client.PutObject(ctx, b.bucket, refKey(checksum, key), bytes.NewReader(nil), 0,
+		minio.PutObjectOptions{},
+	)
+	return err
+}
+
+// unlink removes an existing upload so that it can be replaced.
+// b.mu must be held.
+func (b Backend) unlink(ctx context.Context, key string) error {
+	info, err := b.client.StatObject(ctx, b.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+
+	if checksum := pointerBlob(info); checksum != "" {
+		if err := b.client.RemoveObject(ctx, b.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+		return b.release(ctx, key, checksum)
+	}
+	return nil
+}
+
+// release drops the reference from key to a blob, removing the blob once it is unreferenced.
+// b.mu must be held.
+func (b Backend) release(ctx context.Context, key, checksum string) error {
+	if err := b.client.RemoveObject(ctx, b.bucket, refKey(checksum, key), minio.RemoveObjectOptions{}); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for item := range b.client.ListObjectsIter(ctx, b.bucket, minio.ListObjectsOptions{
+		Prefix:  refPrefix + checksum + "/",
+		MaxKeys: 1,
+	}) {
+		if item.Err != nil {
+			return item.Err
+		}
+		return nil
+	}
+
+	return b.client.RemoveObject(ctx, b.bucket, blobKey(checksum), minio.RemoveObjectOptions{})
+}
+
+// Dedup converts an existing upload to deduplicated storage.
+func (b Backend) Dedup(ctx context.Context, key string) (bool, error) {
+	info, err := b.client.StatObject(ctx, b.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+			return false, backends.ErrNotFound
+		}
+		return false, err
+	}
+	if pointerBlob(info) != "" {
+		return false, nil
+	}
+
+	m, err := unmapMetadata(info)
+	if err != nil {
+		return false, err
+	}
+
+	obj, err := b.client.GetObject(ctx, b.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return false, err
+	}
+	computed, err := helpers.GenerateMetadata(obj)
+	_ = obj.Close()
+	if err != nil {
+		return false, err
+	}
+	m.Checksum = computed.Checksum
+	m.Size = computed.Size
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := b.client.StatObject(ctx, b.bucket, blobKey(m.Checksum), minio.StatObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).StatusCode != http.StatusNotFound {
+			return false, err
+		}
+
+		// Copy server-side so the content does not need to be uploaded again.
+		if _, err := b.client.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: b.bucket, Object: blobKey(m.Checksum), ReplaceMetadata: true},
+			minio.CopySrcOptions{Bucket: b.bucket, Object: key},
+		); err != nil {
+			return false, err
+		}
+	}
+
+	if err := b.addRef(ctx, key, m.Checksum); err != nil {
+		return false, err
+	}
+
+	if err := b.putPointer(ctx, key, m); err != nil {
+		return false, errors.Join(err, b.release(ctx, key, m.Checksum))
+	}
+	return true, nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"mime"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,10 +14,15 @@ import (
 )
 
 const (
-	DeleteKey = "deletekey"
-	AccessKey = "accesskey"
-	Salt      = "salt"
-	Expiry    = "expiry"
+	DeleteKey     = "deletekey"
+	AccessKey     = "accesskey"
+	Salt          = "salt"
+	Uploader      = "uploader"
+	Expiry        = "expiry"
+	DownloadsLeft = "downloadsleft"
+	Encrypted     = "encrypted"
+	WrappedKey    = "wrappedkey"
+	ShareSecret   = "sharesecret"
 )
 
 func mapMetadata(m backends.Metadata) map[string]string {
@@ -30,9 +36,24 @@ func mapMetadata(m backends.Metadata) map[string]string {
 	if m.Salt != "" {
 		mapped[Salt] = url.QueryEscape(m.Salt)
 	}
+	if m.Uploader != "" {
+		mapped[Uploader] = url.QueryEscape(m.Uploader)
+	}
 	if !m.Expiry.IsZero() {
 		mapped[Expiry] = m.Expiry.Format(time.RFC3339)
 	}
+	if m.DownloadsLeft != 0 {
+		mapped[DownloadsLeft] = strconv.Itoa(m.DownloadsLeft)
+	}
+	if m.Encrypted {
+		mapped[Encrypted] = "true"
+	}
+	if m.WrappedKey != "" {
+		mapped[WrappedKey] = m.WrappedKey
+	}
+	if m.ShareSecret != "" {
+		mapped[ShareSecret] = m.ShareSecret
+	}
 	return mapped
 }
 
@@ -62,8 +83,16 @@ func unmapMetadata(info minio.ObjectInfo) (backends.Metadata, error) {
 			m.AccessKey = util.TryQueryUnescape(v)
 		case Salt:
 			m.Salt = util.TryQueryUnescape(v)
-		case "sha256sum":
+		case Uploader:
+			m.Uploader = util.TryQueryUnescape(v)
+		case Sha256sum:
 			m.Checksum = v
+		case BlobSize:
+			size, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return m, err
+			}
+			m.Size = size
 		case "mimetype":
 			m.Mimetype = v
 		case Expiry:
@@ -78,6 +107,18 @@ func unmapMetadata(info minio.ObjectInfo) (backends.Metadata, error) {
 			}
 
 			m.Expiry = time.Time(expiry)
+		case DownloadsLeft:
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return m, err
+			}
+			m.DownloadsLeft = n
+		case Encrypted:
+			m.Encrypted = v == "true"
+		case WrappedKey:
+			m.WrappedKey = v
+		case ShareSecret:
+			m.ShareSecret = v
 		}
 	}
 	return m, nil
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"gabe565.com/linx-server/internal/backends"
@@ -18,15 +19,24 @@ import (
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
-var _ backends.ListBackend = Backend{}
+var _ backends.DedupBackend = Backend{}
 
 type Backend struct {
 	bucket string
 	client *minio.Client
+	dedup  bool
+	mu     *sync.Mutex
 }
 
 func (b Backend) Delete(ctx context.Context, key string) error {
-	return b.client.RemoveObject(ctx, b.bucket, key, minio.RemoveObjectOptions{})
+	info, err := b.client.StatObject(ctx, b.bucket, key, minio.StatObjectOptions{})
+	if err != nil || pointerBlob(info) == "" {
+		return b.client.RemoveObject(ctx, b.bucket, key, minio.RemoveObjectOptions{})
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.unlink(ctx, key)
 }
 
 func (b Backend) Exists(ctx context.Context, key string) (bool, error) {
@@ -73,6 +83,13 @@ func (b Backend) Get(ctx context.Context, key string) (backends.Metadata, io.Rea
 		return backends.Metadata{}, nil, err
 	}
 
+	if checksum := pointerBlob(info); checksum != "" {
+		_ = obj.Close()
+		if obj, err = b.client.GetObject(ctx, b.bucket, blobKey(checksum), minio.GetObjectOptions{}); err != nil {
+			return backends.Metadata{}, nil, err
+		}
+	}
+
 	return m, obj, nil
 }
 
@@ -91,6 +108,14 @@ func (b Backend) ServeFile(key string, w http.ResponseWriter, r *http.Request) e
 	var mod time.Time
 	if stat, err := obj.Stat(); err == nil {
 		mod = stat.LastModified
+
+		if checksum := pointerBlob(stat); checksum != "" {
+			_ = obj.Close()
+			obj, err = b.client.GetObject(r.Context(), b.bucket, blobKey(checksum), minio.GetObjectOptions{})
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	http.ServeContent(w, r, key, mod, obj)
@@ -106,22 +131,41 @@ func (b Backend) Put(
 ) (backends.Metadata, error) {
 	var m backends.Metadata
 
-	mime, r, err := helpers.DetectMimetype(r)
+	if b.dedup {
+		return b.putDedup(ctx, r, key, size, opts)
+	}
+
+	b.mu.Lock()
+	err := b.unlink(ctx, key)
+	b.mu.Unlock()
 	if err != nil {
 		return m, err
 	}
 
+	mimeType := opts.Mimetype
+	if mimeType == "" {
+		mime, detected, err := helpers.DetectMimetype(r)
+		if err != nil {
+			return m, err
+		}
+		r, mimeType = detected, mime.String()
+	}
+
 	if size == 0 {
 		size = -1
 	}
 
 	m = backends.Metadata{
-		OriginalName: opts.OriginalName,
-		DeleteKey:    opts.DeleteKey,
-		AccessKey:    opts.AccessKey,
-		Salt:         opts.Salt,
-		Mimetype:     mime.String(),
-		Expiry:       opts.Expiry,
+		OriginalName:  opts.OriginalName,
+		DeleteKey:     opts.DeleteKey,
+		AccessKey:     opts.AccessKey,
+		Salt:          opts.Salt,
+		Uploader:      opts.Uploader,
+		Mimetype:      mimeType,
+		Expiry:        opts.Expiry,
+		DownloadsLeft: opts.DownloadsLeft,
+		Encrypted:     opts.Encrypted,
+		WrappedKey:    opts.WrappedKey,
 	}
 
 	info, err := b.client.PutObject(ctx, b.bucket, key, r, size, minio.PutObjectOptions{
@@ -143,12 +187,18 @@ func (b Backend) Put(
 }
 
 func (b Backend) PutMetadata(ctx context.Context, key string, m backends.Metadata) error {
+	userMetadata := mapMetadata(m)
+	if info, err := b.client.StatObject(ctx, b.bucket, key, minio.StatObjectOptions{}); err == nil &&
+		pointerBlob(info) != "" {
+		userMetadata = mapPointerMetadata(m)
+	}
+
 	src := minio.CopySrcOptions{Bucket: b.bucket, Object: key}
 	dst := minio.CopyDestOptions{
 		Bucket:          b.bucket,
 		Object:          key,
 		ReplaceMetadata: true,
-		UserMetadata:    mapMetadata(m),
+		UserMetadata:    userMetadata,
 	}
 	_, err := b.client.CopyObject(ctx, dst, src)
 	return err
@@ -159,6 +209,10 @@ func (b Backend) Size(ctx context.Context, key string) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
+	if pointerBlob(info) != "" {
+		m, err := unmapMetadata(info)
+		return m.Size, err
+	}
 	return info.Size, nil
 }
 
@@ -173,6 +227,10 @@ func (b Backend) List(ctx context.Context) iter.Seq2[string, error] {
 				return
 			}
 
+			if isInternalKey(item.Key) {
+				continue
+			}
+
 			if !yield(item.Key, nil) {
 				return
 			}
@@ -183,7 +241,7 @@ func (b Backend) List(ctx context.Context) iter.Seq2[string, error] {
 func New(
 	_ context.Context,
 	bucket, region, endpoint string,
-	forcePathStyle bool,
+	forcePathStyle, dedup bool,
 ) (Backend, error) {
 	u, err := url.Parse(endpoint)
 	if err != nil {
@@ -206,5 +264,5 @@ func New(
 	if err != nil {
 		return Backend{}, err
 	}
-	return Backend{bucket: bucket, client: client}, nil
+	return Backend{bucket: bucket, client: client, dedup: dedup, mu: &sync.Mutex{}}, nil
 }
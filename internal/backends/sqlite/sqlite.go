@@ -0,0 +1,222 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/url"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+	_ "modernc.org/sqlite"
+)
+
+var _ backends.MetadataStore = &Store{}
+
+// Migrations are applied in order and tracked with the user_version pragma.
+//
+//nolint:gochecknoglobals
+var migrations = []string{
+	`CREATE TABLE metadata (
+		key           TEXT PRIMARY KEY,
+		original_name TEXT NOT NULL DEFAULT '',
+		delete_key    TEXT NOT NULL DEFAULT '',
+		access_key    TEXT NOT NULL DEFAULT '',
+		salt          TEXT NOT NULL DEFAULT '',
+		checksum      TEXT NOT NULL DEFAULT '',
+		mimetype      TEXT NOT NULL DEFAULT '',
+		size          INTEGER NOT NULL DEFAULT 0,
+		mod_time      INTEGER NOT NULL DEFAULT 0,
+		expiry        INTEGER,
+		archive_files TEXT
+	);
+	CREATE INDEX metadata_expiry ON metadata (expiry) WHERE expiry IS NOT NULL;
+	CREATE INDEX metadata_checksum ON metadata (checksum);`,
+	`ALTER TABLE metadata ADD COLUMN uploader TEXT NOT NULL DEFAULT '';
+	CREATE INDEX metadata_uploader ON metadata (uploader);`,
+	`ALTER TABLE metadata ADD COLUMN downloads_left INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE metadata ADD COLUMN encrypted INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE metadata ADD COLUMN share_secret TEXT NOT NULL DEFAULT '';`,
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+// New opens the SQLite database at path, creating and migrating it if necessary.
+func New(ctx context.Context, path string) (*Store, error) {
+	dsn := (&url.URL{
+		Scheme: "file",
+		Opaque: path,
+		RawQuery: url.Values{
+			"_pragma": {"busy_timeout(5000)", "journal_mode(WAL)", "synchronous(NORMAL)"},
+			"_txlock": {"immediate"},
+		}.Encode(),
+	}).String()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{db: db}
+	if err := s.migrate(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to migrate metadata index: %w", err)
+	}
+	return s, nil
+}
+
+func (s *Store) migrate(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var version int
+	if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", len(migrations))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Get(ctx context.Context, key string) (backends.Metadata, error) {
+	var m backends.Metadata
+	var modTime int64
+	var expiry sql.NullInt64
+	var archiveFiles sql.NullString
+
+	err := s.db.QueryRowContext(ctx,
+		`SELECT original_name, delete_key, access_key, salt, uploader, checksum, mimetype, size, mod_time, expiry,
+		archive_files, downloads_left, encrypted, share_secret FROM metadata WHERE key = ?`,
+		key,
+	).Scan(
+		&m.OriginalName, &m.DeleteKey, &m.AccessKey, &m.Salt, &m.Uploader, &m.Checksum, &m.Mimetype, &m.Size,
+		&modTime, &expiry, &archiveFiles, &m.DownloadsLeft, &m.Encrypted, &m.ShareSecret,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return m, backends.ErrNotFound
+		}
+		return m, err
+	}
+
+	if modTime != 0 {
+		m.ModTime = time.Unix(0, modTime)
+	}
+	if expiry.Valid {
+		m.Expiry = time.Unix(expiry.Int64, 0)
+	}
+	if archiveFiles.Valid {
+		if err := json.Unmarshal([]byte(archiveFiles.String), &m.ArchiveFiles); err != nil {
+			return m, backends.ErrBadMetadata
+		}
+	}
+	return m, nil
+}
+
+func (s *Store) Put(ctx context.Context, key string, m backends.Metadata) error {
+	var modTime int64
+	if !m.ModTime.IsZero() {
+		modTime = m.ModTime.UnixNano()
+	}
+
+	var expiry sql.NullInt64
+	if !m.Expiry.IsZero() {
+		expiry = sql.NullInt64{Int64: m.Expiry.Unix(), Valid: true}
+	}
+
+	var archiveFiles sql.NullString
+	if len(m.ArchiveFiles) != 0 {
+		b, err := json.Marshal(m.ArchiveFiles)
+		if err != nil {
+			return err
+		}
+		archiveFiles = sql.NullString{String: string(b), Valid: true}
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO metadata
+		(key, original_name, delete_key, access_key, salt, uploader, checksum, mimetype, size, mod_time, expiry,
+		archive_files, downloads_left, encrypted, share_secret) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		key, m.OriginalName, m.DeleteKey, m.AccessKey, m.Salt, m.Uploader, m.Checksum, m.Mimetype, m.Size,
+		modTime, expiry, archiveFiles, m.DownloadsLeft, m.Encrypted, m.ShareSecret,
+	)
+	return err
+}
+
+func (s *Store) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM metadata WHERE key = ?", key)
+	return err
+}
+
+func (s *Store) List(ctx context.Context) iter.Seq2[string, error] {
+	return s.keys(ctx, "SELECT key FROM metadata ORDER BY key")
+}
+
+// ListExpired lists uploads with an expiry before the given time.
+func (s *Store) ListExpired(ctx context.Context, before time.Time) iter.Seq2[string, error] {
+	return s.keys(ctx, "SELECT key FROM metadata WHERE expiry < ? ORDER BY expiry", before.Unix())
+}
+
+// Usage totals the uploads created by an uploader.
+func (s *Store) Usage(ctx context.Context, uploader string) (backends.Usage, error) {
+	var u backends.Usage
+	err := s.db.QueryRowContext(ctx,
+		"SELECT count(*), coalesce(sum(size), 0) FROM metadata WHERE uploader = ?",
+		uploader,
+	).Scan(&u.Files, &u.Bytes)
+	return u, err
+}
+
+// keys collects the results before yielding so that callers are free to modify the store while iterating.
+func (s *Store) keys(ctx context.Context, query string, args ...any) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		rows, err := s.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			yield("", err)
+			return
+		}
+
+		var keys []string
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				_ = rows.Close()
+				yield("", err)
+				return
+			}
+			keys = append(keys, key)
+		}
+		err = errors.Join(rows.Err(), rows.Close())
+
+		for _, key := range keys {
+			if !yield(key, nil) {
+				return
+			}
+		}
+		if err != nil {
+			yield("", err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package sqlite
+
+import (
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T) *Store {
+	s, err := New(t.Context(), filepath.Join(t.TempDir(), "index.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestStore(t *testing.T) {
+	s := newTestStore(t)
+
+	want := backends.Metadata{
+		OriginalName: "test.zip",
+		DeleteKey:    "delete",
+		AccessKey:    "access",
+		Salt:         "salt",
+		Uploader:     "uploader",
+		Checksum:     "abc",
+		Mimetype:     "application/zip",
+		Size:         12,
+		ModTime:      time.Unix(0, time.Now().UnixNano()),
+		Expiry:       time.Unix(time.Now().Add(time.Hour).Unix(), 0),
+		ArchiveFiles: []backends.ArchiveEntry{
+			{Path: "a.txt", Size: 5, Mode: 0o644},
+			{Path: "dir/", Mode: fs.ModeDir | 0o755, IsDir: true},
+		},
+		DownloadsLeft: 3,
+		Encrypted:     true,
+		ShareSecret:   "secret",
+	}
+	require.NoError(t, s.Put(t.Context(), "test.zip", want))
+
+	got, err := s.Get(t.Context(), "test.zip")
+	require.NoError(t, err)
+	assert.True(t, want.ModTime.Equal(got.ModTime))
+	assert.True(t, want.Expiry.Equal(got.Expiry))
+	got.ModTime, got.Expiry = want.ModTime, want.Expiry
+	assert.Equal(t, want, got)
+
+	usage, err := s.Usage(t.Context(), "uploader")
+	require.NoError(t, err)
+	assert.Equal(t, backends.Usage{Files: 1, Bytes: 12}, usage)
+
+	require.NoError(t, s.Put(t.Context(), "test.zip", backends.Metadata{Mimetype: "text/plain"}))
+	got, err = s.Get(t.Context(), "test.zip")
+	require.NoError(t, err)
+	assert.Equal(t, backends.Metadata{Mimetype: "text/plain"}, got)
+
+	require.NoError(t, s.Delete(t.Context(), "test.zip"))
+	_, err = s.Get(t.Context(), "test.zip")
+	require.ErrorIs(t, err, backends.ErrNotFound)
+}
+
+func TestStoreLegacyArchiveFiles(t *testing.T) {
+	s := newTestStore(t)
+	require.NoError(t, s.Put(t.Context(), "test.zip", backends.Metadata{Mimetype: "application/zip"}))
+
+	// Older versions stored a bare path for each entry
+	_, err := s.db.ExecContext(t.Context(),
+		`UPDATE metadata SET archive_files = '["a.txt","dir/"]' WHERE key = 'test.zip'`,
+	)
+	require.NoError(t, err)
+
+	got, err := s.Get(t.Context(), "test.zip")
+	require.NoError(t, err)
+	assert.Equal(t, []backends.ArchiveEntry{
+		{Path: "a.txt"},
+		{Path: "dir/", IsDir: true},
+	}, got.ArchiveFiles)
+}
+
+func TestStoreListExpired(t *testing.T) {
+	s := newTestStore(t)
+
+	now := time.Now()
+	for key, expiry := range map[string]time.Time{
+		"expired.txt": now.Add(-time.Hour),
+		"future.txt":  now.Add(time.Hour),
+		"never.txt":   {},
+	} {
+		require.NoError(t, s.Put(t.Context(), key, backends.Metadata{Expiry: expiry}))
+	}
+
+	var keys []string
+	for key, err := range s.List(t.Context()) {
+		require.NoError(t, err)
+		keys = append(keys, key)
+	}
+	assert.Equal(t, []string{"expired.txt", "future.txt", "never.txt"}, keys)
+
+	keys = keys[:0]
+	for key, err := range s.ListExpired(t.Context(), now) {
+		require.NoError(t, err)
+		keys = append(keys, key)
+	}
+	assert.Equal(t, []string{"expired.txt"}, keys)
+}
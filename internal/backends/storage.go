@@ -26,6 +26,16 @@ type PutOptions struct {
 	DeleteKey    string
 	AccessKey    string
 	Salt         string
+	Uploader     string
+	// DownloadsLeft deletes the upload after it has been downloaded this many times. 0 means unlimited.
+	DownloadsLeft int
+	// Mimetype is stored instead of a detected type, and archives aren't listed,
+	// for contents which can't be inspected.
+	Mimetype string
+	// Encrypted marks an upload which was encrypted by the client.
+	Encrypted bool
+	// WrappedKey is stored for uploads which are encrypted at rest.
+	WrappedKey string
 }
 
 type ListBackend interface {
@@ -33,6 +43,51 @@ type ListBackend interface {
 	List(ctx context.Context) iter.Seq2[string, error]
 }
 
+// DedupBackend can convert existing uploads to deduplicated, content-addressed storage.
+type DedupBackend interface {
+	ListBackend
+	Dedup(ctx context.Context, key string) (bool, error)
+}
+
+// ExpiryBackend can list expired uploads without reading the metadata of every upload.
+type ExpiryBackend interface {
+	ListBackend
+	ListExpired(ctx context.Context, before time.Time) iter.Seq2[string, error]
+}
+
+// MetadataStore indexes upload metadata separately from the storage backend.
+type MetadataStore interface {
+	Get(ctx context.Context, key string) (Metadata, error)
+	Put(ctx context.Context, key string, m Metadata) error
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context) iter.Seq2[string, error]
+	ListExpired(ctx context.Context, before time.Time) iter.Seq2[string, error]
+	Usage(ctx context.Context, uploader string) (Usage, error)
+	Close() error
+}
+
+// Usage is the total size and number of uploads created by an uploader.
+type Usage struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// UsageBackend can total the uploads created by an uploader without reading every upload.
+type UsageBackend interface {
+	Usage(ctx context.Context, uploader string) (Usage, error)
+}
+
+// Unwrap returns the innermost backend if b wraps another backend.
+func Unwrap(b StorageBackend) StorageBackend { //nolint:ireturn
+	for {
+		w, ok := b.(interface{ Unwrap() StorageBackend })
+		if !ok {
+			return b
+		}
+		b = w.Unwrap()
+	}
+}
+
 var (
 	ErrNotFound     = errors.New("file not found")
 	ErrFileEmpty    = errors.New("empty file")
@@ -0,0 +1,73 @@
+package backends
+
+import (
+	"context"
+	"iter"
+	"time"
+)
+
+// Wrap returns b, which wraps inner, with the optional list, expiry and usage interfaces that inner implements.
+// b only needs to implement the methods it changes. The optional methods are called on b if it implements them,
+// otherwise they are called on inner.
+func Wrap(b, inner StorageBackend) StorageBackend { //nolint:ireturn
+	w := wrapper{StorageBackend: b, inner: inner}
+
+	lister, ok := inner.(ListBackend)
+	if !ok {
+		return w
+	}
+	if l, ok := b.(ListBackend); ok {
+		lister = l
+	}
+	list := listWrapper{wrapper: w, lister: lister}
+
+	expiry, ok := inner.(ExpiryBackend)
+	if !ok {
+		return list
+	}
+	usage, ok := inner.(UsageBackend)
+	if !ok {
+		return list
+	}
+	if e, ok := b.(ExpiryBackend); ok {
+		expiry = e
+	}
+	if u, ok := b.(UsageBackend); ok {
+		usage = u
+	}
+	return indexWrapper{listWrapper: list, expiry: expiry, usage: usage}
+}
+
+// wrapper only exposes the StorageBackend methods of b,
+// so the optional interfaces are only implemented when inner implements them.
+type wrapper struct {
+	StorageBackend
+	inner StorageBackend
+}
+
+func (w wrapper) Unwrap() StorageBackend { //nolint:ireturn
+	return w.inner
+}
+
+type listWrapper struct {
+	wrapper
+	lister ListBackend
+}
+
+func (w listWrapper) List(ctx context.Context) iter.Seq2[string, error] {
+	return w.lister.List(ctx)
+}
+
+type indexWrapper struct {
+	listWrapper
+	expiry ExpiryBackend
+	usage  UsageBackend
+}
+
+func (w indexWrapper) ListExpired(ctx context.Context, before time.Time) iter.Seq2[string, error] {
+	return w.expiry.ListExpired(ctx, before)
+}
+
+func (w indexWrapper) Usage(ctx context.Context, uploader string) (Usage, error) {
+	return w.usage.Usage(ctx, uploader)
+}
@@ -7,10 +7,29 @@ import (
 	"time"
 
 	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/metrics"
+	"gabe565.com/linx-server/internal/partial"
+	"gabe565.com/linx-server/internal/webhook"
 )
 
-func Cleanup(ctx context.Context, backend backends.ListBackend, noLogs bool) error {
-	var errs []error
+// orphanGrace is how long chunks without info are kept.
+// Create writes the chunks before the info, so a new partial upload briefly has no info.
+const orphanGrace = time.Hour
+
+func Cleanup(
+	ctx context.Context,
+	backend backends.ListBackend,
+	partials partial.Store,
+	hooks *webhook.Dispatcher,
+	noLogs bool,
+) error {
+	errs := []error{CleanupPartials(ctx, partials, noLogs)}
+
+	if backend, ok := backend.(backends.ExpiryBackend); ok {
+		errs = append(errs, cleanupExpired(ctx, backend, hooks, noLogs))
+		return errors.Join(errs...)
+	}
+
 	for filename, err := range backend.List(ctx) {
 		switch {
 		case err != nil:
@@ -35,20 +54,115 @@ func Cleanup(ctx context.Context, backend backends.ListBackend, noLogs bool) err
 			}
 			if err := backend.Delete(ctx, filename); err != nil {
 				errs = append(errs, err)
+			} else {
+				metrics.CleanupDeleted("upload")
+				hooks.Send(ctx, webhook.EventExpire, filename, metadata)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cleanupExpired deletes uploads which are already known to be expired, without reading every upload's metadata.
+func cleanupExpired(
+	ctx context.Context,
+	backend backends.ExpiryBackend,
+	hooks *webhook.Dispatcher,
+	noLogs bool,
+) error {
+	var errs []error
+	for filename, err := range backend.ListExpired(ctx, time.Now()) {
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+			continue
+		case ctx.Err() != nil:
+			errs = append(errs, ctx.Err())
+			return errors.Join(errs...)
+		}
+
+		// Metadata is only needed for the webhook payload, so the upload is deleted even if it can't be read.
+		var metadata backends.Metadata
+		if hooks != nil {
+			metadata, _ = backend.Head(ctx, filename)
+		}
+
+		if !noLogs {
+			slog.Info("Delete upload", "name", filename)
+		}
+		if err := backend.Delete(ctx, filename); err != nil {
+			errs = append(errs, err)
+		} else {
+			metrics.CleanupDeleted("upload")
+			hooks.Send(ctx, webhook.EventExpire, filename, metadata)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CleanupPartials removes resumable uploads which expired before they were completed.
+func CleanupPartials(ctx context.Context, partials partial.Store, noLogs bool) error {
+	var errs []error
+	for id, err := range partials.List() {
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+			continue
+		case ctx.Err() != nil:
+			errs = append(errs, ctx.Err())
+			return errors.Join(errs...)
+		}
+
+		info, _, err := partials.Head(id)
+		if err != nil && !errors.Is(err, partial.ErrNotFound) {
+			if !noLogs {
+				slog.Warn("Failed to read partial upload", "id", id, "error", err)
+			}
+			continue
+		}
+
+		// Chunks without info were orphaned by an interrupted create.
+		if errors.Is(err, partial.ErrNotFound) && !orphaned(partials, id) {
+			continue
+		}
+		if errors.Is(err, partial.ErrNotFound) || info.Expired() {
+			if !noLogs {
+				slog.Info("Delete partial upload", "id", id)
+			}
+			switch err := partials.Delete(id); {
+			case err == nil:
+				metrics.CleanupDeleted("partial")
+			case !errors.Is(err, partial.ErrNotFound):
+				errs = append(errs, err)
 			}
 		}
 	}
 	return errors.Join(errs...)
 }
 
-func PeriodicCleanup(ctx context.Context, backend backends.ListBackend, d time.Duration, noLogs bool) {
+// orphaned reports whether chunks without info are old enough that their create was interrupted.
+func orphaned(partials partial.Store, id string) bool {
+	modTime, err := partials.ModTime(id)
+	return err == nil && time.Since(modTime) >= orphanGrace
+}
+
+func PeriodicCleanup(
+	ctx context.Context,
+	backend backends.ListBackend,
+	partials partial.Store,
+	hooks *webhook.Dispatcher,
+	d time.Duration,
+	noLogs bool,
+) {
 	ticker := time.NewTicker(d)
 	defer ticker.Stop()
 
 	for {
-		if err := Cleanup(ctx, backend, noLogs); err != nil {
+		start := time.Now()
+		if err := Cleanup(ctx, backend, partials, hooks, noLogs); err != nil {
 			slog.Error("Cleanup failed", "error", err)
 		}
+		metrics.ObserveCleanup(time.Since(start))
 
 		select {
 		case <-ctx.Done():
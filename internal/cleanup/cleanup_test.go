@@ -0,0 +1,35 @@
+package cleanup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gabe565.com/linx-server/internal/partial"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanupPartials(t *testing.T) {
+	path := t.TempDir()
+	partials := partial.New(path)
+
+	require.NoError(t, partials.Create(partial.Info{ID: "active", Size: 1}))
+	require.NoError(t, partials.Create(partial.Info{ID: "expired", Size: 1, Expiry: time.Now().Add(-time.Minute)}))
+
+	// chunks which are still waiting for their info are kept
+	require.NoError(t, os.WriteFile(filepath.Join(path, "creating"), nil, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(path, "orphaned"), nil, 0o600))
+	old := time.Now().Add(-2 * orphanGrace)
+	require.NoError(t, os.Chtimes(filepath.Join(path, "orphaned"), old, old))
+
+	require.NoError(t, CleanupPartials(t.Context(), partials, true))
+
+	var ids []string
+	for id, err := range partials.List() {
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	assert.ElementsMatch(t, []string{"active", "creating"}, ids)
+}
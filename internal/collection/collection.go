@@ -0,0 +1,139 @@
+package collection
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/utils/bytefmt"
+)
+
+// Extension is added to the name of every collection.
+const Extension = "collection"
+
+const maxManifestSize = bytefmt.MiB
+
+var ErrInvalid = errors.New("invalid collection")
+
+// Manifest lists the uploads in a collection. It is stored as the collection's content,
+// so a collection has its own name, delete key, access key and expiry like any other upload.
+type Manifest struct {
+	Files []File `json:"files"`
+}
+
+// File is an upload in a collection.
+// Its checksum is recorded so that the collection never serves an unrelated upload
+// which was created with the same name after the file was deleted.
+type File struct {
+	Name     string `json:"name"`
+	Checksum string `json:"checksum"`
+}
+
+// Is reports whether an upload is a collection.
+func Is(m backends.Metadata) bool {
+	return m.Mimetype == backends.CollectionMimetype
+}
+
+// Title returns the name which a collection was created with, or its filename without the extension.
+func Title(name string, m backends.Metadata) string {
+	title := m.OriginalName
+	if title == "" {
+		title = name
+	}
+	return strings.TrimSuffix(title, "."+Extension)
+}
+
+// Read loads a collection's manifest.
+func Read(ctx context.Context, b backends.StorageBackend, name string) (Manifest, error) {
+	var m Manifest
+	_, rc, err := b.Get(ctx, name)
+	if err != nil {
+		return m, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	if err := json.NewDecoder(io.LimitReader(rc, maxManifestSize)).Decode(&m); err != nil {
+		return m, errors.Join(ErrInvalid, err)
+	}
+	return m, nil
+}
+
+// Contains reports whether an upload is part of the collection.
+// Uploads which replaced a file of the collection are not part of it.
+func (m Manifest) Contains(name string, metadata backends.Metadata) bool {
+	return slices.Contains(m.Files, File{Name: name, Checksum: metadata.Checksum})
+}
+
+// Member is an upload in a collection.
+type Member struct {
+	Name     string
+	Metadata backends.Metadata
+}
+
+// Members returns the collection's uploads.
+// Uploads which were deleted, expired or replaced on their own are skipped.
+func (m Manifest) Members(ctx context.Context, b backends.StorageBackend) ([]Member, error) {
+	members := make([]Member, 0, len(m.Files))
+	for _, f := range m.Files {
+		metadata, err := b.Head(ctx, f.Name)
+		if err != nil {
+			if errors.Is(err, backends.ErrNotFound) {
+				continue
+			}
+			return members, err
+		}
+		if metadata.Expired() || metadata.Checksum != f.Checksum {
+			continue
+		}
+		members = append(members, Member{Name: f.Name, Metadata: metadata})
+	}
+	return members, nil
+}
+
+// WriteZip streams members from the backend into a zip archive, so no temp files are needed.
+// Members are stored without compression, since galleries are mostly already compressed media.
+// Each member is named after its original name, unless another member already uses it.
+func WriteZip(ctx context.Context, w io.Writer, b backends.StorageBackend, members []Member) error {
+	zw := zip.NewWriter(w)
+	used := make(map[string]struct{}, len(members))
+	for _, member := range members {
+		name := member.Metadata.OriginalName
+		if _, ok := used[name]; ok || name == "" {
+			name = member.Name
+		}
+		used[name] = struct{}{}
+
+		if err := writeMember(ctx, zw, b, name, member); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeMember(ctx context.Context, zw *zip.Writer, b backends.StorageBackend, name string, member Member) error {
+	_, rc, err := b.Get(ctx, member.Name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: member.Metadata.ModTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, rc)
+	return err
+}
@@ -0,0 +1,57 @@
+package collection
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/localfs/localfstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMembers(t *testing.T) {
+	b := localfstest.New(t)
+	var manifest Manifest
+	uploads := make(map[string]backends.Metadata)
+	for _, name := range []string{"a.txt", "deleted.txt", "b.txt", "replaced.txt"} {
+		m, err := b.Put(t.Context(), strings.NewReader("File "+name), name, 0, backends.PutOptions{
+			OriginalName: "photo.txt",
+		})
+		require.NoError(t, err)
+		uploads[name] = m
+		manifest.Files = append(manifest.Files, File{Name: name, Checksum: m.Checksum})
+	}
+	require.NoError(t, b.Delete(t.Context(), "deleted.txt"))
+
+	// a file which was deleted, then replaced by an unrelated upload with the same name
+	require.NoError(t, b.Delete(t.Context(), "replaced.txt"))
+	replaced, err := b.Put(t.Context(), strings.NewReader("Private"), "replaced.txt", 0, backends.PutOptions{})
+	require.NoError(t, err)
+
+	assert.True(t, manifest.Contains("b.txt", uploads["b.txt"]))
+	assert.False(t, manifest.Contains("c.txt", uploads["b.txt"]))
+	assert.False(t, manifest.Contains("replaced.txt", replaced))
+
+	members, err := manifest.Members(t.Context(), b)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteZip(t.Context(), &buf, b, members))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 2)
+	// the second file with the same original name keeps its own name
+	assert.Equal(t, "photo.txt", zr.File[0].Name)
+	assert.Equal(t, "b.txt", zr.File[1].Name)
+}
+
+func TestTitle(t *testing.T) {
+	assert.Equal(t, "Screenshots", Title("screenshots.collection", backends.Metadata{
+		OriginalName: "Screenshots.collection",
+	}))
+	assert.Equal(t, "abc123", Title("abc123.collection", backends.Metadata{}))
+}
@@ -0,0 +1,16 @@
+package config
+
+import "gabe565.com/linx-server/internal/auth/unlock"
+
+// NewLockout tracks failed access key attempts. It is nil if lockouts are disabled.
+func (c *Config) NewLockout() *unlock.Lockout {
+	return unlock.NewLockout(c.Auth.MaxAttempts, c.Auth.Lockout.Duration, c.Auth.MaxLockout.Duration)
+}
+
+// UnlockKey returns the secret which signs access key cookies.
+func (c *Config) UnlockKey() []byte {
+	if c.Auth.CookieKey == "" {
+		return unlock.RandomKey()
+	}
+	return []byte(c.Auth.CookieKey)
+}
@@ -28,6 +28,48 @@ func RegisterBasicCompletions(cmd *cobra.Command) {
 				return nil, cobra.ShellCompDirectiveFilterDirs
 			},
 		),
+		cmd.RegisterFlagCompletionFunc(
+			FlagPartialsPath,
+			func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+				return nil, cobra.ShellCompDirectiveFilterDirs
+			},
+		),
+		cmd.RegisterFlagCompletionFunc(
+			FlagThumbnailsPath,
+			func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+				return nil, cobra.ShellCompDirectiveFilterDirs
+			},
+		),
+		cmd.RegisterFlagCompletionFunc(
+			FlagStatsPath,
+			func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+				return nil, cobra.ShellCompDirectiveFilterDirs
+			},
+		),
+		cmd.RegisterFlagCompletionFunc(
+			FlagSharesPath,
+			func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+				return nil, cobra.ShellCompDirectiveFilterDirs
+			},
+		),
+		cmd.RegisterFlagCompletionFunc(
+			FlagTorrentsPath,
+			func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+				return nil, cobra.ShellCompDirectiveFilterDirs
+			},
+		),
+		cmd.RegisterFlagCompletionFunc(
+			FlagWebhooksQueuePath,
+			func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+				return nil, cobra.ShellCompDirectiveFilterDirs
+			},
+		),
+		cmd.RegisterFlagCompletionFunc(
+			FlagMetadataIndex,
+			func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+				return []string{"db", "sqlite"}, cobra.ShellCompDirectiveFilterFileExt
+			},
+		),
 		cmd.RegisterFlagCompletionFunc(
 			FlagS3Endpoint,
 			func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
@@ -84,6 +126,12 @@ func RegisterServeCompletions(cmd *cobra.Command) {
 				return s, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveKeepOrder
 			},
 		),
+		cmd.RegisterFlagCompletionFunc(
+			FlagEvictionPolicy,
+			func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+				return evictionPolicies(), cobra.ShellCompDirectiveNoFileComp
+			},
+		),
 		cmd.RegisterFlagCompletionFunc(
 			FlagMaxExpiry,
 			func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
@@ -98,6 +146,12 @@ func RegisterServeCompletions(cmd *cobra.Command) {
 				}, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveKeepOrder
 			},
 		),
+		cmd.RegisterFlagCompletionFunc(
+			FlagPartialExpiry,
+			func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+				return []string{"1h", "24h", "168h"}, cobra.ShellCompDirectiveNoFileComp
+			},
+		),
 		cmd.RegisterFlagCompletionFunc(
 			FlagTLSCert,
 			func(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
@@ -4,9 +4,19 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync/atomic"
 	"time"
 
+	"gabe565.com/linx-server/internal/auth/apikeys"
+	"gabe565.com/linx-server/internal/auth/unlock"
 	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/capacity"
+	"gabe565.com/linx-server/internal/scan"
+	"gabe565.com/linx-server/internal/share"
+	"gabe565.com/linx-server/internal/stats"
+	"gabe565.com/linx-server/internal/thumbnail"
+	"gabe565.com/linx-server/internal/torrent"
+	"gabe565.com/linx-server/internal/webhook"
 	"gabe565.com/utils/bytefmt"
 )
 
@@ -14,6 +24,13 @@ type Config struct {
 	Bind             string   `toml:"bind"`
 	FilesPath        string   `toml:"files-path"         comment:"Path to files directory"`
 	MetaPath         string   `toml:"meta-path"          comment:"Path to metadata directory"`
+	PartialsPath     string   `toml:"partials-path"      comment:"Path to directory where resumable uploads are staged until complete"`
+	ThumbnailsPath   string   `toml:"thumbnails-path"    comment:"Path to directory where generated image thumbnails are cached"`
+	StatsPath        string   `toml:"stats-path"         comment:"Path to directory where view and download counts are stored"`
+	SharesPath       string   `toml:"shares-path"        comment:"Path to directory where uses of limited share links are counted"`
+	TorrentsPath     string   `toml:"torrents-path"      comment:"Path to directory where torrent piece hashes are cached"`
+	Dedup            bool     `toml:"dedup"              comment:"Store identical uploads only once. Run the dedup command to convert existing uploads."`
+	MetadataIndex    string   `toml:"metadata-index"     comment:"Path to a SQLite database which indexes upload metadata. Run the reindex command after enabling."`
 	SiteName         string   `toml:"site-name"`
 	SiteURL          URL      `toml:"site-url"`
 	ViteURL          string   `toml:"vite-url,omitempty"`
@@ -21,8 +38,11 @@ type Config struct {
 	GracefulShutdown Duration `toml:"graceful-shutdown"  comment:"Maximum time to wait for requests to finish during shutdown"`
 
 	MaxSize               Bytes    `toml:"max-size"                 comment:"Maximum upload file size"`
+	StorageLimit          Bytes    `toml:"storage-limit"            comment:"Maximum total size of all uploads (a value of 0 means no limit)"`
+	EvictionPolicy        string   `toml:"eviction-policy"          comment:"What to do when an upload would exceed the storage limit (reject, oldest, soonest-expiry, largest-never-expiring)"`
 	MaxExpiry             Duration `toml:"max-expiry"               comment:"Maximum expiration time (a value of 0s means no expiry)"`
 	UploadMaxMemory       Bytes    `toml:"upload-max-memory"        comment:"Maximum memory to buffer multipart uploads; excess is written to temp files"`
+	PartialExpiry         Duration `toml:"partial-expiry"           comment:"How long an unfinished resumable upload is kept"`
 	AllowHotlink          bool     `toml:"allow-hotlink"            comment:"Allow hot-linking of files"`
 	AllowReferrers        []string `toml:"allow-referrers"          comment:"Allow some referrers even if hot-linking is disabled."`
 	RemoteUploads         bool     `toml:"remote-uploads"           comment:"Enable remote uploads (/upload?url=https://...)"`
@@ -31,18 +51,34 @@ type Config struct {
 	RandomFilenameLength  int      `toml:"random-filename-length"`
 	RandomDeleteKeyLength int      `toml:"random-delete-key-length"`
 	KeepOriginalFilename  bool     `toml:"keep-original-filename"   comment:"Download as the original filename instead of random filename"`
+	StripExif             bool     `toml:"strip-exif"               comment:"Remove EXIF, GPS and XMP metadata from all uploaded JPEG, PNG and WebP images"`
 	NoLogs                bool     `toml:"no-logs"                  comment:"Remove stdout output for each request"`
 	NoTorrent             bool     `toml:"no-torrent"               comment:"Disable the torrent file endpoint"`
+	Trackers              []string `toml:"trackers"                 comment:"Tracker announce URLs added to torrents and magnet links, in order of preference"`
+	NoThumbnails          bool     `toml:"no-thumbnails"            comment:"Disable the image thumbnail endpoint"`
+	ThumbnailSize         int      `toml:"thumbnail-size"           comment:"Maximum width and height of image thumbnails in pixels"`
+	NoStats               bool     `toml:"no-stats"                 comment:"Disable counting views and downloads of each upload"`
+	Metrics               bool     `toml:"metrics"                  comment:"Serve Prometheus metrics at /metrics"`
 
 	CleanupEvery Duration `toml:"cleanup-every" comment:"How often to clean up expired files. A value of 0 means files will be cleaned up as they are accessed."`
 
 	CustomPagesPath string `toml:"custom-pages-path" comment:"Path to directory containing .md files to render as custom pages"`
 
-	TLS    TLS    `toml:"tls"    comment:"TLS (HTTPS) configuration"`
-	Auth   Auth   `toml:"auth"`
-	S3     S3     `toml:"s3"     comment:"S3-compatible storage configuration"`
-	Limit  Limit  `toml:"limit"  comment:"Configure rate limits"`
-	Header Header `toml:"header" comment:"Modify request/response headers"`
+	TLS          TLS          `toml:"tls"      comment:"TLS (HTTPS) configuration"`
+	Auth         Auth         `toml:"auth"`
+	OIDC         OIDC         `toml:"oidc"     comment:"OpenID Connect login. When an issuer is set, uploads require an SSO login or an API key."`
+	S3           S3           `toml:"s3"       comment:"S3-compatible storage configuration"`
+	Encryption   Encryption   `toml:"encryption" comment:"Encrypt uploads at rest. Run the rotate-key command after adding a new key."`
+	Scan         Scan         `toml:"scan"     comment:"Scan uploads for malware before they are stored"`
+	ContentTypes ContentTypes `toml:"content-types" comment:"Accept or reject uploads by their detected mimetype and extension"`
+	Webhooks     Webhooks     `toml:"webhooks" comment:"Send signed webhooks when uploads are created, deleted or expire"`
+	Limit        Limit        `toml:"limit"    comment:"Configure rate limits"`
+	Header       Header       `toml:"header"   comment:"Modify request/response headers"`
+
+	// AuthKeys, CustomPages and TimeStarted are loaded by server.Setup.
+	AuthKeys    []apikeys.Key `toml:"-"`
+	CustomPages []string      `toml:"-"`
+	TimeStarted time.Time     `toml:"-"`
 }
 
 type TLS struct {
@@ -52,9 +88,25 @@ type TLS struct {
 
 type Auth struct {
 	CookieExpiry Duration `toml:"cookie-expiry" comment:"Expiration time for access key cookies (set to 0s to use session cookies)"`
+	CookieKey    string   `toml:"cookie-key" comment:"Secret used to sign access key cookies. If unset, files must be unlocked again after the server restarts."`
+	MaxAttempts  int      `toml:"max-attempts" comment:"Failed access key attempts before a file is locked (a value of 0 disables lockouts)"`
+	Lockout      Duration `toml:"lockout" comment:"How long a file is locked after too many failed access key attempts. It doubles with each further failure."`
+	MaxLockout   Duration `toml:"max-lockout" comment:"Maximum time a file is locked after failed access key attempts"`
 	Basic        bool     `toml:"basic"         comment:"Allow logging in with basic auth password"`
 	File         string   `toml:"file"          comment:"Path to a file containing newline-separated scrypted auth keys"`
 	RemoteFile   string   `toml:"remote-file"   comment:"Path to a file containing newline-separated scrypted auth keys for remote uploads"`
+	AdminFile    string   `toml:"admin-file"    comment:"Path to a file containing newline-separated scrypted auth keys for the admin API"`
+}
+
+type OIDC struct {
+	Issuer        string   `toml:"issuer"`
+	ClientID      string   `toml:"client-id"`
+	ClientSecret  string   `toml:"client-secret"`
+	Scopes        []string `toml:"scopes"`
+	GroupsClaim   string   `toml:"groups-claim"   comment:"ID token claim which lists the user's groups"`
+	AllowedGroups []string `toml:"allowed-groups" comment:"If set, users must be a member of one of these groups"`
+	SessionExpiry Duration `toml:"session-expiry" comment:"How long a login lasts"`
+	SessionKey    string   `toml:"session-key"    comment:"Secret used to sign session cookies. If unset, sessions end when the server restarts."`
 }
 
 type S3 struct {
@@ -64,6 +116,38 @@ type S3 struct {
 	ForcePathStyle bool   `toml:"force-path-style" comment:"Force path-style addressing for S3 (e.g. https://s3.amazonaws.com/linx/example.txt)"`
 }
 
+type Encryption struct {
+	Key     string `toml:"key"      comment:"Base64-encoded 32 byte master key (e.g. from openssl rand -base64 32)"`
+	KeyFile string `toml:"key-file" comment:"Path to a file containing newline-separated master keys. The first key encrypts new uploads."`
+}
+
+type Scan struct {
+	Clamd    string   `toml:"clamd"     comment:"Address of a clamd daemon (e.g. tcp://127.0.0.1:3310 or unix:///run/clamav/clamd.ctl)"`
+	Command  []string `toml:"command"   comment:"Command which reads each upload on stdin. Exit status 0 means clean and 1 means infected."`
+	FailOpen bool     `toml:"fail-open" comment:"Store uploads when the scanner is unreachable or fails, instead of rejecting them"`
+	Timeout  Duration `toml:"timeout"   comment:"Maximum time to scan an upload"`
+}
+
+type ContentTypes struct {
+	AllowMimetypes  []string `toml:"allow-mimetypes"  comment:"If set, only uploads with these mimetypes are accepted (e.g. image/*, application/pdf)"`
+	DenyMimetypes   []string `toml:"deny-mimetypes"   comment:"Uploads with these mimetypes are rejected (e.g. text/html, application/x-executable)"`
+	AllowExtensions []string `toml:"allow-extensions" comment:"If set, only uploads with these extensions are accepted"`
+	DenyExtensions  []string `toml:"deny-extensions"  comment:"Uploads with these extensions are rejected (e.g. exe, html)"`
+}
+
+type Webhooks struct {
+	QueuePath   string          `toml:"queue-path"   comment:"Path to directory where webhooks are queued until they are delivered"`
+	MaxAttempts int             `toml:"max-attempts" comment:"Number of delivery attempts before a webhook is dropped"`
+	Timeout     Duration        `toml:"timeout"      comment:"Maximum time to wait for a target to respond"`
+	Targets     []WebhookTarget `toml:"targets"      comment:"Endpoints which receive webhooks"`
+}
+
+type WebhookTarget struct {
+	URL    string   `toml:"url"`
+	Secret string   `toml:"secret" comment:"Signs each delivery with HMAC-SHA256 in the Linx-Signature-256 header"`
+	Events []string `toml:"events" comment:"Events to send (upload, delete, expire, lockout). All events except lockout are sent if empty."`
+}
+
 type Limit struct {
 	UploadMaxRequests int      `toml:"upload-max-requests"`
 	UploadInterval    Duration `toml:"upload-interval"`
@@ -85,16 +169,42 @@ func New() *Config {
 		Bind:                  "127.0.0.1:8080",
 		FilesPath:             "data/files",
 		MetaPath:              "data/meta",
+		PartialsPath:          "data/partials",
+		ThumbnailsPath:        "data/thumbnails",
+		StatsPath:             "data/stats",
+		SharesPath:            "data/shares",
+		TorrentsPath:          "data/torrents",
 		SiteName:              "Linx",
 		SelifPath:             "selif",
 		GracefulShutdown:      Duration{30 * time.Second},
 		MaxSize:               4 * bytefmt.GiB,
+		EvictionPolicy:        "reject",
 		UploadMaxMemory:       32 * bytefmt.MiB,
+		PartialExpiry:         Duration{24 * time.Hour},
 		ForceRandomFilename:   true,
 		RandomFilenameLength:  8,
 		RandomDeleteKeyLength: 32,
 		KeepOriginalFilename:  true,
+		ThumbnailSize:         400,
 		CleanupEvery:          Duration{time.Hour},
+		Auth: Auth{
+			MaxAttempts: 5,
+			Lockout:     Duration{time.Minute},
+			MaxLockout:  Duration{time.Hour},
+		},
+		OIDC: OIDC{
+			Scopes:        []string{"openid", "profile"},
+			GroupsClaim:   "groups",
+			SessionExpiry: Duration{24 * time.Hour},
+		},
+		Scan: Scan{
+			Timeout: Duration{2 * time.Minute},
+		},
+		Webhooks: Webhooks{
+			QueuePath:   "data/webhooks",
+			MaxAttempts: 10,
+			Timeout:     Duration{10 * time.Second},
+		},
 		Limit: Limit{
 			UploadMaxRequests: 5,
 			UploadInterval:    Duration{15 * time.Second},
@@ -112,19 +222,45 @@ func New() *Config {
 		c.Bind = ":8080"
 		c.FilesPath = "/data/files"
 		c.MetaPath = "/data/meta"
+		c.PartialsPath = "/data/partials"
+		c.ThumbnailsPath = "/data/thumbnails"
+		c.StatsPath = "/data/stats"
+		c.SharesPath = "/data/shares"
+		c.TorrentsPath = "/data/torrents"
+		c.Webhooks.QueuePath = "/data/webhooks"
 	}
 	return c
 }
 
 //nolint:gochecknoglobals
 var (
-	Default        = New()
+	current        atomic.Pointer[Config]
 	StorageBackend backends.StorageBackend
-	TimeStarted    time.Time
-	RemoteAuthKeys []string
-	CustomPages    []string
+	Thumbnails     *thumbnail.Cache
+	Stats          *stats.Store
+	Shares         *share.Store
+	Torrents       *torrent.Cache
+	Capacity       *capacity.Backend
+	Scanner        scan.Multi
+	Hooks          *webhook.Dispatcher
+	Lockout        *unlock.Lockout
 )
 
+// Default returns the config in use.
+// Reloads publish a new config with Store, so a config must not be modified once it is stored.
+func Default() *Config {
+	if c := current.Load(); c != nil {
+		return c
+	}
+	current.CompareAndSwap(nil, New())
+	return current.Load()
+}
+
+// Store publishes a config. In-flight requests which already loaded the previous config keep using it.
+func Store(c *Config) {
+	current.Store(c)
+}
+
 func getDefaultFile() (string, error) {
 	const configDir, configFile = "linx-server", "config.toml"
 	var dir string
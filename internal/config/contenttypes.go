@@ -0,0 +1,13 @@
+package config
+
+import "gabe565.com/linx-server/internal/contenttype"
+
+// Policy returns the content type policy which uploads are checked against.
+func (c ContentTypes) Policy() contenttype.Policy {
+	return contenttype.Policy{
+		AllowMimetypes:  c.AllowMimetypes,
+		DenyMimetypes:   c.DenyMimetypes,
+		AllowExtensions: c.AllowExtensions,
+		DenyExtensions:  c.DenyExtensions,
+	}
+}
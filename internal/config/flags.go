@@ -4,41 +4,84 @@ import (
 	"os"
 	"strings"
 
+	"gabe565.com/linx-server/internal/backends/capacity"
 	"github.com/spf13/cobra"
 )
 
 const (
-	FlagConfig              = "config"
-	FlagBind                = "bind"
-	FlagFilesPath           = "files-path"
-	FlagMetaPath            = "meta-path"
-	FlagNoLogs              = "no-logs"
-	FlagAuthBasic           = "auth-basic"
-	FlagAllowHotlink        = "allow-hotlink"
-	FlagSiteName            = "site-name"
-	FlagSiteURL             = "site-url"
-	FlagSelifPath           = "selif-path"
-	FlagGracefulShutdown    = "graceful-shutdown"
-	FlagMaxSize             = "max-size"
-	FlagMaxExpiry           = "max-expiry"
-	FlagUploadMaxMemory     = "upload-max-memory"
-	FlagTLSCert             = "tls-cert"
-	FlagTLSKey              = "tls-key"
-	FlagRealIP              = "real-ip"
-	FlagRemoteUploads       = "remote-uploads"
-	FlagAuthFile            = "auth-file"
-	FlagAuthRemoteFile      = "auth-remote-file"
-	FlagNoDirectAgents      = "no-direct-agents"
-	FlagS3Endpoint          = "s3-endpoint"
-	FlagS3Region            = "s3-region"
-	FlagS3Bucket            = "s3-bucket"
-	FlagS3ForcePathStyle    = "s3-force-path-style"
-	FlagForceRandomFilename = "force-random-filename"
-	FlagAuthCookieExpiry    = "auth-cookie-expiry"
-	FlagCustomPagesPath     = "custom-pages-path"
-	FlagCleanupEvery        = "cleanup-every"
+	FlagConfig                      = "config"
+	FlagBind                        = "bind"
+	FlagFilesPath                   = "files-path"
+	FlagMetaPath                    = "meta-path"
+	FlagPartialsPath                = "partials-path"
+	FlagPartialExpiry               = "partial-expiry"
+	FlagDedup                       = "dedup"
+	FlagMetadataIndex               = "metadata-index"
+	FlagNoLogs                      = "no-logs"
+	FlagAuthBasic                   = "auth-basic"
+	FlagAllowHotlink                = "allow-hotlink"
+	FlagSiteName                    = "site-name"
+	FlagSiteURL                     = "site-url"
+	FlagSelifPath                   = "selif-path"
+	FlagGracefulShutdown            = "graceful-shutdown"
+	FlagMaxSize                     = "max-size"
+	FlagMaxExpiry                   = "max-expiry"
+	FlagUploadMaxMemory             = "upload-max-memory"
+	FlagTLSCert                     = "tls-cert"
+	FlagTLSKey                      = "tls-key"
+	FlagRealIP                      = "real-ip"
+	FlagRemoteUploads               = "remote-uploads"
+	FlagAuthFile                    = "auth-file"
+	FlagAuthRemoteFile              = "auth-remote-file"
+	FlagAuthAdminFile               = "auth-admin-file"
+	FlagNoDirectAgents              = "no-direct-agents"
+	FlagS3Endpoint                  = "s3-endpoint"
+	FlagS3Region                    = "s3-region"
+	FlagS3Bucket                    = "s3-bucket"
+	FlagS3ForcePathStyle            = "s3-force-path-style"
+	FlagEncryptionKey               = "encryption-key"
+	FlagEncryptionKeyFile           = "encryption-key-file"
+	FlagForceRandomFilename         = "force-random-filename"
+	FlagAuthCookieExpiry            = "auth-cookie-expiry"
+	FlagAuthMaxAttempts             = "auth-max-attempts"
+	FlagAuthLockout                 = "auth-lockout"
+	FlagAuthMaxLockout              = "auth-max-lockout"
+	FlagCustomPagesPath             = "custom-pages-path"
+	FlagCleanupEvery                = "cleanup-every"
+	FlagMetrics                     = "metrics"
+	FlagStorageLimit                = "storage-limit"
+	FlagEvictionPolicy              = "eviction-policy"
+	FlagThumbnailsPath              = "thumbnails-path"
+	FlagNoThumbnails                = "no-thumbnails"
+	FlagThumbnailSize               = "thumbnail-size"
+	FlagStatsPath                   = "stats-path"
+	FlagSharesPath                  = "shares-path"
+	FlagTorrentsPath                = "torrents-path"
+	FlagTrackers                    = "trackers"
+	FlagNoStats                     = "no-stats"
+	FlagStripExif                   = "strip-exif"
+	FlagScanClamd                   = "scan-clamd"
+	FlagScanCommand                 = "scan-command"
+	FlagScanFailOpen                = "scan-fail-open"
+	FlagScanTimeout                 = "scan-timeout"
+	FlagContentTypesAllowMimetypes  = "content-types-allow-mimetypes"
+	FlagContentTypesDenyMimetypes   = "content-types-deny-mimetypes"
+	FlagContentTypesAllowExtensions = "content-types-allow-extensions"
+	FlagContentTypesDenyExtensions  = "content-types-deny-extensions"
+	FlagWebhooksQueuePath           = "webhooks-queue-path"
+	FlagWebhooksMaxAttempts         = "webhooks-max-attempts"
+	FlagWebhooksTimeout             = "webhooks-timeout"
 )
 
+func evictionPolicies() []string {
+	policies := capacity.Policies()
+	s := make([]string, 0, len(policies))
+	for _, p := range policies {
+		s = append(s, string(p))
+	}
+	return s
+}
+
 func (c *Config) RegisterBasicFlags(cmd *cobra.Command) {
 	fs := cmd.Flags()
 	confPath := os.Getenv("LINX_CONFIG")
@@ -51,7 +94,31 @@ func (c *Config) RegisterBasicFlags(cmd *cobra.Command) {
 	fs.StringP(FlagConfig, "c", confPath, "Path to the config file")
 	fs.StringVar(&c.FilesPath, FlagFilesPath, c.FilesPath, "Path to files directory")
 	fs.StringVar(&c.MetaPath, FlagMetaPath, c.MetaPath, "Path to metadata directory")
+	fs.StringVar(&c.PartialsPath, FlagPartialsPath, c.PartialsPath,
+		"Path to directory where resumable uploads are staged until complete",
+	)
+	fs.StringVar(&c.ThumbnailsPath, FlagThumbnailsPath, c.ThumbnailsPath,
+		"Path to directory where generated image thumbnails are cached",
+	)
+	fs.StringVar(&c.StatsPath, FlagStatsPath, c.StatsPath,
+		"Path to directory where view and download counts are stored",
+	)
+	fs.StringVar(&c.SharesPath, FlagSharesPath, c.SharesPath,
+		"Path to directory where uses of limited share links are counted",
+	)
+	fs.StringVar(&c.TorrentsPath, FlagTorrentsPath, c.TorrentsPath,
+		"Path to directory where torrent piece hashes are cached",
+	)
+	fs.StringVar(&c.Webhooks.QueuePath, FlagWebhooksQueuePath, c.Webhooks.QueuePath,
+		"Path to directory where webhooks are queued until they are delivered",
+	)
 	fs.BoolVar(&c.NoLogs, FlagNoLogs, c.NoLogs, "Remove logging of each request")
+	fs.BoolVar(&c.Dedup, FlagDedup, c.Dedup,
+		"Store identical uploads only once. Run the dedup command to convert existing uploads.",
+	)
+	fs.StringVar(&c.MetadataIndex, FlagMetadataIndex, c.MetadataIndex,
+		"Path to a SQLite database which indexes upload metadata. Run the reindex command after enabling.",
+	)
 
 	fs.StringVar(&c.S3.Endpoint, FlagS3Endpoint, c.S3.Endpoint, "S3 endpoint")
 	fs.StringVar(&c.S3.Region, FlagS3Region, c.S3.Region, "S3 region")
@@ -59,6 +126,13 @@ func (c *Config) RegisterBasicFlags(cmd *cobra.Command) {
 	fs.BoolVar(&c.S3.ForcePathStyle, FlagS3ForcePathStyle, c.S3.ForcePathStyle,
 		"Force path-style addressing for S3 (e.g. https://s3.amazonaws.com/linx/example.txt)",
 	)
+
+	fs.StringVar(&c.Encryption.Key, FlagEncryptionKey, c.Encryption.Key,
+		"Base64-encoded 32 byte master key which encrypts uploads at rest",
+	)
+	fs.StringVar(&c.Encryption.KeyFile, FlagEncryptionKeyFile, c.Encryption.KeyFile,
+		"Path to a file containing newline-separated master keys. The first key encrypts new uploads.",
+	)
 }
 
 func (c *Config) RegisterServeFlags(cmd *cobra.Command) {
@@ -77,12 +151,19 @@ func (c *Config) RegisterServeFlags(cmd *cobra.Command) {
 		"Maximum time to wait for requests to finish during shutdown",
 	)
 	fs.Var(&c.MaxSize, FlagMaxSize, "Maximum upload file size")
+	fs.Var(&c.StorageLimit, FlagStorageLimit, "Maximum total size of all uploads. A value of 0 means no limit.")
+	fs.StringVar(&c.EvictionPolicy, FlagEvictionPolicy, c.EvictionPolicy,
+		"What to do when an upload would exceed the storage limit (one of "+strings.Join(evictionPolicies(), ", ")+")",
+	)
 	fs.DurationVar(&c.MaxExpiry.Duration, FlagMaxExpiry, c.MaxExpiry.Duration,
 		"Maximum expiration time. A value of 0 means no expiry.",
 	)
 	fs.Var(&c.UploadMaxMemory, FlagUploadMaxMemory,
 		"Maximum memory to buffer multipart uploads; excess is written to temp files",
 	)
+	fs.DurationVar(&c.PartialExpiry.Duration, FlagPartialExpiry, c.PartialExpiry.Duration,
+		"How long an unfinished resumable upload is kept",
+	)
 	fs.StringVar(&c.TLS.Cert, FlagTLSCert, c.TLS.Cert, "Path to ssl certificate (for https)")
 	fs.StringVar(&c.TLS.Key, FlagTLSKey, c.TLS.Key, "Path to ssl key (for https)")
 	fs.BoolVar(&c.Header.RealIP, FlagRealIP, c.Header.RealIP, "Use X-Real-IP/X-Forwarded-For headers")
@@ -93,19 +174,74 @@ func (c *Config) RegisterServeFlags(cmd *cobra.Command) {
 	fs.StringVar(&c.Auth.RemoteFile, FlagAuthRemoteFile, c.Auth.RemoteFile,
 		"Path to a file containing newline-separated scrypted auth keys for remote uploads",
 	)
+	fs.StringVar(&c.Auth.AdminFile, FlagAuthAdminFile, c.Auth.AdminFile,
+		"Path to a file containing newline-separated scrypted auth keys for the admin API",
+	)
 	fs.BoolVar(&c.NoDirectAgents, FlagNoDirectAgents, c.NoDirectAgents,
 		"Disable serving files directly for wget/curl user agents",
 	)
 	fs.BoolVar(&c.ForceRandomFilename, FlagForceRandomFilename, c.ForceRandomFilename,
 		"Force all uploads to use a random filename",
 	)
+	fs.BoolVar(&c.StripExif, FlagStripExif, c.StripExif,
+		"Remove EXIF, GPS and XMP metadata from all uploaded JPEG, PNG and WebP images. "+
+			"Otherwise, uploads can opt in with the Linx-Strip-Exif header.",
+	)
 	fs.DurationVar(&c.Auth.CookieExpiry.Duration, FlagAuthCookieExpiry, c.Auth.CookieExpiry.Duration,
 		"Expiration time for access key cookies in seconds (set 0 to use session cookies)",
 	)
+	fs.IntVar(&c.Auth.MaxAttempts, FlagAuthMaxAttempts, c.Auth.MaxAttempts,
+		"Failed access key attempts before a file is locked (a value of 0 disables lockouts)",
+	)
+	fs.DurationVar(&c.Auth.Lockout.Duration, FlagAuthLockout, c.Auth.Lockout.Duration,
+		"How long a file is locked after too many failed access key attempts. It doubles with each further failure.",
+	)
+	fs.DurationVar(&c.Auth.MaxLockout.Duration, FlagAuthMaxLockout, c.Auth.MaxLockout.Duration,
+		"Maximum time a file is locked after failed access key attempts",
+	)
 	fs.StringVar(&c.CustomPagesPath, FlagCustomPagesPath, c.CustomPagesPath,
 		"Path to directory containing .md files to render as custom pages",
 	)
 	fs.DurationVar(&c.CleanupEvery.Duration, FlagCleanupEvery, c.CleanupEvery.Duration,
 		"How often to clean up expired files. A value of 0 means files will be cleaned up as they are accessed.",
 	)
+	fs.BoolVar(&c.Metrics, FlagMetrics, c.Metrics, "Serve Prometheus metrics at /metrics")
+	fs.StringVar(&c.Scan.Clamd, FlagScanClamd, c.Scan.Clamd,
+		"Address of a clamd daemon which scans uploads (e.g. tcp://127.0.0.1:3310 or unix:///run/clamav/clamd.ctl)",
+	)
+	fs.StringSliceVar(&c.Scan.Command, FlagScanCommand, c.Scan.Command,
+		"Command which reads each upload on stdin. Exit status 0 means clean and 1 means infected.",
+	)
+	fs.BoolVar(&c.Scan.FailOpen, FlagScanFailOpen, c.Scan.FailOpen,
+		"Store uploads when the scanner is unreachable or fails, instead of rejecting them",
+	)
+	fs.DurationVar(&c.Scan.Timeout.Duration, FlagScanTimeout, c.Scan.Timeout.Duration,
+		"Maximum time to scan an upload",
+	)
+	fs.StringSliceVar(&c.ContentTypes.AllowMimetypes, FlagContentTypesAllowMimetypes, c.ContentTypes.AllowMimetypes,
+		"If set, only uploads with these mimetypes are accepted (e.g. image/*, application/pdf)",
+	)
+	fs.StringSliceVar(&c.ContentTypes.DenyMimetypes, FlagContentTypesDenyMimetypes, c.ContentTypes.DenyMimetypes,
+		"Uploads with these mimetypes are rejected (e.g. text/html, application/x-executable)",
+	)
+	fs.StringSliceVar(&c.ContentTypes.AllowExtensions, FlagContentTypesAllowExtensions, c.ContentTypes.AllowExtensions,
+		"If set, only uploads with these extensions are accepted",
+	)
+	fs.StringSliceVar(&c.ContentTypes.DenyExtensions, FlagContentTypesDenyExtensions, c.ContentTypes.DenyExtensions,
+		"Uploads with these extensions are rejected (e.g. exe, html)",
+	)
+	fs.IntVar(&c.Webhooks.MaxAttempts, FlagWebhooksMaxAttempts, c.Webhooks.MaxAttempts,
+		"Number of delivery attempts before a webhook is dropped",
+	)
+	fs.DurationVar(&c.Webhooks.Timeout.Duration, FlagWebhooksTimeout, c.Webhooks.Timeout.Duration,
+		"Maximum time to wait for a webhook target to respond",
+	)
+	fs.StringSliceVar(&c.Trackers, FlagTrackers, c.Trackers,
+		"Tracker announce URLs added to torrents and magnet links, in order of preference",
+	)
+	fs.BoolVar(&c.NoThumbnails, FlagNoThumbnails, c.NoThumbnails, "Disable the image thumbnail endpoint")
+	fs.IntVar(&c.ThumbnailSize, FlagThumbnailSize, c.ThumbnailSize,
+		"Maximum width and height of image thumbnails in pixels",
+	)
+	fs.BoolVar(&c.NoStats, FlagNoStats, c.NoStats, "Disable counting views and downloads of each upload")
 }
@@ -53,7 +53,7 @@ func (c *Config) Load(cmd *cobra.Command) error {
 
 	// Load envs
 	const envPrefix = "LINX_"
-	nested := []string{"tls", "auth", "s3", "limit", "header"}
+	nested := []string{"tls", "auth", "oidc", "s3", "encryption", "scan", "content-types", "webhooks", "limit", "header"}
 	if err := k.Load(env.Provider(".", env.Opt{
 		Prefix: envPrefix,
 		TransformFunc: func(k, v string) (string, any) {
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/spf13/cobra"
+)
+
+// Reload reads the config again and returns a copy of c with the settings which can be changed without a restart.
+// Other settings, including the paths to auth files and custom pages, are left unchanged.
+func (c *Config) Reload(cmd *cobra.Command) (*Config, error) {
+	loaded := New()
+	if err := loaded.Load(cmd); err != nil {
+		return nil, err
+	}
+
+	next := *c
+	next.MaxSize = loaded.MaxSize
+	next.MaxExpiry = loaded.MaxExpiry
+	next.UploadMaxMemory = loaded.UploadMaxMemory
+	next.AllowHotlink = loaded.AllowHotlink
+	next.AllowReferrers = slices.Clone(loaded.AllowReferrers)
+	next.NoDirectAgents = loaded.NoDirectAgents
+	next.StripExif = loaded.StripExif
+	next.Trackers = slices.Clone(loaded.Trackers)
+	next.ContentTypes = loaded.ContentTypes
+	next.Limit = loaded.Limit
+	next.Header.AddHeaders = maps.Clone(loaded.Header.AddHeaders)
+	next.Header.ReferrerPolicy = loaded.Header.ReferrerPolicy
+	next.Header.FileReferrerPolicy = loaded.Header.FileReferrerPolicy
+	next.Header.XFrameOptions = loaded.Header.XFrameOptions
+	return &next, nil
+}
@@ -0,0 +1,23 @@
+package config
+
+import "gabe565.com/linx-server/internal/scan"
+
+// NewScanner creates the configured malware scanners. It is empty if scanning is disabled.
+func (c *Config) NewScanner() (scan.Multi, error) {
+	var scanners scan.Multi
+	if c.Scan.Clamd != "" {
+		clamd, err := scan.NewClamd(c.Scan.Clamd)
+		if err != nil {
+			return nil, err
+		}
+		scanners = append(scanners, clamd)
+	}
+	if len(c.Scan.Command) != 0 {
+		exec, err := scan.NewExec(c.Scan.Command)
+		if err != nil {
+			return nil, err
+		}
+		scanners = append(scanners, exec)
+	}
+	return scanners, nil
+}
@@ -2,23 +2,129 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/atrest"
+	"gabe565.com/linx-server/internal/backends/capacity"
+	"gabe565.com/linx-server/internal/backends/index"
 	"gabe565.com/linx-server/internal/backends/localfs"
 	"gabe565.com/linx-server/internal/backends/s3"
+	"gabe565.com/linx-server/internal/backends/sqlite"
+	"gabe565.com/linx-server/internal/share"
+	"gabe565.com/linx-server/internal/stats"
+	"gabe565.com/linx-server/internal/thumbnail"
+	"gabe565.com/linx-server/internal/torrent"
+)
+
+var (
+	ErrDedupEncryption = errors.New("dedup can't be enabled with encryption at rest")
+	ErrEncryptionKeys  = errors.New("only one of encryption key and encryption key-file can be set")
 )
 
 func (c *Config) NewStorageBackend(ctx context.Context) (backends.StorageBackend, error) { //nolint:ireturn
+	var backend backends.StorageBackend
+	var err error
 	if c.S3.Bucket != "" {
-		return c.NewS3Backend(ctx)
+		backend, err = c.NewS3Backend(ctx)
+	} else {
+		backend, err = c.NewLocalBackend()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Encryption.Enabled() {
+		if c.Dedup {
+			return nil, ErrDedupEncryption
+		}
+		keys, err := c.NewKeyring()
+		if err != nil {
+			return nil, err
+		}
+		backend = atrest.Wrap(backend, keys)
+	}
+
+	if c.MetadataIndex == "" {
+		return backend, nil
+	}
+
+	store, err := c.NewMetadataStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return index.New(backend, store), nil
+}
+
+// LimitStorage enforces the storage limit, if one is configured.
+func (c *Config) LimitStorage( //nolint:ireturn
+	ctx context.Context,
+	backend backends.StorageBackend,
+) (*capacity.Backend, backends.StorageBackend, error) {
+	if c.StorageLimit <= 0 {
+		return nil, backend, nil
+	}
+
+	policy, err := capacity.ParsePolicy(c.EvictionPolicy)
+	if err != nil {
+		return nil, nil, err
+	}
+	return capacity.Wrap(ctx, backend, int64(c.StorageLimit), policy)
+}
+
+// CacheThumbnails opens the thumbnail cache and removes cached thumbnails when uploads are deleted.
+// Thumbnails are disabled when uploads are encrypted at rest, since the cache would store them unencrypted.
+func (c *Config) CacheThumbnails( //nolint:ireturn
+	backend backends.StorageBackend,
+) (*thumbnail.Cache, backends.StorageBackend, error) {
+	if c.Encryption.Enabled() {
+		return nil, backend, nil
+	}
+	cache, err := thumbnail.NewCache(c.ThumbnailsPath, c.ThumbnailSize)
+	if err != nil {
+		return nil, nil, err
 	}
-	return c.NewLocalBackend()
+	return cache, thumbnail.WrapBackend(backend, cache), nil
+}
+
+// CacheTorrents opens the torrent piece hash cache and removes cached hashes when uploads are deleted.
+func (c *Config) CacheTorrents( //nolint:ireturn
+	backend backends.StorageBackend,
+) (*torrent.Cache, backends.StorageBackend, error) {
+	cache, err := torrent.NewCache(c.TorrentsPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cache, torrent.WrapBackend(backend, cache), nil
+}
+
+// TrackStats opens the access stats store and removes an upload's stats when it is deleted or replaced.
+func (c *Config) TrackStats( //nolint:ireturn
+	backend backends.StorageBackend,
+) (*stats.Store, backends.StorageBackend, error) {
+	store, err := stats.NewStore(c.StatsPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, stats.WrapBackend(backend, store), nil
+}
+
+// TrackShares opens the share link store and forgets the uses of an upload's links when it is deleted or replaced.
+func (c *Config) TrackShares( //nolint:ireturn
+	backend backends.StorageBackend,
+) (*share.Store, backends.StorageBackend, error) {
+	store, err := share.NewStore(c.SharesPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, share.WrapBackend(backend, store), nil
 }
 
 func (c *Config) NewS3Backend(ctx context.Context) (s3.Backend, error) {
-	return s3.New(ctx, c.S3.Bucket, c.S3.Region, c.S3.Endpoint, c.S3.ForcePathStyle)
+	return s3.New(ctx, c.S3.Bucket, c.S3.Region, c.S3.Endpoint, c.S3.ForcePathStyle, c.Dedup)
 }
 
 func (c *Config) NewLocalBackend() (localfs.Backend, error) {
@@ -32,5 +138,30 @@ func (c *Config) NewLocalBackend() (localfs.Backend, error) {
 		return localfs.Backend{}, fmt.Errorf("could not create metadata directory: %w", err)
 	}
 
-	return localfs.New(c.MetaPath, c.FilesPath), nil
+	return localfs.New(c.MetaPath, c.FilesPath, c.Dedup), nil
+}
+
+// Enabled reports whether uploads are encrypted at rest.
+func (e Encryption) Enabled() bool {
+	return e.Key != "" || e.KeyFile != ""
+}
+
+// NewKeyring loads the master keys which encrypt uploads at rest.
+func (c *Config) NewKeyring() (*atrest.Keyring, error) {
+	switch {
+	case c.Encryption.Key != "" && c.Encryption.KeyFile != "":
+		return nil, ErrEncryptionKeys
+	case c.Encryption.KeyFile != "":
+		return atrest.LoadKeyring(c.Encryption.KeyFile)
+	default:
+		return atrest.NewKeyring(c.Encryption.Key)
+	}
+}
+
+func (c *Config) NewMetadataStore(ctx context.Context) (*sqlite.Store, error) {
+	if err := os.MkdirAll(filepath.Dir(c.MetadataIndex), 0o700); err != nil {
+		return nil, fmt.Errorf("could not create metadata index directory: %w", err)
+	}
+
+	return sqlite.New(ctx, c.MetadataIndex)
 }
@@ -0,0 +1,26 @@
+package config
+
+import "gabe565.com/linx-server/internal/webhook"
+
+// NewWebhooks creates a dispatcher for the configured webhook targets.
+func (c *Config) NewWebhooks() (*webhook.Dispatcher, error) {
+	targets := make([]webhook.Target, 0, len(c.Webhooks.Targets))
+	for _, t := range c.Webhooks.Targets {
+		target := webhook.Target{URL: t.URL, Secret: t.Secret}
+		for _, name := range t.Events {
+			event, err := webhook.ParseEvent(name)
+			if err != nil {
+				return nil, err
+			}
+			target.Events = append(target.Events, event)
+		}
+		targets = append(targets, target)
+	}
+
+	return webhook.New(webhook.Options{
+		QueuePath:   c.Webhooks.QueuePath,
+		Targets:     targets,
+		MaxAttempts: c.Webhooks.MaxAttempts,
+		Timeout:     c.Webhooks.Timeout.Duration,
+	})
+}
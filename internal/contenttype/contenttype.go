@@ -0,0 +1,115 @@
+package contenttype
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+var ErrNotAllowed = errors.New("content type not allowed")
+
+// Policy decides which uploads are accepted by their mimetype and extension.
+// Uploads matching a deny list are rejected. If an allow list is set, uploads must also match it.
+//
+// Mimetype patterns match exactly, including aliases, or by their type like "image/*".
+// Extension patterns match the whole extension or its last part, so "gz" matches "tar.gz".
+type Policy struct {
+	AllowMimetypes  []string
+	DenyMimetypes   []string
+	AllowExtensions []string
+	DenyExtensions  []string
+
+	exemptMimetypes  []string
+	exemptExtensions []string
+}
+
+// Exempt returns a copy of the policy which accepts the given mimetypes and extensions, even if they are denied.
+// A pattern of "*" accepts everything.
+func (p Policy) Exempt(mimetypes, extensions []string) Policy {
+	p.exemptMimetypes = slices.Concat(p.exemptMimetypes, mimetypes)
+	p.exemptExtensions = slices.Concat(p.exemptExtensions, extensions)
+	return p
+}
+
+// ChecksMimetype reports whether the policy has any mimetype rules, so callers can skip detecting the mimetype.
+func (p Policy) ChecksMimetype() bool {
+	return len(p.AllowMimetypes) != 0 || len(p.DenyMimetypes) != 0
+}
+
+// Check returns a *DeniedError if the policy rejects an upload.
+func (p Policy) Check(mime, extension string) error {
+	mime, _, _ = strings.Cut(mime, ";")
+	mime = strings.ToLower(strings.TrimSpace(mime))
+	if !allowed(mime, p.AllowMimetypes, p.DenyMimetypes, p.exemptMimetypes, matchMimetype) {
+		return &DeniedError{Mimetype: mime}
+	}
+
+	extension = strings.ToLower(strings.Trim(extension, "."))
+	if !allowed(extension, p.AllowExtensions, p.DenyExtensions, p.exemptExtensions, matchExtension) {
+		return &DeniedError{Extension: extension}
+	}
+	return nil
+}
+
+func allowed(v string, allow, deny, exempt []string, match func(pattern, v string) bool) bool {
+	matchAny := func(patterns []string) bool {
+		return slices.ContainsFunc(patterns, func(pattern string) bool {
+			return pattern == "*" || match(strings.ToLower(strings.TrimSpace(pattern)), v)
+		})
+	}
+
+	switch {
+	case matchAny(exempt):
+		return true
+	case matchAny(deny):
+		return false
+	case len(allow) != 0:
+		return matchAny(allow)
+	}
+	return true
+}
+
+func matchMimetype(pattern, mime string) bool {
+	if typ, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mime, typ+"/")
+	}
+	if pattern == mime {
+		return true
+	}
+	if kind := mimetype.Lookup(mime); kind != nil {
+		return kind.Is(pattern)
+	}
+	return false
+}
+
+func matchExtension(pattern, extension string) bool {
+	pattern = strings.Trim(pattern, ".")
+	return pattern == extension || strings.HasSuffix(extension, "."+pattern)
+}
+
+// DeniedError describes why the policy rejected an upload. Only one of its fields is set.
+type DeniedError struct {
+	Mimetype  string
+	Extension string
+}
+
+func (e *DeniedError) Error() string {
+	if e.Extension != "" {
+		return ErrNotAllowed.Error() + ": ." + e.Extension
+	}
+	return ErrNotAllowed.Error() + ": " + e.Mimetype
+}
+
+func (e *DeniedError) Is(target error) bool {
+	return target == ErrNotAllowed
+}
+
+// Message is a description of the error which can be shown to the uploader.
+func (e *DeniedError) Message() string {
+	if e.Extension != "" {
+		return "Files with the ." + e.Extension + " extension are not allowed"
+	}
+	return "Files of type " + e.Mimetype + " are not allowed"
+}
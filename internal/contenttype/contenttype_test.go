@@ -0,0 +1,54 @@
+package contenttype
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	p := Policy{
+		DenyMimetypes:  []string{"text/html", "application/x-zip-compressed"},
+		DenyExtensions: []string{".exe", "gz"},
+	}
+	require.NoError(t, p.Check("text/plain; charset=utf-8", "txt"))
+	require.ErrorIs(t, p.Check("text/html; charset=utf-8", "txt"), ErrNotAllowed)
+	require.ErrorIs(t, p.Check("text/plain", "EXE"), ErrNotAllowed)
+	require.ErrorIs(t, p.Check("application/gzip", "tar.gz"), ErrNotAllowed)
+	// Aliases of a denied mimetype are denied
+	require.ErrorIs(t, p.Check("application/zip", "bin"), ErrNotAllowed)
+
+	p = Policy{AllowMimetypes: []string{"image/*", "application/pdf"}}
+	require.NoError(t, p.Check("image/png", "png"))
+	require.NoError(t, p.Check("application/pdf", "pdf"))
+	require.ErrorIs(t, p.Check("text/plain", "png"), ErrNotAllowed)
+	assert.True(t, p.ChecksMimetype())
+	assert.False(t, Policy{DenyExtensions: []string{"exe"}}.ChecksMimetype())
+}
+
+func TestPolicyExempt(t *testing.T) {
+	p := Policy{
+		AllowMimetypes: []string{"image/*"},
+		DenyExtensions: []string{"exe"},
+	}
+	require.Error(t, p.Check("application/x-elf", "exe"))
+
+	exempt := p.Exempt([]string{"application/x-elf"}, []string{"*"})
+	require.NoError(t, exempt.Check("application/x-elf", "exe"))
+	require.Error(t, exempt.Check("text/plain", "txt"))
+	// The original policy is unchanged
+	require.Error(t, p.Check("application/x-elf", "exe"))
+}
+
+func TestDeniedError(t *testing.T) {
+	p := Policy{DenyMimetypes: []string{"text/html"}, DenyExtensions: []string{"exe"}}
+
+	var err *DeniedError
+	require.ErrorAs(t, p.Check("text/html; charset=utf-8", "html"), &err)
+	assert.Equal(t, "Files of type text/html are not allowed", err.Message())
+
+	require.ErrorAs(t, p.Check("application/octet-stream", "exe"), &err)
+	assert.Equal(t, "Files with the .exe extension are not allowed", err.Message())
+	assert.EqualError(t, err, "content type not allowed: .exe")
+}
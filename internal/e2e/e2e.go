@@ -0,0 +1,298 @@
+package e2e
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The format is described in ENCRYPTION.md.
+const (
+	Magic      = "LINXE2E"
+	Version    = 1
+	SaltSize   = 16
+	HeaderSize = len(Magic) + 1 + 4 + SaltSize
+	KeySize    = 32
+	TagSize    = 16
+
+	DefaultChunkSize = 64 * 1024
+	MaxChunkSize     = 4 * 1024 * 1024
+	MaxInfoSize      = 64 * 1024
+
+	// Extension is used for the names of encrypted uploads, since their real names are encrypted.
+	Extension = "bin"
+
+	keyInfo = "linx-e2e v1"
+)
+
+var (
+	ErrInvalidHeader      = errors.New("invalid encryption header")
+	ErrUnsupportedVersion = errors.New("unsupported encryption version")
+	ErrInvalidKey         = errors.New("invalid encryption key")
+	ErrDecrypt            = errors.New("decryption failed")
+	ErrTruncated          = errors.New("truncated ciphertext")
+)
+
+// Header is the unencrypted start of an encrypted file.
+type Header struct {
+	Version   byte
+	ChunkSize uint32
+	Salt      [SaltSize]byte
+}
+
+// ParseHeader parses and validates the first HeaderSize bytes of an encrypted file.
+func ParseHeader(b []byte) (Header, error) {
+	var h Header
+	if len(b) < HeaderSize || string(b[:len(Magic)]) != Magic {
+		return h, ErrInvalidHeader
+	}
+	b = b[len(Magic):]
+
+	h.Version = b[0]
+	if h.Version != Version {
+		return h, fmt.Errorf("%w: %d", ErrUnsupportedVersion, h.Version)
+	}
+
+	h.ChunkSize = binary.BigEndian.Uint32(b[1:5])
+	if h.ChunkSize == 0 || h.ChunkSize > MaxChunkSize {
+		return h, fmt.Errorf("%w: chunk size %d", ErrInvalidHeader, h.ChunkSize)
+	}
+
+	copy(h.Salt[:], b[5:])
+	return h, nil
+}
+
+func (h Header) bytes() []byte {
+	b := make([]byte, 0, HeaderSize)
+	b = append(b, Magic...)
+	b = append(b, h.Version)
+	b = binary.BigEndian.AppendUint32(b, h.ChunkSize)
+	return append(b, h.Salt[:]...)
+}
+
+// Info is encrypted along with the file, so the server never sees the file's name or type.
+type Info struct {
+	Name string `json:"name,omitzero"`
+	Type string `json:"type,omitzero"`
+}
+
+// NewKey generates a random key.
+func NewKey() []byte {
+	key := make([]byte, KeySize)
+	_, _ = rand.Read(key)
+	return key
+}
+
+// EncodeKey encodes a key for the fragment of an upload's URL.
+func EncodeKey(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+// DecodeKey decodes a key from the fragment of an upload's URL.
+func DecodeKey(s string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// newAEAD derives the file key from the key and the header's salt,
+// so a key can be reused for several files without reusing nonces.
+func newAEAD(key []byte, h Header) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	fileKey, err := hkdf.Key(sha256.New, key, h.Salt[:], keyInfo, KeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce returns the nonce for a record: an 11 byte big-endian counter followed by a flag which marks the last record.
+func nonce(counter uint64, last bool) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[3:11], counter)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
+
+// Encrypt encrypts r to w with key.
+func Encrypt(w io.Writer, r io.Reader, key []byte, info Info) error {
+	return encrypt(w, r, key, info, DefaultChunkSize)
+}
+
+func encrypt(w io.Writer, r io.Reader, key []byte, info Info, chunkSize uint32) error {
+	h := Header{Version: Version, ChunkSize: chunkSize}
+	_, _ = rand.Read(h.Salt[:])
+	aead, err := newAEAD(key, h)
+	if err != nil {
+		return err
+	}
+	header := h.bytes()
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	infoJSON, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if len(infoJSON)+TagSize > MaxInfoSize {
+		return fmt.Errorf("%w: info too large", ErrInvalidHeader)
+	}
+	record := aead.Seal(nil, nonce(0, false), infoJSON, header)
+	if _, err := w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(record)))); err != nil { //nolint:gosec
+		return err
+	}
+	if _, err := w.Write(record); err != nil {
+		return err
+	}
+
+	// Read one byte past each chunk to know whether it is the last one.
+	buf := make([]byte, chunkSize+1)
+	var have int
+	for counter := uint64(1); ; counter++ {
+		n, err := io.ReadFull(r, buf[have:])
+		have += n
+		last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !last {
+			return err
+		}
+
+		chunk := buf[:min(have, int(chunkSize))]
+		record = aead.Seal(record[:0], nonce(counter, last), chunk, header)
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		have = copy(buf, buf[chunkSize:have])
+	}
+}
+
+// Reader decrypts an encrypted file.
+type Reader struct {
+	Info Info
+
+	r       io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	counter uint64
+
+	buf   []byte
+	have  int
+	out   []byte
+	plain []byte
+	done  bool
+	err   error
+}
+
+// NewReader reads the header and info of an encrypted file.
+func NewReader(r io.Reader, key []byte) (*Reader, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = ErrInvalidHeader
+		}
+		return nil, err
+	}
+	h, err := ParseHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key, h)
+	if err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, ErrTruncated
+	}
+	infoSize := binary.BigEndian.Uint32(size[:])
+	if infoSize < TagSize || infoSize > MaxInfoSize {
+		return nil, fmt.Errorf("%w: info size %d", ErrInvalidHeader, infoSize)
+	}
+	record := make([]byte, infoSize)
+	if _, err := io.ReadFull(r, record); err != nil {
+		return nil, ErrTruncated
+	}
+	infoJSON, err := aead.Open(nil, nonce(0, false), record, header)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	d := &Reader{
+		r:       r,
+		aead:    aead,
+		header:  header,
+		counter: 1,
+		buf:     make([]byte, int(h.ChunkSize)+TagSize+1),
+		out:     make([]byte, 0, h.ChunkSize),
+	}
+	if err := json.Unmarshal(infoJSON, &d.Info); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+	return d, nil
+}
+
+func (d *Reader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.next()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next record. Records are read one byte past their end,
+// so a missing last record is detected instead of silently truncating the file.
+func (d *Reader) next() error {
+	n, err := io.ReadFull(d.r, d.buf[d.have:])
+	d.have += n
+	last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	if err != nil && !last {
+		return err
+	}
+
+	recordSize := len(d.buf) - 1
+	if last {
+		recordSize = d.have
+		if recordSize < TagSize {
+			return ErrTruncated
+		}
+	}
+
+	plain, err := d.aead.Open(d.out[:0], nonce(d.counter, last), d.buf[:recordSize], d.header)
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.counter++
+	d.done = last
+	d.plain = plain
+	d.have = copy(d.buf, d.buf[recordSize:d.have])
+	return nil
+}
@@ -0,0 +1,147 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encryptTest(t *testing.T, key, plain []byte, chunkSize uint32) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, encrypt(&buf, bytes.NewReader(plain), key, Info{Name: "test.txt", Type: "text/plain"}, chunkSize))
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := NewKey()
+	for _, size := range []int{0, 1, 15, 16, 17, 64, 1000} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+
+		ciphertext := encryptTest(t, key, plain, 16)
+		h, err := ParseHeader(ciphertext)
+		require.NoError(t, err)
+		assert.EqualValues(t, 16, h.ChunkSize)
+
+		r, err := NewReader(bytes.NewReader(ciphertext), key)
+		require.NoError(t, err)
+		assert.Equal(t, Info{Name: "test.txt", Type: "text/plain"}, r.Info)
+
+		got, err := io.ReadAll(r)
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, plain, got, "size %d", size)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	key := NewKey()
+	var buf bytes.Buffer
+	require.NoError(t, Encrypt(&buf, bytes.NewReader([]byte("hello")), key, Info{}))
+
+	h, err := ParseHeader(buf.Bytes())
+	require.NoError(t, err)
+	assert.EqualValues(t, DefaultChunkSize, h.ChunkSize)
+	assert.NotContains(t, buf.String(), "hello")
+}
+
+func TestReaderErrors(t *testing.T) {
+	key := NewKey()
+	plain := bytes.Repeat([]byte("a"), 100)
+	ciphertext := encryptTest(t, key, plain, 16)
+
+	t.Run("wrong key", func(t *testing.T) {
+		_, err := NewReader(bytes.NewReader(ciphertext), NewKey())
+		require.ErrorIs(t, err, ErrDecrypt)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		// Drop the last record, which is 100 % 16 + TagSize bytes long.
+		r, err := NewReader(bytes.NewReader(ciphertext[:len(ciphertext)-(4+TagSize)]), key)
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		require.ErrorIs(t, err, ErrDecrypt)
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		modified := bytes.Clone(ciphertext)
+		modified[len(modified)-1] ^= 1
+		r, err := NewReader(bytes.NewReader(modified), key)
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		require.ErrorIs(t, err, ErrDecrypt)
+	})
+
+	t.Run("modified header", func(t *testing.T) {
+		modified := bytes.Clone(ciphertext)
+		modified[HeaderSize-1] ^= 1
+		_, err := NewReader(bytes.NewReader(modified), key)
+		require.ErrorIs(t, err, ErrDecrypt)
+	})
+}
+
+func TestParseHeader(t *testing.T) {
+	_, err := ParseHeader([]byte("plain text which is long enough"))
+	require.ErrorIs(t, err, ErrInvalidHeader)
+
+	h := Header{Version: 2, ChunkSize: 16}
+	_, err = ParseHeader(h.bytes())
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	h = Header{Version: Version, ChunkSize: MaxChunkSize + 1}
+	_, err = ParseHeader(h.bytes())
+	require.ErrorIs(t, err, ErrInvalidHeader)
+}
+
+func TestKey(t *testing.T) {
+	key := NewKey()
+	decoded, err := DecodeKey(EncodeKey(key))
+	require.NoError(t, err)
+	assert.Equal(t, key, decoded)
+
+	_, err = DecodeKey("short")
+	require.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestReaderAt(t *testing.T) {
+	key := NewKey()
+	for _, size := range []int{0, 1, 16, 17, 100} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+		ciphertext := encryptTest(t, key, plain, 16)
+
+		ra, err := NewReaderAt(bytes.NewReader(ciphertext), int64(len(ciphertext)), key)
+		require.NoError(t, err)
+		assert.Equal(t, "test.txt", ra.Info.Name)
+		require.EqualValues(t, size, ra.Size())
+
+		got, err := io.ReadAll(io.NewSectionReader(ra, 0, ra.Size()))
+		require.NoError(t, err)
+		assert.Equal(t, plain, got, "size %d", size)
+
+		for off := range size {
+			end := min(off+20, size)
+			buf := make([]byte, end-off)
+			n, err := ra.ReadAt(buf, int64(off))
+			require.NoError(t, err)
+			assert.Equal(t, plain[off:end], buf[:n], "size %d offset %d", size, off)
+		}
+
+		_, err = ra.ReadAt(make([]byte, 1), int64(size))
+		require.ErrorIs(t, err, io.EOF)
+	}
+}
+
+func TestSize(t *testing.T) {
+	key := NewKey()
+	sizes := []int64{0, 1, DefaultChunkSize - 1, DefaultChunkSize, DefaultChunkSize + 1, 3 * DefaultChunkSize}
+	for _, size := range sizes {
+		var buf bytes.Buffer
+		require.NoError(t, Encrypt(&buf, bytes.NewReader(make([]byte, size)), key, Info{}))
+		assert.EqualValues(t, buf.Len(), EncryptedSize(size), "size %d", size)
+		assert.Equal(t, size, DecryptedSize(int64(buf.Len())), "size %d", size)
+	}
+}
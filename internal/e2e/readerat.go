@@ -0,0 +1,135 @@
+package e2e
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// emptyDataOffset is where the data records start in a file encrypted by Encrypt with an empty Info.
+const emptyDataOffset = int64(HeaderSize + 4 + len("{}") + TagSize)
+
+// EncryptedSize returns the size of a file encrypted by Encrypt with an empty Info.
+func EncryptedSize(size int64) int64 {
+	records := max((size+DefaultChunkSize-1)/DefaultChunkSize, 1)
+	return emptyDataOffset + size + records*TagSize
+}
+
+// DecryptedSize returns the plaintext size of a file encrypted by Encrypt with an empty Info.
+func DecryptedSize(size int64) int64 {
+	size -= emptyDataOffset
+	records := (size + DefaultChunkSize + TagSize - 1) / (DefaultChunkSize + TagSize)
+	return max(size-records*TagSize, 0)
+}
+
+var ErrNegativeOffset = errors.New("negative offset")
+
+// ReaderAt decrypts any part of an encrypted file without reading the records before it.
+type ReaderAt struct {
+	Info Info
+
+	r          io.ReaderAt
+	size       int64
+	plainSize  int64
+	dataOffset int64
+	chunkSize  int64
+	aead       cipher.AEAD
+	header     []byte
+
+	// The last decrypted record is cached, since reads are usually smaller than a record.
+	mu     sync.Mutex
+	cached int64
+	plain  []byte
+}
+
+// NewReaderAt reads the header and info of an encrypted file of the given size.
+func NewReaderAt(r io.ReaderAt, size int64, key []byte) (*ReaderAt, error) {
+	d, err := NewReader(io.NewSectionReader(r, 0, size), key)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := ParseHeader(d.header)
+	if err != nil {
+		return nil, err
+	}
+	var infoSize [4]byte
+	if _, err := r.ReadAt(infoSize[:], int64(HeaderSize)); err != nil {
+		return nil, ErrTruncated
+	}
+
+	ra := &ReaderAt{
+		Info:       d.Info,
+		r:          r,
+		size:       size,
+		dataOffset: int64(HeaderSize) + 4 + int64(binary.BigEndian.Uint32(infoSize[:])),
+		chunkSize:  int64(h.ChunkSize),
+		aead:       d.aead,
+		header:     d.header,
+		cached:     -1,
+	}
+
+	data := size - ra.dataOffset
+	if data < TagSize {
+		return nil, ErrTruncated
+	}
+	records := (data + ra.chunkSize + TagSize - 1) / (ra.chunkSize + TagSize)
+	ra.plainSize = data - records*TagSize
+	return ra, nil
+}
+
+// Size returns the size of the plaintext.
+func (ra *ReaderAt) Size() int64 {
+	return ra.plainSize
+}
+
+func (ra *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+
+	var n int
+	for n < len(p) && off < ra.plainSize {
+		index := off / ra.chunkSize
+		plain, err := ra.record(index)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], plain[off-index*ra.chunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// record decrypts the data record with the given index, starting at 0.
+func (ra *ReaderAt) record(index int64) ([]byte, error) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+
+	if ra.cached == index {
+		return ra.plain, nil
+	}
+
+	recordSize := ra.chunkSize + TagSize
+	start := ra.dataOffset + index*recordSize
+	end := min(start+recordSize, ra.size)
+	last := end == ra.size
+
+	buf := make([]byte, end-start)
+	if n, err := ra.r.ReadAt(buf, start); n < len(buf) {
+		return nil, err
+	}
+
+	plain, err := ra.aead.Open(buf[:0], nonce(uint64(index)+1, last), buf, ra.header) //nolint:gosec
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	ra.cached, ra.plain = index, plain
+	return plain, nil
+}
@@ -0,0 +1,287 @@
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	MimetypeJPEG = "image/jpeg"
+	MimetypePNG  = "image/png"
+	MimetypeWebP = "image/webp"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported image type")
+	ErrInvalid     = errors.New("invalid image")
+)
+
+// Supported reports whether metadata can be stripped from an upload's mimetype.
+func Supported(mimetype string) bool {
+	switch mimetype {
+	case MimetypeJPEG, MimetypePNG, MimetypeWebP:
+		return true
+	}
+	return false
+}
+
+// Strip copies an image from r to w without its EXIF (including GPS), XMP and text metadata.
+// The image is never decoded, so pixel data is copied unchanged.
+// Data after the end of the image is dropped, since some cameras append a second image with its own metadata.
+// w must be empty, since the size of a WebP image is corrected by seeking back to its header.
+func Strip(w io.WriteSeeker, r io.Reader, mimetype string) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+
+	var err error
+	switch mimetype {
+	case MimetypeJPEG:
+		err = stripJPEG(bw, br)
+	case MimetypePNG:
+		err = stripPNG(bw, br)
+	case MimetypeWebP:
+		err = stripWebP(bw, br, w)
+	default:
+		return ErrUnsupported
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+		return err
+	}
+	return bw.Flush()
+}
+
+const (
+	jpegSOI  = 0xD8
+	jpegEOI  = 0xD9
+	jpegSOS  = 0xDA
+	jpegRST0 = 0xD0
+	jpegRST7 = 0xD7
+	jpegTEM  = 0x01
+	jpegAPP1 = 0xE1 // EXIF and XMP
+	jpegAPPD = 0xED // Photoshop IRB and IPTC
+)
+
+func stripJPEG(w *bufio.Writer, r *bufio.Reader) error {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil {
+		return err
+	}
+	if soi != [2]byte{0xFF, jpegSOI} {
+		return ErrInvalid
+	}
+	_, _ = w.Write(soi[:])
+
+	marker, err := nextJPEGMarker(r)
+	if err != nil {
+		return err
+	}
+	for {
+		switch {
+		case marker == jpegEOI:
+			_, err := w.Write([]byte{0xFF, marker})
+			return err
+		case marker == jpegTEM, marker >= jpegRST0 && marker <= jpegRST7:
+			_, _ = w.Write([]byte{0xFF, marker})
+			if marker, err = nextJPEGMarker(r); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return err
+		}
+		if length < 2 {
+			return ErrInvalid
+		}
+
+		if marker == jpegAPP1 || marker == jpegAPPD {
+			if _, err := r.Discard(int(length) - 2); err != nil {
+				return err
+			}
+			if marker, err = nextJPEGMarker(r); err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, _ = w.Write([]byte{0xFF, marker})
+		_ = binary.Write(w, binary.BigEndian, length)
+		if _, err := io.CopyN(w, r, int64(length)-2); err != nil {
+			return err
+		}
+
+		if marker == jpegSOS {
+			marker, err = copyScan(w, r)
+		} else {
+			marker, err = nextJPEGMarker(r)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// nextJPEGMarker reads a marker, skipping any fill bytes.
+func nextJPEGMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, ErrInvalid
+	}
+	for b == 0xFF {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// copyScan copies entropy-coded data up to the next marker, which is returned.
+// Stuffed zero bytes and restart markers are part of the scan.
+func copyScan(w *bufio.Writer, r *bufio.Reader) (byte, error) {
+	for {
+		data, err := r.ReadSlice(0xFF)
+		if len(data) > 1 {
+			_, _ = w.Write(data[:len(data)-1])
+		}
+		if err != nil {
+			if errors.Is(err, bufio.ErrBufferFull) {
+				_ = w.WriteByte(data[len(data)-1])
+				continue
+			}
+			return 0, err
+		}
+
+		b, err := r.ReadByte()
+		for err == nil && b == 0xFF {
+			b, err = r.ReadByte()
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if b == 0x00 || (b >= jpegRST0 && b <= jpegRST7) {
+			_, _ = w.Write([]byte{0xFF, b})
+			continue
+		}
+		return b, nil
+	}
+}
+
+//nolint:gochecknoglobals
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func stripPNG(w *bufio.Writer, r *bufio.Reader) error {
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return err
+	}
+	if !bytes.Equal(sig, pngSignature) {
+		return ErrInvalid
+	}
+	_, _ = w.Write(sig)
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:])
+
+		switch typ {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+			if _, err := io.CopyN(io.Discard, r, length+4); err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, _ = w.Write(header[:])
+		if _, err := io.CopyN(w, r, length+4); err != nil {
+			return err
+		}
+		if typ == "IEND" {
+			return nil
+		}
+	}
+}
+
+const (
+	webpFlagEXIF = 1 << 3
+	webpFlagXMP  = 1 << 2
+)
+
+// stripWebP removes EXIF and XMP chunks, then seeks back to correct the RIFF size.
+func stripWebP(w *bufio.Writer, r *bufio.Reader, ws io.WriteSeeker) error {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return ErrInvalid
+	}
+	remaining := int64(binary.LittleEndian.Uint32(header[4:8])) - 4
+	_, _ = w.Write(header[:])
+	written := int64(4)
+
+	for remaining >= 8 {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		size += size & 1 // chunks are padded to an even size
+		remaining -= 8 + size
+
+		switch string(chunk[:4]) {
+		case "EXIF", "XMP ":
+			if _, err := io.CopyN(io.Discard, r, size); err != nil {
+				return err
+			}
+			continue
+		case "VP8X":
+			if size < 1 {
+				return ErrInvalid
+			}
+			flags, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			_, _ = w.Write(chunk[:])
+			_ = w.WriteByte(flags &^ (webpFlagEXIF | webpFlagXMP))
+			if _, err := io.CopyN(w, r, size-1); err != nil {
+				return err
+			}
+		default:
+			_, _ = w.Write(chunk[:])
+			if _, err := io.CopyN(w, r, size); err != nil {
+				return err
+			}
+		}
+		written += 8 + size
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if _, err := ws.Seek(4, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(ws, binary.LittleEndian, uint32(written)); err != nil { //nolint:gosec
+		return err
+	}
+	_, err := ws.Seek(0, io.SeekEnd)
+	return err
+}
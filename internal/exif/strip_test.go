@@ -0,0 +1,133 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func strip(t *testing.T, data []byte, mimetype string) ([]byte, error) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "stripped"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+
+	if err := Strip(f, bytes.NewReader(data), mimetype); err != nil {
+		return nil, err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return b, nil
+}
+
+func jpegSegment(marker byte, payload string) []byte {
+	b := []byte{0xFF, marker}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)+2)) //nolint:gosec
+	return append(b, payload...)
+}
+
+func TestStripJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 64)), nil))
+	want := buf.Bytes()
+
+	var data []byte
+	data = append(data, want[:2]...)
+	data = append(data, jpegSegment(jpegAPP1, "Exif\x00\x00GPSLatitude")...)
+	data = append(data, jpegSegment(jpegAPP1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")...)
+	data = append(data, jpegSegment(jpegAPPD, "Photoshop 3.0\x00")...)
+	data = append(data, want[2:]...)
+	data = append(data, "trailing data"...)
+
+	got, err := strip(t, data, MimetypeJPEG)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	_, err = jpeg.Decode(bytes.NewReader(got))
+	require.NoError(t, err)
+}
+
+func pngChunk(typ, data string) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data))) //nolint:gosec
+	b = append(b, typ...)
+	b = append(b, data...)
+	return append(b, 0, 0, 0, 0) // the CRC is not checked
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 64))))
+	want := buf.Bytes()
+
+	// insert metadata after the IHDR chunk
+	const ihdrEnd = 8 + 8 + 13 + 4
+	var data []byte
+	data = append(data, want[:ihdrEnd]...)
+	data = append(data, pngChunk("eXIf", "MM\x00*GPS")...)
+	data = append(data, pngChunk("iTXt", "XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>")...)
+	data = append(data, pngChunk("tEXt", "Comment\x00hello")...)
+	data = append(data, want[ihdrEnd:]...)
+
+	got, err := strip(t, data, MimetypePNG)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func webpChunk(fourcc, data string) []byte {
+	b := append([]byte(fourcc), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...) //nolint:gosec
+	b = append(b, data...)
+	if len(data)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	b := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...) //nolint:gosec
+	return append(b, body...)
+}
+
+func TestStripWebP(t *testing.T) {
+	vp8x := "\x00\x00\x00\x00" + strings.Repeat("\x01", 6)
+	data := webpFile(
+		webpChunk("VP8X", string(rune(webpFlagEXIF|webpFlagXMP|0x10))+vp8x[1:]),
+		webpChunk("VP8L", "pixels"),
+		webpChunk("EXIF", "MM\x00*GPS"),
+		webpChunk("XMP ", "<x:xmpmeta/>"),
+	)
+	want := webpFile(
+		webpChunk("VP8X", "\x10"+vp8x[1:]),
+		webpChunk("VP8L", "pixels"),
+	)
+
+	got, err := strip(t, data, MimetypeWebP)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestStripInvalid(t *testing.T) {
+	for _, mimetype := range []string{MimetypeJPEG, MimetypePNG, MimetypeWebP} {
+		t.Run(mimetype, func(t *testing.T) {
+			_, err := strip(t, []byte("not an image"), mimetype)
+			require.ErrorIs(t, err, ErrInvalid)
+		})
+	}
+
+	_, err := strip(t, []byte("text"), "text/plain")
+	require.ErrorIs(t, err, ErrUnsupported)
+}
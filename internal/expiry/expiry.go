@@ -36,14 +36,14 @@ func IsFileExpired(ctx context.Context, filename string) (bool, error) {
 }
 
 // Return a list of expiration times and their humanized versions.
-func ListExpirationTimes() []ExpirationTime {
+func ListExpirationTimes(maxExpiry time.Duration) []ExpirationTime {
 	epoch := time.Now()
 	actualExpiryInList := false
 	var expiryList []ExpirationTime
 
 	for _, expiryEntry := range defaultExpiryList {
-		if config.Default.MaxExpiry.Duration == 0 || expiryEntry <= config.Default.MaxExpiry.Duration {
-			if expiryEntry == config.Default.MaxExpiry.Duration {
+		if maxExpiry == 0 || expiryEntry <= maxExpiry {
+			if expiryEntry == maxExpiry {
 				actualExpiryInList = true
 			}
 
@@ -54,15 +54,15 @@ func ListExpirationTimes() []ExpirationTime {
 		}
 	}
 
-	if config.Default.MaxExpiry.Duration == 0 {
+	if maxExpiry == 0 {
 		expiryList = append(expiryList, ExpirationTime{
 			0,
 			"never",
 		})
 	} else if !actualExpiryInList {
 		expiryList = append(expiryList, ExpirationTime{
-			Duration: config.Default.MaxExpiry.Duration,
-			Human:    humanize.RelTime(epoch, epoch.Add(config.Default.MaxExpiry.Duration), "", ""),
+			Duration: maxExpiry,
+			Human:    humanize.RelTime(epoch, epoch.Add(maxExpiry), "", ""),
 		})
 	}
 
@@ -4,22 +4,27 @@ import (
 	"encoding/json"
 	"errors"
 	"io/fs"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
 	"path"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"gabe565.com/linx-server/assets"
 	"gabe565.com/linx-server/internal/auth/keyhash"
+	"gabe565.com/linx-server/internal/auth/unlock"
 	"gabe565.com/linx-server/internal/backends"
 	"gabe565.com/linx-server/internal/config"
 	"gabe565.com/linx-server/internal/headers"
+	"gabe565.com/linx-server/internal/metrics"
 	"gabe565.com/linx-server/internal/template"
 	"gabe565.com/linx-server/internal/util"
+	"gabe565.com/linx-server/internal/webhook"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 type AccessKeySource int
@@ -68,7 +73,10 @@ func AccessKeyFromRequest(r *http.Request, src AccessKeySource) (string, bool) {
 	return key, key != ""
 }
 
-func CheckAccessKey(r *http.Request, metadata *backends.Metadata) (AccessKeySource, error) {
+// CheckAccessKey checks the access key of a protected upload.
+// A cookie holds an unlock token, which is verified without scrypt. Other sources hold the access key itself,
+// and each wrong key counts towards the upload's lockout.
+func CheckAccessKey(r *http.Request, fileName string, metadata *backends.Metadata) (AccessKeySource, error) {
 	key := metadata.AccessKey
 	if key == "" {
 		return AccessKeySourceNone, nil
@@ -85,40 +93,97 @@ func CheckAccessKey(r *http.Request, metadata *backends.Metadata) (AccessKeySour
 			continue
 		}
 
+		if src == AccessKeySourceCookie {
+			if err := unlock.VerifyToken(config.Default().UnlockKey(), requestKey, fileName, key); err != nil {
+				return src, errInvalidAccessKey
+			}
+			return src, nil
+		}
+
+		if _, locked := config.Lockout.Locked(fileName); locked {
+			metrics.AccessKeyFailed("locked")
+			return src, unlock.ErrLocked
+		}
+
 		match, err := keyhash.CheckWithFallback(key, requestKey, metadata.Salt)
 		if err != nil {
 			return src, err
 		}
 		if match {
+			config.Lockout.Reset(fileName)
 			return src, nil
 		}
+
+		accessKeyFailed(r, fileName, metadata)
 		return src, errInvalidAccessKey
 	}
 
 	return AccessKeySourceNone, errInvalidAccessKey
 }
 
-func SetAccessKeyCookies(w http.ResponseWriter, r *http.Request, fileName, value string, expires time.Time) {
+// accessKeyFailed logs a wrong access key and locks the upload once it has failed too many times.
+func accessKeyFailed(r *http.Request, fileName string, metadata *backends.Metadata) {
+	metrics.AccessKeyFailed("invalid")
+	failures, lockedUntil := config.Lockout.Fail(fileName)
+	slog.Warn("Invalid access key", //nolint:gosec
+		"path", fileName, "ip", r.RemoteAddr, "failures", failures, "request_id", middleware.GetReqID(r.Context()),
+	)
+	if !lockedUntil.IsZero() {
+		slog.Warn("Locked file after failed access key attempts", //nolint:gosec
+			"path", fileName, "failures", failures, "until", lockedUntil,
+		)
+		config.Hooks.Send(r.Context(), webhook.EventLockout, fileName, *metadata)
+	}
+}
+
+// accessDenied responds to a request which failed CheckAccessKey.
+func accessDenied(w http.ResponseWriter, r *http.Request, fileName string, src AccessKeySource, err error) {
+	// remove invalid cookie
+	if src == AccessKeySourceCookie {
+		SetAccessKeyCookies(w, r, fileName, "", time.Time{})
+	}
+
+	switch {
+	case errors.Is(err, unlock.ErrLocked):
+		if until, ok := config.Lockout.Locked(fileName); ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+		}
+		ErrorMsg(w, r, http.StatusTooManyRequests, "Too many failed attempts, please try again later")
+	case strings.EqualFold("application/json", r.Header.Get("Accept")):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(ErrorResponse{Error: errInvalidAccessKey.Error()})
+	default:
+		Error(w, r, http.StatusUnauthorized)
+	}
+}
+
+// SetAccessKeyCookies stores an unlock token for the display page and the direct file URL.
+// An empty token removes the cookies.
+func SetAccessKeyCookies(w http.ResponseWriter, r *http.Request, fileName, token string, expires time.Time) {
 	u := headers.GetSiteURL(r)
 	cookie := http.Cookie{
 		Name:     AccessKeyHeader,
-		Value:    url.PathEscape(value),
+		Value:    token,
 		HttpOnly: true,
 		Domain:   u.Hostname(),
 		Expires:  expires,
 		Secure:   u.Scheme == "https",
 	}
+	if token == "" {
+		cookie.MaxAge = -1
+	}
 
 	cookie.Path = path.Join(u.Path, fileName)
 	http.SetCookie(w, &cookie)
 
-	cookie.Path = path.Join(u.Path, config.Default.SelifPath, fileName)
+	cookie.Path = path.Join(u.Path, config.Default().SelifPath, fileName)
 	http.SetCookie(w, &cookie)
 }
 
 func IsDirectUA(r *http.Request) bool {
 	ua := strings.ToLower(r.Header.Get("User-Agent"))
-	return !config.Default.NoDirectAgents && !strings.EqualFold(r.Header.Get("Accept"), "application/json") &&
+	return !config.Default().NoDirectAgents && !strings.EqualFold(r.Header.Get("Accept"), "application/json") &&
 		slices.ContainsFunc(cliUserAgents, func(s string) bool {
 			return strings.Contains(ua, s)
 		})
@@ -147,31 +212,22 @@ func FileAccessHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	src, err := CheckAccessKey(r, &metadata)
+	src, err := CheckAccessKey(r, fileName, &metadata)
 	if err != nil {
-		// remove invalid cookie
-		if src == AccessKeySourceCookie {
-			SetAccessKeyCookies(w, r, fileName, "", time.Time{})
-		}
-
-		if strings.EqualFold("application/json", r.Header.Get("Accept")) {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			_ = json.NewEncoder(w).Encode(ErrorResponse{Error: errInvalidAccessKey.Error()})
-			return
-		}
-
-		Error(w, r, http.StatusUnauthorized)
+		accessDenied(w, r, fileName, src, err)
 		return
 	}
 
-	if metadata.AccessKey != "" {
-		if requestKey, ok := AccessKeyFromRequest(r, src); ok {
-			var expiry time.Time
-			if config.Default.Auth.CookieExpiry.Duration != 0 {
-				expiry = time.Now().Add(config.Default.Auth.CookieExpiry.Duration)
-			}
-			SetAccessKeyCookies(w, r, fileName, requestKey, expiry)
+	if metadata.AccessKey != "" && src != AccessKeySourceCookie {
+		var expiry time.Time
+		if config.Default().Auth.CookieExpiry.Duration != 0 {
+			expiry = time.Now().Add(config.Default().Auth.CookieExpiry.Duration)
+		}
+		token, err := unlock.NewToken(config.Default().UnlockKey(), fileName, metadata.AccessKey, expiry)
+		if err != nil {
+			slog.Error("Failed to create unlock token", "path", fileName, "error", err) //nolint:gosec
+		} else {
+			SetAccessKeyCookies(w, r, fileName, token, expiry)
 		}
 	}
 
@@ -6,9 +6,12 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"gabe565.com/linx-server/internal/auth/keyhash"
+	"gabe565.com/linx-server/internal/auth/unlock"
 	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -16,7 +19,7 @@ import (
 func TestCheckAccessKeyNoProtection(t *testing.T) {
 	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil)
 
-	src, err := CheckAccessKey(req, &backends.Metadata{})
+	src, err := CheckAccessKey(req, "file.txt", &backends.Metadata{})
 	require.NoError(t, err)
 	assert.Equal(t, AccessKeySourceNone, src)
 }
@@ -30,7 +33,7 @@ func TestCheckAccessKeyHeaderValid(t *testing.T) {
 	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil)
 	req.Header.Set(AccessKeyHeader, key)
 
-	src, err := CheckAccessKey(req, &backends.Metadata{AccessKey: stored, Salt: salt})
+	src, err := CheckAccessKey(req, "file.txt", &backends.Metadata{AccessKey: stored, Salt: salt})
 	require.NoError(t, err)
 	assert.Equal(t, AccessKeySourceHeader, src)
 }
@@ -45,7 +48,7 @@ func TestCheckAccessKeyCookieHasPriority(t *testing.T) {
 	req.AddCookie(&http.Cookie{Name: AccessKeyHeader, Value: url.PathEscape("wrong")})
 	req.Header.Set(AccessKeyHeader, key)
 
-	src, err := CheckAccessKey(req, &backends.Metadata{AccessKey: stored, Salt: salt})
+	src, err := CheckAccessKey(req, "file.txt", &backends.Metadata{AccessKey: stored, Salt: salt})
 	require.ErrorIs(t, err, errInvalidAccessKey)
 	assert.Equal(t, AccessKeySourceCookie, src)
 }
@@ -64,7 +67,7 @@ func TestCheckAccessKeyHeaderHasPriorityOverForm(t *testing.T) {
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set(AccessKeyHeader, "wrong")
 
-	src, err := CheckAccessKey(req, &backends.Metadata{AccessKey: stored, Salt: salt})
+	src, err := CheckAccessKey(req, "file.txt", &backends.Metadata{AccessKey: stored, Salt: salt})
 	require.ErrorIs(t, err, errInvalidAccessKey)
 	assert.Equal(t, AccessKeySourceHeader, src)
 }
@@ -79,7 +82,69 @@ func TestCheckAccessKeyStdBase64Fallback(t *testing.T) {
 	req.AddCookie(&http.Cookie{Name: AccessKeyHeader, Value: url.PathEscape("wrong")})
 	req.Header.Set(AccessKeyHeader, key)
 
-	src, err := CheckAccessKey(req, &backends.Metadata{AccessKey: stored, Salt: salt})
+	src, err := CheckAccessKey(req, "file.txt", &backends.Metadata{AccessKey: stored, Salt: salt})
 	require.ErrorIs(t, err, errInvalidAccessKey)
 	assert.Equal(t, AccessKeySourceCookie, src)
 }
+
+func TestCheckAccessKeyCookieToken(t *testing.T) {
+	const key, salt = "supersecret", "mysalt"
+
+	stored, err := keyhash.Hash(key, salt, true)
+	require.NoError(t, err)
+	metadata := &backends.Metadata{AccessKey: stored, Salt: salt}
+
+	token, err := unlock.NewToken(config.Default().UnlockKey(), "file.txt", stored, time.Time{})
+	require.NoError(t, err)
+
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: AccessKeyHeader, Value: token})
+	src, err := CheckAccessKey(req, "file.txt", metadata)
+	require.NoError(t, err)
+	assert.Equal(t, AccessKeySourceCookie, src)
+
+	// The token is only valid for the file it was created for
+	_, err = CheckAccessKey(req, "other.txt", metadata)
+	require.ErrorIs(t, err, errInvalidAccessKey)
+
+	// The raw access key is not accepted as a cookie
+	req = httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: AccessKeyHeader, Value: key})
+	_, err = CheckAccessKey(req, "file.txt", metadata)
+	require.ErrorIs(t, err, errInvalidAccessKey)
+}
+
+func TestCheckAccessKeyLockout(t *testing.T) {
+	const key, salt = "supersecret", "mysalt"
+	t.Cleanup(func() { config.Lockout = nil })
+	config.Lockout = unlock.NewLockout(2, time.Minute, time.Hour)
+
+	stored, err := keyhash.Hash(key, salt, true)
+	require.NoError(t, err)
+	metadata := &backends.Metadata{AccessKey: stored, Salt: salt}
+
+	check := func(requestKey string) error {
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil)
+		req.Header.Set(AccessKeyHeader, requestKey)
+		_, err := CheckAccessKey(req, "file.txt", metadata)
+		return err
+	}
+
+	require.ErrorIs(t, check("wrong"), errInvalidAccessKey)
+	require.ErrorIs(t, check("wrong"), errInvalidAccessKey)
+	// The correct key is rejected until the lockout ends
+	require.ErrorIs(t, check(key), unlock.ErrLocked)
+
+	// Other files are not affected
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil)
+	req.Header.Set(AccessKeyHeader, key)
+	_, err = CheckAccessKey(req, "other.txt", metadata)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req = httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+	accessDenied(w, req, "file.txt", AccessKeySourceHeader, unlock.ErrLocked)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
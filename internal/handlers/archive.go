@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/helpers"
+	"gabe565.com/linx-server/internal/util"
+)
+
+// serveArchiveFile extracts a single file from an archive upload.
+// The amount of data which is decompressed is limited by the max upload size and the archive's size,
+// so that a small archive can't expand without bound.
+func serveArchiveFile(
+	w http.ResponseWriter,
+	r *http.Request,
+	fileName string,
+	metadata backends.Metadata,
+	memberName string,
+	link sharedLink,
+) {
+	_, rc, err := config.StorageBackend.Get(r.Context(), fileName)
+	if err != nil {
+		if errors.Is(err, backends.ErrNotFound) {
+			ErrorMsg(w, r, http.StatusNotFound, "File not found")
+		} else {
+			slog.Error("Failed to open archive", "path", fileName, "error", err) //nolint:gosec
+			Error(w, r, http.StatusInternalServerError)
+		}
+		return
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	limit := min(int64(config.Default().MaxSize), metadata.Size*helpers.MaxCompressionRatio)
+	f, err := helpers.OpenArchiveFile(metadata.Mimetype, metadata.Size, rc, memberName, limit)
+	if err != nil {
+		archiveFileError(w, r, fileName, err)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	contentType := "application/octet-stream"
+	kind, src, err := helpers.DetectMimetype(f)
+	switch {
+	case err == nil:
+		contentType = kind.String()
+	case !errors.Is(err, backends.ErrFileEmpty):
+		archiveFileError(w, r, fileName, err)
+		return
+	}
+
+	w.Header().Set("Content-Security-Policy", FileCSP)
+	w.Header().Set("Referrer-Policy", config.Default().Header.FileReferrerPolicy)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(f.Size, 10))
+	if !f.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", f.ModTime.UTC().Format(http.TimeFormat))
+	}
+	setFileCacheControl(w, metadata, link)
+
+	if r.URL.Query().Has("download") || IsDirectUA(r) {
+		w.Header().Set("Content-Disposition", util.EncodeContentDisposition("attachment", path.Base(memberName)))
+	}
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// The response has started, so an error can only be logged.
+	if _, err := io.Copy(w, io.LimitReader(src, f.Size)); err != nil {
+		slog.Error("Failed to extract file from archive", //nolint:gosec
+			"path", fileName, "file", memberName, "error", err,
+		)
+	}
+}
+
+// archiveFileError responds to a file which could not be extracted from an archive.
+func archiveFileError(w http.ResponseWriter, r *http.Request, fileName string, err error) {
+	switch {
+	case errors.Is(err, helpers.ErrArchiveFileNotFound):
+		ErrorMsg(w, r, http.StatusNotFound, "File not found")
+	case errors.Is(err, helpers.ErrArchiveTooLarge):
+		ErrorMsg(w, r, http.StatusUnprocessableEntity, "This file is too large to extract from the archive")
+	case errors.Is(err, helpers.ErrNoReaderAt):
+		slog.Error("Storage backend does not support random access", "path", fileName) //nolint:gosec
+		Error(w, r, http.StatusInternalServerError)
+	default:
+		slog.Warn("Failed to read archive", "path", fileName, "error", err) //nolint:gosec
+		ErrorMsg(w, r, http.StatusUnprocessableEntity, "Failed to read archive")
+	}
+}
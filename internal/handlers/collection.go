@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/collection"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/headers"
+	"gabe565.com/linx-server/internal/util"
+	"gabe565.com/linx-server/internal/webhook"
+)
+
+type CollectionFileJSON struct {
+	OriginalName string `json:"original_name,omitzero"`
+	Filename     string `json:"filename"`
+	URL          string `json:"url"`
+	DirectURL    string `json:"direct_url"`
+	Size         string `json:"size"`
+	Mimetype     string `json:"mimetype"`
+}
+
+// collectionJSON lists the files of a collection on its display page.
+// Their direct URLs go through the collection, so the collection's access key unlocks them.
+func collectionJSON(r *http.Request, fileName string, metadata backends.Metadata, res *DisplayJSON) error {
+	manifest, err := collection.Read(r.Context(), config.StorageBackend, fileName)
+	if err != nil {
+		return err
+	}
+	members, err := manifest.Members(r.Context(), config.StorageBackend)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	res.Files = make([]CollectionFileJSON, 0, len(members))
+	for _, member := range members {
+		res.Files = append(res.Files, CollectionFileJSON{
+			OriginalName: member.Metadata.OriginalName,
+			Filename:     member.Name,
+			URL:          headers.GetFileURL(r, member.Name).String(),
+			DirectURL:    headers.GetSelifURL(r, path.Join(fileName, member.Name)).String(),
+			Size:         strconv.FormatInt(member.Metadata.Size, 10),
+			Mimetype:     member.Metadata.Mimetype,
+		})
+		size += member.Metadata.Size
+	}
+	res.OriginalName = collection.Title(fileName, metadata)
+	res.Size = strconv.FormatInt(size, 10)
+	return nil
+}
+
+// serveCollection streams every file in a collection as a zip archive.
+func serveCollection(
+	w http.ResponseWriter,
+	r *http.Request,
+	fileName string,
+	metadata backends.Metadata,
+	link sharedLink,
+) {
+	manifest, err := collection.Read(r.Context(), config.StorageBackend, fileName)
+	if err != nil {
+		slog.Error("Failed to read collection", "path", fileName, "error", err) //nolint:gosec
+		Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	members, err := manifest.Members(r.Context(), config.StorageBackend)
+	if err != nil {
+		slog.Error("Failed to read collection", "path", fileName, "error", err) //nolint:gosec
+		Error(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	if !claimShare(w, r, fileName, link) {
+		return
+	}
+
+	w.Header().Set("Content-Security-Policy", FileCSP)
+	w.Header().Set("Referrer-Policy", config.Default().Header.FileReferrerPolicy)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition",
+		util.EncodeContentDisposition("attachment", collection.Title(fileName, metadata)+".zip"),
+	)
+	setFileCacheControl(w, metadata, link)
+
+	// The response has started, so an error can only be logged.
+	err = collection.WriteZip(r.Context(), w, config.StorageBackend, members)
+	releaseShare(fileName, link, err == nil)
+	if err != nil {
+		slog.Error("Failed to stream collection", "path", fileName, "error", err) //nolint:gosec
+		return
+	}
+	config.Stats.Download(fileName)
+}
+
+// serveCollectionFile serves a file in a collection to anyone who may access the collection,
+// so that the collection's unlock cookie or share link also covers its files.
+func serveCollectionFile(w http.ResponseWriter, r *http.Request, fileName, memberName string, link sharedLink) {
+	manifest, err := collection.Read(r.Context(), config.StorageBackend, fileName)
+	if err != nil {
+		slog.Error("Failed to read collection", "path", fileName, "error", err) //nolint:gosec
+		Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	memberMeta, ok := headFile(w, r, memberName)
+	if !ok {
+		return
+	}
+	if !manifest.Contains(memberName, memberMeta) {
+		ErrorMsg(w, r, http.StatusNotFound, "File not found")
+		return
+	}
+	serveFile(w, r, memberName, memberMeta, link)
+}
+
+// deleteCollectionFiles deletes the files of a collection which share its delete key.
+// Files which were replaced by another upload are kept.
+func deleteCollectionFiles(r *http.Request, manifest collection.Manifest) {
+	ctx := context.WithoutCancel(r.Context())
+	for _, f := range manifest.Files {
+		name := f.Name
+		metadata, err := config.StorageBackend.Head(ctx, name)
+		if err != nil || !manifest.Contains(name, metadata) || !CheckDeleteKey(r, metadata) {
+			continue
+		}
+		if err := config.StorageBackend.Delete(ctx, name); err != nil {
+			slog.Error("Failed to delete file from collection", "path", name, "error", err) //nolint:gosec
+			continue
+		}
+		config.Hooks.Send(ctx, webhook.EventDelete, name, metadata)
+	}
+}
@@ -5,16 +5,17 @@ import (
 	"io"
 	"net/http"
 
+	"gabe565.com/linx-server/internal/auth/apikeys"
 	"gabe565.com/linx-server/internal/auth/keyhash"
 	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/collection"
 	"gabe565.com/linx-server/internal/config"
 	"gabe565.com/linx-server/internal/util"
+	"gabe565.com/linx-server/internal/webhook"
 	"github.com/go-chi/chi/v5"
 )
 
 func Delete(w http.ResponseWriter, r *http.Request) {
-	requestKey := util.TryPathUnescape(r.Header.Get("Linx-Delete-Key"))
-
 	filename := chi.URLParam(r, "name")
 
 	// Ensure that file exists and delete key is correct
@@ -28,17 +29,39 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	matchDeleteKey, err := keyhash.CheckWithFallback(metadata.DeleteKey, requestKey, metadata.Salt)
-	if err != nil || !matchDeleteKey {
+	if !CheckDeleteKey(r, metadata) {
 		Error(w, r, http.StatusUnauthorized) // 401 - wrong delete key
 		return
 	}
 
+	// A collection's manifest must be read before it is deleted.
+	var manifest collection.Manifest
+	if collection.Is(metadata) {
+		if manifest, err = collection.Read(r.Context(), config.StorageBackend, filename); err != nil {
+			Error(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+
 	if err := config.StorageBackend.Delete(r.Context(), filename); err != nil {
 		Error(w, r, http.StatusInternalServerError)
 		return
 	}
+	config.Hooks.Send(r.Context(), webhook.EventDelete, filename, metadata)
+	deleteCollectionFiles(r, manifest)
 
 	w.Header().Set("Vary", "Accept, Linx-Delete-Key")
 	_, _ = io.WriteString(w, "DELETED\n")
 }
+
+// CheckDeleteKey reports whether a request has the delete key of an upload.
+// Keys with the delete-any scope may manage any upload.
+func CheckDeleteKey(r *http.Request, metadata backends.Metadata) bool {
+	if key, ok := apikeys.KeyFromContext(r.Context()); ok && key.HasScope(apikeys.ScopeDeleteAny) {
+		return true
+	}
+
+	requestKey := util.TryPathUnescape(r.Header.Get("Linx-Delete-Key"))
+	matchDeleteKey, err := keyhash.CheckWithFallback(metadata.DeleteKey, requestKey, metadata.Salt)
+	return err == nil && matchDeleteKey
+}
@@ -3,47 +3,86 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/collection"
 	"gabe565.com/linx-server/internal/config"
 	"gabe565.com/linx-server/internal/headers"
+	"gabe565.com/linx-server/internal/helpers"
+	"gabe565.com/linx-server/internal/stats"
 	"gabe565.com/linx-server/internal/template"
 	"gabe565.com/linx-server/internal/util"
 )
 
 type DisplayJSON struct {
-	OriginalName string   `json:"original_name,omitzero"`
-	Filename     string   `json:"filename"`
-	DirectURL    string   `json:"direct_url"`
-	TorrentURL   string   `json:"torrent_url,omitzero"`
-	Expiry       string   `json:"expiry"`
-	Size         string   `json:"size"`
-	Mimetype     string   `json:"mimetype"`
-	Language     string   `json:"language,omitzero"`
-	ArchiveFiles []string `json:"archive_files,omitzero"`
+	OriginalName string `json:"original_name,omitzero"`
+	Filename     string `json:"filename"`
+	DirectURL    string `json:"direct_url"`
+	TorrentURL   string `json:"torrent_url,omitzero"`
+	// MagnetURI is set once the upload's torrent was hashed.
+	MagnetURI    string                  `json:"magnet_uri,omitzero"`
+	ThumbnailURL string                  `json:"thumbnail_url,omitzero"`
+	Expiry       string                  `json:"expiry"`
+	Size         string                  `json:"size"`
+	Mimetype     string                  `json:"mimetype"`
+	Language     string                  `json:"language,omitzero"`
+	ArchiveFiles []backends.ArchiveEntry `json:"archive_files,omitzero"`
+	// ArchiveExtract is set if files can be extracted from the archive through its direct URL.
+	ArchiveExtract bool         `json:"archive_extract,omitzero"`
+	DownloadsLeft  int          `json:"downloads_left,omitzero"`
+	Encrypted      bool         `json:"encrypted,omitzero"`
+	Stats          *stats.Stats `json:"stats,omitzero"` // Only included for requests with the delete key
+	// Files lists the files of a collection.
+	Files []CollectionFileJSON `json:"files,omitzero"`
 }
 
 func FileDisplay(w http.ResponseWriter, r *http.Request, fileName string, metadata backends.Metadata) {
 	if strings.EqualFold("application/json", r.Header.Get("Accept")) {
 		res := DisplayJSON{
-			OriginalName: metadata.OriginalName,
-			Filename:     fileName,
-			DirectURL:    headers.GetSelifURL(r, fileName).String(),
-			Expiry:       strconv.FormatInt(max(metadata.Expiry.Unix(), 0), 10),
-			Size:         strconv.FormatInt(metadata.Size, 10),
-			Mimetype:     metadata.Mimetype,
-			Language:     util.InferLang(fileName, metadata),
-			ArchiveFiles: metadata.ArchiveFiles,
+			OriginalName:   metadata.OriginalName,
+			Filename:       fileName,
+			DirectURL:      headers.GetSelifURL(r, fileName).String(),
+			Expiry:         strconv.FormatInt(max(metadata.Expiry.Unix(), 0), 10),
+			Size:           strconv.FormatInt(metadata.Size, 10),
+			Mimetype:       metadata.Mimetype,
+			Language:       util.InferLang(fileName, metadata),
+			ArchiveFiles:   metadata.ArchiveFiles,
+			ArchiveExtract: len(metadata.ArchiveFiles) != 0 && helpers.CanExtractArchive(metadata.Mimetype),
+			DownloadsLeft:  metadata.DownloadsLeft,
+			Encrypted:      metadata.Encrypted,
 		}
 
-		if !config.Default.NoTorrent {
+		if collection.Is(metadata) {
+			if err := collectionJSON(r, fileName, metadata, &res); err != nil {
+				slog.Error("Failed to read collection", "path", fileName, "error", err) //nolint:gosec
+				ErrorType(w, r, RespJSON, http.StatusInternalServerError, "Corrupt collection")
+				return
+			}
+		} else if HasTorrent(metadata) {
 			res.TorrentURL = headers.GetTorrentURL(r, fileName).String()
+			res.MagnetURI = torrentMagnet(r, fileName, metadata)
 		}
 
-		if metadata.AccessKey != "" || config.Default.Auth.File != "" || config.Default.Auth.RemoteFile != "" {
+		if HasThumbnail(metadata) {
+			res.ThumbnailURL = headers.GetThumbnailURL(r, fileName).String()
+		}
+
+		// The display page loads this response, so it counts as a view unless the uploader is checking their stats.
+		if config.Stats != nil && r.Header.Get("Linx-Delete-Key") != "" && CheckDeleteKey(r, metadata) {
+			if st, err := config.Stats.Get(fileName); err == nil {
+				res.Stats = &st
+			} else {
+				slog.Error("Failed to read access stats", "path", fileName, "error", err) //nolint:gosec
+			}
+		} else {
+			config.Stats.View(fileName)
+		}
+
+		if metadata.AccessKey != "" || config.Default().Auth.File != "" || config.Default().Auth.RemoteFile != "" {
 			w.Header().Set("Cache-Control", "private, no-cache")
 		} else {
 			w.Header().Set("Cache-Control", "public, no-cache")
@@ -57,18 +96,27 @@ func FileDisplay(w http.ResponseWriter, r *http.Request, fileName string, metada
 		return
 	}
 
-	description := "Download this file on " + config.Default.SiteName + "."
-	if !metadata.Expiry.IsZero() {
-		description += " Expires " + metadata.Expiry.Format("Jan 2, 2006") + "."
-	}
-
+	description := "Download this file on " + config.Default().SiteName + "."
 	prettyName := metadata.OriginalName
 	if metadata.OriginalName == "" {
 		prettyName = fileName
 	}
+	if collection.Is(metadata) {
+		description = "View this collection on " + config.Default().SiteName + "."
+		prettyName = collection.Title(fileName, metadata)
+	}
+	if !metadata.Expiry.IsZero() {
+		description += " Expires " + metadata.Expiry.Format("Jan 2, 2006") + "."
+	}
 
-	AssetHandler(
+	opts := []template.OptionFunc{
 		template.WithTitle(prettyName),
 		template.WithDescription(description),
-	)(w, r)
+	}
+	// Link previews are fetched without the access key, so protected uploads never get an image.
+	if metadata.AccessKey == "" && HasThumbnail(metadata) {
+		opts = append(opts, template.WithOpenGraph(template.OpenGraphImage, headers.GetThumbnailURL(r, fileName).String()))
+	}
+
+	AssetHandler(opts...)(w, r)
 }
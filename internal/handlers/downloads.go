@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/webhook"
+)
+
+// downloads counts responses which are still being served for uploads with a download limit.
+// It is only shared within one process, so instances which share a storage backend can serve an upload
+// more times than allowed.
+//
+//nolint:gochecknoglobals
+var downloads = struct {
+	mu       sync.Mutex
+	inflight map[string]int
+}{inflight: make(map[string]int)}
+
+var ErrNoDownloadsLeft = errors.New("no downloads left")
+
+// claimDownload reserves one of an upload's remaining downloads.
+// Reservations count against the limit until they are released,
+// so concurrent requests can't serve a file more times than allowed.
+func claimDownload(ctx context.Context, fileName string) error {
+	downloads.mu.Lock()
+	defer downloads.mu.Unlock()
+
+	metadata, err := config.StorageBackend.Head(ctx, fileName)
+	if err != nil {
+		return err
+	}
+	if metadata.DownloadsLeft != 0 && metadata.DownloadsLeft <= downloads.inflight[fileName] {
+		return ErrNoDownloadsLeft
+	}
+	downloads.inflight[fileName]++
+	return nil
+}
+
+// releaseDownload releases a reservation. If the file was served,
+// the remaining downloads are decremented and the upload is deleted once none are left.
+func releaseDownload(ctx context.Context, fileName string, served bool) {
+	downloads.mu.Lock()
+	defer downloads.mu.Unlock()
+
+	if downloads.inflight[fileName]--; downloads.inflight[fileName] <= 0 {
+		delete(downloads.inflight, fileName)
+	}
+	if !served {
+		return
+	}
+
+	metadata, err := config.StorageBackend.Head(ctx, fileName)
+	if err != nil {
+		if !errors.Is(err, backends.ErrNotFound) {
+			slog.Error("Failed to count download", "path", fileName, "error", err)
+		}
+		return
+	}
+	if metadata.DownloadsLeft == 0 {
+		return
+	}
+
+	if metadata.DownloadsLeft--; metadata.DownloadsLeft > 0 {
+		if err := config.StorageBackend.PutMetadata(ctx, fileName, metadata); err != nil {
+			slog.Error("Failed to count download", "path", fileName, "error", err)
+		}
+		return
+	}
+
+	if err := config.StorageBackend.Delete(ctx, fileName); err != nil {
+		slog.Error("Failed to delete file after its last download", "path", fileName, "error", err)
+		return
+	}
+	config.Hooks.Send(ctx, webhook.EventExpire, fileName, metadata)
+}
@@ -15,12 +15,17 @@ import (
 
 	"gabe565.com/linx-server/assets"
 	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/collection"
 	"gabe565.com/linx-server/internal/config"
 	"gabe565.com/linx-server/internal/csrf"
 	"gabe565.com/linx-server/internal/headers"
+	"gabe565.com/linx-server/internal/helpers"
+	"gabe565.com/linx-server/internal/share"
 	"gabe565.com/linx-server/internal/template"
 	"gabe565.com/linx-server/internal/util"
+	"gabe565.com/linx-server/internal/webhook"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 const FileCSP = "default-src 'none'; img-src 'self'; object-src 'self'; media-src 'self'; style-src 'self' 'unsafe-inline';"
@@ -28,27 +33,184 @@ const FileCSP = "default-src 'none'; img-src 'self'; object-src 'self'; media-sr
 func FileServeHandler(w http.ResponseWriter, r *http.Request) {
 	fileName := chi.URLParam(r, "name")
 
-	metadata, err := CheckFile(r.Context(), fileName)
-	if err != nil {
-		if errors.Is(err, backends.ErrNotFound) {
-			ErrorMsg(w, r, http.StatusNotFound, "File not found")
-		} else {
-			slog.Error("Corrupt metadata", "path", fileName, "error", err) //nolint:gosec
-			ErrorMsg(w, r, http.StatusInternalServerError, "Corrupt metadata")
+	metadata, link, ok := checkDownload(w, r, fileName)
+	if !ok {
+		return
+	}
+
+	if collection.Is(metadata) {
+		serveCollection(w, r, fileName, metadata, link)
+		return
+	}
+	serveFile(w, r, fileName, metadata, link)
+}
+
+// FileMemberHandler serves a file within an upload: a file in a collection, or a file extracted from an archive.
+// It is only allowed for requests which may download the upload itself.
+func FileMemberHandler(w http.ResponseWriter, r *http.Request) {
+	fileName := chi.URLParam(r, "name")
+	memberName := chi.URLParam(r, "*")
+	if r.URL.RawPath != "" {
+		// The route was matched against the escaped path.
+		if s, err := url.PathUnescape(memberName); err == nil {
+			memberName = s
 		}
+	}
+
+	metadata, link, ok := checkDownload(w, r, fileName)
+	if !ok {
 		return
 	}
 
-	if src, err := CheckAccessKey(r, &metadata); err != nil {
-		// remove invalid cookie
-		if src == AccessKeySourceCookie {
-			SetAccessKeyCookies(w, r, fileName, "", time.Time{})
+	// Limits only apply to downloads of the whole upload, so its files could otherwise be fetched endlessly.
+	switch {
+	case link.MaxUses != 0:
+		ErrorMsg(w, r, http.StatusForbidden, "Invalid share link")
+	case metadata.DownloadsLeft != 0:
+		ErrorMsg(w, r, http.StatusForbidden, "Files can't be opened individually from uploads with a download limit")
+	case collection.Is(metadata):
+		serveCollectionFile(w, r, fileName, memberName, link)
+	case helpers.CanExtractArchive(metadata.Mimetype):
+		serveArchiveFile(w, r, fileName, metadata, memberName, link)
+	default:
+		ErrorMsg(w, r, http.StatusNotFound, "File not found")
+	}
+}
+
+// serveFile serves an upload after its access was checked.
+func serveFile(w http.ResponseWriter, r *http.Request, fileName string, metadata backends.Metadata, link sharedLink) {
+	w.Header().Set("Content-Security-Policy", FileCSP)
+	w.Header().Set("Referrer-Policy", config.Default().Header.FileReferrerPolicy)
+
+	w.Header().Set("Content-Type", metadata.Mimetype)
+	w.Header().Set("Content-Length", strconv.FormatInt(metadata.Size, 10))
+	w.Header().Set("ETag", metadata.Etag())
+	setFileCacheControl(w, metadata, link)
+
+	if r.URL.Query().Has("download") || IsDirectUA(r) {
+		dlName := fileName
+		if metadata.OriginalName != "" {
+			dlName = metadata.OriginalName
+		}
+		w.Header().Set("Content-Disposition", util.EncodeContentDisposition("attachment", dlName))
+	}
+
+	if metadata.DownloadsLeft != 0 || link.MaxUses != 0 {
+		serveLimited(w, r, fileName, metadata, link)
+		return
+	}
+
+	ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	if err := config.StorageBackend.ServeFile(fileName, ww, r); err != nil {
+		slog.Error("Failed to serve file", "path", fileName, "error", err) //nolint:gosec
+		Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	if isDownload(r, ww) {
+		config.Stats.Download(fileName)
+	}
+}
+
+// checkDownload checks a request's share link if it has one, and its access key otherwise.
+func checkDownload(w http.ResponseWriter, r *http.Request, fileName string) (backends.Metadata, sharedLink, bool) {
+	if share.Has(r.URL.Query()) {
+		return checkShare(w, r, fileName)
+	}
+	metadata, ok := checkServe(w, r, fileName)
+	return metadata, sharedLink{}, ok
+}
+
+func setFileCacheControl(w http.ResponseWriter, metadata backends.Metadata, link sharedLink) {
+	switch {
+	case metadata.DownloadsLeft != 0, link.id != "":
+		w.Header().Set("Cache-Control", "private, no-store")
+	case metadata.AccessKey != "" || config.Default().Auth.File != "" || config.Default().Auth.RemoteFile != "":
+		w.Header().Set("Cache-Control", "private, no-cache")
+	default:
+		w.Header().Set("Cache-Control", "public, no-cache")
+	}
+}
+
+// isDownload reports whether a response started a download.
+// Media players fetch a file with many range requests, so only a range from the start of the file counts.
+func isDownload(r *http.Request, ww middleware.WrapResponseWriter) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	switch ww.Status() {
+	case http.StatusOK:
+		return true
+	case http.StatusPartialContent:
+		return strings.HasPrefix(r.Header.Get("Range"), "bytes=0-")
+	}
+	return false
+}
+
+// serveLimited serves an upload with a download limit, or with a share link which has a limited number of uses.
+// Only complete responses to GET requests count as a download,
+// and range requests are answered with the whole file so that a download can't be split across requests.
+func serveLimited(
+	w http.ResponseWriter,
+	r *http.Request,
+	fileName string,
+	metadata backends.Metadata,
+	link sharedLink,
+) {
+	limited := metadata.DownloadsLeft != 0
+	if limited {
+		if err := claimDownload(r.Context(), fileName); err != nil {
+			switch {
+			case errors.Is(err, ErrNoDownloadsLeft), errors.Is(err, backends.ErrNotFound):
+				ErrorMsg(w, r, http.StatusNotFound, "File not found")
+			default:
+				slog.Error("Failed to claim download", "path", fileName, "error", err) //nolint:gosec
+				Error(w, r, http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	if !claimShare(w, r, fileName, link) {
+		if limited {
+			releaseDownload(context.WithoutCancel(r.Context()), fileName, false)
 		}
-		Error(w, r, http.StatusUnauthorized)
 		return
 	}
 
-	if !config.Default.AllowHotlink {
+	r.Header.Del("Range")
+	ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	err := config.StorageBackend.ServeFile(fileName, ww, r)
+	served := err == nil && r.Method == http.MethodGet &&
+		ww.Status() == http.StatusOK && int64(ww.BytesWritten()) == metadata.Size
+	// The link is released first, since the last download deletes the upload along with its link uses.
+	releaseShare(fileName, link, served)
+	if limited {
+		releaseDownload(context.WithoutCancel(r.Context()), fileName, served)
+	}
+	if served {
+		config.Stats.Download(fileName)
+	}
+
+	if err != nil {
+		slog.Error("Failed to serve file", "path", fileName, "error", err) //nolint:gosec
+		Error(w, r, http.StatusInternalServerError)
+	}
+}
+
+// checkServe writes an error response if the file does not exist, the access key is invalid,
+// or the request is a disallowed hotlink.
+func checkServe(w http.ResponseWriter, r *http.Request, fileName string) (backends.Metadata, bool) {
+	metadata, ok := headFile(w, r, fileName)
+	if !ok {
+		return metadata, false
+	}
+
+	if src, err := CheckAccessKey(r, fileName, &metadata); err != nil {
+		accessDenied(w, r, fileName, src, err)
+		return metadata, false
+	}
+
+	if !config.Default().AllowHotlink {
 		referer := r.Header.Get("Referer")
 		ok := referer == ""
 
@@ -57,7 +219,7 @@ func FileServeHandler(w http.ResponseWriter, r *http.Request) {
 			want := headers.GetSiteURL(r)
 
 			if ok = csrf.SameOrigin(got, want); !ok {
-				for _, allowed := range config.Default.AllowReferrers {
+				for _, allowed := range config.Default().AllowReferrers {
 					want, err := url.Parse(allowed)
 					if err != nil {
 						slog.Error("Failed to parse allowed referrer", "referrer", allowed, "error", err)
@@ -74,35 +236,26 @@ func FileServeHandler(w http.ResponseWriter, r *http.Request) {
 
 		if !ok {
 			http.Redirect(w, r, headers.GetFileURL(r, fileName).String(), http.StatusSeeOther)
-			return
+			return metadata, false
 		}
 	}
 
-	w.Header().Set("Content-Security-Policy", FileCSP)
-	w.Header().Set("Referrer-Policy", config.Default.Header.FileReferrerPolicy)
-
-	w.Header().Set("Content-Type", metadata.Mimetype)
-	w.Header().Set("Content-Length", strconv.FormatInt(metadata.Size, 10))
-	w.Header().Set("ETag", metadata.Etag())
-	if metadata.AccessKey != "" || config.Default.Auth.File != "" || config.Default.Auth.RemoteFile != "" {
-		w.Header().Set("Cache-Control", "private, no-cache")
-	} else {
-		w.Header().Set("Cache-Control", "public, no-cache")
-	}
+	return metadata, true
+}
 
-	if r.URL.Query().Has("download") || IsDirectUA(r) {
-		dlName := fileName
-		if metadata.OriginalName != "" {
-			dlName = metadata.OriginalName
+// headFile writes an error response if the file does not exist.
+func headFile(w http.ResponseWriter, r *http.Request, fileName string) (backends.Metadata, bool) {
+	metadata, err := CheckFile(r.Context(), fileName)
+	if err != nil {
+		if errors.Is(err, backends.ErrNotFound) {
+			ErrorMsg(w, r, http.StatusNotFound, "File not found")
+		} else {
+			slog.Error("Corrupt metadata", "path", fileName, "error", err) //nolint:gosec
+			ErrorMsg(w, r, http.StatusInternalServerError, "Corrupt metadata")
 		}
-		w.Header().Set("Content-Disposition", util.EncodeContentDisposition("attachment", dlName))
-	}
-
-	if err := config.StorageBackend.ServeFile(fileName, w, r); err != nil {
-		slog.Error("Failed to serve file", "path", fileName, "error", err) //nolint:gosec
-		Error(w, r, http.StatusInternalServerError)
-		return
+		return metadata, false
 	}
+	return metadata, true
 }
 
 func AssetHandler(opts ...template.OptionFunc) http.HandlerFunc {
@@ -168,7 +321,7 @@ func ServeAsset(w http.ResponseWriter, r *http.Request, status int, opts ...temp
 	}
 
 	w.Header().Set("Vary", "Accept")
-	http.ServeContent(w, r, path, config.TimeStarted, file)
+	http.ServeContent(w, r, path, config.Default().TimeStarted, file)
 }
 
 func CheckFile(ctx context.Context, filename string) (backends.Metadata, error) {
@@ -180,12 +333,14 @@ func CheckFile(ctx context.Context, filename string) (backends.Metadata, error)
 	if metadata.Expired() {
 		//nolint:gosec // Intentional async cleanup; delete should not block the response.
 		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			deleteCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
 			defer cancel()
 
-			if err := config.StorageBackend.Delete(ctx, filename); err != nil {
+			if err := config.StorageBackend.Delete(deleteCtx, filename); err != nil {
 				slog.Error("Failed to delete expired file", "path", filename, "error", err)
+				return
 			}
+			config.Hooks.Send(ctx, webhook.EventExpire, filename, metadata)
 		}()
 		return metadata, backends.ErrNotFound
 	}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/headers"
+	"gabe565.com/linx-server/internal/share"
+	"github.com/go-chi/chi/v5"
+)
+
+const DefaultShareExpiry = 24 * time.Hour
+
+var ErrInvalidShare = errors.New("invalid share link options")
+
+// shareMu prevents concurrent requests from replacing each other's new share secret.
+//
+//nolint:gochecknoglobals
+var shareMu sync.Mutex
+
+type ShareResponse struct {
+	URL     string `json:"url"`
+	Expiry  int64  `json:"expiry"`
+	MaxUses int    `json:"max_uses,omitzero"`
+	IP      string `json:"ip,omitzero"`
+}
+
+// ShareHandler creates a signed link which grants download access to an upload without its access key.
+// It requires the upload's delete key.
+func ShareHandler(w http.ResponseWriter, r *http.Request) {
+	if config.Shares == nil {
+		ErrorType(w, r, RespJSON, http.StatusNotFound, "Share links not available")
+		return
+	}
+
+	fileName := chi.URLParam(r, "name")
+
+	link, err := parseShareLink(r, time.Now())
+	if err != nil {
+		ErrorType(w, r, RespJSON, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	shareMu.Lock()
+	defer shareMu.Unlock()
+
+	metadata, ok := checkShareOwner(w, r, fileName)
+	if !ok {
+		return
+	}
+
+	if metadata.ShareSecret == "" {
+		metadata.ShareSecret = share.NewSecret()
+		if err := config.StorageBackend.PutMetadata(r.Context(), fileName, metadata); err != nil {
+			slog.Error("Failed to save share secret", "path", fileName, "error", err) //nolint:gosec
+			ErrorType(w, r, RespJSON, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	u := headers.GetSelifURL(r, fileName)
+	u.RawQuery = link.Sign(metadata.ShareSecret, fileName).Encode()
+
+	res := ShareResponse{
+		URL:     u.String(),
+		Expiry:  link.Expires.Unix(),
+		MaxUses: link.MaxUses,
+	}
+	if link.Prefix.IsValid() {
+		res.IP = link.Prefix.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// RevokeSharesHandler revokes every share link of an upload by replacing its share secret.
+// It requires the upload's delete key.
+func RevokeSharesHandler(w http.ResponseWriter, r *http.Request) {
+	if config.Shares == nil {
+		ErrorType(w, r, RespJSON, http.StatusNotFound, "Share links not available")
+		return
+	}
+
+	fileName := chi.URLParam(r, "name")
+
+	shareMu.Lock()
+	defer shareMu.Unlock()
+
+	metadata, ok := checkShareOwner(w, r, fileName)
+	if !ok {
+		return
+	}
+
+	if metadata.ShareSecret != "" {
+		// A new secret is created when the next link is shared.
+		metadata.ShareSecret = ""
+		if err := config.StorageBackend.PutMetadata(r.Context(), fileName, metadata); err != nil {
+			slog.Error("Failed to revoke share links", "path", fileName, "error", err) //nolint:gosec
+			ErrorType(w, r, RespJSON, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	if err := config.Shares.Delete(fileName); err != nil {
+		slog.Warn("Failed to delete share link uses", "path", fileName, "error", err) //nolint:gosec
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkShareOwner writes a JSON error response if the file does not exist or the delete key is invalid.
+func checkShareOwner(w http.ResponseWriter, r *http.Request, fileName string) (backends.Metadata, bool) {
+	metadata, err := CheckFile(r.Context(), fileName)
+	if err != nil {
+		if errors.Is(err, backends.ErrNotFound) {
+			ErrorType(w, r, RespJSON, http.StatusNotFound, "File not found")
+		} else {
+			slog.Error("Corrupt metadata", "path", fileName, "error", err) //nolint:gosec
+			ErrorType(w, r, RespJSON, http.StatusInternalServerError, "Corrupt metadata")
+		}
+		return metadata, false
+	}
+
+	if !CheckDeleteKey(r, metadata) {
+		ErrorType(w, r, RespJSON, http.StatusUnauthorized, "")
+		return metadata, false
+	}
+	return metadata, true
+}
+
+// parseShareLink reads the options of a new share link.
+// The expiry is a duration or a number of seconds, and is limited by the max expiry.
+func parseShareLink(r *http.Request, now time.Time) (share.Link, error) {
+	var link share.Link
+
+	expiry := DefaultShareExpiry
+	if s := r.FormValue("expiry"); s != "" {
+		var err error
+		if expiry, err = time.ParseDuration(s); err != nil {
+			seconds, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return link, ErrInvalidShare
+			}
+			expiry = time.Duration(seconds) * time.Second
+		}
+		if expiry <= 0 {
+			return link, ErrInvalidShare
+		}
+	}
+	if maxExpiry := config.Default().MaxExpiry.Duration; maxExpiry != 0 {
+		expiry = min(expiry, maxExpiry)
+	}
+	link.Expires = now.Add(expiry).Truncate(time.Second)
+
+	if s := r.FormValue("max_uses"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return link, ErrInvalidShare
+		}
+		link.MaxUses = n
+	}
+
+	if s := r.FormValue("ip"); s != "" {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return link, ErrInvalidShare
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		link.Prefix = prefix.Masked()
+	}
+	return link, nil
+}
+
+// sharedLink is a verified share link which a download is served with.
+type sharedLink struct {
+	share.Link
+	id string
+}
+
+// checkShare writes an error response if the file does not exist or the share link is invalid.
+// Share links skip the hotlink check, since they are meant to be handed out.
+func checkShare(w http.ResponseWriter, r *http.Request, fileName string) (backends.Metadata, sharedLink, bool) {
+	metadata, ok := headFile(w, r, fileName)
+	if !ok {
+		return metadata, sharedLink{}, false
+	}
+
+	link, id, err := share.Verify(r.URL.Query(), metadata.ShareSecret, fileName, remoteAddr(r), time.Now())
+	if err != nil {
+		shareDenied(w, r, err)
+		return metadata, sharedLink{}, false
+	}
+	return metadata, sharedLink{Link: link, id: id}, true
+}
+
+// claimShare reserves one use of a share link with a limited number of uses.
+// It writes an error response if the link has no uses left.
+func claimShare(w http.ResponseWriter, r *http.Request, fileName string, link sharedLink) bool {
+	if link.MaxUses == 0 {
+		return true
+	}
+	if err := config.Shares.Claim(fileName, link.id, link.MaxUses); err != nil {
+		if errors.Is(err, share.ErrNoUsesLeft) {
+			shareDenied(w, r, err)
+		} else {
+			slog.Error("Failed to claim share link use", "path", fileName, "error", err) //nolint:gosec
+			Error(w, r, http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+// releaseShare releases a reservation by claimShare, and counts a use if the file was served.
+func releaseShare(fileName string, link sharedLink, served bool) {
+	if link.MaxUses == 0 {
+		return
+	}
+	if err := config.Shares.Release(fileName, link.id, served); err != nil {
+		slog.Error("Failed to count share link use", "path", fileName, "error", err) //nolint:gosec
+	}
+}
+
+// shareDenied responds to a request with a share link which can't be used.
+func shareDenied(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, share.ErrExpired):
+		ErrorMsg(w, r, http.StatusForbidden, "This share link has expired")
+	case errors.Is(err, share.ErrNoUsesLeft):
+		ErrorMsg(w, r, http.StatusForbidden, "This share link has no uses left")
+	case errors.Is(err, share.ErrAddrNotAllowed):
+		ErrorMsg(w, r, http.StatusForbidden, "This share link is not valid from your address")
+	default:
+		ErrorMsg(w, r, http.StatusForbidden, "Invalid share link")
+	}
+}
+
+func remoteAddr(r *http.Request) netip.Addr {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	addr, _ := netip.ParseAddr(host)
+	return addr.Unmap()
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/config"
+	"github.com/go-chi/chi/v5"
+)
+
+// StatsHandler reports how often an upload has been viewed and downloaded. It requires the upload's delete key.
+func StatsHandler(w http.ResponseWriter, r *http.Request) {
+	if config.Stats == nil {
+		ErrorType(w, r, RespJSON, http.StatusNotFound, "Stats not available")
+		return
+	}
+
+	fileName := chi.URLParam(r, "name")
+
+	metadata, err := CheckFile(r.Context(), fileName)
+	if err != nil {
+		if errors.Is(err, backends.ErrNotFound) {
+			ErrorType(w, r, RespJSON, http.StatusNotFound, "File not found")
+		} else {
+			slog.Error("Corrupt metadata", "path", fileName, "error", err) //nolint:gosec
+			ErrorType(w, r, RespJSON, http.StatusInternalServerError, "Corrupt metadata")
+		}
+		return
+	}
+
+	if !CheckDeleteKey(r, metadata) {
+		ErrorType(w, r, RespJSON, http.StatusUnauthorized, "")
+		return
+	}
+
+	stats, err := config.Stats.Get(fileName)
+	if err != nil {
+		slog.Error("Failed to read access stats", "path", fileName, "error", err) //nolint:gosec
+		ErrorType(w, r, RespJSON, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(stats)
+}
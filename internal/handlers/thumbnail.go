@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/thumbnail"
+	"github.com/go-chi/chi/v5"
+)
+
+// HasThumbnail reports whether a thumbnail can be served for an upload.
+// Uploads with a download limit have no thumbnail, since it would reveal the image without counting as a download.
+// Encrypted uploads can't be read by the server.
+func HasThumbnail(metadata backends.Metadata) bool {
+	return !config.Default().NoThumbnails && config.Thumbnails != nil && metadata.DownloadsLeft == 0 &&
+		!metadata.Encrypted && thumbnail.Supported(metadata.Mimetype)
+}
+
+// ThumbnailHandler serves a resized copy of an image upload.
+func ThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	fileName := chi.URLParam(r, "name")
+
+	metadata, ok := checkServe(w, r, fileName)
+	if !ok {
+		return
+	}
+
+	if !HasThumbnail(metadata) {
+		ErrorMsg(w, r, http.StatusNotFound, "Thumbnail not available")
+		return
+	}
+
+	f, mimetype, err := config.Thumbnails.Open(r.Context(), config.StorageBackend, fileName, metadata)
+	if err != nil {
+		switch {
+		case errors.Is(err, backends.ErrNotFound):
+			ErrorMsg(w, r, http.StatusNotFound, "File not found")
+		case errors.Is(err, thumbnail.ErrUnsupported), errors.Is(err, thumbnail.ErrTooLarge):
+			ErrorMsg(w, r, http.StatusNotFound, "Thumbnail not available")
+		default:
+			slog.Error("Failed to generate thumbnail", "path", fileName, "error", err) //nolint:gosec
+			ErrorMsg(w, r, http.StatusInternalServerError, "Failed to generate thumbnail")
+		}
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	w.Header().Set("Content-Security-Policy", FileCSP)
+	w.Header().Set("Referrer-Policy", config.Default().Header.FileReferrerPolicy)
+	w.Header().Set("Content-Type", mimetype)
+	w.Header().Set("ETag", metadata.Etag())
+	if metadata.AccessKey != "" || config.Default().Auth.File != "" || config.Default().Auth.RemoteFile != "" {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, no-cache")
+	}
+
+	http.ServeContent(w, r, "", metadata.ModTime, f)
+}
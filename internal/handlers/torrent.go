@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/collection"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/headers"
+	"gabe565.com/linx-server/internal/torrent"
+	"gabe565.com/linx-server/internal/util"
+	"github.com/go-chi/chi/v5"
+)
+
+// HasTorrent reports whether a torrent can be served for an upload.
+// Collections have no torrent, since their content only lists other uploads.
+func HasTorrent(metadata backends.Metadata) bool {
+	return !config.Default().NoTorrent && !collection.Is(metadata)
+}
+
+// FileTorrentHandler serves a torrent which is web seeded from the upload's direct URL.
+// The piece hashes are cached, so an upload is only hashed once.
+func FileTorrentHandler(w http.ResponseWriter, r *http.Request) {
+	fileName := chi.URLParam(r, "name")
+
+	metadata, ok := checkServe(w, r, fileName)
+	if !ok {
+		return
+	}
+
+	if !HasTorrent(metadata) {
+		ErrorMsg(w, r, http.StatusNotFound, "Torrent not available")
+		return
+	}
+
+	h, err := config.Torrents.Hashes(r.Context(), config.StorageBackend, fileName, metadata)
+	if err != nil {
+		if errors.Is(err, backends.ErrNotFound) {
+			ErrorMsg(w, r, http.StatusNotFound, "File not found")
+		} else {
+			slog.Error("Failed to hash torrent", "path", fileName, "error", err) //nolint:gosec
+			ErrorMsg(w, r, http.StatusInternalServerError, "Could not create torrent")
+		}
+		return
+	}
+
+	name := torrentName(fileName, metadata)
+	t := torrent.New(name, h, headers.GetSelifURL(r, fileName).String(), config.Default().Trackers)
+	t.CreationDate = metadata.ModTime.Unix()
+	encoded, err := t.Encode()
+	if err != nil {
+		slog.Error("Failed to encode torrent", "path", fileName, "error", err) //nolint:gosec
+		ErrorMsg(w, r, http.StatusInternalServerError, "Could not create torrent")
+		return
+	}
+
+	w.Header().Set("Content-Disposition", util.EncodeContentDisposition("attachment", name+".torrent"))
+	http.ServeContent(w, r, "", metadata.ModTime, bytes.NewReader(encoded))
+}
+
+// torrentMagnet returns a magnet URI of an upload's torrent if its piece hashes are cached.
+// Otherwise, the upload is hashed in the background so that the display page doesn't wait for it.
+func torrentMagnet(r *http.Request, fileName string, metadata backends.Metadata) string {
+	if config.Torrents == nil {
+		return ""
+	}
+
+	h, err := config.Torrents.Cached(fileName, metadata)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Failed to read cached torrent hashes", "path", fileName, "error", err) //nolint:gosec
+			return ""
+		}
+
+		ctx := context.WithoutCancel(r.Context())
+		go func() {
+			if _, err := config.Torrents.Hashes(ctx, config.StorageBackend, fileName, metadata); err != nil {
+				slog.Warn("Failed to hash torrent", "path", fileName, "error", err) //nolint:gosec
+			}
+		}()
+		return ""
+	}
+
+	seedURL := headers.GetSelifURL(r, fileName).String()
+	magnet, err := torrent.Magnet(torrentName(fileName, metadata), h, seedURL, config.Default().Trackers)
+	if err != nil {
+		slog.Error("Failed to create magnet URI", "path", fileName, "error", err) //nolint:gosec
+		return ""
+	}
+	return magnet
+}
+
+// torrentName is the name of the file in an upload's torrent.
+func torrentName(fileName string, metadata backends.Metadata) string {
+	if metadata.OriginalName != "" {
+		return metadata.OriginalName
+	}
+	return fileName
+}
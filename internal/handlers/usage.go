@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"gabe565.com/linx-server/internal/auth/apikeys"
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/quota"
+)
+
+type UsageResponse struct {
+	ID     string         `json:"id"`
+	Usage  backends.Usage `json:"usage"`
+	Limits UsageLimits    `json:"limits"`
+}
+
+type UsageLimits struct {
+	MaxBytes  int64 `json:"max_bytes"`
+	MaxFiles  int64 `json:"max_files"`
+	MaxSize   int64 `json:"max_size"`
+	MaxExpiry int64 `json:"max_expiry"` // Seconds
+}
+
+// Usage reports the current usage and limits of the requesting API key. A limit of 0 is unlimited.
+func Usage(w http.ResponseWriter, r *http.Request) {
+	key, ok := apikeys.KeyFromContext(r.Context())
+	if !ok {
+		ErrorType(w, r, RespJSON, http.StatusUnauthorized, "")
+		return
+	}
+
+	usage, err := quota.Usage(r.Context(), config.StorageBackend, key.ID())
+	if err != nil {
+		slog.Error("Failed to calculate usage", "error", err)
+		ErrorType(w, r, RespJSON, http.StatusInternalServerError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(UsageResponse{
+		ID:    key.ID(),
+		Usage: usage,
+		Limits: UsageLimits{
+			MaxBytes:  key.Limits.MaxBytes,
+			MaxFiles:  key.Limits.MaxFiles,
+			MaxSize:   key.Limits.MaxSize,
+			MaxExpiry: int64(key.Limits.MaxExpiry.Seconds()),
+		},
+	})
+}
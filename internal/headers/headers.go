@@ -30,16 +30,16 @@ func AddHeaders(headers map[string]string) func(http.Handler) http.Handler {
 
 func GetSiteURL(r *http.Request) *url.URL {
 	switch {
-	case config.Default.SiteURL.Host != "", r == nil:
-		u := config.Default.SiteURL.URL
+	case config.Default().SiteURL.Host != "", r == nil:
+		u := config.Default().SiteURL.URL
 		return &u
 	default:
-		u := config.Default.SiteURL.URL
+		u := config.Default().SiteURL.URL
 		u.Host = r.Host
 
 		if scheme := r.Header.Get("X-Forwarded-Proto"); scheme != "" {
 			u.Scheme = scheme
-		} else if config.Default.TLS.Cert != "" || (r.TLS != nil && r.TLS.HandshakeComplete) {
+		} else if config.Default().TLS.Cert != "" || (r.TLS != nil && r.TLS.HandshakeComplete) {
 			u.Scheme = "https"
 		} else {
 			u.Scheme = "http"
@@ -57,7 +57,7 @@ func GetFileURL(r *http.Request, filename string) *url.URL {
 
 func GetSelifURL(r *http.Request, filename string) *url.URL {
 	u := GetSiteURL(r)
-	u.Path = path.Join(u.Path, config.Default.SelifPath)
+	u.Path = path.Join(u.Path, config.Default().SelifPath)
 	if filename != "" {
 		u.Path = path.Join(u.Path, filename)
 	}
@@ -72,3 +72,12 @@ func GetTorrentURL(r *http.Request, filename string) *url.URL {
 	}
 	return u
 }
+
+func GetThumbnailURL(r *http.Request, filename string) *url.URL {
+	u := GetSiteURL(r)
+	u.Path = path.Join(u.Path, "thumb")
+	if filename != "" {
+		u.Path = path.Join(u.Path, filename)
+	}
+	return u
+}
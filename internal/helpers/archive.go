@@ -3,10 +3,29 @@ package helpers
 import (
 	"archive/tar"
 	"archive/zip"
+	"cmp"
 	"compress/bzip2"
 	"compress/gzip"
+	"errors"
 	"io"
+	"io/fs"
 	"slices"
+	"strings"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/utils/bytefmt"
+	"github.com/bodgit/sevenzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nwaples/rardecode/v2"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	// MaxArchiveEntries limits how many entries are listed from an archive.
+	MaxArchiveEntries = 10000
+	// MaxArchiveScanSize limits how much data is decompressed to list a compressed tar archive.
+	MaxArchiveScanSize = bytefmt.GiB
 )
 
 type ReadSeekerAt interface {
@@ -15,71 +34,327 @@ type ReadSeekerAt interface {
 	io.ReaderAt
 }
 
-func ListArchiveFiles(mimetype string, size int64, r ReadSeekerAt) ([]string, error) {
-	var files []string
+// IsArchive reports whether ListArchiveFiles can list files of the mimetype.
+func IsArchive(mimetype string) bool {
+	switch mimetype {
+	case "application/x-7z-compressed", "application/x-rar-compressed", "application/x-rar":
+		return true
+	}
+	return CanExtractArchive(mimetype)
+}
+
+// CanExtractArchive reports whether OpenArchiveFile can extract files of the mimetype.
+// Files in 7z and rar archives are only listed, since a file in a solid archive
+// can only be extracted after everything before it was decompressed.
+func CanExtractArchive(mimetype string) bool {
+	switch mimetype {
+	case "application/zip":
+		return true
+	}
+	return isTar(mimetype)
+}
+
+func isTar(mimetype string) bool {
+	switch mimetype {
+	case "application/x-tar",
+		"application/gzip", "application/x-gzip",
+		"application/x-bzip", "application/x-bzip2",
+		"application/zstd",
+		"application/x-xz":
+		return true
+	}
+	return false
+}
+
+// ListArchiveFiles lists the files and directories in an archive, sorted by path.
+// Listing stops after MaxArchiveEntries entries, or once a compressed tar archive decompressed
+// more than MaxArchiveScanSize or MaxCompressionRatio times its size.
+// The entries which were listed until then are returned along with the error.
+func ListArchiveFiles(mimetype string, size int64, r ReadSeekerAt) ([]backends.ArchiveEntry, error) {
+	var files []backends.ArchiveEntry
 	defer func() {
-		slices.Sort(files)
+		slices.SortFunc(files, func(a, b backends.ArchiveEntry) int {
+			return cmp.Compare(a.Path, b.Path)
+		})
 	}()
+
 	var err error
 	switch mimetype {
-	case "application/x-tar":
-		tReadr := tar.NewReader(r)
-		for {
-			hdr, err := tReadr.Next()
-			if err != nil {
-				if err == io.EOF {
-					return files, nil
-				}
-				return files, err
-			}
-			if hdr.Typeflag == tar.TypeDir || hdr.Typeflag == tar.TypeReg {
-				files = append(files, hdr.Name)
-			}
+	case "application/zip":
+		files, err = listZip(r, size)
+	case "application/x-7z-compressed":
+		files, err = list7z(r, size)
+	case "application/x-rar-compressed", "application/x-rar":
+		files, err = listRar(r)
+	default:
+		if isTar(mimetype) {
+			limit := min(MaxArchiveScanSize, size*MaxCompressionRatio)
+			files, err = listTar(mimetype, r, limit)
 		}
-	case "application/gzip", "application/x-gzip":
-		gzf, err := gzip.NewReader(r)
+	}
+	return files, err
+}
+
+func listZip(r io.ReaderAt, size int64) ([]backends.ArchiveEntry, error) {
+	zf, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]backends.ArchiveEntry, 0, min(len(zf.File), MaxArchiveEntries))
+	for _, f := range zf.File {
+		if len(files) == MaxArchiveEntries {
+			return files, ErrTooManyArchiveEntries
+		}
+		files = append(files, backends.ArchiveEntry{
+			Path:    f.Name,
+			Size:    int64(f.UncompressedSize64), //nolint:gosec
+			Mode:    f.Mode(),
+			ModTime: f.Modified,
+			IsDir:   f.FileInfo().IsDir(),
+		})
+	}
+	return files, nil
+}
+
+func list7z(r io.ReaderAt, size int64) ([]backends.ArchiveEntry, error) {
+	zf, err := sevenzip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]backends.ArchiveEntry, 0, min(len(zf.File), MaxArchiveEntries))
+	for _, f := range zf.File {
+		if len(files) == MaxArchiveEntries {
+			return files, ErrTooManyArchiveEntries
+		}
+		info := f.FileInfo()
+		files = append(files, backends.ArchiveEntry{
+			Path:    f.Name,
+			Size:    int64(f.UncompressedSize), //nolint:gosec
+			Mode:    info.Mode(),
+			ModTime: f.Modified,
+			IsDir:   info.IsDir(),
+		})
+	}
+	return files, nil
+}
+
+// listRar reads the headers of a rar archive. The compressed data of each file is skipped.
+func listRar(r io.Reader) ([]backends.ArchiveEntry, error) {
+	rr, err := rardecode.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []backends.ArchiveEntry
+	for {
+		hdr, err := rr.Next()
 		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return files, nil
+			}
 			return files, err
 		}
+		if hdr.LinkType != 0 {
+			continue
+		}
+		if len(files) == MaxArchiveEntries {
+			return files, ErrTooManyArchiveEntries
+		}
+		files = append(files, backends.ArchiveEntry{
+			Path:    hdr.Name,
+			Size:    hdr.UnPackedSize,
+			Mode:    hdr.Mode(),
+			ModTime: hdr.ModificationTime,
+			IsDir:   hdr.IsDir,
+		})
+	}
+}
 
-		tReadr := tar.NewReader(gzf)
-		for {
-			hdr, err := tReadr.Next()
-			if err != nil {
-				if err == io.EOF {
-					return files, nil
-				}
-				return files, err
-			}
-			if hdr.Typeflag == tar.TypeDir || hdr.Typeflag == tar.TypeReg {
-				files = append(files, hdr.Name)
+func listTar(mimetype string, r io.Reader, limit int64) ([]backends.ArchiveEntry, error) {
+	rc, err := decompressTar(mimetype, r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	var files []backends.ArchiveEntry
+	tr := tar.NewReader(&limitReader{r: rc, n: limit})
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return files, nil
 			}
+			return files, err
+		}
+		if hdr.Typeflag != tar.TypeDir && hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if len(files) == MaxArchiveEntries {
+			return files, ErrTooManyArchiveEntries
 		}
+		files = append(files, backends.ArchiveEntry{
+			Path:    hdr.Name,
+			Size:    hdr.Size,
+			Mode:    hdr.FileInfo().Mode(),
+			ModTime: hdr.ModTime,
+			IsDir:   hdr.Typeflag == tar.TypeDir,
+		})
+	}
+}
+
+// decompressTar returns the tar stream of a tar archive, which may be compressed.
+func decompressTar(mimetype string, r io.Reader) (io.ReadCloser, error) {
+	switch mimetype {
+	case "application/gzip", "application/x-gzip":
+		return gzip.NewReader(r)
 	case "application/x-bzip", "application/x-bzip2":
-		bzf := bzip2.NewReader(r)
-		tReadr := tar.NewReader(bzf)
-		for {
-			hdr, err := tReadr.Next()
-			if err != nil {
-				if err == io.EOF {
-					return files, nil
-				}
-				return files, err
-			}
-			if hdr.Typeflag == tar.TypeDir || hdr.Typeflag == tar.TypeReg {
-				files = append(files, hdr.Name)
-			}
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case "application/zstd":
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
 		}
-	case "application/zip":
-		zf, err := zip.NewReader(r, size)
+		return zr.IOReadCloser(), nil
+	case "application/x-xz":
+		xr, err := xz.NewReader(r)
 		if err != nil {
-			return files, err
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	}
+	return io.NopCloser(r), nil
+}
+
+// MaxCompressionRatio limits how much data may be decompressed to extract a file from an archive,
+// relative to the size of the archive, so that a small archive can't expand without bound.
+const MaxCompressionRatio = 100
+
+var (
+	ErrArchiveFileNotFound   = errors.New("file not found in archive")
+	ErrArchiveTooLarge       = errors.New("archive expands beyond the decompression limit")
+	ErrTooManyArchiveEntries = errors.New("archive has too many entries")
+	ErrNoReaderAt            = errors.New("zip archives require random access")
+)
+
+// ArchiveFile is a regular file which was found in an archive.
+type ArchiveFile struct {
+	io.ReadCloser
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// OpenArchiveFile finds a regular file in an archive. Zip archives are read with random access,
+// so r must implement io.ReaderAt for them. Tar archives are read until the file is found.
+// Names must be valid slash-separated relative paths, so entries with names like "../a" are never matched,
+// and symlinks and other special entries are skipped.
+// At most limit bytes are decompressed, including any tar entries before the file.
+func OpenArchiveFile(mimetype string, size int64, r io.Reader, name string, limit int64) (ArchiveFile, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return ArchiveFile{}, ErrArchiveFileNotFound
+	}
+
+	switch {
+	case mimetype == "application/zip":
+		ra, ok := r.(io.ReaderAt)
+		if !ok {
+			return ArchiveFile{}, ErrNoReaderAt
+		}
+		return openZipFile(ra, size, name, limit)
+	case isTar(mimetype):
+		rc, err := decompressTar(mimetype, r)
+		if err != nil {
+			return ArchiveFile{}, err
 		}
-		files = slices.Grow(files, len(zf.File))
-		for _, f := range zf.File {
-			files = append(files, f.Name)
+		f, err := openTarFile(rc, name, limit)
+		if err != nil {
+			_ = rc.Close()
+			return f, err
 		}
+		f.ReadCloser = readCloser{Reader: f.ReadCloser, Closer: rc}
+		return f, nil
 	}
+	return ArchiveFile{}, ErrArchiveFileNotFound
+}
 
-	return files, err
+func openZipFile(r io.ReaderAt, size int64, name string, limit int64) (ArchiveFile, error) {
+	zf, err := zip.NewReader(r, size)
+	if err != nil {
+		return ArchiveFile{}, err
+	}
+
+	for _, f := range zf.File {
+		if f.FileInfo().IsDir() || strings.TrimPrefix(f.Name, "./") != name {
+			continue
+		}
+
+		if f.UncompressedSize64 > uint64(limit) || //nolint:gosec
+			f.UncompressedSize64 > f.CompressedSize64*MaxCompressionRatio {
+			return ArchiveFile{}, ErrArchiveTooLarge
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return ArchiveFile{}, err
+		}
+		// The zip reader fails if an entry expands beyond its declared size.
+		return ArchiveFile{
+			ReadCloser: rc,
+			Name:       name,
+			Size:       int64(f.UncompressedSize64), //nolint:gosec
+			ModTime:    f.Modified,
+		}, nil
+	}
+	return ArchiveFile{}, ErrArchiveFileNotFound
+}
+
+func openTarFile(r io.Reader, name string, limit int64) (ArchiveFile, error) {
+	tr := tar.NewReader(&limitReader{r: r, n: limit})
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return ArchiveFile{}, ErrArchiveFileNotFound
+			}
+			return ArchiveFile{}, err
+		}
+
+		if hdr.Typeflag == tar.TypeReg && strings.TrimPrefix(hdr.Name, "./") == name {
+			return ArchiveFile{
+				ReadCloser: io.NopCloser(tr),
+				Name:       name,
+				Size:       hdr.Size,
+				ModTime:    hdr.ModTime,
+			}, nil
+		}
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// limitReader fails with ErrArchiveTooLarge instead of ending early like io.LimitReader,
+// so a truncated file is never mistaken for a complete one.
+type limitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, ErrArchiveTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
 }
@@ -0,0 +1,165 @@
+package helpers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+func newTestZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = io.WriteString(f, content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func newTestTarGz(t *testing.T, files map[string]string) []byte {
+	return newTestTar(t, files, func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	})
+}
+
+func newTestTar(t *testing.T, files map[string]string, compress func(w io.Writer) io.WriteCloser) []byte {
+	var buf bytes.Buffer
+	gw := compress(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0o644,
+			Size:     int64(len(content)),
+			ModTime:  time.Unix(1700000000, 0),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := io.WriteString(tw, content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func TestOpenArchiveFile(t *testing.T) {
+	files := map[string]string{
+		"a.txt":       "File A",
+		"./dir/b.txt": "File B",
+		"../c.txt":    "File C",
+	}
+	archives := map[string][]byte{
+		"application/zip":  newTestZip(t, files),
+		"application/gzip": newTestTarGz(t, files),
+	}
+
+	for mimetype, b := range archives {
+		t.Run(mimetype, func(t *testing.T) {
+			open := func(name string) (ArchiveFile, error) {
+				return OpenArchiveFile(mimetype, int64(len(b)), bytes.NewReader(b), name, 1<<20)
+			}
+
+			f, err := open("dir/b.txt")
+			require.NoError(t, err)
+			got, err := io.ReadAll(f)
+			require.NoError(t, err)
+			assert.Equal(t, "File B", string(got))
+			assert.EqualValues(t, 6, f.Size)
+
+			for _, name := range []string{"../c.txt", "c.txt", "/a.txt", ".", "missing.txt"} {
+				_, err := open(name)
+				require.ErrorIs(t, err, ErrArchiveFileNotFound, name)
+			}
+		})
+	}
+}
+
+func TestOpenArchiveFileLimit(t *testing.T) {
+	files := map[string]string{"bomb.txt": strings.Repeat("a", 1<<20)}
+
+	b := newTestZip(t, files)
+	_, err := OpenArchiveFile("application/zip", int64(len(b)), bytes.NewReader(b), "bomb.txt", 1<<30)
+	require.ErrorIs(t, err, ErrArchiveTooLarge)
+
+	b = newTestTarGz(t, files)
+	f, err := OpenArchiveFile("application/gzip", int64(len(b)), bytes.NewReader(b), "bomb.txt", 1024)
+	require.NoError(t, err)
+	_, err = io.ReadAll(f)
+	require.ErrorIs(t, err, ErrArchiveTooLarge)
+
+	_, err = OpenArchiveFile("application/zip", int64(len(b)), io.MultiReader(bytes.NewReader(b)), "bomb.txt", 1024)
+	require.ErrorIs(t, err, ErrNoReaderAt)
+}
+
+func TestListArchiveFiles(t *testing.T) {
+	files := map[string]string{"b.txt": "File B", "a.txt": "File A!"}
+	archives := map[string][]byte{
+		"application/zip":  newTestZip(t, files),
+		"application/gzip": newTestTarGz(t, files),
+		"application/zstd": newTestTar(t, files, func(w io.Writer) io.WriteCloser {
+			zw, err := zstd.NewWriter(w)
+			require.NoError(t, err)
+			return zw
+		}),
+		"application/x-xz": newTestTar(t, files, func(w io.Writer) io.WriteCloser {
+			xw, err := xz.NewWriter(w)
+			require.NoError(t, err)
+			return xw
+		}),
+	}
+
+	for mimetype, b := range archives {
+		t.Run(mimetype, func(t *testing.T) {
+			got, err := ListArchiveFiles(mimetype, int64(len(b)), bytes.NewReader(b))
+			require.NoError(t, err)
+			require.Len(t, got, 2)
+			assert.Equal(t, "a.txt", got[0].Path)
+			assert.EqualValues(t, 7, got[0].Size)
+			assert.False(t, got[0].IsDir)
+			assert.Equal(t, "b.txt", got[1].Path)
+
+			if mimetype != "application/zip" {
+				assert.Equal(t, fs.FileMode(0o644), got[0].Mode)
+				assert.True(t, time.Unix(1700000000, 0).Equal(got[0].ModTime))
+
+				f, err := OpenArchiveFile(mimetype, int64(len(b)), bytes.NewReader(b), "b.txt", 1<<20)
+				require.NoError(t, err)
+				content, err := io.ReadAll(f)
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+				assert.Equal(t, "File B", string(content))
+			}
+		})
+	}
+}
+
+func TestListArchiveFilesLimits(t *testing.T) {
+	files := make(map[string]string, MaxArchiveEntries+1)
+	for i := range MaxArchiveEntries + 1 {
+		files[strconv.Itoa(i)] = ""
+	}
+	b := newTestZip(t, files)
+	got, err := ListArchiveFiles("application/zip", int64(len(b)), bytes.NewReader(b))
+	require.ErrorIs(t, err, ErrTooManyArchiveEntries)
+	assert.Len(t, got, MaxArchiveEntries)
+
+	// A highly compressed archive is only listed until the scan limit
+	b = newTestTarGz(t, map[string]string{"a.txt": "File A", "bomb.txt": strings.Repeat("a", 1<<20)})
+	_, err = ListArchiveFiles("application/gzip", int64(len(b)), bytes.NewReader(b))
+	require.ErrorIs(t, err, ErrArchiveTooLarge)
+}
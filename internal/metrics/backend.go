@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"net/http"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+//nolint:gochecknoglobals
+var (
+	storageDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "operation_duration_seconds",
+		Help:      "Time taken by storage backend operations by method.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"method"})
+
+	storageErrors = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "operation_errors_total",
+		Help:      "Number of failed storage backend operations by method. Missing files are not counted.",
+	}, []string{"method"})
+)
+
+// WrapBackend records the latency of each storage backend operation.
+func WrapBackend(b backends.StorageBackend) backends.StorageBackend { //nolint:ireturn
+	return backends.Wrap(Backend{StorageBackend: b}, b)
+}
+
+func observe(method string, start time.Time, err error) {
+	storageDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, backends.ErrNotFound) {
+		storageErrors.WithLabelValues(method).Inc()
+	}
+}
+
+type Backend struct {
+	backends.StorageBackend
+}
+
+func (b Backend) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := b.StorageBackend.Delete(ctx, key)
+	observe("delete", start, err)
+	return err
+}
+
+func (b Backend) Exists(ctx context.Context, key string) (bool, error) {
+	start := time.Now()
+	exists, err := b.StorageBackend.Exists(ctx, key)
+	observe("exists", start, err)
+	return exists, err
+}
+
+func (b Backend) Head(ctx context.Context, key string) (backends.Metadata, error) {
+	start := time.Now()
+	m, err := b.StorageBackend.Head(ctx, key)
+	observe("head", start, err)
+	return m, err
+}
+
+// Get records the time until the file is opened, not the time taken to read it.
+func (b Backend) Get(ctx context.Context, key string) (backends.Metadata, io.ReadCloser, error) {
+	start := time.Now()
+	m, r, err := b.StorageBackend.Get(ctx, key)
+	observe("get", start, err)
+	return m, r, err
+}
+
+func (b Backend) Put(
+	ctx context.Context,
+	r io.Reader,
+	key string,
+	size int64,
+	opts backends.PutOptions,
+) (backends.Metadata, error) {
+	start := time.Now()
+	m, err := b.StorageBackend.Put(ctx, r, key, size, opts)
+	observe("put", start, err)
+	return m, err
+}
+
+func (b Backend) PutMetadata(ctx context.Context, key string, m backends.Metadata) error {
+	start := time.Now()
+	err := b.StorageBackend.PutMetadata(ctx, key, m)
+	observe("put_metadata", start, err)
+	return err
+}
+
+func (b Backend) ServeFile(key string, w http.ResponseWriter, r *http.Request) error {
+	start := time.Now()
+	err := b.StorageBackend.ServeFile(key, w, r)
+	observe("serve_file", start, err)
+	return err
+}
+
+func (b Backend) Size(ctx context.Context, key string) (int64, error) {
+	start := time.Now()
+	size, err := b.StorageBackend.Size(ctx, key)
+	observe("size", start, err)
+	return size, err
+}
+
+// List records the time taken to iterate over every upload.
+// It is only exposed by WrapBackend if the wrapped backend implements it, as are ListExpired and Usage.
+func (b Backend) List(ctx context.Context) iter.Seq2[string, error] {
+	lister := b.StorageBackend.(backends.ListBackend) //nolint:errcheck
+	return observeSeq("list", lister.List(ctx))
+}
+
+func (b Backend) ListExpired(ctx context.Context, before time.Time) iter.Seq2[string, error] {
+	expiry := b.StorageBackend.(backends.ExpiryBackend) //nolint:errcheck
+	return observeSeq("list_expired", expiry.ListExpired(ctx, before))
+}
+
+func (b Backend) Usage(ctx context.Context, uploader string) (backends.Usage, error) {
+	usage := b.StorageBackend.(backends.UsageBackend) //nolint:errcheck
+	start := time.Now()
+	u, err := usage.Usage(ctx, uploader)
+	observe("usage", start, err)
+	return u, err
+}
+
+func observeSeq(method string, seq iter.Seq2[string, error]) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		start := time.Now()
+		var err error
+		defer func() {
+			observe(method, start, err)
+		}()
+
+		for key, e := range seq {
+			if e != nil {
+				err = e
+			}
+			if !yield(key, e) {
+				return
+			}
+		}
+	}
+}
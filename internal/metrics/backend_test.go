@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/index"
+	"gabe565.com/linx-server/internal/backends/localfs/localfstest"
+	"gabe565.com/linx-server/internal/backends/sqlite"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapBackend(t *testing.T) {
+	local := localfstest.New(t)
+
+	wrapped := WrapBackend(local)
+	assert.Implements(t, (*backends.ListBackend)(nil), wrapped)
+	assert.NotImplements(t, (*backends.ExpiryBackend)(nil), wrapped)
+	assert.Equal(t, backends.StorageBackend(local), backends.Unwrap(wrapped))
+
+	store, err := sqlite.New(t.Context(), filepath.Join(t.TempDir(), "index.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+
+	wrapped = WrapBackend(index.New(local, store))
+	assert.Implements(t, (*backends.ExpiryBackend)(nil), wrapped)
+	assert.Implements(t, (*backends.UsageBackend)(nil), wrapped)
+
+	before := testutil.CollectAndCount(storageDuration)
+	_, err = wrapped.Put(t.Context(), strings.NewReader("File content"), "a.txt", 0, backends.PutOptions{})
+	require.NoError(t, err)
+	_, err = wrapped.Head(t.Context(), "missing.txt")
+	require.ErrorIs(t, err, backends.ErrNotFound)
+
+	assert.Equal(t, before+2, testutil.CollectAndCount(storageDuration))
+	assert.InDelta(t, 0, testutil.ToFloat64(storageErrors.WithLabelValues("head")), 0)
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "linx"
+
+// Registry holds every metric served by Handler.
+//
+//nolint:gochecknoglobals
+var Registry = newRegistry()
+
+func newRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return r
+}
+
+//nolint:gochecknoglobals
+var (
+	requests = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to respond to HTTP requests by route and method.",
+		Buckets:   []float64{.005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"route", "method"})
+
+	requestBytes = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_bytes_total",
+		Help:      "Bytes received in request bodies by route, including uploads.",
+	}, []string{"route"})
+
+	responseBytes = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "response_bytes_total",
+		Help:      "Bytes sent in response bodies by route, including served files.",
+	}, []string{"route"})
+
+	uploadFailures = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_failures_total",
+		Help:      "Number of failed uploads by reason.",
+	}, []string{"reason"})
+
+	rateLimited = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Number of requests rejected by a rate limit.",
+	}, []string{"limiter"})
+
+	accessKeyFailures = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "access_key_failures_total",
+		Help:      "Number of rejected access key attempts by reason.",
+	}, []string{"reason"})
+
+	cleanupDuration = promauto.With(Registry).NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "cleanup",
+		Name:      "duration_seconds",
+		Help:      "Time taken by periodic cleanup runs.",
+		Buckets:   prometheus.ExponentialBuckets(.01, 4, 10),
+	})
+
+	cleanupDeleted = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cleanup",
+		Name:      "deleted_total",
+		Help:      "Number of expired uploads and partial uploads deleted by cleanup.",
+	}, []string{"kind"})
+)
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Middleware records the count, latency and size of requests to a route.
+func Middleware(route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			var body *countingReader
+			if r.Body != nil && r.Body != http.NoBody {
+				body = &countingReader{ReadCloser: r.Body}
+				r.Body = body
+			}
+
+			defer func() {
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				requests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+				requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+				responseBytes.WithLabelValues(route).Add(float64(ww.BytesWritten()))
+				if body != nil {
+					requestBytes.WithLabelValues(route).Add(float64(body.n))
+				}
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// UploadFailed records a failed upload.
+func UploadFailed(reason string) {
+	uploadFailures.WithLabelValues(reason).Inc()
+}
+
+// RateLimited records a request which was rejected by a rate limit.
+func RateLimited(limiter string) {
+	rateLimited.WithLabelValues(limiter).Inc()
+}
+
+// AccessKeyFailed records an access key attempt which was invalid or rejected by a lockout.
+func AccessKeyFailed(reason string) {
+	accessKeyFailures.WithLabelValues(reason).Inc()
+}
+
+// ObserveCleanup records the duration of a periodic cleanup run.
+func ObserveCleanup(d time.Duration) {
+	cleanupDuration.Observe(d.Seconds())
+}
+
+// CleanupDeleted records an upload or partial upload which was deleted by cleanup.
+func CleanupDeleted(kind string) {
+	cleanupDeleted.WithLabelValues(kind).Inc()
+}
@@ -0,0 +1,290 @@
+package partial
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"iter"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const infoExt = ".json"
+
+type Info struct {
+	ID       string            `json:"id"`
+	Size     int64             `json:"size"`
+	Expiry   time.Time         `json:"expiry"`
+	Metadata map[string]string `json:"metadata,omitzero"`
+	Header   http.Header       `json:"header,omitzero"`
+}
+
+func (i Info) Expired() bool {
+	return !i.Expiry.IsZero() && i.Expiry.Before(time.Now())
+}
+
+var (
+	ErrNotFound       = errors.New("partial upload not found")
+	ErrOffsetMismatch = errors.New("offset mismatch")
+)
+
+// locks prevents concurrent requests from writing to the same partial upload.
+// A lock is kept until its partial upload is deleted,
+// since a request which already loaded a removed lock could hold it alongside a new one.
+//
+//nolint:gochecknoglobals
+var locks sync.Map
+
+// TryLock locks a partial upload for writing. It returns false if another request holds the lock.
+func TryLock(id string) (*sync.Mutex, bool) {
+	mu, _ := locks.LoadOrStore(id, &sync.Mutex{})
+	lock := mu.(*sync.Mutex) //nolint:errcheck
+	if !lock.TryLock() {
+		return nil, false
+	}
+	return lock, true
+}
+
+// Forget discards the lock of a partial upload which no longer exists.
+func Forget(id string) {
+	locks.Delete(id)
+}
+
+// Store stages chunks of resumable uploads until they are complete.
+type Store struct {
+	path string
+}
+
+func New(path string) Store {
+	return Store{path: path}
+}
+
+func (s Store) openRoot() (*os.Root, error) {
+	root, err := os.OpenRoot(s.path)
+	if err != nil && os.IsNotExist(err) {
+		if err := os.MkdirAll(s.path, 0o700); err != nil {
+			return nil, err
+		}
+		return os.OpenRoot(s.path)
+	}
+	return root, err
+}
+
+func (s Store) Create(info Info) error {
+	root, err := s.openRoot()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	var success bool
+	f, err := root.OpenFile(info.ID, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		if !success {
+			_ = root.Remove(info.ID)
+		}
+	}()
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := writeInfo(root, info); err != nil {
+		return err
+	}
+
+	success = true
+	return nil
+}
+
+func writeInfo(root *os.Root, info Info) error {
+	var success bool
+	path := info.ID + infoExt
+	f, err := root.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		if !success {
+			_ = root.Remove(path)
+		}
+	}()
+
+	//nolint:gosec // Partial info includes user-provided keys until the upload is processed.
+	if err := json.NewEncoder(f).Encode(info); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	success = true
+	return nil
+}
+
+// Head returns the info and current offset of a partial upload.
+func (s Store) Head(id string) (Info, int64, error) {
+	var info Info
+
+	root, err := os.OpenRoot(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return info, 0, ErrNotFound
+		}
+		return info, 0, err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	f, err := root.Open(id + infoExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return info, 0, ErrNotFound
+		}
+		return info, 0, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if err := json.NewDecoder(f).Decode(&info); err != nil {
+		return info, 0, err
+	}
+
+	stat, err := root.Stat(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return info, 0, ErrNotFound
+		}
+		return info, 0, err
+	}
+
+	return info, stat.Size(), nil
+}
+
+// Append writes r to the end of a partial upload, as long as offset matches its current size.
+// It never writes past the declared upload size and returns the new offset.
+func (s Store) Append(id string, offset int64, r io.Reader) (int64, error) {
+	info, current, err := s.Head(id)
+	if err != nil {
+		return current, err
+	}
+	if offset != current {
+		return current, ErrOffsetMismatch
+	}
+
+	root, err := os.OpenRoot(s.path)
+	if err != nil {
+		return current, err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	f, err := root.OpenFile(id, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return current, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// Data received before an interrupted request is kept, so the client can resume from there.
+	n, err := io.Copy(f, io.LimitReader(r, info.Size-current))
+	current += n
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return current, err
+}
+
+// ModTime returns when the chunks of a partial upload were last written.
+func (s Store) ModTime(id string) (time.Time, error) {
+	root, err := os.OpenRoot(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return time.Time{}, ErrNotFound
+		}
+		return time.Time{}, err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	stat, err := root.Stat(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return time.Time{}, ErrNotFound
+		}
+		return time.Time{}, err
+	}
+	return stat.ModTime(), nil
+}
+
+func (s Store) Open(id string) (*os.File, error) {
+	f, err := os.OpenInRoot(s.path, id)
+	if err != nil && os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Delete removes a partial upload and forgets its lock.
+func (s Store) Delete(id string) error {
+	defer Forget(id)
+
+	root, err := os.OpenRoot(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	fileErr := root.Remove(id)
+	if fileErr != nil && os.IsNotExist(fileErr) {
+		fileErr = ErrNotFound
+	}
+	infoErr := root.Remove(id + infoExt)
+	if infoErr != nil && os.IsNotExist(infoErr) {
+		infoErr = nil
+	}
+	return errors.Join(fileErr, infoErr)
+}
+
+// List yields the IDs of all partial uploads.
+func (s Store) List() iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		files, err := os.ReadDir(s.path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				yield("", err)
+			}
+			return
+		}
+
+		for _, file := range files {
+			if file.IsDir() || strings.HasSuffix(file.Name(), infoExt) {
+				continue
+			}
+			if !yield(file.Name(), nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package partial
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	s := New(t.TempDir())
+	require.NoError(t, s.Create(Info{ID: "a", Size: 1}))
+
+	lock, ok := TryLock("a")
+	require.True(t, ok)
+	_, ok = TryLock("a")
+	assert.False(t, ok)
+	lock.Unlock()
+
+	// deleting the upload, such as during cleanup, forgets its lock
+	require.NoError(t, s.Delete("a"))
+	_, ok = locks.Load("a")
+	assert.False(t, ok)
+}
@@ -0,0 +1,132 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"gabe565.com/linx-server/internal/backends"
+)
+
+var (
+	ErrExceeded      = errors.New("quota exceeded")
+	ErrUnsupported   = errors.New("backend does not support listing files")
+	ErrIndexRequired = errors.New("max-bytes and max-files require the metadata index")
+)
+
+// Usage totals the uploads created by an uploader.
+// Backends without a metadata index are scanned, which reads the metadata of every upload,
+// so uploads are only checked against max-bytes and max-files when the index is enabled.
+func Usage(ctx context.Context, backend backends.StorageBackend, uploader string) (backends.Usage, error) {
+	if backend, ok := backend.(backends.UsageBackend); ok {
+		return backend.Usage(ctx, uploader)
+	}
+
+	var u backends.Usage
+	lister, ok := backend.(backends.ListBackend)
+	if !ok {
+		return u, ErrUnsupported
+	}
+
+	for key, err := range lister.List(ctx) {
+		if err != nil {
+			return u, err
+		}
+
+		m, err := lister.Head(ctx, key)
+		if err != nil {
+			if errors.Is(err, backends.ErrNotFound) {
+				continue
+			}
+			return u, err
+		}
+
+		if m.Uploader == uploader {
+			u.Files++
+			u.Bytes += m.Size
+		}
+	}
+	return u, nil
+}
+
+// Pending counts uploads which passed the quota check but are still being written.
+// Stored usage doesn't include them yet, so they are added before another upload by the same uploader is checked.
+type Pending struct {
+	mu    sync.Mutex
+	usage map[string]backends.Usage
+}
+
+// Reserve holds the quota of an uploader while check decides whether an upload fits.
+// check receives the uploader's usage including pending uploads, and returns the usage to reserve for the upload.
+// The reservation is kept until release is called, which must happen once the upload is stored or has failed.
+func (p *Pending) Reserve(
+	ctx context.Context,
+	backend backends.StorageBackend,
+	uploader string,
+	check func(usage backends.Usage) (backends.Usage, error),
+) (func(), error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	usage, err := Usage(ctx, backend, uploader)
+	if err != nil {
+		return nil, err
+	}
+	pending := p.usage[uploader]
+	usage.Files += pending.Files
+	usage.Bytes += pending.Bytes
+
+	reserve, err := check(usage)
+	if err != nil {
+		return nil, err
+	}
+	p.add(uploader, reserve.Files, reserve.Bytes)
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.add(uploader, -reserve.Files, -reserve.Bytes)
+	}, nil
+}
+
+// add changes the pending usage of an uploader. The caller must hold the lock.
+func (p *Pending) add(uploader string, files, bytes int64) {
+	if p.usage == nil {
+		p.usage = make(map[string]backends.Usage)
+	}
+	u := p.usage[uploader]
+	u.Files += files
+	u.Bytes += bytes
+	if u == (backends.Usage{}) {
+		delete(p.usage, uploader)
+		return
+	}
+	p.usage[uploader] = u
+}
+
+// LimitReader returns err once more than n bytes have been read from r.
+func LimitReader(r io.Reader, n int64, err error) io.Reader {
+	return &limitedReader{r: r, n: n, err: err}
+}
+
+type limitedReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, l.err
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, l.err
+	}
+	return n, err
+}
@@ -0,0 +1,46 @@
+package quota
+
+import (
+	"strings"
+	"testing"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/localfs/localfstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingReserve(t *testing.T) {
+	backend := localfstest.New(t)
+	_, err := backend.Put(t.Context(), strings.NewReader("stored"), "a.txt", 6, backends.PutOptions{Uploader: "key"})
+	require.NoError(t, err)
+
+	var p Pending
+	var got backends.Usage
+	check := func(usage backends.Usage) (backends.Usage, error) {
+		got = usage
+		return backends.Usage{Files: 1, Bytes: 10}, nil
+	}
+
+	release, err := p.Reserve(t.Context(), backend, "key", check)
+	require.NoError(t, err)
+	assert.Equal(t, backends.Usage{Files: 1, Bytes: 6}, got)
+
+	// pending uploads count until they are released
+	releaseB, err := p.Reserve(t.Context(), backend, "key", check)
+	require.NoError(t, err)
+	assert.Equal(t, backends.Usage{Files: 2, Bytes: 16}, got)
+
+	_, err = p.Reserve(t.Context(), backend, "other", check)
+	require.NoError(t, err)
+	assert.Equal(t, backends.Usage{}, got)
+
+	release()
+	releaseB()
+	_, err = p.Reserve(t.Context(), backend, "key", func(usage backends.Usage) (backends.Usage, error) {
+		got = usage
+		return backends.Usage{}, ErrExceeded
+	})
+	require.ErrorIs(t, err, ErrExceeded)
+	assert.Equal(t, backends.Usage{Files: 1, Bytes: 6}, got)
+}
@@ -0,0 +1,143 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"gabe565.com/utils/bytefmt"
+)
+
+const clamdChunkSize = 64 * bytefmt.KiB
+
+var (
+	ErrClamd          = errors.New("clamd error")
+	ErrInvalidAddress = errors.New("invalid clamd address")
+)
+
+// Clamd streams uploads to a ClamAV daemon with the INSTREAM command.
+type Clamd struct {
+	Network string
+	Address string
+}
+
+// NewClamd parses a clamd address such as tcp://127.0.0.1:3310 or unix:///run/clamav/clamd.ctl.
+// Addresses without a scheme are treated as a unix socket if they are a path, otherwise as a TCP address.
+func NewClamd(addr string) (Clamd, error) {
+	switch {
+	case strings.HasPrefix(addr, "/"):
+		return Clamd{Network: "unix", Address: addr}, nil
+	case !strings.Contains(addr, "://"):
+		return Clamd{Network: "tcp", Address: addr}, nil
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return Clamd{}, fmt.Errorf("%w: %w", ErrInvalidAddress, err)
+	}
+	switch u.Scheme {
+	case "tcp":
+		if u.Host == "" {
+			return Clamd{}, fmt.Errorf("%w: %s", ErrInvalidAddress, addr)
+		}
+		return Clamd{Network: "tcp", Address: u.Host}, nil
+	case "unix":
+		if u.Path == "" {
+			return Clamd{}, fmt.Errorf("%w: %s", ErrInvalidAddress, addr)
+		}
+		return Clamd{Network: "unix", Address: u.Path}, nil
+	default:
+		return Clamd{}, fmt.Errorf("%w: unsupported scheme %q", ErrInvalidAddress, u.Scheme)
+	}
+}
+
+func (c Clamd) Scan(ctx context.Context, r io.Reader) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if err := c.stream(conn, r); err != nil {
+		// clamd replies and closes the connection if the upload exceeds its StreamMaxLength.
+		if reply, readErr := readReply(conn); readErr == nil {
+			return parseReply(reply)
+		}
+		return err
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return err
+	}
+	return parseReply(reply)
+}
+
+func (c Clamd) stream(conn net.Conn, r io.Reader) error {
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return err
+	}
+
+	buf := make([]byte, clamdChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			_ = binary.Write(w, binary.BigEndian, uint32(n)) //nolint:gosec
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return err
+		}
+	}
+
+	// A zero-length chunk ends the stream.
+	if err := binary.Write(w, binary.BigEndian, uint32(0)); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && (reply == "" || !errors.Is(err, io.EOF)) {
+		return "", err
+	}
+	return strings.TrimRight(reply, "\x00\n"), nil
+}
+
+// parseReply interprets replies such as "stream: OK" and "stream: Eicar-Signature FOUND".
+func parseReply(reply string) error {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return Infected(strings.TrimSuffix(result, " FOUND"))
+	default:
+		return fmt.Errorf("%w: %s", ErrClamd, reply)
+	}
+}
@@ -0,0 +1,110 @@
+package clamdtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	// Marker is the EICAR test string. Uploads which contain it are reported as infected.
+	Marker    = "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"
+	Signature = "Eicar-Test-Signature"
+)
+
+// Server is a fake clamd which understands the INSTREAM command.
+type Server struct {
+	Listener net.Listener
+
+	mu      sync.Mutex
+	scanned int
+	wg      sync.WaitGroup
+}
+
+// NewServer starts a fake clamd on a random TCP port.
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return NewServerWithListener(l), nil
+}
+
+// NewServerWithListener starts a fake clamd on an existing listener, such as a unix socket.
+func NewServerWithListener(l net.Listener) *Server {
+	s := &Server{Listener: l}
+	s.wg.Go(s.serve)
+	return s
+}
+
+// Addr returns the address of the server, in the format accepted by scan.NewClamd.
+func (s *Server) Addr() string {
+	addr := s.Listener.Addr()
+	return addr.Network() + "://" + addr.String()
+}
+
+// Scanned returns the number of uploads which have been scanned.
+func (s *Server) Scanned() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scanned
+}
+
+func (s *Server) Close() {
+	_ = s.Listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Go(func() {
+			defer func() {
+				_ = conn.Close()
+			}()
+			_, _ = io.WriteString(conn, s.handle(conn)+"\x00")
+		})
+	}
+}
+
+func (s *Server) handle(conn net.Conn) string {
+	r := bufio.NewReader(conn)
+	cmd, err := r.ReadString(0)
+	if err != nil || cmd != "zINSTREAM\x00" {
+		return "UNKNOWN COMMAND"
+	}
+
+	var data bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return "INSTREAM: read error. ERROR"
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+			if errors.Is(err, io.EOF) {
+				return "INSTREAM: unexpected EOF. ERROR"
+			}
+			return "INSTREAM: read error. ERROR"
+		}
+	}
+
+	s.mu.Lock()
+	s.scanned++
+	s.mu.Unlock()
+
+	if strings.Contains(data.String(), Marker) {
+		return "stream: " + Signature + " FOUND"
+	}
+	return "stream: OK"
+}
@@ -0,0 +1,57 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// ExitInfected is the exit status which marks an upload as infected, matching clamscan.
+const ExitInfected = 1
+
+var ErrNoCommand = errors.New("scan command is empty")
+
+// Exec runs a command with the upload on stdin.
+// Exit status 0 means the upload is clean and ExitInfected means it should be rejected.
+// The first line of output is used as the signature. Any other result means the upload could not be scanned.
+type Exec struct {
+	Command []string
+}
+
+func NewExec(command []string) (Exec, error) {
+	if len(command) == 0 || command[0] == "" {
+		return Exec{}, ErrNoCommand
+	}
+	return Exec{Command: command}, nil
+}
+
+func (e Exec) Scan(ctx context.Context, r io.Reader) error {
+	cmd := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...) //nolint:gosec // Command is set by the admin.
+	cmd.Stdin = r
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	if exitErr, ok := errors.AsType[*exec.ExitError](err); ok && exitErr.ExitCode() == ExitInfected {
+		return Infected(firstLine(&stdout))
+	}
+	if msg := firstLine(&stderr); msg != "" {
+		return fmt.Errorf("%s: %w: %s", e.Command[0], err, msg)
+	}
+	return fmt.Errorf("%s: %w", e.Command[0], err)
+}
+
+func firstLine(r io.Reader) string {
+	line, _ := bufio.NewReader(r).ReadString('\n')
+	return strings.TrimSpace(line)
+}
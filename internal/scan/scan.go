@@ -0,0 +1,69 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var (
+	ErrInfected    = errors.New("upload rejected by malware scan")
+	ErrUnavailable = errors.New("malware scanner unavailable")
+	ErrNotSeekable = errors.New("upload must be seekable to run multiple scanners")
+)
+
+// Scanner checks an upload before it is stored.
+// An upload which should be rejected returns an error which wraps ErrInfected.
+// Any other error means the upload could not be scanned.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) error
+}
+
+// Infected returns an error for an upload which matched a signature.
+func Infected(signature string) error {
+	if signature == "" {
+		return ErrInfected
+	}
+	return &InfectedError{Signature: signature}
+}
+
+type InfectedError struct {
+	Signature string
+}
+
+func (e *InfectedError) Error() string {
+	return ErrInfected.Error() + ": " + e.Signature
+}
+
+func (e *InfectedError) Unwrap() error {
+	return ErrInfected
+}
+
+// Multi runs each scanner in order. The upload is rewound to its starting offset before each scanner after the first.
+type Multi []Scanner
+
+func (m Multi) Scan(ctx context.Context, r io.Reader) error {
+	var start int64
+	seeker, seekable := r.(io.Seeker)
+	if seekable && len(m) > 1 {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+
+	for i, s := range m {
+		if i != 0 {
+			if !seekable {
+				return ErrNotSeekable
+			}
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		if err := s.Scan(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package scan
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gabe565.com/linx-server/internal/scan/clamdtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClamd(t *testing.T) {
+	tests := []struct {
+		addr    string
+		want    Clamd
+		wantErr bool
+	}{
+		{"tcp://127.0.0.1:3310", Clamd{Network: "tcp", Address: "127.0.0.1:3310"}, false},
+		{"127.0.0.1:3310", Clamd{Network: "tcp", Address: "127.0.0.1:3310"}, false},
+		{"unix:///run/clamav/clamd.ctl", Clamd{Network: "unix", Address: "/run/clamav/clamd.ctl"}, false},
+		{"/run/clamav/clamd.ctl", Clamd{Network: "unix", Address: "/run/clamav/clamd.ctl"}, false},
+		{"http://127.0.0.1:3310", Clamd{}, true},
+		{"unix://", Clamd{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got, err := NewClamd(tt.addr)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrInvalidAddress)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestClamd(t *testing.T) {
+	tcp, err := clamdtest.NewServer()
+	require.NoError(t, err)
+	t.Cleanup(tcp.Close)
+
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "clamd.sock"))
+	require.NoError(t, err)
+	unix := clamdtest.NewServerWithListener(l)
+	t.Cleanup(unix.Close)
+
+	for _, server := range []*clamdtest.Server{tcp, unix} {
+		t.Run(server.Listener.Addr().Network(), func(t *testing.T) {
+			clamd, err := NewClamd(server.Addr())
+			require.NoError(t, err)
+
+			require.NoError(t, clamd.Scan(t.Context(), strings.NewReader("clean")))
+
+			// larger than a single chunk
+			infected := strings.Repeat("a", clamdChunkSize) + clamdtest.Marker
+			err = clamd.Scan(t.Context(), strings.NewReader(infected))
+			require.ErrorIs(t, err, ErrInfected)
+			var infectedErr *InfectedError
+			require.ErrorAs(t, err, &infectedErr)
+			assert.Equal(t, clamdtest.Signature, infectedErr.Signature)
+
+			assert.Equal(t, 2, server.Scanned())
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		clamd, err := NewClamd(filepath.Join(t.TempDir(), "missing.sock"))
+		require.NoError(t, err)
+		err = clamd.Scan(t.Context(), strings.NewReader("clean"))
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrInfected)
+	})
+}
+
+func TestParseReply(t *testing.T) {
+	require.NoError(t, parseReply("stream: OK"))
+	require.ErrorIs(t, parseReply("stream: Win.Test.EICAR_HDB-1 FOUND"), ErrInfected)
+	require.ErrorIs(t, parseReply("INSTREAM size limit exceeded. ERROR"), ErrClamd)
+}
+
+func TestExec(t *testing.T) {
+	_, err := NewExec(nil)
+	require.ErrorIs(t, err, ErrNoCommand)
+
+	scanner, err := NewExec([]string{"sh", "-c", `grep -q infected && { echo "Test-Signature"; exit 1; }; exit 0`})
+	require.NoError(t, err)
+
+	require.NoError(t, scanner.Scan(t.Context(), strings.NewReader("clean")))
+
+	err = scanner.Scan(t.Context(), strings.NewReader("infected"))
+	var infectedErr *InfectedError
+	require.ErrorAs(t, err, &infectedErr)
+	assert.Equal(t, "Test-Signature", infectedErr.Signature)
+
+	scanner, err = NewExec([]string{"sh", "-c", "echo broken >&2; exit 2"})
+	require.NoError(t, err)
+	err = scanner.Scan(t.Context(), strings.NewReader("clean"))
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInfected)
+	assert.Contains(t, err.Error(), "broken")
+}
+
+func TestMulti(t *testing.T) {
+	clean, err := NewExec([]string{"sh", "-c", "cat >/dev/null"})
+	require.NoError(t, err)
+	infected, err := NewExec([]string{"sh", "-c", `grep -q infected && exit 1; exit 0`})
+	require.NoError(t, err)
+
+	m := Multi{clean, infected}
+	require.ErrorIs(t, m.Scan(t.Context(), strings.NewReader("infected")), ErrInfected)
+	require.ErrorIs(t, m.Scan(t.Context(), io.MultiReader(strings.NewReader("x"))), ErrNotSeekable)
+
+	// every scanner starts from the offset the upload was at
+	r := strings.NewReader("infected clean")
+	_, err = r.Seek(int64(len("infected ")), io.SeekStart)
+	require.NoError(t, err)
+	require.NoError(t, Multi{clean, infected}.Scan(t.Context(), r))
+}
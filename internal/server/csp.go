@@ -10,7 +10,9 @@ import (
 )
 
 const (
-	DefaultCSP    = "default-src 'self' " + defaultSrcKey + "; img-src 'self' data:; style-src 'self' 'unsafe-inline'; frame-ancestors 'none';"
+	// Decrypted files are previewed from blob URLs created by the display page.
+	DefaultCSP = "default-src 'self' " + defaultSrcKey + "; img-src 'self' data: blob:; media-src 'self' blob:; " +
+		"object-src 'self' blob:; style-src 'self' 'unsafe-inline'; frame-ancestors 'none';"
 	defaultSrcKey = "$DEFAULT_SRC"
 
 	cspHeader          = "Content-Security-Policy"
@@ -52,15 +54,15 @@ func NewCSPMiddleware(o Options) func(http.Handler) http.Handler {
 	return fn
 }
 
-func GenerateCSP() string {
-	conf, err := template.ConfigBytes()
+func GenerateCSP(c *config.Config) string {
+	b, err := template.ConfigBytes(c)
 	if err != nil {
 		panic(err)
 	}
 
-	defaultSrc := util.SubresourceIntegrity(conf)
+	defaultSrc := util.SubresourceIntegrity(b)
 
-	if u := config.Default.ViteURL; u != "" {
+	if u := c.ViteURL; u != "" {
 		defaultSrc += " " + u + " ws:"
 	}
 
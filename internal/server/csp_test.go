@@ -21,17 +21,17 @@ func TestContentSecurityPolicy(t *testing.T) {
 		wantXFrameOptions  = "SAMEORIGIN"
 	)
 
-	// config.Default.SiteURL = "http://linx.example.org/"
-	config.Default.SiteURL.URL = url.URL{Scheme: "http", Host: "linx.example.org"}
-	config.Default.FilesPath = t.TempDir()
-	config.Default.MetaPath = config.Default.FilesPath + "_meta"
-	config.Default.MaxSize = bytefmt.GiB
-	config.Default.NoLogs = true
-	config.Default.SiteName = "linx"
-	config.Default.SelifPath = "/selif"
-	config.Default.Header.ReferrerPolicy = wantReferrerPolicy
-	config.Default.Header.XFrameOptions = wantXFrameOptions
-	r, err := Setup()
+	// config.Default().SiteURL = "http://linx.example.org/"
+	config.Default().SiteURL.URL = url.URL{Scheme: "http", Host: "linx.example.org"}
+	config.Default().FilesPath = t.TempDir()
+	config.Default().MetaPath = config.Default().FilesPath + "_meta"
+	config.Default().MaxSize = bytefmt.GiB
+	config.Default().NoLogs = true
+	config.Default().SiteName = "linx"
+	config.Default().SelifPath = "/selif"
+	config.Default().Header.ReferrerPolicy = wantReferrerPolicy
+	config.Default().Header.XFrameOptions = wantXFrameOptions
+	r, err := Setup(t.Context(), config.Default())
 	require.NoError(t, err)
 
 	w := httptest.NewRecorder()
@@ -41,7 +41,7 @@ func TestContentSecurityPolicy(t *testing.T) {
 
 	r.ServeHTTP(w, req)
 
-	conf, err := template.ConfigBytes()
+	conf, err := template.ConfigBytes(config.Default())
 	require.NoError(t, err)
 
 	testCSPHeaders := map[string]string{
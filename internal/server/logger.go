@@ -0,0 +1,58 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// LogFormatter extends chi's default request log with the name of the API key that authenticated the request.
+type LogFormatter struct {
+	logger *log.Logger
+}
+
+func NewLogFormatter() *LogFormatter {
+	return &LogFormatter{logger: log.New(os.Stdout, "", log.LstdFlags)}
+}
+
+func (f *LogFormatter) NewLogEntry(r *http.Request) middleware.LogEntry { //nolint:ireturn
+	entry := &logEntry{logger: f.logger}
+	formatter := &middleware.DefaultLogFormatter{Logger: entry, NoColor: !isTTY()}
+	entry.LogEntry = formatter.NewLogEntry(r)
+	return entry
+}
+
+type logEntry struct {
+	middleware.LogEntry
+	logger  *log.Logger
+	keyName string
+}
+
+// SetKeyName is called by the apikeys package once a request has been authenticated.
+func (e *logEntry) SetKeyName(name string) {
+	e.keyName = name
+}
+
+// Print implements middleware.LoggerInterface for the wrapped entry.
+func (e *logEntry) Print(v ...any) {
+	if e.keyName == "" {
+		e.logger.Print(v...)
+		return
+	}
+
+	var b strings.Builder
+	for _, s := range v {
+		if s, ok := s.(string); ok {
+			b.WriteString(s)
+		}
+	}
+	e.logger.Print(b.String() + " key=" + e.keyName)
+}
+
+func isTTY() bool {
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"gabe565.com/linx-server/internal/config"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/cobra"
+)
+
+// Handler serves the router built by Setup. The router is replaced when the config is reloaded.
+type Handler struct {
+	router atomic.Pointer[chi.Mux]
+	mu     sync.Mutex
+}
+
+func NewHandler(ctx context.Context) (*Handler, error) {
+	conf := *config.Default()
+	r, err := Setup(ctx, &conf)
+	if err != nil {
+		return nil, err
+	}
+	config.Store(&conf)
+
+	h := &Handler{}
+	h.router.Store(r)
+	return h, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.router.Load().ServeHTTP(w, r)
+}
+
+// Reload reads the config, auth files and custom pages again, then swaps in a new router.
+// In-flight requests finish with the previous router.
+// If anything fails to load, the error is returned and the current config is kept.
+func (h *Handler) Reload(ctx context.Context, cmd *cobra.Command) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	next, err := config.Default().Reload(cmd)
+	if err != nil {
+		return err
+	}
+
+	r, err := Setup(ctx, next)
+	if err != nil {
+		return err
+	}
+
+	config.Store(next)
+	h.router.Store(r)
+	return nil
+}
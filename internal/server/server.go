@@ -2,150 +2,243 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"path"
+	"slices"
 	"strings"
 	"time"
 
+	"gabe565.com/linx-server/internal/admin"
 	"gabe565.com/linx-server/internal/auth/apikeys"
+	"gabe565.com/linx-server/internal/auth/oidc"
 	"gabe565.com/linx-server/internal/config"
 	"gabe565.com/linx-server/internal/handlers"
 	"gabe565.com/linx-server/internal/headers"
+	"gabe565.com/linx-server/internal/metrics"
+	"gabe565.com/linx-server/internal/quota"
 	"gabe565.com/linx-server/internal/template"
-	"gabe565.com/linx-server/internal/torrent"
 	"gabe565.com/linx-server/internal/upload"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/httprate"
 )
 
-func Setup() (*chi.Mux, error) {
-	var err error
-
-	if config.Default.ViteURL == "" {
+// Setup loads the auth files and custom pages into conf and builds a router for it.
+// conf must not be published with config.Store until Setup succeeds.
+func Setup(ctx context.Context, conf *config.Config) (*chi.Mux, error) {
+	if conf.ViteURL == "" {
 		if err := template.LoadManifest(); err != nil {
 			return nil, err
 		}
 	}
 
-	switch config.Default.SiteURL.Path {
+	// conf isn't published yet, so it can be normalized in place
+	switch conf.SiteURL.Path {
 	case "", "/":
-		config.Default.SiteURL.Path = "/"
+		conf.SiteURL.Path = "/"
 	default:
-		config.Default.SiteURL.Path = "/" + strings.Trim(config.Default.SiteURL.Path, "/") + "/"
+		conf.SiteURL.Path = "/" + strings.Trim(conf.SiteURL.Path, "/") + "/"
 	}
-	config.Default.SelifPath = strings.Trim(config.Default.SelifPath, "/") + "/"
-
-	config.TimeStarted = time.Now()
+	conf.SelifPath = strings.Trim(conf.SelifPath, "/") + "/"
 
 	var customPages []string
-	if config.Default.CustomPagesPath != "" {
-		customPages, err = handlers.ListCustomPages(config.Default.CustomPagesPath)
+	if conf.CustomPagesPath != "" {
+		var err error
+		customPages, err = handlers.ListCustomPages(conf.CustomPagesPath)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		config.CustomPages = customPages
+	authKeys, err := readAuthKeys(conf)
+	if err != nil {
+		return nil, err
 	}
 
+	var provider *oidc.Provider
+	if conf.OIDC.Issuer != "" {
+		if provider, err = newOIDCProvider(ctx, conf); err != nil {
+			return nil, fmt.Errorf("failed to set up OIDC: %w", err)
+		}
+	}
+
+	conf.TimeStarted = time.Now()
+	conf.CustomPages = customPages
+	conf.AuthKeys = authKeys
+
 	// Routing setup
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(func(next http.Handler) http.Handler {
 		redirectSlashes := middleware.RedirectSlashes(next)
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == path.Join(config.Default.SiteURL.Path, "upload")+"/" {
+			if r.URL.Path == path.Join(conf.SiteURL.Path, "upload")+"/" {
 				r.URL.Path = strings.TrimSuffix(r.URL.Path, "/")
 			}
 
 			switch {
-			case r.URL.Path == config.Default.SiteURL.Path:
+			case r.URL.Path == conf.SiteURL.Path:
 				next.ServeHTTP(w, r)
-			case r.URL.Path == strings.TrimSuffix(config.Default.SiteURL.Path, "/"):
-				http.Redirect(w, r, config.Default.SiteURL.String(), http.StatusPermanentRedirect)
+			case r.URL.Path == strings.TrimSuffix(conf.SiteURL.Path, "/"):
+				http.Redirect(w, r, conf.SiteURL.String(), http.StatusPermanentRedirect)
 			default:
 				redirectSlashes.ServeHTTP(w, r)
 			}
 		}
 		return http.HandlerFunc(fn)
 	})
-	if config.Default.SiteURL.Path != "/" {
-		r.Use(middleware.StripPrefix(strings.TrimSuffix(config.Default.SiteURL.Path, "/")))
+	if conf.SiteURL.Path != "/" {
+		r.Use(middleware.StripPrefix(strings.TrimSuffix(conf.SiteURL.Path, "/")))
 	}
-	if config.Default.Header.RealIP {
+	if conf.Header.RealIP {
 		r.Use(middleware.RealIP)
 	}
 
 	r.Use(middleware.Heartbeat("/ping"))
 
-	if !config.Default.NoLogs {
-		r.Use(middleware.Logger)
+	if !conf.NoLogs {
+		r.Use(middleware.RequestLogger(NewLogFormatter()))
 	}
 
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.GetHead)
 	r.Use(NewCSPMiddleware(Options{
-		Policy:         GenerateCSP(),
-		ReferrerPolicy: config.Default.Header.ReferrerPolicy,
-		Frame:          config.Default.Header.XFrameOptions,
+		Policy:         GenerateCSP(conf),
+		ReferrerPolicy: conf.Header.ReferrerPolicy,
+		Frame:          conf.Header.XFrameOptions,
 	}))
-	r.Use(headers.AddHeaders(config.Default.Header.AddHeaders))
+	r.Use(headers.AddHeaders(conf.Header.AddHeaders))
 
 	r.Use(RemoveMultipartForm)
 
-	if config.Default.Auth.File != "" {
+	// chi requires every middleware to be added before the first route.
+	if provider != nil {
+		r.Use(provider.Middleware)
+	}
+	if conf.Auth.File != "" || provider != nil {
 		r.Use(apikeys.NewAPIKeysMiddleware(apikeys.AuthOptions{
-			AuthFile:      config.Default.Auth.File,
+			AuthKeys:      conf.AuthKeys,
+			Scopes:        []apikeys.Scope{apikeys.ScopeUpload, apikeys.ScopeDeleteAny, apikeys.ScopeAdmin},
 			UnauthMethods: []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace},
-			BasicAuth:     config.Default.Auth.Basic,
-			SiteName:      config.Default.SiteName,
-			SitePath:      config.Default.SiteURL.Path,
+			BasicAuth:     conf.Auth.Basic,
+			SiteName:      conf.SiteName,
+			SitePath:      conf.SiteURL.Path,
 		}))
 	}
 
+	if provider != nil {
+		r.Get("/"+oidc.LoginPath, provider.Login)
+		r.Get("/"+oidc.CallbackPath, provider.Callback)
+		r.Get("/"+oidc.LogoutPath, provider.Logout)
+	}
+
+	if conf.Auth.File != "" || provider != nil {
+		r.With(apikeys.NewAPIKeysMiddleware(apikeys.AuthOptions{
+			AuthKeys:  conf.AuthKeys,
+			BasicAuth: conf.Auth.Basic,
+			SiteName:  conf.SiteName,
+			SitePath:  conf.SiteURL.Path,
+		})).Get("/api/usage", handlers.Usage)
+	}
+
+	if conf.Metrics {
+		r.Handle("/metrics", metrics.Handler())
+	}
+
 	if len(customPages) != 0 {
-		r.Get("/api/custom_page/{name}", handlers.CustomPage(config.Default.CustomPagesPath))
+		r.Get("/api/custom_page/{name}", handlers.CustomPage(conf.CustomPagesPath))
 	}
 
 	r.Group(func(r chi.Router) {
 		r.Use(
-			rateLimit(config.Default.Limit.UploadMaxRequests, config.Default.Limit.UploadInterval.Duration),
-			LimitBodySize(int64(config.Default.MaxSize)),
+			rateLimit("upload", conf.Limit.UploadMaxRequests, conf.Limit.UploadInterval.Duration),
+			LimitBodySize(int64(conf.MaxSize)),
 		)
 
 		r.Post("/api/auth", func(w http.ResponseWriter, _ *http.Request) {
 			_, _ = w.Write([]byte("Authorized"))
 		})
-		r.Post("/upload", upload.POSTHandler)
-		r.Put("/upload", upload.PUTHandler)
-		r.Put("/upload/{name}", upload.PUTHandler)
-		if config.Default.RemoteUploads {
-			r.Get("/upload", upload.Remote)
-			r.Get("/upload/{name}", upload.Remote)
-
-			if config.Default.Auth.RemoteFile != "" {
-				config.RemoteAuthKeys = apikeys.ReadAuthKeys(config.Default.Auth.RemoteFile)
-			}
+		r.Group(func(r chi.Router) {
+			r.Use(apikeys.RequireScope(apikeys.ScopeUpload), instrument(conf, "upload"))
+
+			r.Post("/upload", upload.POSTHandler)
+			r.Put("/upload", upload.PUTHandler)
+			r.Put("/upload/{name}", upload.PUTHandler)
+		})
+		if conf.RemoteUploads {
+			r.With(instrument(conf, "upload")).Get("/upload", upload.Remote)
+			r.With(instrument(conf, "upload")).Get("/upload/{name}", upload.Remote)
 		}
 
-		r.Delete("/{name}", handlers.Delete)
+		r.With(instrument(conf, "delete")).Delete("/{name}", handlers.Delete)
+	})
+
+	if conf.Auth.File != "" || conf.Auth.AdminFile != "" {
+		r.Route("/api/admin", func(r chi.Router) {
+			r.Use(apikeys.NewAPIKeysMiddleware(apikeys.AuthOptions{
+				AuthKeys:  conf.AuthKeys,
+				Scopes:    []apikeys.Scope{apikeys.ScopeAdmin},
+				BasicAuth: conf.Auth.Basic,
+				SiteName:  conf.SiteName,
+				SitePath:  conf.SiteURL.Path,
+			}))
+
+			r.Get("/files", admin.ListFiles)
+			r.Get("/files/{name}", admin.GetFile)
+			r.Patch("/files/{name}", admin.PatchFile)
+			r.Delete("/files/{name}", admin.DeleteFile)
+		})
+	}
+
+	r.Route("/"+upload.TusPath, func(r chi.Router) {
+		r.Use(upload.TusMiddleware, apikeys.RequireScope(apikeys.ScopeUpload), instrument(conf, "upload"))
+		r.Options("/", upload.TusOptionsHandler)
+		r.With(
+			rateLimit("upload", conf.Limit.UploadMaxRequests, conf.Limit.UploadInterval.Duration),
+		).Post("/", upload.TusCreateHandler)
+
+		r.Group(func(r chi.Router) {
+			r.Use(LimitBodySize(int64(conf.MaxSize)))
+
+			r.Head("/{id}", upload.TusHeadHandler)
+			r.Patch("/{id}", upload.TusPatchHandler)
+			r.Delete("/{id}", upload.TusDeleteHandler)
+		})
 	})
 
 	r.Group(func(r chi.Router) {
-		r.Use(rateLimit(config.Default.Limit.FileMaxRequests, config.Default.Limit.FileInterval.Duration))
+		r.Use(
+			instrument(conf, "display"),
+			rateLimit("file", conf.Limit.FileMaxRequests, conf.Limit.FileInterval.Duration),
+		)
 
 		r.Get("/{name}", handlers.FileAccessHandler)
 		r.Post("/{name}", handlers.FileAccessHandler)
 	})
 
 	r.Group(func(r chi.Router) {
-		r.Use(rateLimit(config.Default.Limit.FileMaxRequests, config.Default.Limit.FileInterval.Duration))
+		r.Use(rateLimit("file", conf.Limit.FileMaxRequests, conf.Limit.FileInterval.Duration))
+
+		r.With(instrument(conf, "selif")).Get(path.Join("/", conf.SelifPath, "{name}"), handlers.FileServeHandler)
+		r.With(instrument(conf, "selif")).Get(path.Join("/", conf.SelifPath, "{name}", "*"), handlers.FileMemberHandler)
+
+		if !conf.NoThumbnails {
+			r.With(instrument(conf, "thumb")).Get("/thumb/{name}", handlers.ThumbnailHandler)
+		}
+
+		if !conf.NoStats {
+			r.With(instrument(conf, "stats")).Get("/api/stats/{name}", handlers.StatsHandler)
+		}
 
-		r.Get(path.Join("/", config.Default.SelifPath, "{name}"), handlers.FileServeHandler)
+		r.With(instrument(conf, "share")).Post("/api/share/{name}", handlers.ShareHandler)
+		r.With(instrument(conf, "share")).Delete("/api/share/{name}", handlers.RevokeSharesHandler)
 
-		if !config.Default.NoTorrent {
-			r.Get("/torrent/{name}", torrent.FileTorrentHandler)
+		if !conf.NoTorrent {
+			r.With(instrument(conf, "torrent")).Get("/torrent/{name}", handlers.FileTorrentHandler)
 			r.Get("/{name}/torrent", func(w http.ResponseWriter, r *http.Request) {
 				http.Redirect(w, r, "/torrent/"+chi.URLParam(r, "name"), http.StatusMovedPermanently)
 			})
@@ -153,8 +246,8 @@ func Setup() (*chi.Mux, error) {
 	})
 
 	r.Get("/api/config", func(w http.ResponseWriter, r *http.Request) {
-		b, _ := json.Marshal(template.NewConfig())
-		http.ServeContent(w, r, "config.json", config.TimeStarted, bytes.NewReader(b))
+		b, _ := json.Marshal(template.NewConfig(conf))
+		http.ServeContent(w, r, "config.json", conf.TimeStarted, bytes.NewReader(b))
 	})
 
 	for _, p := range append(customPages, "Paste", "API") {
@@ -166,10 +259,72 @@ func Setup() (*chi.Mux, error) {
 	return r, nil
 }
 
-func rateLimit(requestLimit int, windowLength time.Duration) func(next http.Handler) http.Handler {
+func newOIDCProvider(ctx context.Context, conf *config.Config) (*oidc.Provider, error) {
+	c := conf.OIDC
+	return oidc.New(ctx, oidc.Options{
+		Issuer:        c.Issuer,
+		ClientID:      c.ClientID,
+		ClientSecret:  c.ClientSecret,
+		Scopes:        c.Scopes,
+		GroupsClaim:   c.GroupsClaim,
+		AllowedGroups: c.AllowedGroups,
+		SessionExpiry: c.SessionExpiry.Duration,
+		SessionKey:    []byte(c.SessionKey),
+	})
+}
+
+// readAuthKeys loads every configured auth file.
+// Keys in the flat format are scoped by the file they were loaded from.
+func readAuthKeys(conf *config.Config) ([]apikeys.Key, error) {
+	var keys []apikeys.Key
+	read := func(path string, scope apikeys.Scope) error {
+		if path == "" {
+			return nil
+		}
+
+		k, err := apikeys.ReadAuthKeys(path, scope)
+		if err != nil {
+			return fmt.Errorf("failed to read auth file: %w", err)
+		}
+		keys = append(keys, k...)
+		return nil
+	}
+
+	var remoteFile string
+	if conf.RemoteUploads {
+		remoteFile = conf.Auth.RemoteFile
+	}
+
+	if err := errors.Join(
+		read(conf.Auth.File, apikeys.ScopeUpload),
+		read(remoteFile, apikeys.ScopeRemoteUpload),
+		read(conf.Auth.AdminFile, apikeys.ScopeAdmin),
+	); err != nil {
+		return nil, err
+	}
+
+	// Checking these limits without the index would read the metadata of every upload.
+	if conf.MetadataIndex == "" && slices.ContainsFunc(keys, func(k apikeys.Key) bool {
+		return k.Limits.MaxBytes != 0 || k.Limits.MaxFiles != 0
+	}) {
+		return nil, quota.ErrIndexRequired
+	}
+	return keys, nil
+}
+
+// instrument records metrics for a route if they are enabled.
+func instrument(conf *config.Config, route string) func(http.Handler) http.Handler {
+	if !conf.Metrics {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return metrics.Middleware(route)
+}
+
+func rateLimit(name string, requestLimit int, windowLength time.Duration) func(next http.Handler) http.Handler {
 	limiter := httprate.NewRateLimiter(requestLimit, windowLength,
 		httprate.WithKeyByIP(),
 		httprate.WithLimitHandler(func(w http.ResponseWriter, r *http.Request) {
+			metrics.RateLimited(name)
 			handlers.ErrorMsg(w, r, http.StatusTooManyRequests, "Too many requests")
 		}),
 	)
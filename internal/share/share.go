@@ -0,0 +1,108 @@
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Query parameters of a share link.
+const (
+	ParamExpires = "exp"
+	ParamUses    = "uses"
+	ParamIP      = "ip"
+	ParamSig     = "sig"
+)
+
+var (
+	ErrInvalidLink    = errors.New("invalid share link")
+	ErrExpired        = errors.New("share link has expired")
+	ErrAddrNotAllowed = errors.New("share link is not valid from this address")
+)
+
+// NewSecret returns a random per-upload secret which signs its share links.
+func NewSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Link grants download access to an upload without its access key.
+type Link struct {
+	Expires time.Time
+	// MaxUses is the number of times the link can be downloaded. 0 means unlimited.
+	MaxUses int
+	// Prefix restricts the link to clients in an IP range. The zero value allows any client.
+	Prefix netip.Prefix
+}
+
+// Has reports whether a request's query contains a share link.
+func Has(q url.Values) bool {
+	return q.Has(ParamSig)
+}
+
+// Sign returns the query parameters of a link to an upload.
+func (l Link) Sign(secret, name string) url.Values {
+	q := make(url.Values, 4)
+	q.Set(ParamExpires, strconv.FormatInt(l.Expires.Unix(), 10))
+	if l.MaxUses != 0 {
+		q.Set(ParamUses, strconv.Itoa(l.MaxUses))
+	}
+	if l.Prefix.IsValid() {
+		q.Set(ParamIP, l.Prefix.String())
+	}
+	q.Set(ParamSig, base64.RawURLEncoding.EncodeToString(mac(secret, name, q)))
+	return q
+}
+
+// Verify checks a link's signature, expiry and IP range.
+// It returns the link and its ID, which identifies the link when its uses are counted.
+func Verify(q url.Values, secret, name string, addr netip.Addr, now time.Time) (Link, string, error) {
+	var l Link
+	sig, err := base64.RawURLEncoding.DecodeString(q.Get(ParamSig))
+	if secret == "" || err != nil || !hmac.Equal(sig, mac(secret, name, q)) {
+		return l, "", ErrInvalidLink
+	}
+
+	exp, err := strconv.ParseInt(q.Get(ParamExpires), 10, 64)
+	if err != nil {
+		return l, "", ErrInvalidLink
+	}
+	l.Expires = time.Unix(exp, 0)
+
+	if q.Has(ParamUses) {
+		if l.MaxUses, err = strconv.Atoi(q.Get(ParamUses)); err != nil || l.MaxUses < 0 {
+			return l, "", ErrInvalidLink
+		}
+	}
+
+	if q.Has(ParamIP) {
+		if l.Prefix, err = netip.ParsePrefix(q.Get(ParamIP)); err != nil {
+			return l, "", ErrInvalidLink
+		}
+	}
+
+	switch {
+	case !now.Before(l.Expires):
+		return l, "", ErrExpired
+	case l.Prefix.IsValid() && !l.Prefix.Contains(addr.Unmap()):
+		return l, "", ErrAddrNotAllowed
+	}
+	return l, q.Get(ParamSig), nil
+}
+
+func mac(secret, name string, q url.Values) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	for _, s := range []string{name, q.Get(ParamExpires), q.Get(ParamUses), q.Get(ParamIP)} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,81 @@
+package share
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLink(t *testing.T) {
+	secret := NewSecret()
+	now := time.Now()
+	addr := netip.MustParseAddr("10.1.2.3")
+	link := Link{
+		Expires: now.Add(time.Hour).Truncate(time.Second),
+		MaxUses: 3,
+		Prefix:  netip.MustParsePrefix("10.0.0.0/8"),
+	}
+	q := link.Sign(secret, "file.txt")
+	require.True(t, Has(q))
+
+	got, id, err := Verify(q, secret, "file.txt", addr, now)
+	require.NoError(t, err)
+	assert.Equal(t, link, got)
+	assert.Equal(t, q.Get(ParamSig), id)
+
+	_, _, err = Verify(q, secret, "other.txt", addr, now)
+	require.ErrorIs(t, err, ErrInvalidLink)
+
+	_, _, err = Verify(q, NewSecret(), "file.txt", addr, now)
+	require.ErrorIs(t, err, ErrInvalidLink)
+
+	_, _, err = Verify(q, "", "file.txt", addr, now)
+	require.ErrorIs(t, err, ErrInvalidLink)
+
+	_, _, err = Verify(q, secret, "file.txt", addr, now.Add(2*time.Hour))
+	require.ErrorIs(t, err, ErrExpired)
+
+	_, _, err = Verify(q, secret, "file.txt", netip.MustParseAddr("192.0.2.1"), now)
+	require.ErrorIs(t, err, ErrAddrNotAllowed)
+
+	tampered := Link{Expires: link.Expires, Prefix: link.Prefix}.Sign(secret, "file.txt")
+	tampered.Set(ParamUses, "100")
+	_, _, err = Verify(tampered, secret, "file.txt", addr, now)
+	require.ErrorIs(t, err, ErrInvalidLink)
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shares")
+	s, err := NewStore(path)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Claim("file.txt", "a", 2))
+	require.NoError(t, s.Claim("file.txt", "a", 2))
+	// claims which are in flight count against the limit
+	require.ErrorIs(t, s.Claim("file.txt", "a", 2), ErrNoUsesLeft)
+	require.NoError(t, s.Release("file.txt", "a", false))
+	require.NoError(t, s.Release("file.txt", "a", true))
+
+	uses, err := s.Uses("file.txt", "a")
+	require.NoError(t, err)
+	assert.Equal(t, 1, uses)
+
+	// uses are saved across restarts
+	s, err = NewStore(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Claim("file.txt", "a", 2))
+	require.NoError(t, s.Release("file.txt", "a", true))
+	require.ErrorIs(t, s.Claim("file.txt", "a", 2), ErrNoUsesLeft)
+	require.NoError(t, s.Claim("file.txt", "b", 2))
+	require.NoError(t, s.Release("file.txt", "b", true))
+
+	require.NoError(t, s.Delete("file.txt"))
+	assert.NoFileExists(t, filepath.Join(path, "file.txt.json"))
+	uses, err = s.Uses("file.txt", "a")
+	require.NoError(t, err)
+	assert.Zero(t, uses)
+}
@@ -0,0 +1,147 @@
+package share
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+const ext = ".json"
+
+var ErrNoUsesLeft = errors.New("share link has no uses left")
+
+// Store counts the uses of limited share links in a small file per upload.
+// Uses are written before a claim is released, so a restart can't reset a link's limit.
+type Store struct {
+	path string
+
+	mu       sync.Mutex
+	inflight map[string]int
+}
+
+func NewStore(path string) (*Store, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, err
+	}
+	return &Store{path: path, inflight: make(map[string]int)}, nil
+}
+
+// Claim reserves one use of a link. Reservations count against the limit until they are released,
+// so concurrent requests can't use a link more times than allowed.
+func (s *Store) Claim(name, id string, maxUses int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uses, err := s.read(name)
+	if err != nil {
+		return err
+	}
+	inflightKey := name + "/" + id
+	if uses[id]+s.inflight[inflightKey] >= maxUses {
+		return ErrNoUsesLeft
+	}
+	s.inflight[inflightKey]++
+	return nil
+}
+
+// Release releases a reservation. If the link was used, its use is saved.
+func (s *Store) Release(name, id string, used bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inflightKey := name + "/" + id
+	if s.inflight[inflightKey]--; s.inflight[inflightKey] <= 0 {
+		delete(s.inflight, inflightKey)
+	}
+	if !used {
+		return nil
+	}
+
+	uses, err := s.read(name)
+	if err != nil {
+		return err
+	}
+	uses[id]++
+	return s.write(name, uses)
+}
+
+// Uses returns how often a link has been used.
+func (s *Store) Uses(name, id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uses, err := s.read(name)
+	return uses[id], err
+}
+
+// Delete forgets the uses of all of an upload's links.
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	root, err := os.OpenRoot(s.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	if err := root.Remove(name + ext); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) read(name string) (map[string]int, error) {
+	uses := make(map[string]int)
+
+	root, err := os.OpenRoot(s.path)
+	if err != nil {
+		return uses, err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	b, err := root.ReadFile(name + ext)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return uses, nil
+		}
+		return uses, err
+	}
+	// Unlike access stats, corrupt uses fail closed, since starting over would reset every limit.
+	if err := json.Unmarshal(b, &uses); err != nil {
+		return uses, err
+	}
+	return uses, nil
+}
+
+// write replaces the uses file with a temp file, so a partially written file is never read.
+func (s *Store) write(name string, uses map[string]int) error {
+	b, err := json.Marshal(uses)
+	if err != nil {
+		return err
+	}
+
+	root, err := os.OpenRoot(s.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	tmp := name + ".tmp"
+	if err := root.WriteFile(tmp, b, 0o600); err != nil {
+		_ = root.Remove(tmp)
+		return err
+	}
+	if err := root.Rename(tmp, name+ext); err != nil {
+		_ = root.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+
+	"gabe565.com/linx-server/internal/backends"
+)
+
+// WrapBackend removes an upload's stats when it is deleted or replaced.
+func WrapBackend(b backends.StorageBackend, store *Store) backends.StorageBackend { //nolint:ireturn
+	return backends.Wrap(Backend{StorageBackend: b, store: store}, b)
+}
+
+type Backend struct {
+	backends.StorageBackend
+	store *Store
+}
+
+func (b Backend) Put(
+	ctx context.Context,
+	r io.Reader,
+	key string,
+	size int64,
+	opts backends.PutOptions,
+) (backends.Metadata, error) {
+	m, err := b.StorageBackend.Put(ctx, r, key, size, opts)
+	if err == nil {
+		b.deleteStats(key)
+	}
+	return m, err
+}
+
+func (b Backend) Delete(ctx context.Context, key string) error {
+	err := b.StorageBackend.Delete(ctx, key)
+	if err == nil || errors.Is(err, backends.ErrNotFound) {
+		b.deleteStats(key)
+	}
+	return err
+}
+
+func (b Backend) deleteStats(key string) {
+	if err := b.store.Delete(key); err != nil {
+		slog.Warn("Failed to delete access stats", "name", key, "error", err)
+	}
+}
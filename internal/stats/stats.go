@@ -0,0 +1,203 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	ext           = ".json"
+	flushInterval = 10 * time.Second
+)
+
+// Stats counts how often an upload has been accessed.
+type Stats struct {
+	Views        int64     `json:"views"`
+	Downloads    int64     `json:"downloads"`
+	LastAccessed time.Time `json:"last_accessed,omitzero"`
+}
+
+func (s Stats) add(o Stats) Stats {
+	s.Views += o.Views
+	s.Downloads += o.Downloads
+	if o.LastAccessed.After(s.LastAccessed) {
+		s.LastAccessed = o.LastAccessed
+	}
+	return s
+}
+
+// Store keeps access stats in a small file per upload, separately from its metadata.
+// Hits are counted in memory and written in batches by Flush, so a popular upload isn't rewritten on every request.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	pending map[string]Stats
+
+	// flushMu prevents a flush from recreating stats which are being deleted.
+	flushMu sync.Mutex
+}
+
+func NewStore(path string) (*Store, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, err
+	}
+	return &Store{path: path, pending: make(map[string]Stats)}, nil
+}
+
+// View counts a visit to an upload's display page. It is a no-op on a nil Store.
+func (s *Store) View(key string) {
+	s.hit(key, Stats{Views: 1})
+}
+
+// Download counts a download of an upload. It is a no-op on a nil Store.
+func (s *Store) Download(key string) {
+	s.hit(key, Stats{Downloads: 1})
+}
+
+func (s *Store) hit(key string, st Stats) {
+	if s == nil {
+		return
+	}
+	st.LastAccessed = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[key] = s.pending[key].add(st)
+}
+
+// Get returns an upload's stats, including hits which have not been flushed yet.
+func (s *Store) Get(key string) (Stats, error) {
+	root, err := os.OpenRoot(s.path)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	st, err := read(root, key)
+	if err != nil {
+		return st, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return st.add(s.pending[key]), nil
+}
+
+// Delete removes an upload's stats.
+func (s *Store) Delete(key string) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	delete(s.pending, key)
+	s.mu.Unlock()
+
+	root, err := os.OpenRoot(s.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	if err := root.Remove(key + ext); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Flush writes pending hits to disk.
+func (s *Store) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[string]Stats, len(pending))
+	s.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	root, err := os.OpenRoot(s.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	var errs []error
+	for key, hits := range pending {
+		st, err := read(root, key)
+		if err == nil {
+			err = write(root, key, st.add(hits))
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run periodically flushes pending hits until ctx is canceled.
+// Hits which are still pending must be saved with a final Flush once requests have stopped.
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				slog.Error("Failed to save access stats", "error", err)
+			}
+		}
+	}
+}
+
+func read(root *os.Root, key string) (Stats, error) {
+	var st Stats
+	b, err := root.ReadFile(key + ext)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return st, nil
+		}
+		return st, err
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		// Stats are informational, so corrupt stats start over instead of failing every hit.
+		return Stats{}, nil //nolint:nilerr
+	}
+	return st, nil
+}
+
+// write replaces the stats file with a temp file, so a partially written file is never read.
+func write(root *os.Root, key string, st Stats) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	tmp := key + ".tmp"
+	if err := root.WriteFile(tmp, b, 0o600); err != nil {
+		_ = root.Remove(tmp)
+		return err
+	}
+	if err := root.Rename(tmp, key+ext); err != nil {
+		_ = root.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/localfs/localfstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats")
+	s, err := NewStore(path)
+	require.NoError(t, err)
+
+	s.View("file.txt")
+	s.View("file.txt")
+	s.Download("file.txt")
+
+	// pending hits are included before they are flushed
+	st, err := s.Get("file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), st.Views)
+	assert.Equal(t, int64(1), st.Downloads)
+	assert.False(t, st.LastAccessed.IsZero())
+	assert.NoFileExists(t, filepath.Join(path, "file.txt.json"))
+
+	require.NoError(t, s.Flush())
+	assert.FileExists(t, filepath.Join(path, "file.txt.json"))
+
+	// flushed hits are added to the saved stats
+	s, err = NewStore(path)
+	require.NoError(t, err)
+	s.Download("file.txt")
+	require.NoError(t, s.Flush())
+	st, err = s.Get("file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), st.Views)
+	assert.Equal(t, int64(2), st.Downloads)
+
+	s.View("file.txt")
+	require.NoError(t, s.Delete("file.txt"))
+	require.NoError(t, s.Flush())
+	assert.NoFileExists(t, filepath.Join(path, "file.txt.json"))
+	st, err = s.Get("file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, Stats{}, st)
+}
+
+func TestStoreNil(t *testing.T) {
+	var s *Store
+	assert.NotPanics(t, func() {
+		s.View("file.txt")
+		s.Download("file.txt")
+	})
+}
+
+func TestWrapBackend(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "stats"))
+	require.NoError(t, err)
+	backend := WrapBackend(localfstest.New(t), store)
+	_, ok := backend.(backends.ListBackend)
+	assert.True(t, ok)
+
+	put := func() {
+		_, err := backend.Put(t.Context(), strings.NewReader("content"), "file.txt", 7, backends.PutOptions{})
+		require.NoError(t, err)
+	}
+	get := func() Stats {
+		st, err := store.Get("file.txt")
+		require.NoError(t, err)
+		return st
+	}
+
+	put()
+	store.Download("file.txt")
+	require.NoError(t, store.Flush())
+	assert.Equal(t, int64(1), get().Downloads)
+
+	// replacing an upload resets its stats
+	put()
+	assert.Equal(t, Stats{}, get())
+
+	store.Download("file.txt")
+	require.NoError(t, backend.Delete(t.Context(), "file.txt"))
+	assert.Equal(t, Stats{}, get())
+}
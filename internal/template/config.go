@@ -15,6 +15,7 @@ type Config struct {
 	MaxSize         int64            `json:"max_size"`
 	ForceRandom     bool             `json:"force_random"`
 	Auth            bool             `json:"auth"`
+	OIDC            bool             `json:"oidc,omitzero"`
 	ExpirationTimes []ExpirationTime `json:"expiration_times"`
 	CustomPages     []string         `json:"custom_pages,omitzero"`
 }
@@ -24,16 +25,17 @@ type ExpirationTime struct {
 	Value string `json:"value"`
 }
 
-func NewConfig() Config {
-	expirationTimes := expiry.ListExpirationTimes()
+func NewConfig(c *config.Config) Config {
+	expirationTimes := expiry.ListExpirationTimes(c.MaxExpiry.Duration)
 	conf := Config{
-		SiteName:        config.Default.SiteName,
-		SitePath:        config.Default.SiteURL.Path,
-		ForceRandom:     config.Default.ForceRandomFilename,
-		MaxSize:         int64(config.Default.MaxSize),
-		Auth:            config.Default.Auth.Basic || config.Default.Auth.File != "",
+		SiteName:        c.SiteName,
+		SitePath:        c.SiteURL.Path,
+		ForceRandom:     c.ForceRandomFilename,
+		MaxSize:         int64(c.MaxSize),
+		Auth:            c.Auth.Basic || c.Auth.File != "" || c.OIDC.Issuer != "",
+		OIDC:            c.OIDC.Issuer != "",
 		ExpirationTimes: make([]ExpirationTime, 0, len(expirationTimes)),
-		CustomPages:     config.CustomPages,
+		CustomPages:     c.CustomPages,
 	}
 	for _, t := range expirationTimes {
 		conf.ExpirationTimes = append(conf.ExpirationTimes, ExpirationTime{
@@ -44,10 +46,10 @@ func NewConfig() Config {
 	return conf
 }
 
-func ConfigBytes() ([]byte, error) {
+func ConfigBytes(c *config.Config) ([]byte, error) {
 	var buf bytes.Buffer
 
-	if config.Default.ViteURL == "" {
+	if c.ViteURL == "" {
 		f, err := assets.Static().Open(manifest["src/fouc.ts"].File)
 		if err != nil {
 			return nil, err
@@ -62,7 +64,7 @@ func ConfigBytes() ([]byte, error) {
 	}
 
 	buf.WriteString("window.config=")
-	if err := json.NewEncoder(&buf).Encode(NewConfig()); err != nil {
+	if err := json.NewEncoder(&buf).Encode(NewConfig(c)); err != nil {
 		return nil, err
 	}
 	buf.WriteByte(';')
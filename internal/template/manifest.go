@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"sync"
 
 	"gabe565.com/linx-server/assets"
 	"gabe565.com/linx-server/internal/config"
@@ -46,26 +47,28 @@ func (m ManifestMap) Preload(name string) Node {
 }
 
 //nolint:gochecknoglobals
-var manifest ManifestMap
-
-func LoadManifest() error {
-	f, err := assets.Static().Open(".vite/manifest.json")
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = f.Close()
-	}()
+var (
+	manifest     ManifestMap
+	loadManifest = sync.OnceValue(func() error {
+		f, err := assets.Static().Open(".vite/manifest.json")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
 
-	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
-		return err
-	}
+		return json.NewDecoder(f).Decode(&manifest)
+	})
+)
 
-	return nil
+// LoadManifest decodes the embedded Vite manifest. It is only decoded once, since it can't change while running.
+func LoadManifest() error {
+	return loadManifest()
 }
 
 func ImportAssets(r *http.Request) Node {
-	if u := config.Default.ViteURL; u != "" {
+	if u := config.Default().ViteURL; u != "" {
 		return Group{
 			Script(Type("module"), Src(u+"/@vite/client")),
 			Script(Type("module"), Src(u+"/src/main.ts")),
@@ -14,6 +14,7 @@ const (
 	OpenGraphSiteName    = "og:site_name"
 	OpenGraphURL         = "og:url"
 	OpenGraphType        = "og:type"
+	OpenGraphImage       = "og:image"
 )
 
 type Options struct {
@@ -62,10 +63,10 @@ type OptionFunc func(o *Options)
 
 func WithTitle(title string) OptionFunc {
 	return func(o *Options) {
-		if title != "" && config.Default.SiteName != "" {
+		if title != "" && config.Default().SiteName != "" {
 			title += " · "
 		}
-		title += config.Default.SiteName
+		title += config.Default().SiteName
 
 		o.Title = title
 	}
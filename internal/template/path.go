@@ -7,8 +7,8 @@ import (
 )
 
 func SitePath(p string) string {
-	if config.Default.SiteURL.Path == "" {
+	if config.Default().SiteURL.Path == "" {
 		return p
 	}
-	return path.Join(config.Default.SiteURL.Path, p)
+	return path.Join(config.Default().SiteURL.Path, p)
 }
@@ -16,10 +16,10 @@ func Index(r *http.Request, opts ...OptionFunc) Node {
 	u.Path = path.Join(u.Path, r.URL.Path)
 
 	options := Options{
-		Title:       config.Default.SiteName,
+		Title:       config.Default().SiteName,
 		Description: "Self-hosted file/media sharing website.",
 		OpenGraph: map[string]string{
-			OpenGraphSiteName: config.Default.SiteName,
+			OpenGraphSiteName: config.Default().SiteName,
 			OpenGraphURL:      u.String(),
 			OpenGraphType:     "website",
 		},
@@ -37,7 +37,7 @@ func Index(r *http.Request, opts ...OptionFunc) Node {
 				Meta(Name("viewport"), Content("width=device-width, initial-scale=1.0")),
 				options.Components(),
 				func() Node {
-					conf, err := ConfigBytes()
+					conf, err := ConfigBytes(config.Default())
 					if err != nil {
 						return NodeFunc(func(io.Writer) error {
 							return err
@@ -0,0 +1,29 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"gabe565.com/linx-server/internal/backends"
+)
+
+// WrapBackend removes cached thumbnails when an upload is deleted.
+func WrapBackend(b backends.StorageBackend, cache *Cache) backends.StorageBackend { //nolint:ireturn
+	return backends.Wrap(Backend{StorageBackend: b, cache: cache}, b)
+}
+
+type Backend struct {
+	backends.StorageBackend
+	cache *Cache
+}
+
+func (b Backend) Delete(ctx context.Context, key string) error {
+	err := b.StorageBackend.Delete(ctx, key)
+	if err == nil || errors.Is(err, backends.ErrNotFound) {
+		if err := b.cache.Delete(key); err != nil {
+			slog.Warn("Failed to delete cached thumbnails", "name", key, "error", err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+
+	"gabe565.com/linx-server/internal/backends"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache stores generated thumbnails on disk, in a directory per upload.
+// Thumbnails are keyed by the upload's checksum and modification time, so a replaced upload gets a new thumbnail.
+type Cache struct {
+	path  string
+	size  int
+	group singleflight.Group
+}
+
+func NewCache(path string, size int) (*Cache, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create thumbnails directory: %w", err)
+	}
+	return &Cache{path: path, size: size}, nil
+}
+
+// Open returns a thumbnail of the upload, generating it if it is not cached yet.
+func (c *Cache) Open(
+	ctx context.Context,
+	backend backends.StorageBackend,
+	key string,
+	m backends.Metadata,
+) (*os.File, string, error) {
+	if !Supported(m.Mimetype) {
+		return nil, "", ErrUnsupported
+	}
+
+	root, err := os.OpenRoot(c.path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	name := path.Join(key, strconv.Itoa(c.size)+"-"+m.Checksum+"-"+strconv.FormatInt(m.ModTime.Unix(), 36))
+	if f, mimetype, err := open(root, name); err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return f, mimetype, err
+	}
+
+	if _, err, _ := c.group.Do(name, func() (any, error) {
+		return nil, c.generate(ctx, root, backend, key, name)
+	}); err != nil {
+		return nil, "", err
+	}
+	return open(root, name)
+}
+
+func open(root *os.Root, name string) (*os.File, string, error) {
+	for _, mimetype := range []string{MimetypeJPEG, MimetypePNG} {
+		f, err := root.Open(name + ext(mimetype))
+		if err == nil {
+			return f, mimetype, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, "", err
+		}
+	}
+	return nil, "", fs.ErrNotExist
+}
+
+func ext(mimetype string) string {
+	if mimetype == MimetypePNG {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// generate writes the thumbnail to a temporary file, then renames it so that partial thumbnails are never served.
+func (c *Cache) generate(
+	ctx context.Context,
+	root *os.Root,
+	backend backends.StorageBackend,
+	key, name string,
+) error {
+	_, src, err := backend.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	if err := root.MkdirAll(path.Dir(name), 0o700); err != nil {
+		return err
+	}
+
+	tmp := name + ".tmp"
+	f, err := root.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = root.Remove(tmp)
+	}()
+
+	mimetype, err := Generate(f, src, c.size)
+	if err := errors.Join(err, f.Close()); err != nil {
+		return err
+	}
+	return root.Rename(tmp, name+ext(mimetype))
+}
+
+// Delete removes every cached thumbnail of an upload.
+func (c *Cache) Delete(key string) error {
+	root, err := os.OpenRoot(c.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	return root.RemoveAll(key)
+}
@@ -0,0 +1,83 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// MaxPixels is the largest source image which will be decoded.
+	MaxPixels = 50_000_000
+
+	MimetypeJPEG = "image/jpeg"
+	MimetypePNG  = "image/png"
+	MimetypeWebP = "image/webp"
+
+	jpegQuality = 85
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported image type")
+	ErrTooLarge    = errors.New("image is too large")
+)
+
+// Supported reports whether a thumbnail can be generated for an upload's mimetype.
+func Supported(mimetype string) bool {
+	switch mimetype {
+	case MimetypeJPEG, MimetypePNG, MimetypeWebP:
+		return true
+	}
+	return false
+}
+
+// Generate decodes a JPEG, PNG or WebP image and writes a copy which fits within size×size pixels.
+// Opaque images are encoded as JPEG and images with transparency as PNG. The written mimetype is returned.
+func Generate(w io.Writer, r io.Reader, size int) (string, error) {
+	// Check the dimensions before decoding so that huge images are not loaded into memory.
+	var head bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &head))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return "", ErrUnsupported
+		}
+		return "", err
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return "", ErrTooLarge
+	}
+
+	src, _, err := image.Decode(io.MultiReader(&head, r))
+	if err != nil {
+		return "", err
+	}
+
+	dst := src
+	if width, height := fit(src.Bounds().Dx(), src.Bounds().Dy(), size); width != src.Bounds().Dx() {
+		rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(rgba, rgba.Bounds(), src, src.Bounds(), draw.Src, nil)
+		dst = rgba
+	}
+
+	if opaque, ok := dst.(interface{ Opaque() bool }); ok && !opaque.Opaque() {
+		return MimetypePNG, png.Encode(w, dst)
+	}
+	return MimetypeJPEG, jpeg.Encode(w, dst, &jpeg.Options{Quality: jpegQuality})
+}
+
+// fit scales width and height down to fit within size, keeping the aspect ratio.
+func fit(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		return size, max(height*size/width, 1)
+	}
+	return max(width*size/height, 1), size
+}
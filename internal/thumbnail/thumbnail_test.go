@@ -0,0 +1,104 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/localfs/localfstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodePNG(t *testing.T, width, height int, c color.Color) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestGenerate(t *testing.T) {
+	t.Run("opaque", func(t *testing.T) {
+		var buf bytes.Buffer
+		mimetype, err := Generate(&buf, bytes.NewReader(encodePNG(t, 200, 100, color.White)), 50)
+		require.NoError(t, err)
+		assert.Equal(t, MimetypeJPEG, mimetype)
+
+		cfg, format, err := image.DecodeConfig(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, 50, cfg.Width)
+		assert.Equal(t, 25, cfg.Height)
+	})
+
+	t.Run("transparent", func(t *testing.T) {
+		var buf bytes.Buffer
+		mimetype, err := Generate(&buf, bytes.NewReader(encodePNG(t, 100, 200, color.Transparent)), 50)
+		require.NoError(t, err)
+		assert.Equal(t, MimetypePNG, mimetype)
+
+		cfg, err := png.DecodeConfig(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, 25, cfg.Width)
+		assert.Equal(t, 50, cfg.Height)
+	})
+
+	t.Run("small", func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := Generate(&buf, bytes.NewReader(encodePNG(t, 10, 10, color.White)), 50)
+		require.NoError(t, err)
+
+		cfg, _, err := image.DecodeConfig(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, 10, cfg.Width)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := Generate(&bytes.Buffer{}, strings.NewReader("not an image"), 50)
+		require.ErrorIs(t, err, ErrUnsupported)
+	})
+}
+
+func TestCache(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "thumbnails")
+	cache, err := NewCache(cachePath, 50)
+	require.NoError(t, err)
+	backend := WrapBackend(localfstest.New(t), cache)
+	_, ok := backend.(backends.ListBackend)
+	assert.True(t, ok)
+
+	data := encodePNG(t, 200, 200, color.White)
+	m, err := backend.Put(t.Context(), bytes.NewReader(data), "image.png", int64(len(data)), backends.PutOptions{})
+	require.NoError(t, err)
+
+	f, mimetype, err := cache.Open(t.Context(), backend, "image.png", m)
+	require.NoError(t, err)
+	assert.Equal(t, MimetypeJPEG, mimetype)
+	require.NoError(t, f.Close())
+
+	// cached thumbnails are reused
+	entries, err := os.ReadDir(filepath.Join(cachePath, "image.png"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	f, _, err = cache.Open(t.Context(), backend, "image.png", m)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, backend.Delete(t.Context(), "image.png"))
+	assert.NoDirExists(t, filepath.Join(cachePath, "image.png"))
+
+	m.Mimetype = "text/plain"
+	_, _, err = cache.Open(t.Context(), backend, "image.png", m)
+	require.ErrorIs(t, err, ErrUnsupported)
+}
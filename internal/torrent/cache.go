@@ -0,0 +1,147 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+
+	"gabe565.com/linx-server/internal/backends"
+	"github.com/zeebo/bencode"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache stores the piece hashes of uploads on disk, so a torrent is only hashed once.
+// Hashes are keyed by the upload's checksum, so a replaced upload is hashed again,
+// but changes to its metadata keep the cached hashes.
+type Cache struct {
+	path  string
+	group singleflight.Group
+}
+
+func NewCache(path string) (*Cache, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create torrents directory: %w", err)
+	}
+	return &Cache{path: path}, nil
+}
+
+func cacheName(key string, m backends.Metadata) string {
+	return path.Join(key, m.Checksum)
+}
+
+// Hashes returns the piece hashes of an upload, hashing it if they are not cached yet.
+// It hashes the upload on every call on a nil Cache.
+func (c *Cache) Hashes(
+	ctx context.Context,
+	backend backends.StorageBackend,
+	key string,
+	m backends.Metadata,
+) (Hashes, error) {
+	if c == nil {
+		return hashUpload(ctx, backend, key, m)
+	}
+
+	if h, err := c.Cached(key, m); err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return h, err
+	}
+
+	h, err, _ := c.group.Do(cacheName(key, m), func() (any, error) {
+		return c.generate(ctx, backend, key, m)
+	})
+	if err != nil {
+		return Hashes{}, err
+	}
+	return h.(Hashes), nil //nolint:forcetypeassert
+}
+
+// Cached returns the piece hashes of an upload if they were already cached.
+func (c *Cache) Cached(key string, m backends.Metadata) (Hashes, error) {
+	var h Hashes
+	if c == nil {
+		return h, fs.ErrNotExist
+	}
+
+	root, err := os.OpenRoot(c.path)
+	if err != nil {
+		return h, err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	b, err := root.ReadFile(cacheName(key, m))
+	if err != nil {
+		return h, err
+	}
+	if err := bencode.DecodeBytes(b, &h); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// generate writes the hashes to a temporary file, then renames it so that partial hashes are never read.
+// Hashes of a replaced upload are removed.
+func (c *Cache) generate(
+	ctx context.Context,
+	backend backends.StorageBackend,
+	key string,
+	m backends.Metadata,
+) (Hashes, error) {
+	h, err := hashUpload(ctx, backend, key, m)
+	if err != nil {
+		return h, err
+	}
+	b, err := bencode.EncodeBytes(h)
+	if err != nil {
+		return h, err
+	}
+
+	root, err := os.OpenRoot(c.path)
+	if err != nil {
+		return h, err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	if err := root.RemoveAll(key); err != nil {
+		return h, err
+	}
+	if err := root.MkdirAll(key, 0o700); err != nil {
+		return h, err
+	}
+	name := cacheName(key, m)
+	tmp := name + ".tmp"
+	if err := root.WriteFile(tmp, b, 0o600); err != nil {
+		_ = root.Remove(tmp)
+		return h, err
+	}
+	return h, root.Rename(tmp, name)
+}
+
+func hashUpload(ctx context.Context, backend backends.StorageBackend, key string, m backends.Metadata) (Hashes, error) {
+	_, rc, err := backend.Get(ctx, key)
+	if err != nil {
+		return Hashes{}, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	return Hash(rc, m.Size)
+}
+
+// Delete removes the cached hashes of an upload.
+func (c *Cache) Delete(key string) error {
+	root, err := os.OpenRoot(c.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	return root.RemoveAll(key)
+}
@@ -1,129 +1,246 @@
 package torrent
 
 import (
-	"bytes"
-	"context"
 	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io"
-	"log/slog"
-	"net/http"
-	"time"
-
-	"gabe565.com/linx-server/internal/backends"
-	"gabe565.com/linx-server/internal/config"
-	"gabe565.com/linx-server/internal/handlers"
-	"gabe565.com/linx-server/internal/headers"
-	"gabe565.com/linx-server/internal/util"
+	"net/url"
+	"slices"
+	"strings"
+
 	"gabe565.com/utils/bytefmt"
-	"github.com/go-chi/chi/v5"
 	"github.com/zeebo/bencode"
 )
 
-const PieceLength = 256 * bytefmt.KiB
+const (
+	// BlockSize is the size of the leaves of a file's BitTorrent v2 merkle tree.
+	BlockSize = 16 * bytefmt.KiB
+	// MinPieceLength and MaxPieceLength bound the piece length, which doubles as files grow.
+	MinPieceLength = 256 * bytefmt.KiB
+	MaxPieceLength = 16 * bytefmt.MiB
+	// targetPieces is the number of pieces which files stay below until the piece length reaches its maximum.
+	targetPieces = 1024
+)
 
-type Info struct {
-	PieceLength int    `bencode:"piece length"`
-	Pieces      string `bencode:"pieces"`
-	Name        string `bencode:"name"`
-	Length      int    `bencode:"length"`
+// PieceLength returns the piece length for a file, so that large files don't get huge piece lists.
+func PieceLength(size int64) int64 {
+	length := int64(MinPieceLength)
+	for length < MaxPieceLength && size > length*targetPieces {
+		length *= 2
+	}
+	return length
 }
 
-type Torrent struct {
-	Encoding string   `bencode:"encoding"`
-	Info     Info     `bencode:"info"`
-	URLList  []string `bencode:"url-list"`
+// Hashes are the piece hashes of a file, which are used for both the v1 and v2 parts of a hybrid torrent.
+// Computing them requires reading the whole file, so they are cached.
+type Hashes struct {
+	PieceLength int64 `bencode:"piece length"`
+	Length      int64 `bencode:"length"`
+	// Pieces are the concatenated SHA-1 hashes of each piece.
+	Pieces string `bencode:"pieces"`
+	// PiecesRoot is the root of the file's SHA-256 merkle tree. It is empty for empty files.
+	PiecesRoot string `bencode:"pieces root,omitempty"`
+	// PieceLayer are the concatenated merkle tree hashes of each piece.
+	// It is only set for files which are larger than one piece.
+	PieceLayer string `bencode:"piece layer,omitempty"`
 }
 
-func HashPiece(piece []byte) []byte {
-	h := sha1.New() //nolint:gosec
-	h.Write(piece)
-	return h.Sum(nil)
-}
+// Hash reads a file and computes its piece hashes. The size is only used to choose the piece length.
+func Hash(r io.Reader, size int64) (Hashes, error) {
+	h := Hashes{PieceLength: PieceLength(size)}
+	piece := make([]byte, h.PieceLength)
+	var pieces, layer strings.Builder
+	var leaves, layerNodes []node
+	for {
+		n, err := io.ReadFull(r, piece)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return h, err
+		}
 
-func CreateTorrent(fileName string, f io.Reader, r *http.Request) ([]byte, error) {
-	url := headers.GetSelifURL(r, fileName)
-	chunk := make([]byte, PieceLength)
+		h.Length += int64(n)
+		sum := sha1.Sum(piece[:n]) //nolint:gosec
+		pieces.Write(sum[:])
 
-	t := Torrent{
-		Encoding: "UTF-8",
-		Info: Info{
-			PieceLength: PieceLength,
-			Name:        fileName,
-		},
-		URLList: []string{url.String()},
+		leaves = leaves[:0]
+		for block := range slices.Chunk(piece[:n], BlockSize) {
+			leaves = append(leaves, sha256.Sum256(block))
+		}
+		layerNodes = append(layerNodes, merkleRoot(leaves, int(h.PieceLength/BlockSize)))
+
+		if n < len(piece) {
+			break
+		}
 	}
+	h.Pieces = pieces.String()
+
+	switch len(layerNodes) {
+	case 0:
+		// Empty files have no pieces.
+	case 1:
+		// A file which fits in one piece has a tree which is only as large as its blocks need.
+		root := merkleRoot(leaves, nextPowerOfTwo(len(leaves)))
+		h.PiecesRoot = string(root[:])
+	default:
+		for _, n := range layerNodes {
+			layer.Write(n[:])
+		}
+		h.PieceLayer = layer.String()
+		root := merkleRootPadded(layerNodes, padHash(int(h.PieceLength/BlockSize)))
+		h.PiecesRoot = string(root[:])
+	}
+	return h, nil
+}
 
-	for {
-		n, err := io.ReadFull(f, chunk)
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else if !errors.Is(err, io.ErrUnexpectedEOF) {
-				return []byte{}, err
-			}
+type node = [sha256.Size]byte
+
+// merkleRoot returns the root of a tree with the given number of leaves, which must be a power of two.
+// Missing leaves are zero.
+func merkleRoot(leaves []node, width int) node {
+	layer := make([]node, width)
+	copy(layer, leaves)
+	return merkleRootPadded(layer, node{})
+}
+
+// merkleRootPadded returns the root of a tree whose layer is padded with pad to a power of two.
+// The pad is doubled for each layer above.
+func merkleRootPadded(layer []node, pad node) node {
+	layer = append([]node(nil), layer...)
+	for len(layer) > 1 {
+		if len(layer)%2 != 0 {
+			layer = append(layer, pad)
+		}
+		next := layer[:0]
+		for i := 0; i < len(layer); i += 2 {
+			next = append(next, hashPair(layer[i], layer[i+1]))
 		}
+		layer = next
+		pad = hashPair(pad, pad)
+	}
+	return layer[0]
+}
 
-		t.Info.Length += n
-		t.Info.Pieces += string(HashPiece(chunk[:n]))
+// padHash returns the root of a subtree with the given number of zero leaves.
+func padHash(width int) node {
+	var pad node
+	for ; width > 1; width /= 2 {
+		pad = hashPair(pad, pad)
 	}
+	return pad
+}
 
-	data, err := bencode.EncodeBytes(&t)
-	if err != nil {
-		return []byte{}, err
+func hashPair(a, b node) node {
+	return sha256.Sum256(append(a[:], b[:]...))
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p *= 2
 	}
+	return p
+}
 
-	return data, nil
+// Info is the info dict of a hybrid torrent, which is valid for both v1 and v2 clients.
+type Info struct {
+	Name        string `bencode:"name"`
+	PieceLength int64  `bencode:"piece length"`
+	// Pieces and Length describe the file for v1 clients.
+	Pieces string `bencode:"pieces"`
+	Length int64  `bencode:"length"`
+	// MetaVersion and FileTree describe the file for v2 clients.
+	MetaVersion int                                 `bencode:"meta version"`
+	FileTree    map[string]map[string]FileTreeEntry `bencode:"file tree"`
 }
 
-func FileTorrentHandler(w http.ResponseWriter, r *http.Request) {
-	fileName := chi.URLParam(r, "name")
+// FileTreeEntry describes a file in a v2 file tree. It is stored under an empty key below the file's name.
+type FileTreeEntry struct {
+	Length     int64  `bencode:"length"`
+	PiecesRoot string `bencode:"pieces root,omitempty"`
+}
 
-	metadata, f, err := config.StorageBackend.Get(r.Context(), fileName)
-	if err != nil {
-		switch {
-		case errors.Is(err, backends.ErrNotFound):
-			handlers.ErrorMsg(w, r, http.StatusNotFound, "File not found")
-			return
-		case errors.Is(err, backends.ErrBadMetadata):
-			slog.Error("Corrupt metadata", "path", fileName, "error", err) //nolint:gosec
-			handlers.ErrorMsg(w, r, http.StatusInternalServerError, "Corrupt metadata")
-			return
-		default:
-			slog.Error("Failed to get file", "path", fileName, "error", err) //nolint:gosec
-			handlers.Error(w, r, http.StatusInternalServerError)
-			return
-		}
+func NewInfo(name string, h Hashes) Info {
+	return Info{
+		Name:        name,
+		PieceLength: h.PieceLength,
+		Pieces:      h.Pieces,
+		Length:      h.Length,
+		MetaVersion: 2,
+		FileTree: map[string]map[string]FileTreeEntry{
+			name: {"": {Length: h.Length, PiecesRoot: h.PiecesRoot}},
+		},
 	}
-	defer func() {
-		_ = f.Close()
-	}()
-
-	if metadata.Expired() {
-		//nolint:gosec // Intentional async cleanup; delete should not block the response.
-		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-			defer cancel()
-
-			if err := config.StorageBackend.Delete(ctx, fileName); err != nil {
-				slog.Error("Failed to delete expired file", "path", fileName)
-			}
-		}()
+}
 
-		handlers.ErrorMsg(w, r, http.StatusNotFound, "File not found")
-		return
+// InfoHashes returns the v1 and v2 hashes of the info dict, which identify the torrent.
+func (i Info) InfoHashes() ([sha1.Size]byte, [sha256.Size]byte, error) {
+	b, err := bencode.EncodeBytes(i)
+	if err != nil {
+		return [sha1.Size]byte{}, [sha256.Size]byte{}, err
 	}
+	return sha1.Sum(b), sha256.Sum256(b), nil //nolint:gosec
+}
 
-	if metadata.OriginalName != "" {
-		fileName = metadata.OriginalName
+type Torrent struct {
+	Announce     string     `bencode:"announce,omitempty"`
+	AnnounceList [][]string `bencode:"announce-list,omitempty"`
+	CreationDate int64      `bencode:"creation date,omitempty"`
+	Encoding     string     `bencode:"encoding"`
+	Info         Info       `bencode:"info"`
+	// PieceLayers maps the file's pieces root to its piece layer, for files which are larger than one piece.
+	PieceLayers map[string]string `bencode:"piece layers,omitempty"`
+	URLList     []string          `bencode:"url-list"`
+}
+
+// New creates a torrent of a file which is web seeded from seedURL.
+// Each tracker is in its own tier, so clients try them in order.
+func New(name string, h Hashes, seedURL string, trackers []string) Torrent {
+	t := Torrent{
+		Encoding: "UTF-8",
+		Info:     NewInfo(name, h),
+		URLList:  []string{seedURL},
 	}
+	if h.PieceLayer != "" {
+		t.PieceLayers = map[string]string{h.PiecesRoot: h.PieceLayer}
+	}
+	if len(trackers) != 0 {
+		t.Announce = trackers[0]
+		t.AnnounceList = make([][]string, 0, len(trackers))
+		for _, tracker := range trackers {
+			t.AnnounceList = append(t.AnnounceList, []string{tracker})
+		}
+	}
+	return t
+}
 
-	encoded, err := CreateTorrent(fileName, f, r)
+func (t Torrent) Encode() ([]byte, error) {
+	return bencode.EncodeBytes(t)
+}
+
+// Magnet returns a magnet URI of a file's torrent, with both the v1 and v2 info hashes.
+func Magnet(name string, h Hashes, seedURL string, trackers []string) (string, error) {
+	v1, v2, err := NewInfo(name, h).InfoHashes()
 	if err != nil {
-		handlers.ErrorMsg(w, r, http.StatusInternalServerError, "Could not create torrent")
-		return
+		return "", err
 	}
 
-	w.Header().Set(`Content-Disposition`, util.EncodeContentDisposition("attachment", fileName+".torrent"))
-	http.ServeContent(w, r, "", time.Now(), bytes.NewReader(encoded))
+	var b strings.Builder
+	b.WriteString("magnet:?xt=urn:btih:")
+	b.WriteString(hex.EncodeToString(v1[:]))
+	// 0x12 is the multihash code of SHA-256 and 0x20 is its length
+	b.WriteString("&xt=urn:btmh:1220")
+	b.WriteString(hex.EncodeToString(v2[:]))
+	b.WriteString("&dn=")
+	b.WriteString(url.QueryEscape(name))
+	for _, tracker := range trackers {
+		b.WriteString("&tr=")
+		b.WriteString(url.QueryEscape(tracker))
+	}
+	b.WriteString("&ws=")
+	b.WriteString(url.QueryEscape(seedURL))
+	return b.String(), nil
 }
@@ -2,60 +2,152 @@ package torrent
 
 import (
 	"bytes"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"encoding/hex"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/png"
-	"os"
-	"path"
+	"io/fs"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
-	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/localfs/localfstest"
+	"gabe565.com/utils/bytefmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/zeebo/bencode"
 )
 
-func TestCreateTorrent(t *testing.T) {
-	var decoded Torrent
+func TestPieceLength(t *testing.T) {
+	assert.EqualValues(t, MinPieceLength, PieceLength(4))
+	assert.EqualValues(t, bytefmt.MiB, PieceLength(bytefmt.GiB))
+	assert.EqualValues(t, MaxPieceLength, PieceLength(1024*bytefmt.GiB))
+}
 
-	tmp := t.TempDir()
-	tmpFile := filepath.Join(tmp, "test.txt")
-	err := os.WriteFile(tmpFile, []byte("test"), 0o600)
+func TestHash(t *testing.T) {
+	h, err := Hash(strings.NewReader("test"), 4)
 	require.NoError(t, err)
 
-	f, err := os.Open(tmpFile)
+	v1 := sha1.Sum([]byte("test")) //nolint:gosec
+	v2 := sha256.Sum256([]byte("test"))
+	assert.EqualValues(t, MinPieceLength, h.PieceLength)
+	assert.EqualValues(t, 4, h.Length)
+	assert.Equal(t, string(v1[:]), h.Pieces)
+	// A file with a single block is the root of its own tree
+	assert.Equal(t, string(v2[:]), h.PiecesRoot)
+	assert.Empty(t, h.PieceLayer)
+}
+
+// naiveRoot computes a merkle root over every block, padded with zero leaves to a power of two.
+func naiveRoot(b []byte) node {
+	var layer []node
+	for len(b) != 0 {
+		n := min(BlockSize, len(b))
+		layer = append(layer, sha256.Sum256(b[:n]))
+		b = b[n:]
+	}
+	layer = append(layer, make([]node, nextPowerOfTwo(len(layer))-len(layer))...)
+	for len(layer) > 1 {
+		var next []node
+		for i := 0; i < len(layer); i += 2 {
+			next = append(next, hashPair(layer[i], layer[i+1]))
+		}
+		layer = next
+	}
+	return layer[0]
+}
+
+func TestHashPieceLayer(t *testing.T) {
+	for _, size := range []int{MinPieceLength + 1, 2*MinPieceLength + BlockSize*3 + 5, 5 * MinPieceLength} {
+		b := bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+
+		h, err := Hash(bytes.NewReader(b), int64(size))
+		require.NoError(t, err)
+		pieces := (size + MinPieceLength - 1) / MinPieceLength
+		assert.Len(t, h.Pieces, pieces*sha1.Size)
+		assert.Len(t, h.PieceLayer, pieces*sha256.Size)
+
+		root := naiveRoot(b)
+		assert.Equal(t, string(root[:]), h.PiecesRoot, size)
+	}
+}
+
+func TestNew(t *testing.T) {
+	b := bytes.Repeat([]byte("a"), MinPieceLength+1)
+	h, err := Hash(bytes.NewReader(b), int64(len(b)))
 	require.NoError(t, err)
-	t.Cleanup(func() { _ = f.Close() })
 
-	encoded, err := CreateTorrent(filepath.Base(tmpFile), f, nil)
+	trackers := []string{"udp://tracker.example.org:1337/announce", "https://tracker.example.com/announce"}
+	encoded, err := New("test.txt", h, "https://linx.example.org/selif/test.txt", trackers).Encode()
 	require.NoError(t, err)
 
+	var decoded Torrent
 	require.NoError(t, bencode.DecodeBytes(encoded, &decoded))
-
 	assert.Equal(t, "UTF-8", decoded.Encoding)
-	assert.Equal(t, filepath.Base(tmpFile), decoded.Info.Name)
-	assert.NotZero(t, decoded.Info.PieceLength, "expected a piece length")
-	assert.NotEmpty(t, decoded.Info.Pieces, "expected at least one piece")
-	assert.NotZero(t, decoded.Info.Length, "invalid length")
-
-	tracker := config.Default.SiteURL.URL
-	tracker.Path = path.Join(tracker.Path, config.Default.SelifPath, filepath.Base(tmpFile))
-	assert.Equal(t, tracker.String(), decoded.URLList[0])
+	assert.Equal(t, "test.txt", decoded.Info.Name)
+	assert.EqualValues(t, len(b), decoded.Info.Length)
+	assert.Equal(t, 2, decoded.Info.MetaVersion)
+	assert.Equal(t, FileTreeEntry{Length: int64(len(b)), PiecesRoot: h.PiecesRoot}, decoded.Info.FileTree["test.txt"][""])
+	assert.Equal(t, map[string]string{h.PiecesRoot: h.PieceLayer}, decoded.PieceLayers)
+	assert.Equal(t, trackers[0], decoded.Announce)
+	assert.Equal(t, [][]string{{trackers[0]}, {trackers[1]}}, decoded.AnnounceList)
+	assert.Equal(t, []string{"https://linx.example.org/selif/test.txt"}, decoded.URLList)
+
+	magnet, err := Magnet("test.txt", h, "https://linx.example.org/selif/test.txt", trackers)
+	require.NoError(t, err)
+	v1, v2, err := decoded.Info.InfoHashes()
+	require.NoError(t, err)
+	assert.Contains(t, magnet, "xt=urn:btih:"+hex.EncodeToString(v1[:]))
+	assert.Contains(t, magnet, "xt=urn:btmh:1220"+hex.EncodeToString(v2[:]))
+	assert.Contains(t, magnet, "tr=udp%3A%2F%2Ftracker.example.org%3A1337%2Fannounce")
 }
 
 func TestCreateTorrentWithImage(t *testing.T) {
-	var decoded Torrent
-
 	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
 	draw.Draw(img, img.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
 	var buf bytes.Buffer
 	require.NoError(t, png.Encode(&buf, img))
 
-	encoded, err := CreateTorrent("test.png", &buf, nil)
+	h, err := Hash(&buf, int64(buf.Len()))
 	require.NoError(t, err)
+	assert.Equal(t, "\x1f?\xe6a#\xe3wIi\xf5}\xf2\x87X\x89\r\xf8t\xdc\xc0", h.Pieces)
+}
 
-	require.NoError(t, bencode.DecodeBytes(encoded, &decoded))
-	assert.Equal(t, "\x1f?\xe6a#\xe3wIi\xf5}\xf2\x87X\x89\r\xf8t\xdc\xc0", decoded.Info.Pieces)
+func TestCache(t *testing.T) {
+	backend := localfstest.New(t)
+
+	m, err := backend.Put(t.Context(), strings.NewReader("test"), "test.txt", 0, backends.PutOptions{})
+	require.NoError(t, err)
+
+	cache, err := NewCache(filepath.Join(t.TempDir(), "torrents"))
+	require.NoError(t, err)
+	b := WrapBackend(backend, cache)
+
+	_, err = cache.Cached("test.txt", m)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	h, err := cache.Hashes(t.Context(), b, "test.txt", m)
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, h.Length)
+
+	cached, err := cache.Cached("test.txt", m)
+	require.NoError(t, err)
+	assert.Equal(t, h, cached)
+
+	// Updating the metadata keeps the cached hashes
+	m.ModTime = m.ModTime.Add(time.Hour)
+	require.NoError(t, b.PutMetadata(t.Context(), "test.txt", m))
+	cached, err = cache.Cached("test.txt", m)
+	require.NoError(t, err)
+	assert.Equal(t, h, cached)
+
+	// Deleting the upload removes its hashes
+	require.NoError(t, b.Delete(t.Context(), "test.txt"))
+	_, err = cache.Cached("test.txt", m)
+	require.ErrorIs(t, err, fs.ErrNotExist)
 }
@@ -0,0 +1,85 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/collection"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/webhook"
+)
+
+var ErrCollectionUnsupported = errors.New("collections can't be encrypted or have a download limit")
+
+type CollectionJSONResponse struct {
+	JSONResponse
+	Files []JSONResponse `json:"files"`
+}
+
+// CollectionJSONResponse describes a collection and the uploads it was created with.
+// Its direct URL downloads every file in a zip archive.
+func (u Upload) CollectionJSONResponse(r *http.Request, uploads []Upload) CollectionJSONResponse {
+	res := CollectionJSONResponse{
+		JSONResponse: u.JSONResponse(r),
+		Files:        make([]JSONResponse, 0, len(uploads)),
+	}
+	var size int64
+	for _, upload := range uploads {
+		res.Files = append(res.Files, upload.JSONResponse(r))
+		size += upload.Metadata.Size
+	}
+	res.Size = strconv.FormatInt(size, 10)
+	return res
+}
+
+// checkCollection rejects options which can't apply to a collection.
+// Encrypted files can't be zipped, and a zip download would use up every file's download limit at once.
+func checkCollection(upReq Request) error {
+	if upReq.encrypted || upReq.maxDownloads != 0 {
+		return ErrCollectionUnsupported
+	}
+	return nil
+}
+
+// CreateCollection stores a collection of uploads which were created by the same request.
+// The collection shares the uploads' delete key, access key and expiry.
+// If name is empty, a random name is generated.
+func CreateCollection(ctx context.Context, upReq Request, name string, uploads []Upload) (Upload, error) {
+	manifest := collection.Manifest{Files: make([]collection.File, 0, len(uploads))}
+	for _, upload := range uploads {
+		manifest.Files = append(manifest.Files, collection.File{
+			Name:     upload.Filename,
+			Checksum: upload.Metadata.Checksum,
+		})
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	upReq.src = bytes.NewReader(b)
+	upReq.size = int64(len(b))
+	upReq.filename = name + "." + collection.Extension
+	upReq.randomBarename = upReq.randomBarename || name == ""
+	upReq.stripExif = false
+	upReq.mimetype = backends.CollectionMimetype
+	return Process(ctx, upReq)
+}
+
+// discardUploads deletes the files which were stored before a collection failed.
+func discardUploads(ctx context.Context, uploads []Upload) {
+	ctx = context.WithoutCancel(ctx)
+	for _, upload := range uploads {
+		if err := config.StorageBackend.Delete(ctx, upload.Filename); err != nil {
+			slog.Error("Failed to delete file from failed collection", "path", upload.Filename, "error", err)
+			continue
+		}
+		config.Hooks.Send(ctx, webhook.EventDelete, upload.Filename, upload.Metadata)
+	}
+}
@@ -0,0 +1,34 @@
+package upload
+
+import (
+	"context"
+
+	"gabe565.com/linx-server/internal/auth/apikeys"
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/helpers"
+)
+
+// checkContentType rejects uploads which the content type policy doesn't accept.
+// The key which authenticated an upload may exempt some mimetypes and extensions.
+// Encrypted uploads can only be checked as the encrypted mimetype, since their content is unknown.
+func checkContentType(ctx context.Context, upReq *Request, extension string) error {
+	policy := config.Default().ContentTypes.Policy()
+	if key, ok := apikeys.KeyFromContext(ctx); ok {
+		policy = policy.Exempt(key.AllowMimetypes, key.AllowExtensions)
+	}
+
+	var mimetype string
+	switch {
+	case upReq.encrypted:
+		mimetype = backends.EncryptedMimetype
+	case policy.ChecksMimetype():
+		kind, src, err := helpers.DetectMimetype(upReq.src)
+		if err != nil {
+			return err
+		}
+		upReq.src = src
+		mimetype = kind.String()
+	}
+	return policy.Check(mimetype, extension)
+}
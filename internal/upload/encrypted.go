@@ -0,0 +1,29 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/e2e"
+)
+
+// checkEncrypted verifies that an encrypted upload starts with a valid header,
+// so plaintext can't be stored with the encrypted flag. The rest of the file can only be verified by the client.
+func checkEncrypted(upReq *Request) error {
+	header := make([]byte, e2e.HeaderSize)
+	n, err := io.ReadFull(upReq.src, header)
+	upReq.src = io.MultiReader(bytes.NewReader(header[:n]), upReq.src)
+	switch {
+	case errors.Is(err, io.EOF):
+		return backends.ErrFileEmpty
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return e2e.ErrInvalidHeader
+	case err != nil:
+		return err
+	}
+
+	_, err = e2e.ParseHeader(header)
+	return err
+}
@@ -0,0 +1,50 @@
+package upload
+
+import (
+	"io"
+	"os"
+
+	"gabe565.com/linx-server/internal/exif"
+	"gabe565.com/linx-server/internal/helpers"
+)
+
+// stripExif removes metadata from image uploads before they are stored.
+// The stripped image is staged in a temp file so that its final size is known before it is written to the backend.
+// The returned func removes the temp file.
+func stripExif(upReq *Request) (func(), error) {
+	kind, src, err := helpers.DetectMimetype(upReq.src)
+	if err != nil {
+		return nil, err
+	}
+	upReq.src = src
+	if !exif.Supported(kind.String()) {
+		return func() {}, nil
+	}
+
+	f, err := os.CreateTemp("", "linx-strip-*")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+
+	if err := exif.Strip(f, upReq.src, kind.String()); err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	upReq.src = f
+	upReq.size = size
+	return cleanup, nil
+}
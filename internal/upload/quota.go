@@ -0,0 +1,66 @@
+package upload
+
+import (
+	"context"
+	"net/http"
+
+	"gabe565.com/linx-server/internal/auth/apikeys"
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/quota"
+)
+
+//nolint:gochecknoglobals
+var pendingQuota quota.Pending
+
+// applyLimits enforces the limits of the key which authenticated an upload.
+// replaced is the existing upload which will be overwritten, if any.
+// The upload counts against the key's quota until release is called, which must happen once it is stored.
+func applyLimits(
+	ctx context.Context,
+	key apikeys.Key,
+	upReq *Request,
+	replaced *backends.Metadata,
+) (func(), error) {
+	limits := key.Limits
+
+	if limits.MaxSize != 0 {
+		if upReq.size > limits.MaxSize {
+			return nil, &http.MaxBytesError{Limit: limits.MaxSize}
+		}
+		upReq.src = quota.LimitReader(upReq.src, limits.MaxSize, &http.MaxBytesError{Limit: limits.MaxSize})
+	}
+
+	if limits.MaxExpiry != 0 && (upReq.expiry == 0 || upReq.expiry > limits.MaxExpiry) {
+		upReq.expiry = limits.MaxExpiry
+	}
+
+	if limits.MaxBytes == 0 && limits.MaxFiles == 0 {
+		return func() {}, nil
+	}
+
+	return pendingQuota.Reserve(ctx, config.StorageBackend, key.ID(), func(usage backends.Usage) (backends.Usage, error) {
+		if replaced != nil && replaced.Uploader == key.ID() {
+			usage.Files--
+			usage.Bytes -= replaced.Size
+		}
+
+		if limits.MaxFiles != 0 && usage.Files >= limits.MaxFiles {
+			return backends.Usage{}, quota.ErrExceeded
+		}
+
+		// An upload of unknown size may use the rest of the quota.
+		reserve := backends.Usage{Files: 1, Bytes: upReq.size}
+		if limits.MaxBytes != 0 {
+			remaining := limits.MaxBytes - usage.Bytes
+			if remaining <= 0 || upReq.size > remaining {
+				return backends.Usage{}, quota.ErrExceeded
+			}
+			upReq.src = quota.LimitReader(upReq.src, remaining, quota.ErrExceeded)
+			if upReq.size <= 0 {
+				reserve.Bytes = remaining
+			}
+		}
+		return reserve, nil
+	})
+}
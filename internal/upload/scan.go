@@ -0,0 +1,74 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/scan"
+)
+
+// scanUpload runs the configured malware scanners before an upload is stored.
+// Uploads which cannot be rewound are staged in a temp file first. The returned func removes the temp file.
+func scanUpload(ctx context.Context, upReq *Request) (func(), error) {
+	cleanup := func() {}
+	src, ok := upReq.src.(io.ReadSeeker)
+	if !ok {
+		f, err := os.CreateTemp("", "linx-scan-*")
+		if err != nil {
+			return nil, err
+		}
+		cleanup = func() {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+
+		_, err = io.Copy(f, upReq.src)
+		if err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		upReq.src, src = f, f
+	}
+
+	start, err := src.Seek(0, io.SeekCurrent)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	scanCtx := ctx
+	if timeout := config.Default().Scan.Timeout.Duration; timeout > 0 {
+		var cancel context.CancelFunc
+		scanCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err = config.Scanner.Scan(scanCtx, src)
+	if _, seekErr := src.Seek(start, io.SeekStart); seekErr != nil {
+		cleanup()
+		return nil, seekErr
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, scan.ErrInfected):
+		slog.Warn("Rejected infected upload", "name", upReq.filename, "error", err) //nolint:gosec
+		cleanup()
+		return nil, err
+	case config.Default().Scan.FailOpen:
+		slog.Error("Failed to scan upload; storing it anyway", "name", upReq.filename, "error", err) //nolint:gosec
+	default:
+		slog.Error("Failed to scan upload", "name", upReq.filename, "error", err) //nolint:gosec
+		cleanup()
+		return nil, fmt.Errorf("%w: %w", scan.ErrUnavailable, err)
+	}
+	return cleanup, nil
+}
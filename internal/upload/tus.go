@@ -0,0 +1,303 @@
+package upload
+
+import (
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/handlers"
+	"gabe565.com/linx-server/internal/headers"
+	"gabe565.com/linx-server/internal/partial"
+	"github.com/dchest/uniuri"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	TusVersion    = "1.0.0"
+	TusExtensions = "creation,termination,expiration"
+	TusPath       = "api/tus"
+
+	tusOffsetContentType = "application/offset+octet-stream"
+)
+
+// Headers which are captured when a resumable upload is created and applied once it completes.
+//
+//nolint:gochecknoglobals
+var tusCapturedHeaders = []string{
+	"Linx-Delete-Key", "Linx-Expiry", "Linx-Randomize", "Linx-Strip-Exif", "Linx-Max-Downloads", "Linx-Encrypted",
+	handlers.AccessKeyHeader,
+}
+
+func partialStore() partial.Store {
+	return partial.New(config.Default().PartialsPath)
+}
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", TusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// TusMiddleware rejects requests made with an unsupported version of the tus protocol.
+func TusMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setTusHeaders(w)
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != TusVersion {
+			w.Header().Set("Tus-Version", TusVersion)
+			handlers.ErrorMsg(w, r, http.StatusPreconditionFailed, "Unsupported tus version")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TusOptionsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Tus-Version", TusVersion)
+	w.Header().Set("Tus-Extension", TusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(int64(config.Default().MaxSize), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TusCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		handlers.ErrorMsg(w, r, http.StatusBadRequest, "Deferred length is not supported")
+		return
+	}
+
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		handlers.ErrorMsg(w, r, http.StatusBadRequest, "Upload-Length must be a positive integer")
+		return
+	}
+	if size == 0 {
+		HandleProcessError(w, r, backends.ErrFileEmpty)
+		return
+	}
+	if size > int64(config.Default().MaxSize) {
+		HandleProcessError(w, r, &http.MaxBytesError{Limit: int64(config.Default().MaxSize)})
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		handlers.ErrorMsg(w, r, http.StatusBadRequest, "Invalid Upload-Metadata")
+		return
+	}
+
+	info := partial.Info{
+		ID:       uniuri.NewLen(32),
+		Size:     size,
+		Metadata: metadata,
+		Header:   make(http.Header, len(tusCapturedHeaders)),
+	}
+	if config.Default().PartialExpiry.Duration != 0 {
+		info.Expiry = time.Now().Add(config.Default().PartialExpiry.Duration)
+	}
+	for _, k := range tusCapturedHeaders {
+		if v := r.Header.Get(k); v != "" {
+			info.Header.Set(k, v)
+		}
+	}
+
+	if err := partialStore().Create(info); err != nil {
+		slog.Error("Failed to create partial upload", "error", err)
+		handlers.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	u := headers.GetSiteURL(r)
+	u.Path = path.Join(u.Path, TusPath, info.ID)
+	w.Header().Set("Location", u.String())
+	setUploadExpires(w, info)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func TusHeadHandler(w http.ResponseWriter, r *http.Request) {
+	info, offset, ok := headPartial(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Size, 10))
+	setUploadExpires(w, info)
+	w.WriteHeader(http.StatusOK)
+}
+
+func TusPatchHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Content-Type"), tusOffsetContentType) {
+		handlers.ErrorMsg(w, r, http.StatusUnsupportedMediaType, "Content-Type must be "+tusOffsetContentType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		handlers.ErrorMsg(w, r, http.StatusBadRequest, "Upload-Offset must be a positive integer")
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	lock, ok := tryLockPartial(w, r, id)
+	if !ok {
+		return
+	}
+	defer lock.Unlock()
+
+	info, _, ok := headPartial(w, r)
+	if !ok {
+		partial.Forget(id)
+		return
+	}
+
+	store := partialStore()
+	newOffset, err := store.Append(id, offset, r.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, partial.ErrOffsetMismatch):
+			handlers.ErrorMsg(w, r, http.StatusConflict, "Upload-Offset does not match")
+		case newOffset != offset:
+			// Keep what was received so the client can resume.
+			w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+			HandleProcessError(w, r, err)
+		default:
+			HandleProcessError(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	setUploadExpires(w, info)
+
+	if newOffset < info.Size {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	upload, err := completePartial(r, store, info)
+	if err != nil {
+		HandleProcessError(w, r, err)
+		return
+	}
+
+	res := upload.JSONResponse(r)
+	w.Header().Set("Linx-Url", res.URL)
+	w.Header().Set("Linx-Direct-Url", res.DirectURL)
+	w.Header().Set("Linx-Filename", res.Filename)
+	w.Header().Set("Linx-Delete-Key", res.DeleteKey)
+	w.Header().Set("Linx-Expiry", res.Expiry)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TusDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	lock, ok := tryLockPartial(w, r, id)
+	if !ok {
+		return
+	}
+	defer lock.Unlock()
+
+	if err := partialStore().Delete(id); err != nil {
+		if errors.Is(err, partial.ErrNotFound) {
+			handlers.ErrorMsg(w, r, http.StatusNotFound, "Upload not found")
+			return
+		}
+		slog.Error("Failed to delete partial upload", "error", err)
+		handlers.Error(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tryLockPartial locks a partial upload, or responds with 423 Locked if another request holds the lock.
+func tryLockPartial(w http.ResponseWriter, r *http.Request, id string) (*sync.Mutex, bool) {
+	lock, ok := partial.TryLock(id)
+	if !ok {
+		handlers.ErrorMsg(w, r, http.StatusLocked, "Upload is already in progress")
+		return nil, false
+	}
+	return lock, true
+}
+
+func headPartial(w http.ResponseWriter, r *http.Request) (partial.Info, int64, bool) {
+	info, offset, err := partialStore().Head(chi.URLParam(r, "id"))
+	switch {
+	case errors.Is(err, partial.ErrNotFound) || (err == nil && info.Expired()):
+		handlers.ErrorMsg(w, r, http.StatusNotFound, "Upload not found")
+		return info, offset, false
+	case err != nil:
+		slog.Error("Failed to read partial upload", "error", err)
+		handlers.Error(w, r, http.StatusInternalServerError)
+		return info, offset, false
+	}
+	return info, offset, true
+}
+
+// completePartial processes a finished resumable upload and removes it from the staging area.
+func completePartial(r *http.Request, store partial.Store, info partial.Info) (Upload, error) {
+	f, err := store.Open(info.ID)
+	if err != nil {
+		return Upload{}, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	upReq := Request{
+		src:  f,
+		size: info.Size,
+	}
+	headerProcess(info.Header, &upReq)
+	upReq.filename = info.Metadata["filename"]
+	if upReq.filename == "" {
+		upReq.filename = info.Metadata["name"]
+	}
+
+	upload, err := Process(r.Context(), upReq)
+	if err != nil {
+		return upload, err
+	}
+
+	_ = f.Close()
+	if err := store.Delete(info.ID); err != nil {
+		slog.Error("Failed to remove completed partial upload", "error", err)
+	}
+	return upload, nil
+}
+
+func setUploadExpires(w http.ResponseWriter, info partial.Info) {
+	if !info.Expiry.IsZero() {
+		w.Header().Set("Upload-Expires", info.Expiry.UTC().Format(http.TimeFormat))
+	}
+}
+
+var errInvalidTusMetadata = errors.New("invalid upload metadata")
+
+// parseTusMetadata decodes an Upload-Metadata header into a map.
+func parseTusMetadata(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	pairs := strings.Split(s, ",")
+	metadata := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		k, v, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if k == "" {
+			return nil, errInvalidTusMetadata
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, errInvalidTusMetadata
+		}
+		metadata[k] = string(decoded)
+	}
+	return metadata, nil
+}
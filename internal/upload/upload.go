@@ -19,14 +19,23 @@ import (
 	"time"
 
 	"gabe565.com/linx-server/assets"
+	"gabe565.com/linx-server/internal/auth/apikeys"
 	"gabe565.com/linx-server/internal/auth/keyhash"
 	"gabe565.com/linx-server/internal/backends"
+	"gabe565.com/linx-server/internal/backends/capacity"
 	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/contenttype"
 	"gabe565.com/linx-server/internal/csrf"
+	"gabe565.com/linx-server/internal/e2e"
+	"gabe565.com/linx-server/internal/exif"
 	"gabe565.com/linx-server/internal/handlers"
 	"gabe565.com/linx-server/internal/headers"
 	"gabe565.com/linx-server/internal/helpers"
+	"gabe565.com/linx-server/internal/metrics"
+	"gabe565.com/linx-server/internal/quota"
+	"gabe565.com/linx-server/internal/scan"
 	"gabe565.com/linx-server/internal/util"
+	"gabe565.com/linx-server/internal/webhook"
 	"gabe565.com/utils/bytefmt"
 	"github.com/dchest/uniuri"
 	"github.com/gabriel-vasile/mimetype"
@@ -50,6 +59,10 @@ type Request struct {
 	deleteKey      string        // Empty string if not defined
 	randomBarename bool
 	accessKey      string // Empty string if not defined
+	stripExif      bool
+	maxDownloads   int // 0 = unlimited
+	encrypted      bool
+	mimetype       string // Stored instead of the detected type if set
 }
 
 // Metadata associated with a file as it would actually be stored.
@@ -91,17 +104,22 @@ func (u Upload) JSONResponse(r *http.Request) JSONResponse {
 
 func POSTHandler(w http.ResponseWriter, r *http.Request) {
 	siteURL := headers.GetSiteURL(r).String()
-	if !csrf.StrictReferrerCheck(r, siteURL,
-		[]string{"Linx-Delete-Key", "Linx-Expiry", "Linx-Randomize", "X-Requested-With"},
-	) {
+	if !csrf.StrictReferrerCheck(r, siteURL, []string{
+		"Linx-Delete-Key", "Linx-Expiry", "Linx-Randomize", "Linx-Strip-Exif", "Linx-Max-Downloads", "Linx-Encrypted",
+		"X-Requested-With",
+	}) {
 		handlers.Error(w, r, http.StatusBadRequest)
 		return
 	}
 
 	upReq := Request{
-		expiry: config.Default.MaxExpiry.Duration,
+		expiry: config.Default().MaxExpiry.Duration,
 	}
 	HeaderProcess(r, &upReq)
+	// Every file in a collection shares its delete key, so it is generated before the first file is stored.
+	if upReq.deleteKey == "" {
+		upReq.deleteKey = uniuri.NewLen(config.Default().RandomDeleteKeyLength)
+	}
 
 	multipart, err := r.MultipartReader()
 	if err != nil {
@@ -113,27 +131,62 @@ func POSTHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Options must be sent before the first file, since each file is stored as soon as it is read.
+	var (
+		uploads        []Upload
+		collectionName string
+		isCollection   bool
+	)
 	for {
 		part, err := multipart.NextPart()
 		if err != nil {
+			if errors.Is(err, io.EOF) && len(uploads) != 0 {
+				break
+			}
+			discardUploads(r.Context(), uploads)
 			HandleProcessError(w, r, err)
 			return
 		}
 
 		if part.FormName() == "file" {
-			upReq.src = part
-			upReq.filename = part.FileName()
-			break
+			if len(uploads) == 1 {
+				if err := checkCollection(upReq); err != nil {
+					discardUploads(r.Context(), uploads)
+					HandleProcessError(w, r, err)
+					return
+				}
+			}
+
+			fileReq := upReq
+			fileReq.src = part
+			fileReq.filename = part.FileName()
+			upload, err := Process(r.Context(), fileReq)
+			if err != nil {
+				discardUploads(r.Context(), uploads)
+				HandleProcessError(w, r, err)
+				return
+			}
+			uploads = append(uploads, upload)
+			// The size field describes one file, so later files are read to the end instead.
+			upReq.size = 0
+			continue
 		}
 
 		b, err := io.ReadAll(io.LimitReader(part, 32*bytefmt.KiB))
 		if err != nil {
+			discardUploads(r.Context(), uploads)
 			HandleProcessError(w, r, err)
 			return
 		}
 		_ = part.Close()
+		if len(uploads) != 0 {
+			continue
+		}
 
 		switch part.FormName() {
+		case "collection":
+			collectionName = string(b)
+			isCollection = true
 		case "size":
 			upReq.size, err = strconv.ParseInt(string(b), 10, 64)
 			if err != nil {
@@ -146,19 +199,40 @@ func POSTHandler(w http.ResponseWriter, r *http.Request) {
 			upReq.accessKey = string(b)
 		case "randomize":
 			upReq.randomBarename = util.ParseBool(string(b), false)
+		case "strip_exif":
+			upReq.stripExif = util.ParseBool(string(b), false)
+		case "max_downloads":
+			upReq.maxDownloads = ParseMaxDownloads(string(b))
+		case "encrypted":
+			upReq.encrypted = util.ParseBool(string(b), false)
 		}
 	}
 
-	if upReq.src == nil {
-		HandleProcessError(w, r, backends.ErrFileEmpty)
-		return
-	}
+	if len(uploads) > 1 || isCollection {
+		if err := checkCollection(upReq); err != nil {
+			discardUploads(r.Context(), uploads)
+			HandleProcessError(w, r, err)
+			return
+		}
 
-	upload, err := Process(r.Context(), upReq)
-	if err != nil {
-		HandleProcessError(w, r, err)
+		coll, err := CreateCollection(r.Context(), upReq, collectionName, uploads)
+		if err != nil {
+			discardUploads(r.Context(), uploads)
+			HandleProcessError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Vary", "Accept")
+		if strings.EqualFold("application/json", r.Header.Get("Accept")) {
+			w.Header().Set("Content-Type", "application/json")
+			//nolint:gosec // JSON response intentionally includes keys for client use.
+			_ = json.NewEncoder(w).Encode(coll.CollectionJSONResponse(r, uploads))
+		} else {
+			http.Redirect(w, r, headers.GetFileURL(r, coll.Filename).String(), http.StatusSeeOther)
+		}
 		return
 	}
+	upload := uploads[0]
 
 	w.Header().Set("Vary", "Accept")
 
@@ -198,30 +272,32 @@ func PUTHandler(w http.ResponseWriter, r *http.Request) {
 
 //nolint:gosec // Upload routes are wrapped with server.LimitBodySize(MaxSize).
 func Remote(w http.ResponseWriter, r *http.Request) {
-	if config.Default.Auth.RemoteFile != "" {
+	remoteKeys := apikeys.WithScope(config.Default().AuthKeys, apikeys.ScopeRemoteUpload)
+	if config.Default().Auth.RemoteFile != "" || len(remoteKeys) != 0 {
 		key := util.TryPathUnescape(r.FormValue("key"))
-		if key == "" && config.Default.Auth.Basic {
+		if key == "" && config.Default().Auth.Basic {
 			_, password, ok := r.BasicAuth()
 			if ok {
 				key = password
 			}
 		}
-		result, err := keyhash.CheckList(config.RemoteAuthKeys, key, "", false)
-		if err != nil || !result {
-			if config.Default.Auth.Basic {
+		authKey, ok, err := apikeys.Match(remoteKeys, key)
+		if err != nil || !ok || !authKey.Allows(r, time.Now()) {
+			if config.Default().Auth.Basic {
 				rs := ""
-				if config.Default.SiteName != "" {
-					rs = " realm=" + strconv.Quote(config.Default.SiteName)
+				if config.Default().SiteName != "" {
+					rs = " realm=" + strconv.Quote(config.Default().SiteName)
 				}
 				w.Header().Set("WWW-Authenticate", `Basic`+rs)
 			}
 			handlers.Error(w, r, http.StatusUnauthorized)
 			return
 		}
+		r = r.WithContext(apikeys.WithKey(r.Context(), authKey))
 	}
 
 	if r.FormValue("url") == "" {
-		http.Redirect(w, r, config.Default.SiteURL.String(), http.StatusSeeOther)
+		http.Redirect(w, r, config.Default().SiteURL.String(), http.StatusSeeOther)
 		return
 	}
 
@@ -278,11 +354,14 @@ func Remote(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	upReq.src = http.MaxBytesReader(w, resp.Body, int64(config.Default.MaxSize))
+	upReq.src = http.MaxBytesReader(w, resp.Body, int64(config.Default().MaxSize))
 	upReq.size = resp.ContentLength
 	upReq.deleteKey = r.FormValue("deletekey")
 	upReq.accessKey = r.FormValue(handlers.AccessKeyParam)
 	upReq.randomBarename = util.ParseBool(r.FormValue("randomize"), false)
+	upReq.stripExif = util.ParseBool(r.FormValue("strip_exif"), false)
+	upReq.maxDownloads = ParseMaxDownloads(r.FormValue("max_downloads"))
+	upReq.encrypted = util.ParseBool(r.FormValue("encrypted"), false)
 	upReq.expiry = ParseExpiry(r.FormValue("expiry"))
 
 	upload, err := Process(r.Context(), upReq)
@@ -309,13 +388,20 @@ func Remote(w http.ResponseWriter, r *http.Request) {
 }
 
 func HeaderProcess(r *http.Request, upReq *Request) {
-	upReq.randomBarename = util.ParseBool(r.Header.Get("Linx-Randomize"), false)
+	headerProcess(r.Header, upReq)
+}
 
-	upReq.deleteKey = util.TryPathUnescape(r.Header.Get("Linx-Delete-Key"))
-	upReq.accessKey = util.TryPathUnescape(r.Header.Get(handlers.AccessKeyHeader))
+func headerProcess(h http.Header, upReq *Request) {
+	upReq.randomBarename = util.ParseBool(h.Get("Linx-Randomize"), false)
+	upReq.stripExif = util.ParseBool(h.Get("Linx-Strip-Exif"), false)
+	upReq.maxDownloads = ParseMaxDownloads(h.Get("Linx-Max-Downloads"))
+	upReq.encrypted = util.ParseBool(h.Get("Linx-Encrypted"), false)
+
+	upReq.deleteKey = util.TryPathUnescape(h.Get("Linx-Delete-Key"))
+	upReq.accessKey = util.TryPathUnescape(h.Get(handlers.AccessKeyHeader))
 
 	// Get seconds until expiry. Non-integer responses never expire.
-	expStr := r.Header.Get("Linx-Expiry")
+	expStr := h.Get("Linx-Expiry")
 	upReq.expiry = ParseExpiry(expStr)
 }
 
@@ -324,15 +410,23 @@ var ErrProhibitedFilename = errors.New("prohibited filename")
 func Process(ctx context.Context, upReq Request) (Upload, error) {
 	var upload Upload
 
-	if upReq.size > int64(config.Default.MaxSize) {
-		return upload, &http.MaxBytesError{Limit: int64(config.Default.MaxSize)}
+	if upReq.size > int64(config.Default().MaxSize) {
+		return upload, &http.MaxBytesError{Limit: int64(config.Default().MaxSize)}
+	}
+
+	if upReq.encrypted {
+		if err := checkEncrypted(&upReq); err != nil {
+			return upload, err
+		}
+		// The real name is encrypted with the file, and any name sent in the clear could reveal what it contains.
+		upReq.filename = ""
 	}
 
 	// Determine the appropriate filename
 	barename, extension := BarePlusExt(upReq.filename)
 	var randomize bool
 
-	if config.Default.KeepOriginalFilename && !strings.HasPrefix(upReq.filename, ".") {
+	if config.Default().KeepOriginalFilename && !strings.HasPrefix(upReq.filename, ".") {
 		upload.OriginalName = upReq.filename
 	}
 
@@ -342,7 +436,9 @@ func Process(ctx context.Context, upReq Request) (Upload, error) {
 		randomize = true
 	}
 
-	if len(extension) == 0 {
+	if len(extension) == 0 && upReq.encrypted {
+		extension = e2e.Extension
+	} else if len(extension) == 0 {
 		// Determine the type of file from the file header
 		var kind *mimetype.MIME
 		var err error
@@ -360,6 +456,21 @@ func Process(ctx context.Context, upReq Request) (Upload, error) {
 
 	upload.Filename = barename + "." + extension
 
+	// Collections are created by the server, and each of their files was already checked.
+	if upReq.mimetype == "" {
+		if err := checkContentType(ctx, &upReq, extension); err != nil {
+			return upload, err
+		}
+	}
+
+	if !upReq.encrypted && (upReq.stripExif || config.Default().StripExif) {
+		cleanup, err := stripExif(&upReq)
+		if err != nil {
+			return upload, err
+		}
+		defer cleanup()
+	}
+
 	var exists, deleteKeyMatch bool
 	var existingMeta backends.Metadata
 	var err error
@@ -393,7 +504,22 @@ func Process(ctx context.Context, upReq Request) (Upload, error) {
 		upload.OriginalName = existingMeta.OriginalName
 	}
 
-	if !deleteKeyMatch && config.Default.ForceRandomFilename {
+	var uploader string
+	if key, ok := apikeys.KeyFromContext(ctx); ok {
+		uploader = key.ID()
+
+		var replaced *backends.Metadata
+		if deleteKeyMatch {
+			replaced = &existingMeta
+		}
+		release, err := applyLimits(ctx, key, &upReq, replaced)
+		if err != nil {
+			return upload, err
+		}
+		defer release()
+	}
+
+	if !deleteKeyMatch && config.Default().ForceRandomFilename {
 		randomize = true
 		exists = true
 	}
@@ -437,7 +563,7 @@ func Process(ctx context.Context, upReq Request) (Upload, error) {
 	salt := uniuri.NewLen(16)
 
 	if upReq.deleteKey == "" {
-		upReq.deleteKey = uniuri.NewLen(config.Default.RandomDeleteKeyLength)
+		upReq.deleteKey = uniuri.NewLen(config.Default().RandomDeleteKeyLength)
 	}
 	hashedDeleteKey, err := keyhash.Hash(upReq.deleteKey, salt, true)
 	if err != nil {
@@ -450,16 +576,34 @@ func Process(ctx context.Context, upReq Request) (Upload, error) {
 		}
 	}
 
+	if len(config.Scanner) != 0 {
+		cleanup, err := scanUpload(ctx, &upReq)
+		if err != nil {
+			return upload, err
+		}
+		defer cleanup()
+	}
+
+	mimeType := upReq.mimetype
+	if upReq.encrypted {
+		mimeType = backends.EncryptedMimetype
+	}
+
 	upload.Metadata, err = config.StorageBackend.Put(ctx, upReq.src, upload.Filename, upReq.size, backends.PutOptions{
-		OriginalName: upload.OriginalName,
-		Expiry:       fileExpiry,
-		DeleteKey:    hashedDeleteKey,
-		AccessKey:    storedAccessKey,
-		Salt:         salt,
+		OriginalName:  upload.OriginalName,
+		Expiry:        fileExpiry,
+		DeleteKey:     hashedDeleteKey,
+		AccessKey:     storedAccessKey,
+		Salt:          salt,
+		Uploader:      uploader,
+		DownloadsLeft: upReq.maxDownloads,
+		Mimetype:      mimeType,
+		Encrypted:     upReq.encrypted,
 	})
 	if err != nil {
 		return upload, err
 	}
+	config.Hooks.Send(ctx, webhook.EventUpload, upload.Filename, upload.Metadata)
 	upload.Metadata.DeleteKey = upReq.deleteKey
 	upload.Metadata.AccessKey = upReq.accessKey
 
@@ -468,27 +612,62 @@ func Process(ctx context.Context, upReq Request) (Upload, error) {
 
 func HandleProcessError(w http.ResponseWriter, r *http.Request, err error) {
 	_, isMaxBytes := errors.AsType[*http.MaxBytesError](err)
+	denied, isDenied := errors.AsType[*contenttype.DeniedError](err)
 	switch {
 	case isMaxBytes:
+		metrics.UploadFailed("too_large")
 		handlers.ErrorMsg(w, r, http.StatusRequestEntityTooLarge, "File too large")
 	case errors.Is(err, io.EOF):
+		metrics.UploadFailed("unexpected_eof")
 		handlers.ErrorMsg(w, r, http.StatusBadRequest, "Unexpected EOF")
 	case errors.Is(err, backends.ErrFileEmpty):
+		metrics.UploadFailed("empty")
 		handlers.ErrorMsg(w, r, http.StatusBadRequest, "Empty file")
 	case errors.Is(err, ErrProhibitedFilename):
+		metrics.UploadFailed("prohibited_filename")
 		handlers.ErrorMsg(w, r, http.StatusBadRequest, "Prohibited filename")
+	case isDenied:
+		metrics.UploadFailed("content_type")
+		handlers.ErrorMsg(w, r, http.StatusUnsupportedMediaType, denied.Message())
 	case errors.Is(err, io.ErrUnexpectedEOF):
+		metrics.UploadFailed("canceled")
 		handlers.ErrorMsg(w, r, http.StatusBadRequest, "Upload canceled")
 	case errors.Is(err, backends.ErrSizeMismatch):
+		metrics.UploadFailed("size_mismatch")
 		handlers.ErrorMsg(w, r, http.StatusBadRequest, "Size mismatch")
+	case errors.Is(err, quota.ErrExceeded):
+		metrics.UploadFailed("quota_exceeded")
+		handlers.ErrorMsg(w, r, http.StatusForbidden, "Upload quota exceeded")
+	case errors.Is(err, scan.ErrInfected):
+		metrics.UploadFailed("infected")
+		handlers.ErrorMsg(w, r, http.StatusUnprocessableEntity, "Upload rejected by malware scan")
+	case errors.Is(err, scan.ErrUnavailable):
+		metrics.UploadFailed("scanner_unavailable")
+		handlers.ErrorMsg(w, r, http.StatusServiceUnavailable, "Malware scanner unavailable")
+	case errors.Is(err, exif.ErrInvalid):
+		metrics.UploadFailed("invalid_image")
+		handlers.ErrorMsg(w, r, http.StatusBadRequest, "Invalid image")
+	case errors.Is(err, e2e.ErrInvalidHeader), errors.Is(err, e2e.ErrUnsupportedVersion):
+		metrics.UploadFailed("invalid_encryption")
+		handlers.ErrorMsg(w, r, http.StatusBadRequest, "Invalid encrypted upload")
+	case errors.Is(err, ErrCollectionUnsupported):
+		metrics.UploadFailed("collection_unsupported")
+		handlers.ErrorMsg(w, r, http.StatusBadRequest, "Collections can't be encrypted or have a download limit")
+	case errors.Is(err, capacity.ErrInsufficientStorage):
+		metrics.UploadFailed("insufficient_storage")
+		handlers.ErrorMsg(w, r, http.StatusInsufficientStorage, "Insufficient storage")
+	case errors.Is(err, capacity.ErrUnknownSize):
+		metrics.UploadFailed("unknown_size")
+		handlers.ErrorMsg(w, r, http.StatusLengthRequired, "Content-Length is required to replace an upload")
 	default:
+		metrics.UploadFailed("internal")
 		slog.Error("Upload failed", "error", err)
 		handlers.Error(w, r, http.StatusInternalServerError)
 	}
 }
 
 func GenerateBarename() string {
-	return uniuri.NewLenChars(config.Default.RandomFilenameLength, []byte("abcdefghijklmnopqrstuvwxyz0123456789"))
+	return uniuri.NewLenChars(config.Default().RandomFilenameLength, []byte("abcdefghijklmnopqrstuvwxyz0123456789"))
 }
 
 //nolint:gochecknoglobals
@@ -530,9 +709,18 @@ func BarePlusExt(filename string) (string, string) {
 	return barename, extension
 }
 
+// ParseMaxDownloads returns how many times an upload can be downloaded. Invalid values mean unlimited.
+func ParseMaxDownloads(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return max(n, 0)
+}
+
 func ParseExpiry(expStr string) time.Duration {
 	if expStr == "" {
-		return config.Default.MaxExpiry.Duration
+		return config.Default().MaxExpiry.Duration
 	}
 
 	var fileExpiry time.Duration
@@ -541,7 +729,7 @@ func ParseExpiry(expStr string) time.Duration {
 	} else {
 		seconds, err := strconv.ParseInt(expStr, 10, 64)
 		if err != nil {
-			return config.Default.MaxExpiry.Duration
+			return config.Default().MaxExpiry.Duration
 		}
 
 		fileExpiry = time.Duration(seconds) * time.Second
@@ -549,11 +737,11 @@ func ParseExpiry(expStr string) time.Duration {
 
 	fileExpiry = max(fileExpiry, 0)
 
-	if config.Default.MaxExpiry.Duration == 0 {
+	if config.Default().MaxExpiry.Duration == 0 {
 		return fileExpiry
 	} else if fileExpiry == 0 {
-		return config.Default.MaxExpiry.Duration
+		return config.Default().MaxExpiry.Duration
 	}
 
-	return min(fileExpiry, config.Default.MaxExpiry.Duration)
+	return min(fileExpiry, config.Default().MaxExpiry.Duration)
 }
@@ -0,0 +1,226 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"iter"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
+
+const (
+	queueExt   = ".json"
+	claimedExt = ".claimed"
+)
+
+type delivery struct {
+	ID       string          `json:"id"`
+	URL      string          `json:"url"`
+	Event    Event           `json:"event"`
+	Body     json.RawMessage `json:"body"`
+	Attempts int             `json:"attempts,omitzero"`
+	Next     time.Time       `json:"next,omitzero"`
+}
+
+// queue stores each pending delivery as a JSON file.
+// IDs start with the time they were queued, so listing the directory returns deliveries in order.
+// A delivery is claimed by renaming it before it is sent, so processes which share the queue never send it twice.
+type queue struct {
+	path string
+	// claimTimeout is how long a claim lasts. Claims left by a process which stopped are released after it.
+	claimTimeout time.Duration
+}
+
+func newQueue(path string, claimTimeout time.Duration) (queue, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return queue{}, err
+	}
+	return queue{path: path, claimTimeout: claimTimeout}, nil
+}
+
+func (q queue) add(dl delivery) error {
+	dl.ID = strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + uniuri.NewLen(8)
+	return q.update(dl)
+}
+
+// update writes a delivery to a temp file and renames it, so a partially written delivery is never read.
+// If the delivery was claimed, the claim is released.
+func (q queue) update(dl delivery) error {
+	root, err := os.OpenRoot(q.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	tmp := dl.ID + ".tmp"
+	if err := root.WriteFile(tmp, b, 0o600); err != nil {
+		_ = root.Remove(tmp)
+		return err
+	}
+	if err := root.Rename(tmp, dl.ID+queueExt); err != nil {
+		_ = root.Remove(tmp)
+		return err
+	}
+	if err := root.Remove(dl.ID + claimedExt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// claim reserves a delivery for this process and reads it again, since another process may have updated it.
+// It returns false if another process claimed or removed the delivery first.
+func (q queue) claim(id string) (delivery, bool, error) {
+	var dl delivery
+	root, err := os.OpenRoot(q.path)
+	if err != nil {
+		return dl, false, err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	if err := root.Rename(id+queueExt, id+claimedExt); err != nil {
+		if os.IsNotExist(err) {
+			return dl, false, nil
+		}
+		return dl, false, err
+	}
+	// The modification time records when the delivery was claimed.
+	now := time.Now()
+	if err := root.Chtimes(id+claimedExt, now, now); err != nil {
+		return dl, false, errors.Join(err, q.release(id))
+	}
+
+	b, err := root.ReadFile(id + claimedExt)
+	if err != nil {
+		return dl, false, errors.Join(err, q.release(id))
+	}
+	if err := json.Unmarshal(b, &dl); err != nil {
+		// A corrupt delivery can never succeed.
+		return dl, false, errors.Join(err, q.remove(id))
+	}
+	dl.ID = id
+	return dl, true, nil
+}
+
+// release returns a claimed delivery to the queue.
+func (q queue) release(id string) error {
+	root, err := os.OpenRoot(q.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	if err := root.Rename(id+claimedExt, id+queueExt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// remove deletes a delivery, whether or not it is claimed.
+func (q queue) remove(id string) error {
+	root, err := os.OpenRoot(q.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	var errs []error
+	for _, name := range []string{id + queueExt, id + claimedExt} {
+		if err := root.Remove(name); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (q queue) list() iter.Seq2[delivery, error] {
+	return func(yield func(delivery, error) bool) {
+		root, err := os.OpenRoot(q.path)
+		if err != nil {
+			yield(delivery{}, err)
+			return
+		}
+		defer func() {
+			_ = root.Close()
+		}()
+
+		entries, err := os.ReadDir(q.path)
+		if err != nil {
+			yield(delivery{}, err)
+			return
+		}
+		slices.SortFunc(entries, func(a, b os.DirEntry) int {
+			return strings.Compare(a.Name(), b.Name())
+		})
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			id, ok := strings.CutSuffix(entry.Name(), queueExt)
+			if !ok {
+				// An expired claim is released, then listed like any other delivery.
+				if id, ok = strings.CutSuffix(entry.Name(), claimedExt); !ok || !q.releaseExpired(id, entry) {
+					continue
+				}
+			}
+
+			b, err := root.ReadFile(id + queueExt)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				if !yield(delivery{}, err) {
+					return
+				}
+				continue
+			}
+
+			var dl delivery
+			if err := json.Unmarshal(b, &dl); err != nil {
+				// A corrupt delivery can never succeed.
+				_ = root.Remove(id + queueExt)
+				if !yield(delivery{}, err) {
+					return
+				}
+				continue
+			}
+			dl.ID = id
+			if !yield(dl, nil) {
+				return
+			}
+		}
+	}
+}
+
+// releaseExpired releases a claim which has lasted longer than the claim timeout.
+// It returns whether the claim was released.
+func (q queue) releaseExpired(id string, entry os.DirEntry) bool {
+	info, err := entry.Info()
+	if err != nil || time.Since(info.ModTime()) < q.claimTimeout {
+		return false
+	}
+	if err := q.release(id); err != nil {
+		slog.Error("Failed to release webhook claim", "id", id, "error", err)
+		return false
+	}
+	return true
+}
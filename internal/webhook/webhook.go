@@ -0,0 +1,329 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type Event string
+
+const (
+	EventUpload Event = "upload"
+	EventDelete Event = "delete"
+	EventExpire Event = "expire"
+	// EventLockout is sent when an upload is locked after too many failed access key attempts.
+	// It is only sent to targets which list it.
+	EventLockout Event = "lockout"
+)
+
+const (
+	HeaderEvent     = "Linx-Event"
+	HeaderDelivery  = "Linx-Delivery"
+	HeaderSignature = "Linx-Signature-256"
+)
+
+var (
+	ErrUnknownEvent = errors.New("unknown webhook event")
+	ErrNoURL        = errors.New("webhook target has no url")
+	ErrStatus       = errors.New("unexpected webhook response status")
+)
+
+// ParseEvent validates an event name from the config.
+func ParseEvent(s string) (Event, error) {
+	switch e := Event(strings.ToLower(s)); e {
+	case EventUpload, EventDelete, EventExpire, EventLockout:
+		return e, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownEvent, s)
+}
+
+// Payload is the JSON body sent to each target.
+type Payload struct {
+	Event        Event     `json:"event"`
+	Time         time.Time `json:"time"`
+	Filename     string    `json:"filename"`
+	OriginalName string    `json:"original_name,omitzero"`
+	Size         int64     `json:"size"`
+	Mimetype     string    `json:"mimetype,omitzero"`
+	Expiry       time.Time `json:"expiry,omitzero"`
+	Uploader     string    `json:"uploader,omitzero"`
+	RequestID    string    `json:"request_id,omitzero"`
+}
+
+// Target is an endpoint which receives webhooks.
+type Target struct {
+	URL string
+	// Secret signs each delivery with HMAC-SHA256. Deliveries are unsigned if it is empty.
+	Secret string
+	// Events limits which events are sent. All events except lockout are sent if it is empty.
+	Events []Event
+}
+
+func (t Target) wants(event Event) bool {
+	if len(t.Events) == 0 {
+		return event != EventLockout
+	}
+	return slices.Contains(t.Events, event)
+}
+
+// Sign returns the signature header value for a body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type Options struct {
+	QueuePath   string
+	Targets     []Target
+	MaxAttempts int
+	Timeout     time.Duration
+	// Backoff is the delay before the first retry. It doubles after each failed attempt.
+	Backoff time.Duration
+}
+
+const (
+	defaultBackoff = 10 * time.Second
+	maxBackoff     = time.Hour
+	pollInterval   = time.Minute
+	// claimTimeout is how long a process may take to send a delivery before another process retries it.
+	claimTimeout = 10 * time.Minute
+)
+
+// Dispatcher queues webhooks on disk and delivers them in the background.
+// Queued deliveries survive restarts, and deliveries queued by other processes, such as the cleanup command,
+// are picked up the next time the queue is polled. Each delivery is claimed before it is sent,
+// so processes which share the queue never send the same delivery at once.
+type Dispatcher struct {
+	opts   Options
+	queue  queue
+	client *http.Client
+	notify chan struct{}
+	mu     sync.Mutex
+}
+
+func New(opts Options) (*Dispatcher, error) {
+	for _, t := range opts.Targets {
+		if t.URL == "" {
+			return nil, ErrNoURL
+		}
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultBackoff
+	}
+
+	q, err := newQueue(opts.QueuePath, max(claimTimeout, 2*opts.Timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Dispatcher{
+		opts:   opts,
+		queue:  q,
+		client: &http.Client{Timeout: opts.Timeout},
+		notify: make(chan struct{}, 1),
+	}, nil
+}
+
+// Send queues an event for every target which wants it. It is a no-op on a nil Dispatcher.
+// The request ID is read from ctx, so ctx may already be canceled.
+func (d *Dispatcher) Send(ctx context.Context, event Event, name string, m backends.Metadata) {
+	if d == nil {
+		return
+	}
+
+	body, err := json.Marshal(Payload{
+		Event:        event,
+		Time:         time.Now().UTC(),
+		Filename:     name,
+		OriginalName: m.OriginalName,
+		Size:         m.Size,
+		Mimetype:     m.Mimetype,
+		Expiry:       m.Expiry,
+		Uploader:     m.Uploader,
+		RequestID:    middleware.GetReqID(ctx),
+	})
+	if err != nil {
+		slog.Error("Failed to encode webhook", "event", event, "name", name, "error", err)
+		return
+	}
+
+	var queued bool
+	for _, t := range d.opts.Targets {
+		if !t.wants(event) {
+			continue
+		}
+		if err := d.queue.add(delivery{URL: t.URL, Event: event, Body: body}); err != nil {
+			slog.Error("Failed to queue webhook", "event", event, "name", name, "url", t.URL, "error", err)
+			continue
+		}
+		queued = true
+	}
+
+	if queued {
+		select {
+		case d.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run delivers queued webhooks until ctx is canceled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-d.notify:
+		}
+
+		wait := pollInterval
+		if next := d.Flush(ctx); !next.IsZero() {
+			wait = min(wait, max(time.Until(next), 0))
+		}
+		timer.Reset(wait)
+	}
+}
+
+// Flush attempts every queued delivery which is due.
+// It returns when the earliest remaining delivery is due, or zero if the queue is empty.
+func (d *Dispatcher) Flush(ctx context.Context) time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var next time.Time
+	for dl, err := range d.queue.list() {
+		if err != nil {
+			slog.Error("Failed to read webhook queue", "error", err)
+			continue
+		}
+		if ctx.Err() != nil {
+			return next
+		}
+
+		if dl.Next.After(time.Now()) {
+			if next.IsZero() || dl.Next.Before(next) {
+				next = dl.Next
+			}
+			continue
+		}
+
+		dl, ok, err := d.queue.claim(dl.ID)
+		if err != nil {
+			slog.Error("Failed to claim webhook", "id", dl.ID, "error", err)
+			continue
+		}
+		if !ok {
+			// Another process is sending it.
+			continue
+		}
+		if dl.Next.After(time.Now()) {
+			// Another process retried it since the queue was listed.
+			if err := d.queue.release(dl.ID); err != nil {
+				slog.Error("Failed to release webhook claim", "id", dl.ID, "error", err)
+			}
+			if next.IsZero() || dl.Next.Before(next) {
+				next = dl.Next
+			}
+			continue
+		}
+
+		target, ok := d.target(dl.URL)
+		if !ok {
+			// The target was removed from the config.
+			_ = d.queue.remove(dl.ID)
+			continue
+		}
+
+		err = d.deliver(ctx, target, dl)
+		if err == nil {
+			_ = d.queue.remove(dl.ID)
+			continue
+		}
+
+		dl.Attempts++
+		if dl.Attempts >= d.opts.MaxAttempts {
+			slog.Error("Dropping webhook after failed attempts",
+				"event", dl.Event, "url", dl.URL, "attempts", dl.Attempts, "error", err,
+			)
+			_ = d.queue.remove(dl.ID)
+			continue
+		}
+
+		dl.Next = time.Now().Add(d.backoff(dl.Attempts))
+		slog.Warn("Webhook delivery failed", "event", dl.Event, "url", dl.URL, "retry", dl.Next, "error", err)
+		if err := d.queue.update(dl); err != nil {
+			slog.Error("Failed to requeue webhook", "url", dl.URL, "error", err)
+			continue
+		}
+		if next.IsZero() || dl.Next.Before(next) {
+			next = dl.Next
+		}
+	}
+	return next
+}
+
+func (d *Dispatcher) target(url string) (Target, bool) {
+	i := slices.IndexFunc(d.opts.Targets, func(t Target) bool { return t.URL == url })
+	if i == -1 {
+		return Target{}, false
+	}
+	return d.opts.Targets[i], true
+}
+
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	b := d.opts.Backoff
+	for range attempts - 1 {
+		if b *= 2; b >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return b
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, target Target, dl delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(dl.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "linx-server")
+	req.Header.Set(HeaderEvent, string(dl.Event))
+	req.Header.Set(HeaderDelivery, dl.ID)
+	if target.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(target.Secret, dl.Body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s", ErrStatus, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gabe565.com/linx-server/internal/backends"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+type testServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	received []received
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{status: http.StatusNoContent}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.received = append(s.received, received{header: r.Header.Clone(), body: body})
+		w.WriteHeader(s.status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *testServer) requests() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]received(nil), s.received...)
+}
+
+func queued(t *testing.T, path string) int {
+	entries, err := os.ReadDir(path)
+	require.NoError(t, err)
+	return len(entries)
+}
+
+func TestParseEvent(t *testing.T) {
+	event, err := ParseEvent("Upload")
+	require.NoError(t, err)
+	assert.Equal(t, EventUpload, event)
+
+	_, err = ParseEvent("rename")
+	require.ErrorIs(t, err, ErrUnknownEvent)
+}
+
+func TestTargetWants(t *testing.T) {
+	all := Target{}
+	assert.True(t, all.wants(EventUpload))
+	assert.False(t, all.wants(EventLockout))
+
+	lockouts := Target{Events: []Event{EventLockout}}
+	assert.True(t, lockouts.wants(EventLockout))
+	assert.False(t, lockouts.wants(EventUpload))
+}
+
+func TestDispatcher(t *testing.T) {
+	srv := newTestServer(t)
+	queuePath := filepath.Join(t.TempDir(), "webhooks")
+
+	d, err := New(Options{
+		QueuePath: queuePath,
+		Targets: []Target{
+			{URL: srv.URL, Secret: "secret"},
+			{URL: srv.URL + "/uploads", Events: []Event{EventUpload}},
+		},
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	ctx := context.WithValue(t.Context(), middleware.RequestIDKey, "request-id")
+	expiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	d.Send(ctx, EventDelete, "file.txt", backends.Metadata{
+		OriginalName: "original.txt",
+		Size:         12,
+		Mimetype:     "text/plain",
+		Expiry:       expiry,
+		Uploader:     "alice",
+	})
+	assert.Equal(t, 1, queued(t, queuePath))
+
+	assert.True(t, d.Flush(t.Context()).IsZero())
+	assert.Equal(t, 0, queued(t, queuePath))
+
+	reqs := srv.requests()
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "delete", reqs[0].header.Get(HeaderEvent))
+	assert.NotEmpty(t, reqs[0].header.Get(HeaderDelivery))
+	assert.Equal(t, Sign("secret", reqs[0].body), reqs[0].header.Get(HeaderSignature))
+
+	var payload Payload
+	require.NoError(t, json.Unmarshal(reqs[0].body, &payload))
+	assert.Equal(t, Payload{
+		Event:        EventDelete,
+		Time:         payload.Time,
+		Filename:     "file.txt",
+		OriginalName: "original.txt",
+		Size:         12,
+		Mimetype:     "text/plain",
+		Expiry:       expiry,
+		Uploader:     "alice",
+		RequestID:    "request-id",
+	}, payload)
+}
+
+func TestDispatcherRetry(t *testing.T) {
+	srv := newTestServer(t)
+	srv.setStatus(http.StatusInternalServerError)
+	queuePath := filepath.Join(t.TempDir(), "webhooks")
+
+	opts := Options{
+		QueuePath:   queuePath,
+		Targets:     []Target{{URL: srv.URL}},
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+	}
+	d, err := New(opts)
+	require.NoError(t, err)
+
+	d.Send(t.Context(), EventUpload, "file.txt", backends.Metadata{})
+	next := d.Flush(t.Context())
+	assert.False(t, next.IsZero())
+	assert.Equal(t, 1, queued(t, queuePath))
+	time.Sleep(time.Until(next))
+
+	// the queue survives a restart
+	srv.setStatus(http.StatusOK)
+	d, err = New(opts)
+	require.NoError(t, err)
+	assert.True(t, d.Flush(t.Context()).IsZero())
+	assert.Equal(t, 0, queued(t, queuePath))
+
+	reqs := srv.requests()
+	require.Len(t, reqs, 2)
+	assert.Equal(t, reqs[0].header.Get(HeaderDelivery), reqs[1].header.Get(HeaderDelivery))
+	assert.Equal(t, reqs[0].body, reqs[1].body)
+	assert.Empty(t, reqs[1].header.Get(HeaderSignature))
+
+	// deliveries are dropped after the maximum attempts
+	srv.setStatus(http.StatusInternalServerError)
+	d.Send(t.Context(), EventUpload, "file.txt", backends.Metadata{})
+	for range opts.MaxAttempts {
+		time.Sleep(time.Until(d.Flush(t.Context())))
+	}
+	assert.Equal(t, 0, queued(t, queuePath))
+	assert.Len(t, srv.requests(), 2+opts.MaxAttempts)
+}
+
+func TestDispatcherClaim(t *testing.T) {
+	srv := newTestServer(t)
+	queuePath := filepath.Join(t.TempDir(), "webhooks")
+
+	opts := Options{
+		QueuePath:   queuePath,
+		Targets:     []Target{{URL: srv.URL}},
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+	}
+	a, err := New(opts)
+	require.NoError(t, err)
+	b, err := New(opts)
+	require.NoError(t, err)
+
+	// a delivery claimed by another process is skipped
+	a.Send(t.Context(), EventUpload, "file.txt", backends.Metadata{})
+	var id string
+	for dl, err := range a.queue.list() {
+		require.NoError(t, err)
+		id = dl.ID
+	}
+	_, ok, err := a.queue.claim(id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, ok, err = b.queue.claim(id)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	b.Flush(t.Context())
+	assert.Empty(t, srv.requests())
+
+	// the claim is released once it expires
+	old := time.Now().Add(-2 * claimTimeout)
+	require.NoError(t, os.Chtimes(filepath.Join(queuePath, id+claimedExt), old, old))
+	b.Flush(t.Context())
+	assert.Len(t, srv.requests(), 1)
+	assert.Equal(t, 0, queued(t, queuePath))
+
+	// processes which flush at once send each delivery once
+	for range 20 {
+		a.Send(t.Context(), EventUpload, "file.txt", backends.Metadata{})
+	}
+	var wg sync.WaitGroup
+	for _, d := range []*Dispatcher{a, b} {
+		wg.Go(func() {
+			d.Flush(t.Context())
+		})
+	}
+	wg.Wait()
+	assert.Len(t, srv.requests(), 21)
+	assert.Equal(t, 0, queued(t, queuePath))
+}
+
+func TestDispatcherNil(t *testing.T) {
+	var d *Dispatcher
+	assert.NotPanics(t, func() {
+		d.Send(t.Context(), EventUpload, "file.txt", backends.Metadata{})
+	})
+}
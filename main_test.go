@@ -1,29 +1,54 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"image"
+	"image/jpeg"
+	"image/png"
 	"io"
+	"maps"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"path"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"gabe565.com/linx-server/cmd"
+	"gabe565.com/linx-server/internal/admin"
+	"gabe565.com/linx-server/internal/auth/apikeys"
 	"gabe565.com/linx-server/internal/auth/keyhash"
+	"gabe565.com/linx-server/internal/auth/oidc"
+	"gabe565.com/linx-server/internal/auth/oidc/oidctest"
+	"gabe565.com/linx-server/internal/backends"
 	"gabe565.com/linx-server/internal/config"
+	"gabe565.com/linx-server/internal/e2e"
+	"gabe565.com/linx-server/internal/handlers"
+	"gabe565.com/linx-server/internal/quota"
+	"gabe565.com/linx-server/internal/scan/clamdtest"
 	"gabe565.com/linx-server/internal/server"
+	"gabe565.com/linx-server/internal/stats"
 	"gabe565.com/linx-server/internal/template"
+	"gabe565.com/linx-server/internal/torrent"
 	"gabe565.com/linx-server/internal/upload"
+	"gabe565.com/linx-server/internal/webhook"
 	"gabe565.com/utils/bytefmt"
-	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/zeebo/bencode"
 )
 
 type RespOkJSON struct {
@@ -42,29 +67,30 @@ type RespErrJSON struct {
 
 const testURL = "http://linx.example.org/"
 
-func setup(t *testing.T, overrides func()) (*chi.Mux, *httptest.ResponseRecorder) {
-	t.Cleanup(func() { config.Default = config.New() })
+func setup(t *testing.T, overrides func()) (*server.Handler, *httptest.ResponseRecorder) {
+	t.Cleanup(func() { config.Store(config.New()) })
 
 	u, err := url.Parse(testURL)
 	require.NoError(t, err)
-	config.Default.SiteURL.URL = *u
+	config.Default().SiteURL.URL = *u
 
-	config.Default.FilesPath = t.TempDir()
-	config.Default.MetaPath = config.Default.FilesPath + "_meta"
-	config.StorageBackend, err = config.Default.NewStorageBackend(t.Context())
+	config.Default().FilesPath = t.TempDir()
+	config.Default().MetaPath = config.Default().FilesPath + "_meta"
+	config.Default().PartialsPath = config.Default().FilesPath + "_partials"
+	config.StorageBackend, err = config.Default().NewStorageBackend(t.Context())
 	require.NoError(t, err)
-	config.Default.MaxSize = bytefmt.GiB
-	config.Default.NoLogs = true
-	config.Default.SiteName = "linx"
-	config.Default.ForceRandomFilename = false
+	config.Default().MaxSize = bytefmt.GiB
+	config.Default().NoLogs = true
+	config.Default().SiteName = "linx"
+	config.Default().ForceRandomFilename = false
 
 	if overrides != nil {
 		overrides()
 	}
 
-	r, err := server.Setup()
+	h, err := server.NewHandler(t.Context())
 	require.NoError(t, err)
-	return r, httptest.NewRecorder()
+	return h, httptest.NewRecorder()
 }
 
 func assertResponse(t *testing.T, w *httptest.ResponseRecorder, wantStatus int, wantContentType string) {
@@ -100,7 +126,7 @@ func TestIndex(t *testing.T) {
 
 func TestConfigStandardMaxExpiry(t *testing.T) {
 	r, w := setup(t, func() {
-		config.Default.MaxExpiry.Duration = 60 * time.Second
+		config.Default().MaxExpiry.Duration = 60 * time.Second
 	})
 
 	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil)
@@ -117,7 +143,7 @@ func TestConfigStandardMaxExpiry(t *testing.T) {
 
 func TestConfigWeirdMaxExpiry(t *testing.T) {
 	r, w := setup(t, func() {
-		config.Default.MaxExpiry.Duration = 25 * time.Minute
+		config.Default().MaxExpiry.Duration = 25 * time.Minute
 	})
 
 	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil)
@@ -134,7 +160,7 @@ func TestConfigWeirdMaxExpiry(t *testing.T) {
 
 func TestAddHeader(t *testing.T) {
 	r, w := setup(t, func() {
-		config.Default.Header.AddHeaders = map[string]string{"Linx-Test": "It works!"}
+		config.Default().Header.AddHeaders = map[string]string{"Linx-Test": "It works!"}
 	})
 
 	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil)
@@ -161,7 +187,7 @@ func TestFileNotFound(t *testing.T) {
 
 	filename := upload.GenerateBarename()
 	req, err := http.NewRequestWithContext(t.Context(),
-		http.MethodGet, path.Join("/", config.Default.SelifPath, filename), nil,
+		http.MethodGet, path.Join("/", config.Default().SelifPath, filename), nil,
 	)
 	require.NoError(t, err)
 
@@ -234,7 +260,7 @@ func TestPostUpload(t *testing.T) {
 	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, "/upload", &b)
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
-	req.Header.Set("Referer", config.Default.SiteURL.String())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
 	require.NoError(t, err)
 
 	r.ServeHTTP(w, req)
@@ -266,7 +292,7 @@ func TestPostUploadBadOrigin(t *testing.T) {
 	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, "/upload", &b)
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
-	req.Header.Set("Referer", config.Default.SiteURL.String())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
 	req.Header.Set("Origin", "http://example.com")
 
 	r.ServeHTTP(w, req)
@@ -283,9 +309,9 @@ func TestPostJSONUpload(t *testing.T) {
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Referer", config.Default.SiteURL.String())
-	req.Header.Set("Referer", config.Default.SiteURL.String())
-	req.Header.Set("Referer", config.Default.SiteURL.String())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
 	require.NoError(t, err)
 
 	r.ServeHTTP(w, req)
@@ -310,7 +336,7 @@ func TestPostJSONUploadAccessKeyStoredHashed(t *testing.T) {
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Referer", config.Default.SiteURL.String())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
 
 	r.ServeHTTP(w, req)
 	assertResponse(t, w, http.StatusOK, "application/json")
@@ -340,7 +366,7 @@ func TestAccessProtectedFileWithAccessKeyHeader(t *testing.T) {
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Referer", config.Default.SiteURL.String())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
 
 	r.ServeHTTP(w, req)
 	assertResponse(t, w, http.StatusOK, "application/json")
@@ -364,11 +390,27 @@ func TestAccessProtectedFileWithAccessKeyHeader(t *testing.T) {
 
 	r.ServeHTTP(w, req)
 	assertResponse(t, w, http.StatusOK, "application/json")
+
+	// The cookie holds an unlock token instead of the access key
+	cookies := w.Result().Cookies()
+	require.NotEmpty(t, cookies)
+	assert.NotContains(t, cookies[0].Value, "supersecret")
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodGet,
+		path.Join("/", config.Default().SelifPath, myjson.Filename), nil,
+	)
+	require.NoError(t, err)
+	req.AddCookie(cookies[0])
+
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "File content", w.Body.String())
 }
 
 func TestPostJSONUploadMaxExpiry(t *testing.T) {
 	r, _ := setup(t, func() {
-		config.Default.MaxExpiry.Duration = 5 * time.Minute
+		config.Default().MaxExpiry.Duration = 5 * time.Minute
 	})
 
 	// include 0 to test edge case
@@ -397,7 +439,7 @@ func TestPostJSONUploadMaxExpiry(t *testing.T) {
 		myExp, err := strconv.ParseInt(myjson.Expiry, 10, 64)
 		require.NoError(t, err)
 
-		expected := time.Now().Add(config.Default.MaxExpiry.Duration).Unix()
+		expected := time.Now().Add(config.Default().MaxExpiry.Duration).Unix()
 		assert.InDelta(t, expected, myExp, 1)
 	}
 }
@@ -412,7 +454,7 @@ func TestPostExpiresJSONUpload(t *testing.T) {
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Referer", config.Default.SiteURL.String())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
 	require.NoError(t, err)
 
 	r.ServeHTTP(w, req)
@@ -441,7 +483,7 @@ func TestPostRandomizeJSONUpload(t *testing.T) {
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Referer", config.Default.SiteURL.String())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
 	require.NoError(t, err)
 
 	r.ServeHTTP(w, req)
@@ -463,7 +505,7 @@ func TestPostEmptyUpload(t *testing.T) {
 	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, "/upload", &b)
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
-	req.Header.Set("Referer", config.Default.SiteURL.String())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
 	require.NoError(t, err)
 
 	r.ServeHTTP(w, req)
@@ -472,7 +514,7 @@ func TestPostEmptyUpload(t *testing.T) {
 
 func TestPostTooLargeUpload(t *testing.T) {
 	r, w := setup(t, func() {
-		config.Default.MaxSize = 2
+		config.Default().MaxSize = 2
 	})
 
 	filename := upload.GenerateBarename() + "." + ExtTxt
@@ -481,7 +523,7 @@ func TestPostTooLargeUpload(t *testing.T) {
 	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, "/upload", &b)
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
-	req.Header.Set("Referer", config.Default.SiteURL.String())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
 	require.NoError(t, err)
 
 	r.ServeHTTP(w, req)
@@ -498,7 +540,7 @@ func TestPostEmptyJSONUpload(t *testing.T) {
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Referer", config.Default.SiteURL.String())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
 	require.NoError(t, err)
 
 	r.ServeHTTP(w, req)
@@ -536,7 +578,7 @@ func TestPutUpload(t *testing.T) {
 			if filename == "" {
 				assert.NotEmpty(t, w.Body.String())
 			} else {
-				expect, err := config.Default.SiteURL.Parse(filename)
+				expect, err := config.Default().SiteURL.Parse(filename)
 				require.NoError(t, err)
 				assert.Equal(t, expect.String()+"\n", w.Body.String())
 			}
@@ -558,14 +600,14 @@ func TestPutRandomizedUpload(t *testing.T) {
 	r.ServeHTTP(w, req)
 	assertResponse(t, w, http.StatusOK, "text/plain; charset=utf-8")
 
-	expect, err := config.Default.SiteURL.Parse(filename)
+	expect, err := config.Default().SiteURL.Parse(filename)
 	require.NoError(t, err)
 	assert.NotEqual(t, expect.String(), w.Body.String())
 }
 
 func TestPutForceRandomUpload(t *testing.T) {
 	r, w := setup(t, func() {
-		config.Default.ForceRandomFilename = true
+		config.Default().ForceRandomFilename = true
 	})
 
 	filename := "randomizeme.file"
@@ -581,7 +623,7 @@ func TestPutForceRandomUpload(t *testing.T) {
 	r.ServeHTTP(w, req)
 	assertResponse(t, w, http.StatusOK, "text/plain; charset=utf-8")
 
-	expect, err := config.Default.SiteURL.Parse(filename)
+	expect, err := config.Default().SiteURL.Parse(filename)
 	require.NoError(t, err)
 	assert.NotEqual(t, expect.String(), w.Body.String())
 }
@@ -605,7 +647,7 @@ func TestPutForceRandomUploadExistingName(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, filename, first.Filename, "first upload should keep explicit filename")
 
-	config.Default.ForceRandomFilename = true
+	config.Default().ForceRandomFilename = true
 
 	w = httptest.NewRecorder()
 	req, err = http.NewRequestWithContext(t.Context(),
@@ -626,7 +668,7 @@ func TestPutForceRandomUploadExistingName(t *testing.T) {
 
 	ext := path.Ext(second.Filename)
 	assert.Equal(t, ".file", ext)
-	assert.Len(t, strings.TrimSuffix(second.Filename, ext), config.Default.RandomFilenameLength)
+	assert.Len(t, strings.TrimSuffix(second.Filename, ext), config.Default().RandomFilenameLength)
 }
 
 func TestPutNoExtensionUpload(t *testing.T) {
@@ -643,7 +685,7 @@ func TestPutNoExtensionUpload(t *testing.T) {
 	r.ServeHTTP(w, req)
 	assertResponse(t, w, http.StatusOK, "text/plain; charset=utf-8")
 
-	expect, err := config.Default.SiteURL.Parse(filename)
+	expect, err := config.Default().SiteURL.Parse(filename)
 	require.NoError(t, err)
 	assert.NotEqual(t, expect.String(), w.Body.String())
 }
@@ -664,7 +706,7 @@ func TestPutEmptyUpload(t *testing.T) {
 
 func TestPutTooLargeUpload(t *testing.T) {
 	r, w := setup(t, func() {
-		config.Default.MaxSize = 2
+		config.Default().MaxSize = 2
 	})
 
 	filename := upload.GenerateBarename() + ".file"
@@ -808,7 +850,7 @@ func TestPutAndOverwrite(t *testing.T) {
 	// Make sure it's the new file
 	w = httptest.NewRecorder()
 	req, err = http.NewRequestWithContext(t.Context(),
-		http.MethodGet, path.Join("/", config.Default.SelifPath, myjson.Filename), nil,
+		http.MethodGet, path.Join("/", config.Default().SelifPath, myjson.Filename), nil,
 	)
 	require.NoError(t, err)
 
@@ -826,7 +868,7 @@ func TestPutAndOverwritePreservesOriginalName(t *testing.T) {
 	require.NoError(t, err)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Referer", config.Default.SiteURL.String())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
 
 	r.ServeHTTP(w, req)
 	assertResponse(t, w, http.StatusOK, "application/json")
@@ -860,7 +902,7 @@ func TestPutAndOverwritePreservesOriginalName(t *testing.T) {
 
 func TestPutAndOverwriteForceRandom(t *testing.T) {
 	r, w := setup(t, func() {
-		config.Default.ForceRandomFilename = true
+		config.Default().ForceRandomFilename = true
 	})
 
 	req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload", strings.NewReader("File content"))
@@ -888,7 +930,7 @@ func TestPutAndOverwriteForceRandom(t *testing.T) {
 	// Make sure it's the new file
 	w = httptest.NewRecorder()
 	req, err = http.NewRequestWithContext(t.Context(),
-		http.MethodGet, path.Join("/", config.Default.SelifPath, myjson.Filename), nil,
+		http.MethodGet, path.Join("/", config.Default().SelifPath, myjson.Filename), nil,
 	)
 	require.NoError(t, err)
 	r.ServeHTTP(w, req)
@@ -981,3 +1023,1361 @@ func TestPutAndGetCLI(t *testing.T) {
 	r.ServeHTTP(w, req)
 	assertResponse(t, w, http.StatusOK, "text/plain; charset=utf-8")
 }
+
+func TestTusUpload(t *testing.T) {
+	r, _ := setup(t, nil)
+
+	// create upload
+	w := httptest.NewRecorder()
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, "/"+upload.TusPath, nil)
+	require.NoError(t, err)
+	req.Header.Set("Tus-Resumable", upload.TusVersion)
+	req.Header.Set("Upload-Length", "12")
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("test.txt")))
+	req.Header.Set("Linx-Delete-Key", "supersecret")
+
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, w.Header().Get("Upload-Expires"))
+
+	patch := func(offset int, content string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(),
+			http.MethodPatch, location.Path, strings.NewReader(content),
+		)
+		require.NoError(t, err)
+		req.Header.Set("Tus-Resumable", upload.TusVersion)
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// upload first chunk
+	w = patch(0, "File ")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Upload-Offset"))
+
+	// retry with stale offset
+	w = patch(0, "File ")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// check offset
+	w = httptest.NewRecorder()
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodHead, location.Path, nil)
+	require.NoError(t, err)
+	req.Header.Set("Tus-Resumable", upload.TusVersion)
+
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Upload-Offset"))
+	assert.Equal(t, "12", w.Header().Get("Upload-Length"))
+
+	// upload last chunk
+	w = patch(5, "content")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "12", w.Header().Get("Upload-Offset"))
+	assert.Equal(t, "test.txt", w.Header().Get("Linx-Filename"))
+	assert.Equal(t, "supersecret", w.Header().Get("Linx-Delete-Key"))
+
+	// partial upload is removed
+	w = patch(12, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// fetch completed upload
+	w = httptest.NewRecorder()
+	req, err = http.NewRequestWithContext(t.Context(),
+		http.MethodGet, path.Join("/", config.Default().SelifPath, "test.txt"), nil,
+	)
+	require.NoError(t, err)
+
+	r.ServeHTTP(w, req)
+	assertResponse(t, w, http.StatusOK, "text/plain; charset=utf-8")
+	assert.Equal(t, "File content", w.Body.String())
+}
+
+func TestTusUploadConcurrent(t *testing.T) {
+	r, _ := setup(t, nil)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodPost, "/"+upload.TusPath, nil)
+	req.Header.Set("Tus-Resumable", upload.TusVersion)
+	req.Header.Set("Upload-Length", "12")
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte("test.txt")))
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+
+	// only one request may write each chunk
+	var wg sync.WaitGroup
+	var written atomic.Int64
+	for _, chunk := range []struct {
+		offset  int
+		content string
+	}{{0, "File "}, {5, "content"}} {
+		for range 8 {
+			wg.Go(func() {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequestWithContext(t.Context(),
+					http.MethodPatch, location.Path, strings.NewReader(chunk.content),
+				)
+				req.Header.Set("Tus-Resumable", upload.TusVersion)
+				req.Header.Set("Content-Type", "application/offset+octet-stream")
+				req.Header.Set("Upload-Offset", strconv.Itoa(chunk.offset))
+				r.ServeHTTP(w, req)
+				if w.Code == http.StatusNoContent {
+					written.Add(1)
+				} else {
+					assert.Contains(t, []int{http.StatusConflict, http.StatusLocked, http.StatusNotFound}, w.Code)
+				}
+			})
+		}
+		wg.Wait()
+	}
+	assert.EqualValues(t, 2, written.Load())
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequestWithContext(t.Context(),
+		http.MethodGet, path.Join("/", config.Default().SelifPath, "test.txt"), nil,
+	)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "File content", w.Body.String())
+}
+
+func TestTusUploadWithoutVersion(t *testing.T) {
+	r, w := setup(t, nil)
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, "/"+upload.TusPath, nil)
+	require.NoError(t, err)
+	req.Header.Set("Upload-Length", "12")
+
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, upload.TusVersion, w.Header().Get("Tus-Version"))
+}
+
+func TestPutUploadNamedTus(t *testing.T) {
+	r, w := setup(t, nil)
+
+	// the tus endpoint doesn't shadow uploads named after it
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/tus", strings.NewReader("File content"))
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/json")
+
+	r.ServeHTTP(w, req)
+	assertResponse(t, w, http.StatusOK, "application/json")
+
+	var myjson RespOkJSON
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &myjson))
+	assert.Equal(t, "tus.txt", myjson.Filename)
+}
+
+func TestAdminFiles(t *testing.T) {
+	const adminKey = "adminkey"
+	r, w := setup(t, func() {
+		hash, err := keyhash.Hash(adminKey, "", false)
+		require.NoError(t, err)
+
+		config.Default().Auth.AdminFile = path.Join(t.TempDir(), "admin-keys")
+		require.NoError(t, os.WriteFile(config.Default().Auth.AdminFile, []byte(hash+"\n"), 0o600))
+	})
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		w = httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(),
+			http.MethodPut, "/upload/"+name, strings.NewReader("File content"),
+		)
+		require.NoError(t, err)
+		req.Header.Set("Linx-Expiry", "0")
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	adminRequest := func(method, target, body, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(), method, target, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Linx-Api-Key", key)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// requires the admin key
+	w = adminRequest(http.MethodGet, "/api/admin/files", "", "wrongkey")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// list
+	w = adminRequest(http.MethodGet, "/api/admin/files?name=b.txt", "", adminKey)
+	assertResponse(t, w, http.StatusOK, "application/json")
+	assert.NotContains(t, w.Body.String(), "delete_key")
+
+	var list admin.ListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 1, list.Total)
+	require.Len(t, list.Files, 1)
+	assert.Equal(t, "b.txt", list.Files[0].Filename)
+	assert.EqualValues(t, 12, list.Files[0].Size)
+
+	// pagination
+	w = adminRequest(http.MethodGet, "/api/admin/files?limit=1&offset=1&mimetype=text/", "", adminKey)
+	assertResponse(t, w, http.StatusOK, "application/json")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 2, list.Total)
+	require.Len(t, list.Files, 1)
+	assert.Equal(t, "b.txt", list.Files[0].Filename)
+
+	// invalid filter
+	w = adminRequest(http.MethodGet, "/api/admin/files?min_size=abc", "", adminKey)
+	assertResponse(t, w, http.StatusBadRequest, "application/json")
+
+	// change expiry
+	w = adminRequest(http.MethodPatch, "/api/admin/files/a.txt", `{"expiry":"1h"}`, adminKey)
+	assertResponse(t, w, http.StatusOK, "application/json")
+
+	var file admin.File
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &file))
+	assert.WithinDuration(t, time.Now().Add(time.Hour), file.Expiry, time.Minute)
+
+	w = adminRequest(http.MethodGet, "/api/admin/files?never_expires=true", "", adminKey)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 1, list.Total)
+
+	// force delete
+	w = adminRequest(http.MethodDelete, "/api/admin/files/a.txt", "", adminKey)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = adminRequest(http.MethodGet, "/api/admin/files/a.txt", "", adminKey)
+	assertResponse(t, w, http.StatusNotFound, "application/json")
+}
+
+func TestAPIKeyQuota(t *testing.T) {
+	const apiKey = "apikey"
+	r, w := setup(t, func() {
+		hash, err := keyhash.Hash(apiKey, "", false)
+		require.NoError(t, err)
+
+		config.Default().Auth.File = path.Join(t.TempDir(), "keys")
+		require.NoError(t, os.WriteFile(config.Default().Auth.File,
+			[]byte(hash+" max-files=1 max-size=16 max-expiry=1h\n"), 0o600,
+		))
+
+		// file quotas require the metadata index
+		_, err = server.NewHandler(t.Context())
+		require.ErrorIs(t, err, quota.ErrIndexRequired)
+
+		config.Default().MetadataIndex = path.Join(t.TempDir(), "index.db")
+		config.StorageBackend, err = config.Default().NewStorageBackend(t.Context())
+		require.NoError(t, err)
+	})
+
+	put := func(name, content string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/"+name, strings.NewReader(content))
+		require.NoError(t, err)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Linx-Api-Key", apiKey)
+		req.Header.Set("Linx-Expiry", "0")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// max size
+	w = put("large.txt", strings.Repeat("a", 17))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// max expiry
+	w = put("a.txt", "File content")
+	assertResponse(t, w, http.StatusOK, "application/json")
+
+	var myjson RespOkJSON
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &myjson))
+	expiry, err := strconv.ParseInt(myjson.Expiry, 10, 64)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), time.Unix(expiry, 0), time.Minute)
+
+	// max files
+	w = put("b.txt", "File content")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// usage
+	w = httptest.NewRecorder()
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "/api/usage", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req.Header.Set("Linx-Api-Key", apiKey)
+	r.ServeHTTP(w, req)
+	assertResponse(t, w, http.StatusOK, "application/json")
+
+	var usage handlers.UsageResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &usage))
+	assert.EqualValues(t, 1, usage.Usage.Files)
+	assert.EqualValues(t, 12, usage.Usage.Bytes)
+	assert.EqualValues(t, 1, usage.Limits.MaxFiles)
+	assert.EqualValues(t, 3600, usage.Limits.MaxExpiry)
+
+	metadata, err := config.StorageBackend.Head(t.Context(), "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, usage.ID, metadata.Uploader)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	r, w := setup(t, func() {
+		var buf strings.Builder
+		for _, k := range []struct{ name, scopes string }{
+			{"uploader", `["upload"]`},
+			{"moderator", `["delete-any"]`},
+			{"expired", `["upload"]`},
+		} {
+			hash, err := keyhash.Hash(k.name, "", false)
+			require.NoError(t, err)
+			buf.WriteString("[[keys]]\nname = \"" + k.name + "\"\nkey = \"" + hash + "\"\nscopes = " + k.scopes + "\n")
+			if k.name == "expired" {
+				buf.WriteString("expires = 2000-01-01T00:00:00Z\n")
+			}
+		}
+
+		config.Default().Auth.File = path.Join(t.TempDir(), "keys.toml")
+		require.NoError(t, os.WriteFile(config.Default().Auth.File, []byte(buf.String()), 0o600))
+	})
+
+	request := func(method, target, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(), method, target, strings.NewReader("File content"))
+		require.NoError(t, err)
+		req.Header.Set("Linx-Api-Key", key)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// upload scope
+	w = request(http.MethodPut, "/upload/a.txt", "moderator")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = request(http.MethodPut, "/upload/a.txt", "expired")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = request(http.MethodPut, "/upload/a.txt", "uploader")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	metadata, err := config.StorageBackend.Head(t.Context(), "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "uploader", metadata.Uploader)
+
+	// delete-any scope
+	w = request(http.MethodDelete, "/a.txt", "uploader")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = request(http.MethodDelete, "/a.txt", "moderator")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// admin scope
+	w = request(http.MethodGet, "/api/admin/files", "uploader")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	r, w := setup(t, func() {
+		config.Default().OIDC.Issuer = issuer.URL
+		config.Default().OIDC.ClientID = "linx"
+	})
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, "/"+oidc.LoginPath+"?redirect=/paste", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+
+	callback, err := url.Parse(issuer.Authorize(t, w.Header().Get("Location")))
+	require.NoError(t, err)
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodGet, callback.RequestURI(), nil)
+	require.NoError(t, err)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+	assert.Equal(t, "/paste", w.Header().Get("Location"))
+	cookies := w.Result().Cookies()
+
+	upload := func(cookies []*http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/file.txt",
+			strings.NewReader("File content"),
+		)
+		require.NoError(t, err)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w = upload(nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = upload(cookies)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	metadata, err := config.StorageBackend.Head(t.Context(), "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, oidctest.Subject, metadata.Uploader)
+}
+
+func TestReload(t *testing.T) {
+	writeKey := func(path, key string) {
+		hash, err := keyhash.Hash(key, "", false)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, []byte(hash+"\n"), 0o600))
+	}
+
+	h, _ := setup(t, func() {
+		config.Default().Auth.File = path.Join(t.TempDir(), "keys")
+		writeKey(config.Default().Auth.File, "oldkey")
+	})
+
+	cfgFile := path.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(cfgFile, []byte("max-size = \"8B\"\n"), 0o600))
+	root := cmd.New()
+	require.NoError(t, root.ParseFlags([]string{"--config", cfgFile}))
+
+	put := func(key, content string) int {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/", strings.NewReader(content))
+		require.NoError(t, err)
+		req.Header.Set("Linx-Api-Key", key)
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, put("oldkey", "File content"))
+
+	// rotate key and reduce max size
+	writeKey(config.Default().Auth.File, "newkey")
+	require.NoError(t, h.Reload(t.Context(), root))
+	assert.Equal(t, http.StatusUnauthorized, put("oldkey", "content"))
+	assert.Equal(t, http.StatusOK, put("newkey", "content"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, put("newkey", "File content"))
+
+	// invalid auth file is rejected
+	require.NoError(t, os.WriteFile(config.Default().Auth.File, []byte("invalid\n"), 0o600))
+	require.ErrorIs(t, h.Reload(t.Context(), root), apikeys.ErrNoValidKeys)
+	assert.Equal(t, http.StatusOK, put("newkey", "content"))
+
+	// requests are served while the config is reloaded
+	writeKey(config.Default().Auth.File, "newkey")
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for range 10 {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil))
+				assert.Equal(t, http.StatusOK, w.Code)
+			}
+		})
+	}
+	for range 10 {
+		require.NoError(t, h.Reload(t.Context(), root))
+	}
+	wg.Wait()
+}
+
+func TestMetrics(t *testing.T) {
+	r, w := setup(t, func() {
+		config.Default().Metrics = true
+	})
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/a.txt", strings.NewReader("File content"))
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodGet, "/metrics", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	assert.Contains(t, body, `linx_http_requests_total{code="200",method="PUT",route="upload"}`)
+	assert.Contains(t, body, `linx_http_request_bytes_total{route="upload"} 12`)
+	assert.Contains(t, body, "linx_http_request_duration_seconds_bucket")
+}
+
+func TestThumbnail(t *testing.T) {
+	r, _ := setup(t, func() {
+		config.Default().ThumbnailsPath = t.TempDir()
+		var err error
+		config.Thumbnails, config.StorageBackend, err = config.Default().CacheThumbnails(config.StorageBackend)
+		require.NoError(t, err)
+	})
+	t.Cleanup(func() { config.Thumbnails = nil })
+
+	img := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+
+	put := func(name, accessKey string) RespOkJSON {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/"+name, bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Linx-Access-Key", accessKey)
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var res RespOkJSON
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res
+	}
+	get := func(target string, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, target, nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", accept)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	uploaded := put("image.png", "")
+	thumbURL := testURL + "thumb/" + uploaded.Filename
+
+	w := get("/"+uploaded.Filename, "application/json")
+	require.Equal(t, http.StatusOK, w.Code)
+	var display handlers.DisplayJSON
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &display))
+	assert.Equal(t, thumbURL, display.ThumbnailURL)
+
+	w = get("/"+uploaded.Filename, "text/html")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<meta property="og:image" content="`+thumbURL+`">`)
+
+	w = get("/thumb/"+uploaded.Filename, "")
+	assertResponse(t, w, http.StatusOK, "image/png")
+	cfg, err := png.DecodeConfig(w.Body)
+	require.NoError(t, err)
+	assert.Equal(t, config.Default().ThumbnailSize, cfg.Width)
+	assert.DirExists(t, path.Join(config.Default().ThumbnailsPath, uploaded.Filename))
+
+	w = httptest.NewRecorder()
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodDelete, "/"+uploaded.Filename, nil)
+	require.NoError(t, err)
+	req.Header.Set("Linx-Delete-Key", uploaded.DeleteKey)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NoDirExists(t, path.Join(config.Default().ThumbnailsPath, uploaded.Filename))
+
+	protected := put("protected.png", "supersecret")
+	w = get("/thumb/"+protected.Filename, "application/json")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = get("/"+protected.Filename, "text/html")
+	assert.NotContains(t, w.Body.String(), "og:image")
+}
+
+func TestStripExif(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 16, 16)), nil))
+	want := buf.Bytes()
+
+	const exifPayload = "Exif\x00\x00GPSLatitude"
+	withExif := slices.Concat(want[:2], []byte{0xFF, 0xE1, 0, byte(len(exifPayload) + 2)}, []byte(exifPayload), want[2:])
+
+	tests := []struct {
+		name     string
+		config   bool
+		header   string
+		stripped bool
+	}{
+		{"disabled", false, "", false},
+		{"header", false, "yes", true},
+		{"config", true, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, w := setup(t, func() {
+				config.Default().StripExif = tt.config
+			})
+
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/photo.jpg", bytes.NewReader(withExif))
+			require.NoError(t, err)
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set("Linx-Strip-Exif", tt.header)
+			r.ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var res RespOkJSON
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+
+			m, f, err := config.StorageBackend.Get(t.Context(), res.Filename)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = f.Close() })
+			got, err := io.ReadAll(f)
+			require.NoError(t, err)
+
+			if tt.stripped {
+				assert.Equal(t, want, got)
+			} else {
+				assert.Equal(t, withExif, got)
+			}
+			assert.Equal(t, strconv.Itoa(len(got)), res.Size)
+			assert.EqualValues(t, len(got), m.Size)
+			sum := sha256.Sum256(got)
+			assert.Equal(t, hex.EncodeToString(sum[:]), m.Checksum)
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	clamd, err := clamdtest.NewServer()
+	require.NoError(t, err)
+	t.Cleanup(clamd.Close)
+
+	tests := []struct {
+		name     string
+		addr     string
+		failOpen bool
+		content  string
+		want     int
+	}{
+		{"clean", clamd.Addr(), false, "File content", http.StatusOK},
+		{"infected", clamd.Addr(), false, "File " + clamdtest.Marker, http.StatusUnprocessableEntity},
+		{"fail closed", "unix://" + path.Join(t.TempDir(), "missing.sock"), false, "File content",
+			http.StatusServiceUnavailable},
+		{"fail open", "unix://" + path.Join(t.TempDir(), "missing.sock"), true, "File content", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := setup(t, func() {
+				config.Default().Scan.Clamd = tt.addr
+				config.Default().Scan.FailOpen = tt.failOpen
+				config.Scanner, err = config.Default().NewScanner()
+				require.NoError(t, err)
+			})
+			t.Cleanup(func() { config.Scanner = nil })
+
+			// multipart uploads are not seekable, so they are staged before scanning
+			w := httptest.NewRecorder()
+			mw, b := newPostForm(t, "file.txt", tt.content, 0, "", false)
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, "/upload", &b)
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set("Referer", config.Default().SiteURL.String())
+			r.ServeHTTP(w, req)
+			require.Equal(t, tt.want, w.Code, w.Body.String())
+
+			if tt.want != http.StatusOK {
+				exists, err := config.StorageBackend.Exists(t.Context(), "file.txt")
+				require.NoError(t, err)
+				assert.False(t, exists)
+				return
+			}
+
+			var res RespOkJSON
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			_, f, err := config.StorageBackend.Get(t.Context(), res.Filename)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = f.Close() })
+			got, err := io.ReadAll(f)
+			require.NoError(t, err)
+			assert.Equal(t, tt.content, string(got))
+		})
+	}
+	assert.Equal(t, 2, clamd.Scanned())
+}
+
+func TestContentTypes(t *testing.T) {
+	r, _ := setup(t, func() {
+		var buf strings.Builder
+		for _, k := range []struct{ key, options string }{
+			{"untrusted", ""},
+			{"trusted", " allow-mimetypes=text/html allow-extensions=exe"},
+		} {
+			hash, err := keyhash.Hash(k.key, "", false)
+			require.NoError(t, err)
+			buf.WriteString(hash + k.options + "\n")
+		}
+		config.Default().Auth.File = path.Join(t.TempDir(), "keys")
+		require.NoError(t, os.WriteFile(config.Default().Auth.File, []byte(buf.String()), 0o600))
+
+		config.Default().ContentTypes.DenyMimetypes = []string{"text/html"}
+		config.Default().ContentTypes.DenyExtensions = []string{"exe"}
+	})
+
+	const html = "<!DOCTYPE html><html><body>File content</body></html>"
+	tests := []struct {
+		key, name, content string
+		want               int
+		wantErr            string
+	}{
+		{"untrusted", "file.txt", "File content", http.StatusOK, ""},
+		{"untrusted", "page.txt", html, http.StatusUnsupportedMediaType, "Files of type text/html are not allowed"},
+		{"untrusted", "app.exe", "File content", http.StatusUnsupportedMediaType,
+			"Files with the .exe extension are not allowed"},
+		{"trusted", "page.txt", html, http.StatusOK, ""},
+		{"trusted", "app.exe", "File content", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+" "+tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/"+tt.name,
+				strings.NewReader(tt.content),
+			)
+			require.NoError(t, err)
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set("Linx-Api-Key", tt.key)
+			req.Header.Set("Linx-Randomize", "yes")
+			r.ServeHTTP(w, req)
+			require.Equal(t, tt.want, w.Code, w.Body.String())
+
+			if tt.wantErr != "" {
+				var res RespErrJSON
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+				assert.Equal(t, tt.wantErr, res.Error)
+			}
+		})
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	var payloads []webhook.Payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, webhook.Sign("secret", body), r.Header.Get(webhook.HeaderSignature))
+		var payload webhook.Payload
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		mu.Lock()
+		payloads = append(payloads, payload)
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+
+	r, w := setup(t, func() {
+		config.Default().Webhooks.QueuePath = path.Join(t.TempDir(), "webhooks")
+		config.Default().Webhooks.Targets = []config.WebhookTarget{{URL: srv.URL, Secret: "secret"}}
+		var err error
+		config.Hooks, err = config.Default().NewWebhooks()
+		require.NoError(t, err)
+	})
+	t.Cleanup(func() { config.Hooks = nil })
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/file.txt",
+		strings.NewReader("File content"),
+	)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Linx-Randomize", "no")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var res RespOkJSON
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodDelete, path.Join("/", res.Filename), nil)
+	require.NoError(t, err)
+	req.Header.Set("Linx-Delete-Key", res.DeleteKey)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.True(t, config.Hooks.Flush(t.Context()).IsZero())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, payloads, 2)
+	assert.Equal(t, webhook.EventUpload, payloads[0].Event)
+	assert.Equal(t, webhook.EventDelete, payloads[1].Event)
+	for _, payload := range payloads {
+		assert.Equal(t, "file.txt", payload.Filename)
+		assert.EqualValues(t, len("File content"), payload.Size)
+		assert.Equal(t, "text/plain; charset=utf-8", payload.Mimetype)
+		assert.NotEmpty(t, payload.RequestID)
+	}
+	assert.NotEqual(t, payloads[0].RequestID, payloads[1].RequestID)
+}
+
+func TestMaxDownloads(t *testing.T) {
+	r, w := setup(t, nil)
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/secret.txt",
+		strings.NewReader("File content"),
+	)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Linx-Randomize", "no")
+	req.Header.Set("Linx-Max-Downloads", "2")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	get := func(method, target string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(), method, target, nil)
+		require.NoError(t, err)
+		maps.Copy(req.Header, header)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	selif := path.Join("/", config.Default().SelifPath, "secret.txt")
+
+	// the display page, HEAD and partial requests don't count as downloads
+	w = get(http.MethodGet, "/secret.txt", http.Header{"Accept": {"application/json"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	var display handlers.DisplayJSON
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &display))
+	assert.Equal(t, 2, display.DownloadsLeft)
+	assert.Empty(t, display.ThumbnailURL)
+	assert.Equal(t, http.StatusOK, get(http.MethodHead, selif, nil).Code)
+
+	w = get(http.MethodGet, selif, http.Header{"Range": {"bytes=0-3"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "File content", w.Body.String())
+	assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+
+	w = get(http.MethodGet, selif, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "File content", w.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, get(http.MethodGet, selif, nil).Code)
+	exists, err := config.StorageBackend.Exists(t.Context(), "secret.txt")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestMaxDownloadsConcurrent(t *testing.T) {
+	r, w := setup(t, nil)
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/secret.txt",
+		strings.NewReader("File content"),
+	)
+	require.NoError(t, err)
+	req.Header.Set("Linx-Randomize", "no")
+	req.Header.Set("Linx-Max-Downloads", "3")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var served atomic.Int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequestWithContext(t.Context(),
+				http.MethodGet, path.Join("/", config.Default().SelifPath, "secret.txt"), nil,
+			)
+			if !assert.NoError(t, err) {
+				return
+			}
+			r.ServeHTTP(w, req)
+			if w.Code == http.StatusOK {
+				served.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, 3, served.Load())
+}
+
+func TestStats(t *testing.T) {
+	r, w := setup(t, func() {
+		var err error
+		config.Default().StatsPath = path.Join(t.TempDir(), "stats")
+		config.Stats, config.StorageBackend, err = config.Default().TrackStats(config.StorageBackend)
+		require.NoError(t, err)
+	})
+	t.Cleanup(func() { config.Stats = nil })
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/file.txt",
+		strings.NewReader("File content"),
+	)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Linx-Randomize", "no")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var res RespOkJSON
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, target, nil)
+		require.NoError(t, err)
+		maps.Copy(req.Header, header)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	selif := path.Join("/", config.Default().SelifPath, "file.txt")
+
+	require.Equal(t, http.StatusOK, get("/file.txt", http.Header{"Accept": {"application/json"}}).Code)
+	require.Equal(t, http.StatusOK, get(selif, nil).Code)
+	require.Equal(t, http.StatusPartialContent, get(selif, http.Header{"Range": {"bytes=0-3"}}).Code)
+	// later ranges are part of the same download
+	require.Equal(t, http.StatusPartialContent, get(selif, http.Header{"Range": {"bytes=4-"}}).Code)
+
+	assert.Equal(t, http.StatusUnauthorized, get("/api/stats/file.txt", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized,
+		get("/api/stats/file.txt", http.Header{"Linx-Delete-Key": {"wrong"}}).Code,
+	)
+
+	w = get("/api/stats/file.txt", http.Header{"Linx-Delete-Key": {res.DeleteKey}})
+	require.Equal(t, http.StatusOK, w.Code)
+	var st stats.Stats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &st))
+	assert.Equal(t, int64(1), st.Views)
+	assert.Equal(t, int64(2), st.Downloads)
+	assert.WithinDuration(t, time.Now(), st.LastAccessed, time.Minute)
+
+	// the uploader checking their stats is not counted as a view
+	w = get("/file.txt", http.Header{"Accept": {"application/json"}, "Linx-Delete-Key": {res.DeleteKey}})
+	require.Equal(t, http.StatusOK, w.Code)
+	var display handlers.DisplayJSON
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &display))
+	require.NotNil(t, display.Stats)
+	assert.Equal(t, st, *display.Stats)
+
+	w = get("/file.txt", http.Header{"Accept": {"application/json"}})
+	var anonymous handlers.DisplayJSON
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &anonymous))
+	assert.Nil(t, anonymous.Stats)
+}
+
+func TestShareLinks(t *testing.T) {
+	r, w := setup(t, func() {
+		var err error
+		config.Default().SharesPath = path.Join(t.TempDir(), "shares")
+		config.Shares, config.StorageBackend, err = config.Default().TrackShares(config.StorageBackend)
+		require.NoError(t, err)
+	})
+	t.Cleanup(func() { config.Shares = nil })
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/file.txt",
+		strings.NewReader("File content"),
+	)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Linx-Randomize", "no")
+	req.Header.Set("Linx-Access-Key", "supersecret")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var res RespOkJSON
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+
+	do := func(method, target string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(), method, target, nil)
+		require.NoError(t, err)
+		maps.Copy(req.Header, header)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	owner := http.Header{"Linx-Delete-Key": {res.DeleteKey}}
+	mint := func(query string) handlers.ShareResponse {
+		w := do(http.MethodPost, "/api/share/file.txt?"+query, owner)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var share handlers.ShareResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &share))
+		return share
+	}
+	get := func(u string) *httptest.ResponseRecorder {
+		parsed, err := url.Parse(u)
+		require.NoError(t, err)
+		return do(http.MethodGet, parsed.RequestURI(), nil)
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/share/file.txt", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/share/file.txt?expiry=soon", owner).Code)
+
+	link := mint("expiry=1h")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), time.Unix(link.Expiry, 0), time.Minute)
+	w = get(link.URL)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "File content", w.Body.String())
+	assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+
+	// tampered links are rejected
+	assert.Equal(t, http.StatusForbidden, get(strings.Replace(link.URL, "exp=", "exp=9", 1)).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/selif/file.txt", nil).Code)
+
+	limited := mint("max_uses=1")
+	require.Equal(t, http.StatusOK, get(limited.URL).Code)
+	assert.Equal(t, http.StatusForbidden, get(limited.URL).Code)
+
+	restricted := mint("ip=10.0.0.0/8")
+	assert.Equal(t, "10.0.0.0/8", restricted.IP)
+	parsed, err := url.Parse(restricted.URL)
+	require.NoError(t, err)
+	for addr, want := range map[string]int{
+		"10.1.2.3:1234":          http.StatusOK,
+		"192.0.2.1:1234":         http.StatusForbidden,
+		"[::ffff:10.0.0.1]:1234": http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, parsed.RequestURI(), nil)
+		req.RemoteAddr = addr
+		r.ServeHTTP(w, req)
+		assert.Equal(t, want, w.Code, addr)
+	}
+
+	// revoking replaces the secret, so every link stops working
+	require.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/share/file.txt", owner).Code)
+	assert.Equal(t, http.StatusForbidden, get(link.URL).Code)
+	require.Equal(t, http.StatusOK, get(mint("").URL).Code)
+}
+
+func TestCollection(t *testing.T) {
+	r, w := setup(t, nil)
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	for field, value := range map[string]string{"collection": "Screenshots", "access_key": "supersecret"} {
+		require.NoError(t, mw.WriteField(field, value))
+	}
+	files := map[string]string{"a.txt": "File A", "b.txt": "File B", "c.txt": "File C"}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		fw, err := mw.CreateFormFile("file", name)
+		require.NoError(t, err)
+		_, err = io.WriteString(fw, files[name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, "/upload", &b)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
+	req.Header.Set("Accept", "application/json")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var res upload.CollectionJSONResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "screenshots.collection", res.Filename)
+	assert.Equal(t, "18", res.Size)
+	require.Len(t, res.Files, 3)
+	for _, f := range res.Files {
+		assert.Equal(t, res.DeleteKey, f.DeleteKey)
+	}
+
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, target, nil)
+		require.NoError(t, err)
+		maps.Copy(req.Header, header)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	key := http.Header{"Linx-Access-Key": {"supersecret"}}
+	selif := path.Join("/", config.Default().SelifPath, res.Filename)
+
+	assert.Equal(t, http.StatusUnauthorized, get(selif, nil).Code)
+
+	w = get("/"+res.Filename, http.Header{"Accept": {"application/json"}, "Linx-Access-Key": {"supersecret"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	var display handlers.DisplayJSON
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &display))
+	assert.Equal(t, "Screenshots", display.OriginalName)
+	require.Len(t, display.Files, 3)
+	assert.Equal(t, "a.txt", display.Files[0].OriginalName)
+
+	// files are served through the collection with its access key
+	member, err := url.Parse(display.Files[1].DirectURL)
+	require.NoError(t, err)
+	w = get(member.Path, key)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "File B", w.Body.String())
+	assert.Equal(t, http.StatusNotFound, get(path.Join(selif, "other.txt"), key).Code)
+
+	w = get(selif, key)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "Screenshots.zip")
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	got := make(map[string]string, len(zr.File))
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		_ = rc.Close()
+		got[f.Name] = string(b)
+	}
+	assert.Equal(t, files, got)
+
+	// a file which was deleted and replaced by an unrelated upload is not served through the collection
+	w = httptest.NewRecorder()
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodDelete, "/c.txt", nil)
+	require.NoError(t, err)
+	req.Header.Set("Linx-Delete-Key", res.DeleteKey)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/c.txt", strings.NewReader("Private"))
+	require.NoError(t, err)
+	req.Header.Set("Linx-Randomize", "no")
+	req.Header.Set("Linx-Access-Key", "other")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusNotFound, get(path.Join(selif, "c.txt"), key).Code)
+	w = get("/"+res.Filename, http.Header{"Accept": {"application/json"}, "Linx-Access-Key": {"supersecret"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &display))
+	assert.Len(t, display.Files, 2)
+	w = get(selif, key)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "Private")
+
+	// deleting the collection deletes its files, but not the replacement
+	w = httptest.NewRecorder()
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodDelete, "/"+res.Filename, nil)
+	require.NoError(t, err)
+	req.Header.Set("Linx-Delete-Key", res.DeleteKey)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	for _, f := range res.Files {
+		_, err := config.StorageBackend.Head(t.Context(), f.Filename)
+		if f.Filename == "c.txt" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorIs(t, err, backends.ErrNotFound)
+		}
+	}
+}
+
+func TestCollectionUnsupported(t *testing.T) {
+	r, w := setup(t, nil)
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	require.NoError(t, mw.WriteField("max_downloads", "1"))
+	for _, name := range []string{"a.txt", "b.txt"} {
+		fw, err := mw.CreateFormFile("file", name)
+		require.NoError(t, err)
+		_, err = io.WriteString(fw, "File content")
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, "/upload", &b)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the file which was stored before the second file was rejected is removed
+	exists, err := config.StorageBackend.Exists(t.Context(), "a.txt")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestCollectionSize(t *testing.T) {
+	r, w := setup(t, nil)
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	require.NoError(t, mw.WriteField("size", "4"))
+	for _, content := range []string{"File", "File B"} {
+		fw, err := mw.CreateFormFile("file", "file.txt")
+		require.NoError(t, err)
+		_, err = io.WriteString(fw, content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, "/upload", &b)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Referer", config.Default().SiteURL.String())
+	req.Header.Set("Accept", "application/json")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// the size field only applies to the first file
+	var res upload.CollectionJSONResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "10", res.Size)
+}
+
+func TestArchiveFile(t *testing.T) {
+	r, w := setup(t, nil)
+
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for name, content := range map[string]string{"readme.txt": "Read me", "dir/page.html": "<h1>Hi</h1>"} {
+		f, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = io.WriteString(f, content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/archive.zip", &b)
+	require.NoError(t, err)
+	req.Header.Set("Linx-Randomize", "false")
+	req.Header.Set("Linx-Access-Key", "supersecret")
+	req.Header.Set("Accept", "application/json")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, target, nil)
+		require.NoError(t, err)
+		maps.Copy(req.Header, header)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	key := http.Header{"Linx-Access-Key": {"supersecret"}}
+	selif := path.Join("/", config.Default().SelifPath, "archive.zip")
+
+	assert.Equal(t, http.StatusUnauthorized, get(selif+"/readme.txt", nil).Code)
+
+	w = get(selif+"/readme.txt", key)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Read me", w.Body.String())
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+
+	w = get(selif+"/dir/page.html?download", key)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, handlers.FileCSP, w.Header().Get("Content-Security-Policy"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "page.html")
+
+	assert.Equal(t, http.StatusNotFound, get(selif+"/missing.txt", key).Code)
+	assert.Equal(t, http.StatusNotFound, get(selif+"/dir/../../readme.txt", key).Code)
+
+	// hotlinks are redirected like the archive itself
+	w = get(selif+"/readme.txt", http.Header{"Linx-Access-Key": {"supersecret"}, "Referer": {"https://example.com"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+}
+
+func TestTorrent(t *testing.T) {
+	r, w := setup(t, func() {
+		var err error
+		config.Default().TorrentsPath = path.Join(t.TempDir(), "torrents")
+		config.Default().Trackers = []string{"https://tracker.example.org/announce"}
+		config.Torrents, config.StorageBackend, err = config.Default().CacheTorrents(config.StorageBackend)
+		require.NoError(t, err)
+	})
+	t.Cleanup(func() { config.Torrents = nil })
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/file.txt",
+		strings.NewReader("File content"),
+	)
+	require.NoError(t, err)
+	req.Header.Set("Linx-Randomize", "false")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	metadata, err := config.StorageBackend.Head(t.Context(), "file.txt")
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodGet, "/torrent/file.txt", nil)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, metadata.ModTime.UTC().Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+
+	var decoded torrent.Torrent
+	require.NoError(t, bencode.DecodeBytes(w.Body.Bytes(), &decoded))
+	assert.Equal(t, "file.txt", decoded.Info.Name)
+	assert.Equal(t, "https://tracker.example.org/announce", decoded.Announce)
+	assert.Equal(t, testURL+path.Join(config.Default().SelifPath, "file.txt"), decoded.URLList[0])
+
+	// The display page has a magnet URI once the hashes are cached
+	w = httptest.NewRecorder()
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodGet, "/file.txt", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/json")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var display handlers.DisplayJSON
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &display))
+	v1, _, err := decoded.Info.InfoHashes()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(display.MagnetURI, "magnet:?xt=urn:btih:"+hex.EncodeToString(v1[:])))
+}
+
+func TestEncrypted(t *testing.T) {
+	r, w := setup(t, nil)
+
+	key := e2e.NewKey()
+	var ciphertext bytes.Buffer
+	require.NoError(t, e2e.Encrypt(&ciphertext, strings.NewReader("PK secret archive"), key, e2e.Info{
+		Name: "secret.zip",
+		Type: "application/zip",
+	}))
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/secret.zip",
+		bytes.NewReader(ciphertext.Bytes()),
+	)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Linx-Randomize", "no")
+	req.Header.Set("Linx-Encrypted", "yes")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var res upload.JSONResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.NotEqual(t, "secret.zip", res.Filename)
+	assert.Equal(t, ".bin", path.Ext(res.Filename))
+	assert.Empty(t, res.OriginalName)
+	assert.Equal(t, backends.EncryptedMimetype, res.Mimetype)
+
+	metadata, err := config.StorageBackend.Head(t.Context(), res.Filename)
+	require.NoError(t, err)
+	assert.True(t, metadata.Encrypted)
+	assert.Empty(t, metadata.ArchiveFiles)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodGet, "/"+res.Filename, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "application/json")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var display handlers.DisplayJSON
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &display))
+	assert.True(t, display.Encrypted)
+
+	// the ciphertext is served as-is for the client to decrypt
+	w = httptest.NewRecorder()
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodGet,
+		path.Join("/", config.Default().SelifPath, res.Filename), nil,
+	)
+	require.NoError(t, err)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, backends.EncryptedMimetype, w.Header().Get("Content-Type"))
+	assert.Equal(t, ciphertext.Bytes(), w.Body.Bytes())
+
+	dec, err := e2e.NewReader(w.Body, key)
+	require.NoError(t, err)
+	assert.Equal(t, "secret.zip", dec.Info.Name)
+	plain, err := io.ReadAll(dec)
+	require.NoError(t, err)
+	assert.Equal(t, "PK secret archive", string(plain))
+
+	// plaintext can't be flagged as encrypted
+	w = httptest.NewRecorder()
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodPut, "/upload/plain.txt",
+		strings.NewReader("This is not encrypted"),
+	)
+	require.NoError(t, err)
+	req.Header.Set("Linx-Encrypted", "yes")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}